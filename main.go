@@ -17,12 +17,23 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
+	"github.com/rkosegi/pkitool/pkg/cmd"
+	"github.com/rkosegi/pkitool/pkg/common"
 	"os"
-	"pkitool/pkg/cmd"
+	"os/signal"
 )
 
 func main() {
-	if err := cmd.New(os.Stdin, os.Stdout, os.Stderr).Execute(); err != nil {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := cmd.New(os.Stdin, os.Stdout, os.Stderr).ExecuteContext(ctx); err != nil {
+		var ee *common.ExitError
+		if errors.As(err, &ee) {
+			cancel()
+			os.Exit(ee.Code)
+		}
 		panic(err)
 	}
 }
@@ -0,0 +1,297 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
+	"io"
+	"os"
+)
+
+// LetsEncryptURL is directory URL of Let's Encrypt production environment.
+const LetsEncryptURL = acme.LetsEncryptURL
+
+type obtainData struct {
+	w            io.Writer
+	errw         io.Writer
+	dir          string
+	alias        string
+	domains      []string
+	directoryURL string
+	email        string
+	accountKey   string
+	agreeTOS     bool
+	httpListen   string
+	config       string
+	bits         int
+}
+
+// loadAccountKey loads ACME account key from file, generating and saving new one if file doesn't exist.
+// Without file, new ephemeral key is generated, which means new account is registered.
+func loadAccountKey(file string) (crypto.Signer, error) {
+	if len(file) > 0 {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, &certmgr.PEMError{File: file, Type: "EC PRIVATE KEY"}
+			}
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(file) > 0 {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// authorize completes all authorizations of order, using first solver that accepts domain and supports offered challenge.
+func authorize(ctx context.Context, client *acme.Client, order *acme.Order, solvers []Solver, p certmgr.Progress) error {
+	for _, u := range order.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return err
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		var (
+			chal   *acme.Challenge
+			solver Solver
+		)
+	outer:
+		for _, s := range solvers {
+			if !s.Accepts(z.Identifier.Value) {
+				continue
+			}
+			for _, c := range z.Challenges {
+				if c.Type == s.Type() {
+					chal, solver = c, s
+					break outer
+				}
+			}
+		}
+		if chal == nil {
+			return fmt.Errorf("no supported challenge offered for %s", z.Identifier.Value)
+		}
+		task := fmt.Sprintf("validating %s using %s challenge", z.Identifier.Value, chal.Type)
+		p.Begin(task)
+		err = func() error {
+			if err := solver.Present(ctx, client, z.Identifier.Value, chal); err != nil {
+				return err
+			}
+			defer func() {
+				_ = solver.CleanUp(ctx, client, z.Identifier.Value, chal)
+			}()
+			if _, err := client.Accept(ctx, chal); err != nil {
+				return err
+			}
+			_, err := client.WaitAuthorization(ctx, z.URI)
+			return err
+		}()
+		p.End(task, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// solvers creates solvers of dns-01 challenges from configuration, followed by http-01 solver, unless it's disabled.
+func solvers(d *obtainData) ([]Solver, *httpSolver, error) {
+	cfg, err := config.Load(d.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	res := make([]Solver, 0, len(cfg.ACME.Solvers)+1)
+	for i := range cfg.ACME.Solvers {
+		s, err := newDNSSolver(&cfg.ACME.Solvers[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		res = append(res, s)
+	}
+	var hs *httpSolver
+	if len(d.httpListen) > 0 {
+		hs = newHTTPSolver(d.httpListen)
+		res = append(res, hs)
+	}
+	if len(res) == 0 {
+		return nil, nil, errors.New("no challenge solver is configured")
+	}
+	return res, hs, nil
+}
+
+func obtain(ctx context.Context, d *obtainData) error {
+	ss, hs, err := solvers(d)
+	if err != nil {
+		return err
+	}
+	if hs != nil {
+		defer func() {
+			_ = hs.Close()
+		}()
+	}
+	accountKey, err := loadAccountKey(d.accountKey)
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: d.directoryURL}
+	acct := &acme.Account{}
+	if len(d.email) > 0 {
+		acct.Contact = []string{"mailto:" + d.email}
+	}
+	if _, err = client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(d.domains...))
+	if err != nil {
+		return err
+	}
+	p := common.NewSpinner(d.errw)
+	if err = authorize(ctx, client, order, ss, p); err != nil {
+		return err
+	}
+	// polled order doesn't carry its URL
+	orderURL := order.URI
+	if order, err = client.WaitOrder(ctx, orderURL); err != nil {
+		return err
+	}
+	cd := &certmgr.CertData{KeySize: d.bits, Subject: pkix.Name{CommonName: d.domains[0]}}
+	for _, domain := range d.domains {
+		cd.AddSAN(domain)
+	}
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return err
+	}
+	ders, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr.CSR.Raw, true)
+	if err != nil {
+		// some servers don't send location of order in response to finalization,
+		// so poll order using URL known from its creation instead
+		if order, err = client.WaitOrder(ctx, orderURL); err != nil {
+			return err
+		}
+		if ders, err = client.FetchCert(ctx, order.CertURL, true); err != nil {
+			return err
+		}
+	}
+	certs := make([]*x509.Certificate, 0, len(ders))
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.New("ACME server returned no certificate")
+	}
+	if err = certmgr.New(d.dir).Import(ctx, d.alias, certs[0], csr.Key, certs[1:]); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Obtained certificate '%s' for %v, valid to %s\n", d.alias, d.domains, certs[0].NotAfter)
+	return err
+}
+
+func validateObtain(d *obtainData) error {
+	if len(d.domains) == 0 {
+		return errors.New("at least one domain is required")
+	}
+	if !d.agreeTOS {
+		return errors.New("terms of service of ACME server must be accepted using --agree-tos")
+	}
+	if len(d.alias) == 0 {
+		d.alias = d.domains[0]
+	}
+	if len(d.config) == 0 {
+		d.config = config.PathFor(d.dir)
+	}
+	return nil
+}
+
+func newObtainSubCommand(w io.Writer) *cobra.Command {
+	d := &obtainData{
+		w:            w,
+		dir:          ".",
+		directoryURL: LetsEncryptURL,
+		httpListen:   ":80",
+		bits:         2048,
+	}
+	cmd := &cobra.Command{
+		Use:   "obtain",
+		Short: "Obtain certificate from ACME server, like Let's Encrypt",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateObtain(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return obtain(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.domains, "domain", d.domains, "Domain name to obtain certificate for, can be repeated")
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias for new certificate, defaults to first domain")
+	cmd.Flags().StringVar(&d.directoryURL, "directory-url", d.directoryURL, "URL of ACME directory")
+	cmd.Flags().StringVar(&d.email, "email", d.email, "Contact email of ACME account")
+	cmd.Flags().StringVar(&d.accountKey, "account-key", d.accountKey, "PEM file with ACME account key, created if it doesn't exist. "+
+		"When not set, new account is registered every time")
+	cmd.Flags().BoolVar(&d.agreeTOS, "agree-tos", d.agreeTOS, "Agree to terms of service of ACME server")
+	cmd.Flags().StringVar(&d.httpListen, "http-listen", d.httpListen, "Address to answer http-01 challenges on, empty value disables http-01 challenges")
+	cmd.Flags().StringVar(&d.config, "config", d.config, "Configuration file with dns-01 challenge solvers, defaults to "+config.FileName+" in directory")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "acme",
+		Short: "Interact with ACME certificate authorities",
+	}
+	cmd.AddCommand(newObtainSubCommand(out))
+	return cmd
+}
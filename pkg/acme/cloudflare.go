@@ -0,0 +1,158 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const cloudflareURL = "https://api.cloudflare.com/client/v4"
+
+// cloudflare manages records using Cloudflare API. API token needs Zone:Read and DNS:Edit permissions.
+type cloudflare struct {
+	token  string
+	zoneID string
+	client *http.Client
+}
+
+func newCloudflare(s *config.Solver) (*cloudflare, error) {
+	cf := &cloudflare{
+		token:  s.Option("apiToken", ""),
+		zoneID: s.Option("zoneId", ""),
+		client: http.DefaultClient,
+	}
+	if len(cf.token) == 0 {
+		return nil, errors.New("cloudflare: apiToken option is required")
+	}
+	return cf, nil
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+func (cf *cloudflare) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cf.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cf.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var cr cloudflareResponse
+	if err = json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return fmt.Errorf("cloudflare: %s %s: %s", method, path, resp.Status)
+	}
+	if !cr.Success {
+		msgs := make([]string, 0, len(cr.Errors))
+		for _, e := range cr.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare: %s %s: %s", method, path, strings.Join(msgs, ", "))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(cr.Result, out)
+}
+
+// zone gets ID of zone that contains fqdn.
+func (cf *cloudflare) zone(ctx context.Context, fqdn string) (string, error) {
+	if len(cf.zoneID) > 0 {
+		return cf.zoneID, nil
+	}
+	for _, name := range zoneCandidates(fqdn) {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := cf.do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			cf.zoneID = zones[0].ID
+			return cf.zoneID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone found for %s", fqdn)
+}
+
+func (cf *cloudflare) SetTXT(ctx context.Context, fqdn, value string) error {
+	zone, err := cf.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	return cf.do(ctx, http.MethodPost, "/zones/"+zone+"/dns_records", &cloudflareRecord{
+		Type:    "TXT",
+		Name:    fqdn,
+		Content: value,
+		TTL:     120,
+	}, nil)
+}
+
+func (cf *cloudflare) DeleteTXT(ctx context.Context, fqdn, value string) error {
+	zone, err := cf.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	q := url.Values{"type": {"TXT"}, "name": {fqdn}, "content": {value}}
+	var records []cloudflareRecord
+	if err = cf.do(ctx, http.MethodGet, "/zones/"+zone+"/dns_records?"+q.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err = cf.do(ctx, http.MethodDelete, "/zones/"+zone+"/dns_records/"+r.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"golang.org/x/crypto/acme"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	challengePrefix           = "_acme-challenge."
+	defaultPropagationTimeout = 2 * time.Minute
+	propagationPollInterval   = 5 * time.Second
+)
+
+// DNSProvider manages TXT records used to answer dns-01 challenges.
+type DNSProvider interface {
+	// SetTXT adds TXT record with given value. Other values of the same name must be kept.
+	SetTXT(ctx context.Context, fqdn, value string) error
+	// DeleteTXT removes TXT record with given value.
+	DeleteTXT(ctx context.Context, fqdn, value string) error
+}
+
+// dnsSolver answers dns-01 challenges using DNS provider.
+type dnsSolver struct {
+	provider DNSProvider
+	domains  []string
+	timeout  time.Duration
+}
+
+// newDNSSolver creates solver from configuration.
+func newDNSSolver(s *config.Solver) (*dnsSolver, error) {
+	var (
+		p   DNSProvider
+		err error
+	)
+	switch s.Provider {
+	case "cloudflare":
+		p, err = newCloudflare(s)
+	case "route53":
+		p, err = newRoute53(s)
+	case "rfc2136":
+		p, err = newRFC2136(s)
+	case "exec":
+		p, err = newExecDNS(s)
+	default:
+		err = fmt.Errorf("unknown DNS provider: '%s'", s.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultPropagationTimeout
+	if v := s.Option("propagationTimeout", ""); len(v) > 0 {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid propagationTimeout: %w", err)
+		}
+	}
+	return &dnsSolver{provider: p, domains: s.Domains, timeout: timeout}, nil
+}
+
+func (ds *dnsSolver) Type() string {
+	return "dns-01"
+}
+
+func (ds *dnsSolver) Accepts(domain string) bool {
+	return len(ds.domains) == 0 || slices.ContainsFunc(ds.domains, func(d string) bool {
+		return domain == d || strings.HasSuffix(domain, "."+d)
+	})
+}
+
+func (ds *dnsSolver) Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := challengePrefix + domain
+	if err = ds.provider.SetTXT(ctx, fqdn, value); err != nil {
+		return err
+	}
+	return waitForTXT(ctx, fqdn, value, ds.timeout)
+}
+
+func (ds *dnsSolver) CleanUp(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	return ds.provider.DeleteTXT(ctx, challengePrefix+domain, value)
+}
+
+// waitForTXT waits until record is visible to resolver, or timeout expires.
+// Expired timeout is not an error, ACME server might still see record even when local resolver doesn't.
+func waitForTXT(ctx context.Context, fqdn, value string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	t := time.NewTicker(propagationPollInterval)
+	defer t.Stop()
+	for {
+		if values, err := net.DefaultResolver.LookupTXT(ctx, fqdn); err == nil && slices.Contains(values, value) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// zoneCandidates gets all parent domains of fqdn, starting with the longest one.
+func zoneCandidates(fqdn string) []string {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	res := make([]string, 0, len(labels))
+	for i := 1; i < len(labels)-1; i++ {
+		res = append(res, strings.Join(labels[i:], "."))
+	}
+	return res
+}
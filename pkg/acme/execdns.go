@@ -0,0 +1,52 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+)
+
+// execDNS delegates management of records to external plugin.
+// Plugin receives {"op": "present"|"cleanup", "fqdn": "...", "value": "..."} and responds with {}.
+type execDNS struct {
+	command string
+}
+
+type execDNSRequest struct {
+	Op    string `json:"op"`
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+}
+
+func newExecDNS(s *config.Solver) (*execDNS, error) {
+	e := &execDNS{command: s.Option("command", "")}
+	if len(e.command) == 0 {
+		return nil, errors.New("exec: command option is required")
+	}
+	return e, nil
+}
+
+func (e *execDNS) SetTXT(ctx context.Context, fqdn, value string) error {
+	return plugin.Call(ctx, e.command, &execDNSRequest{Op: "present", FQDN: fqdn, Value: value}, nil)
+}
+
+func (e *execDNS) DeleteTXT(ctx context.Context, fqdn, value string) error {
+	return plugin.Call(ctx, e.command, &execDNSRequest{Op: "cleanup", FQDN: fqdn, Value: value}, nil)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"time"
+)
+
+// rfc2136 manages records using dynamic DNS updates, optionally authenticated using TSIG.
+type rfc2136 struct {
+	server  string
+	zone    string
+	keyName string
+	secret  string
+	alg     string
+}
+
+func newRFC2136(s *config.Solver) (*rfc2136, error) {
+	r := &rfc2136{
+		server:  s.Option("server", ""),
+		zone:    s.Option("zone", ""),
+		keyName: s.Option("tsigKey", ""),
+		secret:  s.Option("tsigSecret", ""),
+		alg:     s.Option("tsigAlgorithm", dns.HmacSHA256),
+	}
+	if len(r.server) == 0 {
+		return nil, errors.New("rfc2136: server option is required")
+	}
+	if (len(r.keyName) == 0) != (len(r.secret) == 0) {
+		return nil, errors.New("rfc2136: both tsigKey and tsigSecret options are required for TSIG")
+	}
+	return r, nil
+}
+
+func (r *rfc2136) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: "tcp"}
+	if len(r.keyName) > 0 {
+		m.SetTsig(dns.Fqdn(r.keyName), dns.Fqdn(r.alg), 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{dns.Fqdn(r.keyName): r.secret}
+	}
+	resp, _, err := c.ExchangeContext(ctx, m, r.server)
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("rfc2136: server responded with %s", dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// findZone gets zone that contains fqdn, by asking server for SOA record.
+func (r *rfc2136) findZone(ctx context.Context, fqdn string) (string, error) {
+	if len(r.zone) > 0 {
+		return dns.Fqdn(r.zone), nil
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeSOA)
+	c := &dns.Client{Net: "tcp"}
+	resp, _, err := c.ExchangeContext(ctx, m, r.server)
+	if err != nil {
+		return "", fmt.Errorf("rfc2136: %w", err)
+	}
+	for _, rr := range append(resp.Answer, resp.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			r.zone = soa.Hdr.Name
+			return r.zone, nil
+		}
+	}
+	return "", fmt.Errorf("rfc2136: no zone found for %s", fqdn)
+}
+
+func (r *rfc2136) update(ctx context.Context, fqdn, value string, insert bool) error {
+	zone, err := r.findZone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(fqdn), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+		Txt: []string{value},
+	}
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	if insert {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+	_, err = r.exchange(ctx, m)
+	return err
+}
+
+func (r *rfc2136) SetTXT(ctx context.Context, fqdn, value string) error {
+	return r.update(ctx, fqdn, value, true)
+}
+
+func (r *rfc2136) DeleteTXT(ctx context.Context, fqdn, value string) error {
+	return r.update(ctx, fqdn, value, false)
+}
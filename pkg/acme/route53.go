@@ -0,0 +1,263 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	route53URL       = "https://route53.amazonaws.com/2013-04-01"
+	route53Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+	route53Region    = "us-east-1"
+	route53Service   = "route53"
+)
+
+// route53 manages records using AWS Route 53 API.
+// Credentials are taken from options, or from standard AWS_* environment variables.
+type route53 struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	zoneID       string
+	client       *http.Client
+	// all values of TXT records, since Route 53 manages whole record sets
+	mu     sync.Mutex
+	values map[string][]string
+}
+
+func newRoute53(s *config.Solver) (*route53, error) {
+	r := &route53{
+		accessKey:    s.Option("accessKeyId", os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey:    s.Option("secretAccessKey", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		sessionToken: s.Option("sessionToken", os.Getenv("AWS_SESSION_TOKEN")),
+		zoneID:       s.Option("hostedZoneId", ""),
+		client:       http.DefaultClient,
+		values:       map[string][]string{},
+	}
+	if len(r.accessKey) == 0 || len(r.secretKey) == 0 {
+		return nil, errors.New("route53: credentials are required, set accessKeyId and secretAccessKey options or AWS_* environment variables")
+	}
+	return r, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign signs request using AWS Signature Version 4.
+func (r *route53) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(r.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", r.sessionToken)
+	}
+	headers := map[string]string{
+		"host":       req.URL.Host,
+		"x-amz-date": amzDate,
+	}
+	if len(r.sessionToken) > 0 {
+		headers["x-amz-security-token"] = r.sessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + route53Region + "/" + route53Service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+r.secretKey), date)
+	key = hmacSHA256(key, route53Region)
+	key = hmacSHA256(key, route53Service)
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		r.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func (r *route53) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = xml.Marshal(in); err != nil {
+			return err
+		}
+		body = append([]byte(xml.Header), body...)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, route53URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	r.sign(req, body, time.Now())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Code    string `xml:"Code"`
+				Message string `xml:"Message"`
+			} `xml:"Error"`
+		}
+		if xml.Unmarshal(data, &e) == nil && len(e.Error.Code) > 0 {
+			return fmt.Errorf("route53: %s: %s", e.Error.Code, e.Error.Message)
+		}
+		return fmt.Errorf("route53: %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// zone gets ID of hosted zone that contains fqdn.
+func (r *route53) zone(ctx context.Context, fqdn string) (string, error) {
+	if len(r.zoneID) > 0 {
+		return r.zoneID, nil
+	}
+	for _, name := range zoneCandidates(fqdn) {
+		var resp struct {
+			HostedZones []struct {
+				ID   string `xml:"Id"`
+				Name string `xml:"Name"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		q := url.Values{"dnsname": {name}, "maxitems": {"1"}}
+		if err := r.do(ctx, http.MethodGet, "/hostedzonesbyname?"+q.Encode(), nil, &resp); err != nil {
+			return "", err
+		}
+		if len(resp.HostedZones) > 0 && resp.HostedZones[0].Name == name+"." {
+			r.zoneID = strings.TrimPrefix(resp.HostedZones[0].ID, "/hostedzone/")
+			return r.zoneID, nil
+		}
+	}
+	return "", fmt.Errorf("route53: no hosted zone found for %s", fqdn)
+}
+
+type route53Change struct {
+	Action string   `xml:"Action"`
+	Name   string   `xml:"ResourceRecordSet>Name"`
+	Type   string   `xml:"ResourceRecordSet>Type"`
+	TTL    int      `xml:"ResourceRecordSet>TTL"`
+	Values []string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+// change replaces record set with given values, or deletes it when old values are given.
+func (r *route53) change(ctx context.Context, fqdn, action string, values []string) error {
+	zone, err := r.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, `"`+v+`"`)
+	}
+	return r.do(ctx, http.MethodPost, "/hostedzone/"+zone+"/rrset", &route53ChangeRequest{
+		XMLNS: route53Namespace,
+		Changes: []route53Change{{
+			Action: action,
+			Name:   fqdn + ".",
+			Type:   "TXT",
+			TTL:    60,
+			Values: quoted,
+		}},
+	}, nil)
+}
+
+func (r *route53) SetTXT(ctx context.Context, fqdn, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values := append(r.values[fqdn], value)
+	if err := r.change(ctx, fqdn, "UPSERT", values); err != nil {
+		return err
+	}
+	r.values[fqdn] = values
+	return nil
+}
+
+func (r *route53) DeleteTXT(ctx context.Context, fqdn, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.values[fqdn]
+	values := slices.DeleteFunc(slices.Clone(old), func(v string) bool {
+		return v == value
+	})
+	var err error
+	if len(values) > 0 {
+		err = r.change(ctx, fqdn, "UPSERT", values)
+	} else {
+		err = r.change(ctx, fqdn, "DELETE", old)
+	}
+	if err != nil {
+		return err
+	}
+	r.values[fqdn] = values
+	return nil
+}
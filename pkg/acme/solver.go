@@ -0,0 +1,119 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/acme"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Solver fulfills ACME challenges of single type.
+type Solver interface {
+	// Type gets type of challenges handled by solver, like "http-01".
+	Type() string
+	// Accepts checks whether solver can be used for given domain.
+	Accepts(domain string) bool
+	// Present makes response to challenge available for validation.
+	Present(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error
+	// CleanUp removes any resources created by Present.
+	CleanUp(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) error
+}
+
+const http01Prefix = "/.well-known/acme-challenge/"
+
+// httpSolver answers http-01 challenges using built-in HTTP server.
+// Server is started on first challenge and stopped by Close.
+type httpSolver struct {
+	listen    string
+	mu        sync.Mutex
+	responses map[string]string
+	srv       *http.Server
+}
+
+func newHTTPSolver(listen string) *httpSolver {
+	return &httpSolver{listen: listen, responses: map[string]string{}}
+}
+
+func (hs *httpSolver) Type() string {
+	return "http-01"
+}
+
+func (hs *httpSolver) Accepts(string) bool {
+	return true
+}
+
+func (hs *httpSolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hs.mu.Lock()
+	resp, ok := hs.responses[strings.TrimPrefix(r.URL.Path, http01Prefix)]
+	hs.mu.Unlock()
+	if !ok || !strings.HasPrefix(r.URL.Path, http01Prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(resp))
+}
+
+func (hs *httpSolver) Present(_ context.Context, client *acme.Client, _ string, chal *acme.Challenge) error {
+	resp, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.responses[chal.Token] = resp
+	if hs.srv != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", hs.listen)
+	if err != nil {
+		return err
+	}
+	hs.srv = &http.Server{Handler: hs, ReadHeaderTimeout: 10 * time.Second}
+	go func(srv *http.Server) {
+		_ = srv.Serve(l)
+	}(hs.srv)
+	return nil
+}
+
+func (hs *httpSolver) CleanUp(_ context.Context, _ *acme.Client, _ string, chal *acme.Challenge) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	delete(hs.responses, chal.Token)
+	return nil
+}
+
+// Close stops HTTP server, if it was started.
+func (hs *httpSolver) Close() error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.srv == nil {
+		return nil
+	}
+	err := hs.srv.Close()
+	hs.srv = nil
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
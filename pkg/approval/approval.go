@@ -0,0 +1,437 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package approval implements dual control of sensitive operations performed by servers.
+// Operation that requires approval is first refused with request ID, which distinct operators then approve.
+// Requester retries operation with that ID once enough approvals were collected, each request can be used once.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation is kind of operation that can require approval.
+type Operation string
+
+const (
+	// OpIssue is issuance of leaf certificate, including signing of CSR
+	OpIssue Operation = "issue"
+	// OpIssueCA is issuance of intermediate CA
+	OpIssueCA Operation = "issue-ca"
+	// OpRevoke is revocation of leaf certificate
+	OpRevoke Operation = "revoke"
+	// OpRevokeCA is revocation of CA
+	OpRevokeCA Operation = "revoke-ca"
+)
+
+var operations = []Operation{OpIssue, OpIssueCA, OpRevoke, OpRevokeCA}
+
+const (
+	// dirName is directory next to configuration file where requests are kept
+	dirName          = "approvals"
+	defaultApprovers = 2
+	defaultTTL       = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidApproval is returned when request doesn't exist or can't be approved or used by caller.
+	ErrInvalidApproval = errors.New("invalid approval")
+	// ErrUnauthenticated is returned when caller can't be identified, so it can't request nor approve anything.
+	ErrUnauthenticated = errors.New("approval requires authenticated caller")
+
+	validID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// PendingError is returned when operation can't proceed until request is approved.
+type PendingError struct {
+	Request *Request
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("approval required: request %s to %s '%s' has %d of %d approvals",
+		e.Request.ID, e.Request.Operation, e.Request.Target, len(e.Request.Approvals), e.Request.Required)
+}
+
+// Params are parameters of operation, like subject and names of issued certificate.
+// Request is bound to their digest, so that approved request can't be used for operation with other parameters.
+type Params map[string]string
+
+// Digest gets hex-encoded SHA-256 of parameters, independent of their order.
+func (p Params) Digest() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		// length prefixes keep boundaries between keys and values unambiguous
+		_, _ = fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(p[k]), p[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CertParams describes certificate to be issued from cd.
+func CertParams(cd *certmgr.CertData) Params {
+	p := Params{
+		"parent":  cd.ParentAlias,
+		"subject": cd.Subject.String(),
+		"keySize": strconv.Itoa(cd.KeySize),
+		"profile": cd.Profile,
+		"ca":      strconv.FormatBool(cd.IsCA),
+	}
+	if cd.Validity > 0 {
+		p["validity"] = cd.Validity.String()
+	}
+	if cd.ValidYears > 0 {
+		p["validYears"] = strconv.Itoa(cd.ValidYears)
+	}
+	sans := slices.Clone(cd.DNSSan)
+	for _, ip := range cd.IPSan {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cd.URISan {
+		sans = append(sans, u.String())
+	}
+	sans = append(append(sans, cd.EmailSan...), cd.UPNSan...)
+	if len(sans) > 0 {
+		p["sans"] = strings.Join(sans, ",")
+	}
+	if c := cd.Constraints; !c.IsZero() {
+		p["maxLeafValidity"] = c.MaxLeafValidity.String()
+		p["dnsSuffixes"] = strings.Join(c.DNSSuffixes, ",")
+		p["profiles"] = strings.Join(c.Profiles, ",")
+	}
+	return p
+}
+
+// CSRParams describes certificate to be issued for CSR, bound to its exact DER encoding.
+func CSRParams(parent string, csr *x509.CertificateRequest) Params {
+	sum := sha256.Sum256(csr.Raw)
+	return Params{
+		"parent":  parent,
+		"subject": csr.Subject.String(),
+		"csr":     hex.EncodeToString(sum[:]),
+	}
+}
+
+// Approval is approval of request by single operator.
+type Approval struct {
+	By   string    `json:"by"`
+	Time time.Time `json:"time"`
+}
+
+// Request is request to perform sensitive operation.
+type Request struct {
+	ID        string    `json:"id"`
+	Operation Operation `json:"operation"`
+	// Target is alias of certificate that is issued or revoked
+	Target string `json:"target"`
+	// Params describe operation to approvers, Digest binds request to them
+	Params    Params     `json:"params,omitempty"`
+	Digest    string     `json:"digest"`
+	Requester string     `json:"requester"`
+	Required  int        `json:"required"`
+	Created   time.Time  `json:"created"`
+	Expires   time.Time  `json:"expires"`
+	Approvals []Approval `json:"approvals,omitempty"`
+	Used      bool       `json:"used,omitempty"`
+}
+
+// Approved tells whether request has enough approvals.
+func (r *Request) Approved() bool {
+	return len(r.Approvals) >= r.Required
+}
+
+func (r *Request) approvedBy(name string) bool {
+	return slices.ContainsFunc(r.Approvals, func(a Approval) bool {
+		return a.By == name
+	})
+}
+
+// Manager keeps requests for approval. Nil manager doesn't require approval of any operation.
+type Manager struct {
+	dir       string
+	ops       []Operation
+	approvers int
+	ttl       time.Duration
+	cm        certmgr.Issuer
+	mu        sync.Mutex
+}
+
+// New creates manager as configured in configuration file. Requests are recorded in audit log of cm.
+// Nil is returned when no operation requires approval.
+func New(configPath string, cm certmgr.Issuer) (*Manager, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	a := &cfg.Approval
+	if len(a.Operations) == 0 {
+		return nil, nil
+	}
+	m := &Manager{
+		dir:       filepath.Join(filepath.Dir(configPath), dirName),
+		approvers: a.Approvers,
+		ttl:       a.TTL,
+		cm:        cm,
+	}
+	for _, op := range a.Operations {
+		if !slices.Contains(operations, Operation(op)) {
+			return nil, fmt.Errorf("unknown operation requiring approval: %s", op)
+		}
+		m.ops = append(m.ops, Operation(op))
+	}
+	if m.approvers < 0 {
+		return nil, fmt.Errorf("invalid number of approvers: %d", m.approvers)
+	}
+	if m.approvers == 0 {
+		m.approvers = defaultApprovers
+	}
+	if m.ttl < 0 {
+		return nil, fmt.Errorf("invalid TTL of approval: %s", m.ttl)
+	}
+	if m.ttl == 0 {
+		m.ttl = defaultTTL
+	}
+	return m, nil
+}
+
+// Required tells whether operation requires approval.
+func (m *Manager) Required(op Operation) bool {
+	return m != nil && slices.Contains(m.ops, op)
+}
+
+func (m *Manager) load(id string) (*Request, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("%w: malformed request ID '%s'", ErrInvalidApproval, id)
+	}
+	data, err := os.ReadFile(filepath.Join(m.dir, id+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: request %s not found", ErrInvalidApproval, id)
+		}
+		return nil, err
+	}
+	r := &Request{}
+	if err = json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("corrupted approval request %s: %w", id, err)
+	}
+	return r, nil
+}
+
+// save writes request to temporary file first, so that it's never left incomplete.
+func (m *Manager) save(r *Request) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(m.dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(m.dir, ".request-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(m.dir, r.ID+".json"))
+}
+
+// request creates new request and records it in audit log.
+func (m *Manager) request(ctx context.Context, op Operation, target, requester string, params Params) (*Request, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	r := &Request{
+		ID:        hex.EncodeToString(id[:]),
+		Operation: op,
+		Target:    target,
+		Params:    params,
+		Digest:    params.Digest(),
+		Requester: requester,
+		Required:  m.approvers,
+		Created:   now,
+		Expires:   now.Add(m.ttl),
+	}
+	if err := m.save(r); err != nil {
+		return nil, err
+	}
+	return r, m.cm.Audit(ctx, certmgr.AuditApprovalRequest, target, map[string]string{
+		"id":        r.ID,
+		"operation": string(op),
+		"requester": requester,
+		"digest":    r.Digest,
+	})
+}
+
+// Authorize checks that requester may perform operation on target.
+// When operation requires approval and id is empty, new request is created and returned within PendingError.
+// Otherwise request of id must be made by the same requester for the same operation with the same parameters
+// and have enough approvals. Request is marked as used before operation is performed, so failed operation needs
+// to be approved again.
+func (m *Manager) Authorize(ctx context.Context, op Operation, target, requester, id string, params Params) error {
+	if !m.Required(op) {
+		return nil
+	}
+	if len(requester) == 0 {
+		return ErrUnauthenticated
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(id) == 0 {
+		r, err := m.request(ctx, op, target, requester, params)
+		if err != nil {
+			return err
+		}
+		return &PendingError{Request: r}
+	}
+	r, err := m.load(id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case r.Operation != op || r.Target != target:
+		return fmt.Errorf("%w: request %s is to %s '%s'", ErrInvalidApproval, id, r.Operation, r.Target)
+	case r.Digest != params.Digest():
+		return fmt.Errorf("%w: parameters differ from those of request %s (digest %s)", ErrInvalidApproval, id, r.Digest)
+	case r.Requester != requester:
+		return fmt.Errorf("%w: request %s was made by someone else", ErrInvalidApproval, id)
+	case r.Used:
+		return fmt.Errorf("%w: request %s was already used", ErrInvalidApproval, id)
+	case time.Now().After(r.Expires):
+		return fmt.Errorf("%w: request %s expired", ErrInvalidApproval, id)
+	case !r.Approved():
+		return &PendingError{Request: r}
+	}
+	r.Used = true
+	if err = m.save(r); err != nil {
+		return err
+	}
+	var by []string
+	for _, a := range r.Approvals {
+		by = append(by, a.By)
+	}
+	return m.cm.Audit(ctx, certmgr.AuditApprovalUse, target, map[string]string{
+		"id":        r.ID,
+		"operation": string(op),
+		"requester": requester,
+		"approvers": strings.Join(by, ","),
+		"digest":    r.Digest,
+	})
+}
+
+// Approve records approval of request by approver, who must be someone else than requester.
+func (m *Manager) Approve(ctx context.Context, id, approver string) (*Request, error) {
+	if m == nil {
+		return nil, fmt.Errorf("%w: no operation requires approval", ErrInvalidApproval)
+	}
+	if len(approver) == 0 {
+		return nil, ErrUnauthenticated
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.load(id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r.Used:
+		return nil, fmt.Errorf("%w: request %s was already used", ErrInvalidApproval, id)
+	case time.Now().After(r.Expires):
+		return nil, fmt.Errorf("%w: request %s expired", ErrInvalidApproval, id)
+	case r.Requester == approver:
+		return nil, fmt.Errorf("%w: requester can't approve own request", ErrInvalidApproval)
+	case r.approvedBy(approver):
+		return nil, fmt.Errorf("%w: request %s was already approved by %s", ErrInvalidApproval, id, approver)
+	}
+	r.Approvals = append(r.Approvals, Approval{By: approver, Time: time.Now().UTC()})
+	if err = m.save(r); err != nil {
+		return nil, err
+	}
+	return r, m.cm.Audit(ctx, certmgr.AuditApprove, r.Target, map[string]string{
+		"id":        r.ID,
+		"operation": string(r.Operation),
+		"approver":  approver,
+		"approvals": strconv.Itoa(len(r.Approvals)),
+	})
+}
+
+// Pending gets requests that can still be approved or used, oldest first.
+func (m *Manager) Pending(ctx context.Context) ([]*Request, error) {
+	if m == nil {
+		return nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	var res []*Request
+	for _, e := range entries {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validID.MatchString(id) {
+			continue
+		}
+		r, err := m.load(id)
+		if err != nil {
+			return nil, err
+		}
+		if !r.Used && now.Before(r.Expires) {
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res, nil
+}
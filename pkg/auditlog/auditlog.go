@@ -0,0 +1,157 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package auditlog shows audit log of operations performed on store.
+package auditlog
+
+import (
+	"context"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+type showData struct {
+	w     io.Writer
+	dir   string
+	op    string
+	alias string
+	user  string
+	since string
+	until string
+	of    common.OutputFormat
+}
+
+var ops = []certmgr.AuditOp{
+	certmgr.AuditCreate, certmgr.AuditSign, certmgr.AuditImport, certmgr.AuditRevoke, certmgr.AuditDelete,
+	certmgr.AuditApprovalRequest, certmgr.AuditApprove, certmgr.AuditApprovalUse,
+}
+
+// parseTime parses either RFC 3339 timestamp, or duration which is subtracted from now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 timestamp or duration like 24h", s)
+	}
+	return now.Add(-d), nil
+}
+
+func (d *showData) filter() (*certmgr.AuditFilter, error) {
+	var err error
+	now := time.Now()
+	f := &certmgr.AuditFilter{
+		Op:    certmgr.AuditOp(d.op),
+		Alias: d.alias,
+		User:  d.user,
+	}
+	if f.Since, err = parseTime(d.since, now); err != nil {
+		return nil, err
+	}
+	if f.Until, err = parseTime(d.until, now); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func validate(d *showData) error {
+	if len(d.op) > 0 && !slices.Contains(ops, certmgr.AuditOp(d.op)) {
+		return fmt.Errorf("unknown operation %q, expected one of %s", d.op, strings.Join(lo.Map(ops, func(op certmgr.AuditOp, _ int) string {
+			return string(op)
+		}), ", "))
+	}
+	_, err := d.filter()
+	return err
+}
+
+// formatParams formats parameters as key=value pairs, sorted by key.
+func formatParams(params map[string]string) string {
+	keys := lo.Keys(params)
+	slices.Sort(keys)
+	return strings.Join(lo.Map(keys, func(k string, _ int) string {
+		return k + "=" + params[k]
+	}), " ")
+}
+
+func show(ctx context.Context, d *showData) error {
+	f, err := d.filter()
+	if err != nil {
+		return err
+	}
+	var cm certmgr.Reader = certmgr.New(d.dir)
+	res, err := cm.AuditLog(ctx, f)
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		res = make([]certmgr.AuditRecord, 0)
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Time", "Operation", "Alias", "Serial", "Subject", "User", "Parameters",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, r := range res {
+			tbl.Append([]string{r.Time.Local().Format(time.DateTime), string(r.Op), r.Alias, r.Serial, r.Subject, r.User, formatParams(r.Params)})
+		}
+	})
+}
+
+func newShowSubCommand(w io.Writer) *cobra.Command {
+	d := &showData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show records of audit log, oldest first",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validate(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			return show(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.op, "op", d.op, "Only show records of given operation, one of create, sign, import, revoke, delete, approval-request, approve, approval-use")
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Only show records of given alias")
+	cmd.Flags().StringVar(&d.user, "user", d.user, "Only show records of operations invoked by given user")
+	cmd.Flags().StringVar(&d.since, "since", d.since, "Only show records not older than given RFC 3339 timestamp or duration, like 24h")
+	cmd.Flags().StringVar(&d.until, "until", d.until, "Only show records older than given RFC 3339 timestamp or duration, like 1h")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit-log",
+		Short: "Inspect audit log of create, sign, import, revoke and delete operations, and of approvals",
+	}
+	cmd.AddCommand(newShowSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bench measures performance of cryptographic operations on current host.
+package bench
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyGenerators generate key of given type, ignoring the key itself.
+var keyGenerators = map[string]func() error{
+	"rsa2048": func() error { return genRSA(2048) },
+	"rsa3072": func() error { return genRSA(3072) },
+	"rsa4096": func() error { return genRSA(4096) },
+	"ec-p256": func() error { return genEC(elliptic.P256()) },
+	"ec-p384": func() error { return genEC(elliptic.P384()) },
+	"ec-p521": func() error { return genEC(elliptic.P521()) },
+	"ed25519": func() error {
+		_, _, err := ed25519.GenerateKey(rand.Reader)
+		return err
+	},
+}
+
+func genRSA(bits int) error {
+	_, err := rsa.GenerateKey(rand.Reader, bits)
+	return err
+}
+
+func genEC(c elliptic.Curve) error {
+	_, err := ecdsa.GenerateKey(c, rand.Reader)
+	return err
+}
+
+type keygenData struct {
+	w       io.Writer
+	types   []string
+	count   int
+	workers int
+	of      common.OutputFormat
+}
+
+type keygenResult struct {
+	Type       string  `json:"type" yaml:"type"`
+	Count      int     `json:"count" yaml:"count"`
+	Workers    int     `json:"workers" yaml:"workers"`
+	Elapsed    float64 `json:"elapsedSeconds" yaml:"elapsedSeconds"`
+	PerKey     float64 `json:"perKeySeconds" yaml:"perKeySeconds"`
+	KeysPerSec float64 `json:"keysPerSecond" yaml:"keysPerSecond"`
+}
+
+// run generates count keys using workers goroutines, returning wall-clock time it took.
+func run(ctx context.Context, gen func() error, count, workers int) (time.Duration, error) {
+	jobs := make(chan struct{}, count)
+	for i := 0; i < count; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		err  error
+	)
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				e := ctx.Err()
+				if e == nil {
+					e = gen()
+				}
+				if e != nil {
+					once.Do(func() { err = e })
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return time.Since(start), err
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func keygen(ctx context.Context, d *keygenData) error {
+	var res []keygenResult
+	for _, t := range d.types {
+		workers := min(d.workers, d.count)
+		elapsed, err := run(ctx, keyGenerators[t], d.count, workers)
+		if err != nil {
+			return err
+		}
+		res = append(res, keygenResult{
+			Type:       t,
+			Count:      d.count,
+			Workers:    workers,
+			Elapsed:    elapsed.Seconds(),
+			PerKey:     elapsed.Seconds() / float64(d.count),
+			KeysPerSec: float64(d.count) / elapsed.Seconds(),
+		})
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Type", "Count", "Workers", "Elapsed", "Per key", "Keys/s",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, r := range res {
+			tbl.Append([]string{
+				r.Type, fmt.Sprint(r.Count), fmt.Sprint(r.Workers), seconds(r.Elapsed).Round(time.Millisecond).String(),
+				seconds(r.PerKey).Round(time.Microsecond).String(), fmt.Sprintf("%.2f", r.KeysPerSec),
+			})
+		}
+	})
+}
+
+func validateKeygen(d *keygenData) error {
+	for _, t := range d.types {
+		if _, ok := keyGenerators[t]; !ok {
+			return fmt.Errorf("unknown key type '%s', expected one of %s", t, strings.Join(keyTypes(), ", "))
+		}
+	}
+	if d.count < 1 {
+		return fmt.Errorf("count must be positive, got %d", d.count)
+	}
+	if d.workers < 1 {
+		d.workers = runtime.GOMAXPROCS(0)
+	}
+	return nil
+}
+
+func keyTypes() []string {
+	res := lo.Keys(keyGenerators)
+	slices.Sort(res)
+	return res
+}
+
+func newKeygenSubCommand(w io.Writer) *cobra.Command {
+	d := &keygenData{
+		w:     w,
+		types: []string{"rsa2048", "rsa4096", "ec-p256"},
+		count: 10,
+	}
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Measure throughput of key generation",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateKeygen(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			return keygen(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringSliceVar(&d.types, "type", d.types, "Key type to measure, one of "+strings.Join(keyTypes(), ", ")+". Can be repeated")
+	cmd.Flags().IntVar(&d.count, "count", d.count, "Number of keys to generate of every type")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many keys to generate concurrently, defaults to number of CPUs")
+	return cmd
+}
+
+func NewCommand(w io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure performance of cryptographic operations on this host",
+	}
+	cmd.AddCommand(newKeygenSubCommand(w))
+	return cmd
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+type initData struct {
+	w            io.Writer
+	errw         io.Writer
+	dir          string
+	cn           string
+	org          []string
+	bits         int
+	intermediate bool
+	leaf         string
+	rootAlias    string
+	imAlias      string
+	rootYears    int
+	imYears      int
+	leafYears    int
+	workers      int
+}
+
+func validate(d *initData) error {
+	if len(d.cn) == 0 {
+		return errors.New("common name is required")
+	}
+	return nil
+}
+
+func (d *initData) name(cn string) pkix.Name {
+	return pkix.Name{
+		CommonName:   cn,
+		Organization: d.org,
+	}
+}
+
+func bootstrap(ctx context.Context, d *initData) error {
+	progress := common.NewSpinner(d.errw)
+	cm := certmgr.New(d.dir, certmgr.WithProgress(progress))
+	root := &certmgr.CertData{
+		KeySize:    d.bits,
+		ValidYears: d.rootYears,
+		Alias:      d.rootAlias,
+		Subject:    d.name(d.cn + " Root CA"),
+	}
+	root.Issuer = root.Subject
+	cds := []*certmgr.CertData{root}
+	parent := d.rootAlias
+	var im, leaf *certmgr.CertData
+	if d.intermediate {
+		im = &certmgr.CertData{
+			KeySize:     d.bits,
+			ValidYears:  d.imYears,
+			Alias:       d.imAlias,
+			ParentAlias: parent,
+			Subject:     d.name(d.cn + " Intermediate CA"),
+		}
+		cds = append(cds, im)
+		parent = d.imAlias
+	}
+	if len(d.leaf) > 0 {
+		leaf = &certmgr.CertData{
+			KeySize:     d.bits,
+			ValidYears:  d.leafYears,
+			Alias:       d.leaf,
+			ParentAlias: parent,
+			Subject:     d.name(d.leaf),
+		}
+		leaf.AddSAN(d.leaf)
+		cds = append(cds, leaf)
+	}
+	// keys don't depend on each other, unlike certificates
+	if err := certmgr.GenerateKeys(ctx, progress, d.workers, cds...); err != nil {
+		return err
+	}
+	if err := cm.NewRootCA(ctx, root); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(d.w, "Created root CA '%s'\n", d.rootAlias); err != nil {
+		return err
+	}
+	if im != nil {
+		if err := cm.NewIntermediateCA(ctx, im); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(d.w, "Created intermediate CA '%s'\n", d.imAlias); err != nil {
+			return err
+		}
+	}
+	if leaf != nil {
+		if err := cm.NewLeaf(ctx, leaf); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(d.w, "Created leaf certificate '%s'\n", d.leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewCommand(w io.Writer) *cobra.Command {
+	d := &initData{
+		w:         w,
+		dir:       ".",
+		bits:      4096,
+		rootAlias: "rootCA",
+		imAlias:   "intermediateCA",
+		rootYears: 10,
+		imYears:   5,
+		leafYears: 1,
+	}
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Bootstrap PKI with root CA, optional intermediate CA and optional leaf certificate",
+		Args:  cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validate(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return bootstrap(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.cn, "cn", d.cn, "Common name prefix used for CA certificates, like 'Acme'")
+	cmd.Flags().StringArrayVar(&d.org, "organization", d.org, "Organization components of subject DN of all certificates")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().BoolVar(&d.intermediate, "intermediate", d.intermediate, "Whether to create intermediate CA signed by root CA")
+	cmd.Flags().StringVar(&d.leaf, "leaf", d.leaf, "DNS name or IP address of optional leaf certificate. Also used as its alias")
+	cmd.Flags().StringVar(&d.rootAlias, "root-alias", d.rootAlias, "Alias of root CA")
+	cmd.Flags().StringVar(&d.imAlias, "intermediate-alias", d.imAlias, "Alias of intermediate CA")
+	cmd.Flags().IntVar(&d.rootYears, "root-years", d.rootYears, "How many years should root CA be valid for")
+	cmd.Flags().IntVar(&d.imYears, "intermediate-years", d.imYears, "How many years should intermediate CA be valid for")
+	cmd.Flags().IntVar(&d.leafYears, "leaf-years", d.leafYears, "How many years should leaf certificate be valid for")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many keys to generate concurrently, defaults to number of CPUs")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package caa checks DNS Certification Authority Authorization records (RFC 8659) before certificate is issued.
+package caa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrForbidden is returned when CAA records don't allow issuer to issue certificate for DNS name.
+var ErrForbidden = errors.New("CAA records forbid issuance")
+
+const (
+	tagIssue     = "issue"
+	tagIssueWild = "issuewild"
+	tagIodef     = "iodef"
+	// flagCritical marks property that must be understood by issuer
+	flagCritical = 128
+
+	resolvConf = "/etc/resolv.conf"
+)
+
+// Checker checks CAA records of DNS names.
+type Checker struct {
+	// Issuer is domain that identifies CA in issue and issuewild properties, like ca.example.com
+	Issuer string
+	// Server is address of DNS resolver, like 10.0.0.1:53. First nameserver from /etc/resolv.conf is used when empty
+	Server string
+	// Timeout of single DNS query
+	Timeout time.Duration
+}
+
+func (c *Checker) server() (string, error) {
+	if len(c.Server) > 0 {
+		if _, _, err := net.SplitHostPort(c.Server); err != nil {
+			return net.JoinHostPort(c.Server, "53"), nil
+		}
+		return c.Server, nil
+	}
+	cc, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil {
+		return "", fmt.Errorf("can't find DNS resolver: %w", err)
+	}
+	if len(cc.Servers) == 0 {
+		return "", fmt.Errorf("can't find DNS resolver: no nameserver in %s", resolvConf)
+	}
+	return net.JoinHostPort(cc.Servers[0], cc.Port), nil
+}
+
+// lookup gets CAA records of name. Nil is returned when there are none.
+func (c *Checker) lookup(ctx context.Context, server, name string) ([]*dns.CAA, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeCAA)
+	m.RecursionDesired = true
+	client := &dns.Client{Timeout: c.Timeout}
+	resp, _, err := client.ExchangeContext(ctx, m, server)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, m, server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CAA lookup of %s failed: %w", name, err)
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return nil, fmt.Errorf("CAA lookup of %s failed: %s", name, dns.RcodeToString[resp.Rcode])
+	}
+	var res []*dns.CAA
+	for _, rr := range resp.Answer {
+		if caa, ok := rr.(*dns.CAA); ok {
+			res = append(res, caa)
+		}
+	}
+	return res, nil
+}
+
+// Check checks that CAA records allow issuer to issue certificate for DNS name, which can be wildcard.
+// Records of name are looked up first, then those of its parent domains, until some are found.
+// Name without any such records can be issued by anyone. Failed lookup is reported as error.
+func (c *Checker) Check(ctx context.Context, name string) error {
+	server, err := c.server()
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSuffix(name, ".")
+	domain, wildcard := strings.CutPrefix(name, "*.")
+	for len(domain) > 0 {
+		records, err := c.lookup(ctx, server, domain)
+		if err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			return c.allowed(name, domain, records, wildcard)
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return nil
+}
+
+// allowed evaluates relevant record set found at domain.
+func (c *Checker) allowed(name, domain string, records []*dns.CAA, wildcard bool) error {
+	var issue, issueWild []string
+	for _, r := range records {
+		switch strings.ToLower(r.Tag) {
+		case tagIssue:
+			issue = append(issue, r.Value)
+		case tagIssueWild:
+			issueWild = append(issueWild, r.Value)
+		case tagIodef:
+		default:
+			if r.Flag&flagCritical != 0 {
+				return fmt.Errorf("%w for %s: unknown critical property %s at %s", ErrForbidden, name, r.Tag, domain)
+			}
+		}
+	}
+	values := issue
+	if wildcard && len(issueWild) > 0 {
+		values = issueWild
+	}
+	if len(values) == 0 {
+		// only iodef or unknown non-critical properties, issuance is not restricted
+		return nil
+	}
+	for _, v := range values {
+		// value is issuer domain, optionally followed by parameters separated by semicolon
+		issuer, _, _ := strings.Cut(v, ";")
+		if strings.EqualFold(strings.TrimSpace(issuer), c.Issuer) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w for %s: %s is not among issuers allowed at %s", ErrForbidden, name, c.Issuer, domain)
+}
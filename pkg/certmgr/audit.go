@@ -0,0 +1,195 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditOp is type of operation recorded in audit log.
+type AuditOp string
+
+const (
+	AuditCreate AuditOp = "create"
+	AuditSign   AuditOp = "sign"
+	AuditImport AuditOp = "import"
+	AuditRevoke AuditOp = "revoke"
+	AuditDelete AuditOp = "delete"
+	// AuditApprovalRequest is request for approval of sensitive operation
+	AuditApprovalRequest AuditOp = "approval-request"
+	// AuditApprove is approval of request by single approver
+	AuditApprove AuditOp = "approve"
+	// AuditApprovalUse is execution of approved operation
+	AuditApprovalUse AuditOp = "approval-use"
+)
+
+// AuditRecord is single entry of audit log.
+type AuditRecord struct {
+	Time time.Time `json:"time" yaml:"time"`
+	Op   AuditOp   `json:"op" yaml:"op"`
+	// Alias is empty when signed certificate was not stored
+	Alias   string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Serial  string `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Subject string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	// User is name of operating system user that invoked operation
+	User string `json:"user" yaml:"user"`
+	// Params are details of operation, like issuing CA, validity or SANs
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// AuditFilter selects records of audit log. Zero values match any record.
+type AuditFilter struct {
+	Op    AuditOp
+	Alias string
+	User  string
+	Since time.Time
+	Until time.Time
+}
+
+func (f *AuditFilter) matches(r *AuditRecord) bool {
+	if f == nil {
+		return true
+	}
+	return (len(f.Op) == 0 || f.Op == r.Op) &&
+		(len(f.Alias) == 0 || f.Alias == r.Alias) &&
+		(len(f.User) == 0 || f.User == r.User) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until))
+}
+
+// auditUser gets name of user running this process.
+var auditUser = sync.OnceValue(func() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	for _, env := range []string{"USER", "USERNAME"} {
+		if name := os.Getenv(env); len(name) > 0 {
+			return name
+		}
+	}
+	return "unknown"
+})
+
+// certParams describes issued certificate for audit log.
+func certParams(cert *x509.Certificate, params map[string]string) map[string]string {
+	if params == nil {
+		params = map[string]string{}
+	}
+	params["notBefore"] = cert.NotBefore.UTC().Format(time.RFC3339)
+	params["notAfter"] = cert.NotAfter.UTC().Format(time.RFC3339)
+	params["isCA"] = strconv.FormatBool(cert.IsCA)
+	if len(cert.DNSNames) > 0 {
+		params["dns"] = strings.Join(cert.DNSNames, ",")
+	}
+	if len(cert.IPAddresses) > 0 {
+		var ips []string
+		for _, ip := range cert.IPAddresses {
+			ips = append(ips, ip.String())
+		}
+		params["ip"] = strings.Join(ips, ",")
+	}
+	if len(cert.URIs) > 0 {
+		var uris []string
+		for _, u := range cert.URIs {
+			uris = append(uris, u.String())
+		}
+		params["uri"] = strings.Join(uris, ",")
+	}
+	return params
+}
+
+// audit appends record of operation to audit log, if store keeps one.
+// Certificate is optional, params are extended by its details.
+func (cm *certMgr) audit(ctx context.Context, op AuditOp, alias string, cert *x509.Certificate, params map[string]string) error {
+	al, ok := cm.store.(store.AuditLog)
+	if !ok {
+		return nil
+	}
+	rec := &AuditRecord{
+		Time:   time.Now().UTC(),
+		Op:     op,
+		Alias:  alias,
+		User:   auditUser(),
+		Params: params,
+	}
+	if cert != nil {
+		rec.Serial = cert.SerialNumber.String()
+		rec.Subject = cert.Subject.String()
+		rec.Params = certParams(cert, params)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err = al.AppendAudit(ctx, data); err != nil {
+		return fmt.Errorf("can't write audit log: %w", err)
+	}
+	return nil
+}
+
+func (cm *certMgr) Audit(ctx context.Context, op AuditOp, alias string, params map[string]string) error {
+	return cm.audit(ctx, op, alias, nil, params)
+}
+
+func (cm *certMgr) AuditLog(ctx context.Context, filter *AuditFilter) ([]AuditRecord, error) {
+	al, ok := cm.store.(store.AuditLog)
+	if !ok {
+		return nil, ErrAuditUnsupported
+	}
+	rc, err := al.OpenAudit(ctx)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	var res []AuditRecord
+	sc := bufio.NewScanner(rc)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec AuditRecord
+		if err = json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("corrupted audit log record at line %d: %w", line, err)
+		}
+		if filter.matches(&rec) {
+			res = append(res, rec)
+		}
+	}
+	return res, sc.Err()
+}
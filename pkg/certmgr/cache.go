@@ -0,0 +1,86 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/x509"
+	"sync"
+)
+
+// certCacheSize is maximum number of parsed certificates kept in memory.
+// It keeps memory use bounded for stores with hundreds of thousands of certificates.
+const certCacheSize = 4096
+
+// certCache keeps recently used parsed certificates along with PEM data they were parsed from.
+// Data is still read from store every time, so changes made by other processes are noticed,
+// but parsing is skipped as long as data didn't change. This makes repeated scans of store,
+// like those done when building chains or listing certificates by long-running servers, cheap.
+type certCache struct {
+	mu sync.Mutex
+	// least recently used entry is at the back
+	lru     list.List
+	entries map[string]*list.Element
+}
+
+type cachedCert struct {
+	alias string
+	data  []byte
+	cert  *x509.Certificate
+}
+
+// get gets certificate of alias parsed from the same data, or nil.
+func (c *certCache) get(alias string, data []byte) *x509.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[alias]; ok {
+		if e := el.Value.(*cachedCert); bytes.Equal(e.data, data) {
+			c.lru.MoveToFront(el)
+			return e.cert
+		}
+	}
+	return nil
+}
+
+func (c *certCache) put(alias string, data []byte, cert *x509.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*list.Element{}
+	}
+	if el, ok := c.entries[alias]; ok {
+		el.Value = &cachedCert{alias: alias, data: data, cert: cert}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[alias] = c.lru.PushFront(&cachedCert{alias: alias, data: data, cert: cert})
+	if c.lru.Len() > certCacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedCert).alias)
+	}
+}
+
+func (c *certCache) evict(alias string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[alias]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, alias)
+	}
+}
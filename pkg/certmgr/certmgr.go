@@ -17,18 +17,26 @@ limitations under the License.
 package certmgr
 
 import (
-	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"fmt"
-	"github.com/samber/lo"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"go.opentelemetry.io/otel/attribute"
+	"io/fs"
 	"math/big"
 	"net"
-	"os"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,94 +45,190 @@ const (
 	typeRsaPrivateKey = "RSA PRIVATE KEY"
 )
 
-type Interface interface {
-	NewRootCA(cd *CertData) error
-	NewIntermediateCA(cd *CertData) error
-	// NewLeaf creates new leaf certificate and private key
-	NewLeaf(cd *CertData) error
+// Reader provides read-only access to certificates.
+// All methods accept context, which can be used to cancel operation or to propagate deadline.
+type Reader interface {
 	// List lists all aliases.
-	List() ([]string, error)
+	List(ctx context.Context) ([]string, error)
+	// ListStream lists aliases lazily, optionally with their certificates. Options can be nil.
+	ListStream(ctx context.Context, opts *ListOptions) (*Stream, error)
+	// Get gets both certificate and private key for given alias.
+	Get(ctx context.Context, alias string) (*PairHolder, error)
+	// GetCert gets certificate of given alias. Unlike Get, it doesn't require private key to be present.
+	// Parsed certificates are cached, so returned certificate must not be modified.
+	GetCert(ctx context.Context, alias string) (*x509.Certificate, error)
+	// GetChain gets chain of certificates starting with given alias, ordered from alias up to the root CA.
+	// Private keys are not loaded.
+	// See BuildChain for details.
+	GetChain(ctx context.Context, alias string) ([]*PairHolder, error)
+	// GetRevocation gets revocation record of alias, or nil if certificate was not revoked.
+	GetRevocation(ctx context.Context, alias string) (*Revocation, error)
+	// GetConstraints gets constraints of certificates issued by CA of given alias, or nil if it has none.
+	GetConstraints(ctx context.Context, alias string) (*Constraints, error)
+	// GetEscrow gets recovery copy of private key of alias, as produced by sealer given to WithEscrow.
+	// Error matching ErrNoEscrow is returned when key of alias was not escrowed.
+	GetEscrow(ctx context.Context, alias string) ([]byte, error)
+	// AuditLog gets records of audit log that match filter, in order they were written. Filter can be nil.
+	// Only stores that implement store.AuditLog keep audit log, ErrAuditUnsupported is returned otherwise.
+	AuditLog(ctx context.Context, filter *AuditFilter) ([]AuditRecord, error)
+}
+
+// Issuer creates and deletes certificates.
+// All methods accept context, which can be used to cancel operation or to propagate deadline.
+type Issuer interface {
+	NewRootCA(ctx context.Context, cd *CertData) error
+	NewIntermediateCA(ctx context.Context, cd *CertData) error
+	// NewLeaf creates new leaf certificate and private key
+	NewLeaf(ctx context.Context, cd *CertData) error
 	// Delete removes both certificate and private key file corresponding to given alias.
 	// Ignore any "not found" errors.
-	Delete(alias string) error
-	// Get gets both certificate and private key for given alias.
-	Get(alias string) (*PairHolder, error)
+	Delete(ctx context.Context, alias string) error
+	// Import stores existing certificate under alias, together with optional private key and chain of its issuers.
+	Import(ctx context.Context, alias string, cert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate) error
+	// Revoke marks certificate of alias as revoked for given reason.
+	Revoke(ctx context.Context, alias string, reason RevocationReason) error
+	// SignCSR issues certificate for given certificate signing request, using parent alias as issuing CA.
+	SignCSR(ctx context.Context, parent string, csr *x509.CertificateRequest, opts *SignOptions) (*x509.Certificate, error)
+	// Audit records operation performed outside of certificate manager, like approval of sensitive operation.
+	Audit(ctx context.Context, op AuditOp, alias string, params map[string]string) error
+}
+
+// Interface is certificate manager, providing both read and issuing access.
+type Interface interface {
+	Reader
+	Issuer
 }
 
 // PairHolder is structure to wrap both certificate and corresponding private key
 type PairHolder struct {
-	Cert *x509.Certificate
-	Key  *rsa.PrivateKey
+	// Alias of pair, empty if pair is not loaded from directory
+	Alias string
+	Cert  *x509.Certificate
+	Key   crypto.Signer
 }
 
 type certMgr struct {
-	// root directory where certificates and private keys are stored
-	dir string
+	// backend where certificates and private keys are stored
+	store store.Interface
+	// guards access to store
+	mu sync.RWMutex
+	// aliases that are being created, but not yet saved
+	pending map[string]struct{}
+	// hooks invoked after lifecycle events
+	hooks []Hook
+	// receiver of progress notifications
+	progress Progress
+	// certificates parsed so far
+	cache certCache
+	// private keys not kept in store, see WithKey
+	keys map[string]crypto.Signer
+	// escrow of generated private keys, see WithEscrow
+	escrow *escrow
 }
 
-// aliasToFile
-func (cm *certMgr) aliasToFile(alias string, private bool) string {
-	suffix := "pem"
-	if private {
-		suffix = "key"
+// exists checks if any object of alias exists in store. Caller must hold lock.
+func (cm *certMgr) exists(ctx context.Context, alias string) (bool, error) {
+	for _, kind := range []store.Kind{store.KindCert, store.KindKey} {
+		found, err := cm.store.Exists(ctx, alias, kind)
+		if err != nil || found {
+			return found, err
+		}
 	}
-	return fmt.Sprintf("%s/%s.%s", cm.dir, alias, suffix)
+	return false, nil
 }
 
-// doesAliasFileExist checks if given alias resolves into existing file.
-func (cm *certMgr) doesAliasFileExist(alias string, private bool) bool {
-	if _, err := os.Stat(cm.aliasToFile(alias, private)); err != nil {
-		return !os.IsNotExist(err)
+// reserve marks alias as being created, so that concurrent attempt to create the same alias fails early.
+func (cm *certMgr) reserve(ctx context.Context, alias string) error {
+	if err := ValidateAlias(alias); err != nil {
+		return err
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	found, err := cm.exists(ctx, alias)
+	if err != nil {
+		return err
+	}
+	if _, pending := cm.pending[alias]; found || pending {
+		return fmt.Errorf("%w: %s", ErrAliasExists, alias)
 	}
-	return true
+	cm.pending[alias] = struct{}{}
+	return nil
 }
 
-// isAliasFilename checks if provided filename is valid file for alias.
-// it could be either private key file (.key) or certificate file (.pem).
-func (cm *certMgr) isAliasFilename(file string) bool {
-	return strings.HasSuffix(file, ".pem") || strings.HasSuffix(file, ".key")
+// release removes alias reservation made by reserve.
+func (cm *certMgr) release(alias string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.pending, alias)
 }
 
-// fileToAlias extracts alias from filename.
-// No checks are done here, it's expected that isAliasFile was called before using this function.
-func (cm *certMgr) fileToAlias(file string) string {
-	return file[0 : len(file)-4]
+// nextSerial allocates serial number for new certificate.
+// Serial is random positive number of up to 127 bits, so it's unique with overwhelming probability.
+func nextSerial() (*big.Int, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	return n.Add(n, big.NewInt(1)), nil
 }
 
-func (cm *certMgr) Delete(alias string) error {
-	err := os.Remove(cm.aliasToFile(alias, true))
-	if err != nil && !os.IsNotExist(err) {
+// remove removes both objects of alias, ignoring any "not found" errors.
+func (cm *certMgr) remove(ctx context.Context, alias string) error {
+	if err := ValidateAlias(alias); err != nil {
 		return err
 	}
-	err = os.Remove(cm.aliasToFile(alias, false))
-	if err != nil && !os.IsNotExist(err) {
-		return err
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.cache.evict(alias)
+	for _, kind := range []store.Kind{store.KindKey, store.KindCert, store.KindRevocation, store.KindChain, store.KindConstraints, store.KindEscrow} {
+		if err := cm.store.Delete(ctx, alias, kind); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
 	}
 	return nil
 }
 
-func (cm *certMgr) List() ([]string, error) {
-	entries, err := os.ReadDir(cm.dir)
-	if err != nil {
+func (cm *certMgr) Delete(ctx context.Context, alias string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// certificate is only needed for audit log, alias may be already gone
+	cert, _ := cm.GetCert(ctx, alias)
+	if err := cm.remove(ctx, alias); err != nil {
+		return err
+	}
+	if err := cm.audit(ctx, AuditDelete, alias, cert, nil); err != nil {
+		return err
+	}
+	return cm.fire(ctx, EventDelete, alias, cert)
+}
+
+func (cm *certMgr) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	return lo.Uniq(lo.Map(lo.Filter(entries, func(entry os.DirEntry, _ int) bool {
-		return cm.isAliasFilename(entry.Name())
-	}), func(item os.DirEntry, _ int) string {
-		return cm.fileToAlias(item.Name())
-	}),
-	), nil
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.store.List(ctx)
 }
 
-func (cm *certMgr) Get(alias string) (*PairHolder, error) {
-	return cm.load(alias)
+func (cm *certMgr) Get(ctx context.Context, alias string) (*PairHolder, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return cm.load(ctx, alias)
 }
 
 type CertData struct {
-	KeySize     int
-	ValidYears  int
+	KeySize    int
+	ValidYears int
+	// Validity, when set, takes precedence over ValidYears
+	Validity    time.Duration
 	IPSan       []net.IP
 	DNSSan      []string
+	URISan      []*url.URL
+	EmailSan    []string
+	UPNSan      []string
 	Alias       string
 	ParentAlias string
 	SelfSigned  bool
@@ -132,9 +236,81 @@ type CertData struct {
 	Issuer      pkix.Name
 	Subject     pkix.Name
 	Serial      int64
+	// Key is existing key to use instead of generating new one
+	Key crypto.Signer
+	// ExtKeyUsage of leaf certificate. When empty, both client and server authentication is allowed.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// UnknownExtKeyUsage are extended key usages of leaf certificate not known to crypto/x509, like smart card logon
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	// DelegationUsage allows leaf certificate to issue delegated credentials for TLS (RFC 9345)
+	DelegationUsage bool
+	// SecurityID is security identifier (SID) of Active Directory account certificate maps to, see ParseSecurityID
+	SecurityID string
+	// HardwareModuleSan identifies hardware module holding key, as in IEEE 802.1AR device identity
+	HardwareModuleSan []HardwareModuleName
+	// AllowAnyWildcard disables validation of wildcard DNS names of leaf certificate
+	AllowAnyWildcard bool
+	// AllowUnderscore allows underscores in DNS names of leaf certificate, like in service names
+	AllowUnderscore bool
+	// NoCommonNameSAN disables copying of subject common name into subject alternative names of leaf certificate
+	NoCommonNameSAN bool
+	// WriteChain stores certificates of intermediate CAs that issued leaf certificate as its chain
+	WriteChain bool
+	// Profile is name of profile leaf certificate is issued with, checked against constraints of issuing CA
+	Profile string
+	// Constraints of certificates issued by new CA. Intermediate CA inherits constraints of its issuer that are not set.
+	Constraints *Constraints
+	// DiscardKey prevents private key from being stored, caller keeps it another way, like split into shares
+	DiscardKey bool
+	// NoExpiry sets validity end to 99991231235959Z, which means no well-defined expiration date (RFC 5280, section 4.1.2.5).
+	// It's used by device identities that are meant to last as long as device itself. Takes precedence over Validity.
+	NoExpiry bool
+}
+
+// AddSAN adds name as either IP or DNS subject alternative name, depending on its form.
+func (cd *CertData) AddSAN(name string) {
+	if ip := net.ParseIP(name); ip != nil {
+		cd.IPSan = append(cd.IPSan, ip)
+	} else {
+		cd.DNSSan = append(cd.DNSSan, name)
+	}
 }
 
-func (cm *certMgr) NewRootCA(cd *CertData) error {
+// HasSAN tells whether there is any subject alternative name.
+func (cd *CertData) HasSAN() bool {
+	return len(cd.DNSSan)+len(cd.IPSan)+len(cd.URISan)+len(cd.EmailSan)+len(cd.UPNSan)+len(cd.HardwareModuleSan) > 0
+}
+
+// copyCommonName adds subject common name as subject alternative name, unless it's already there,
+// since modern clients ignore common name when verifying host.
+// Only common names that look like DNS name or IP address of certificate usable by server are copied.
+func copyCommonName(cd *CertData) {
+	cn := cd.Subject.CommonName
+	if cd.NoCommonNameSAN || len(cn) == 0 {
+		return
+	}
+	if len(cd.ExtKeyUsage) > 0 && !slices.Contains(cd.ExtKeyUsage, x509.ExtKeyUsageServerAuth) {
+		return
+	}
+	if ip := net.ParseIP(cn); ip != nil {
+		if !slices.ContainsFunc(cd.IPSan, ip.Equal) {
+			cd.IPSan = append(cd.IPSan, ip)
+		}
+		return
+	}
+	name, err := normalizeDNSName(cn, cd.AllowUnderscore)
+	if err != nil {
+		return
+	}
+	if !slices.ContainsFunc(cd.DNSSan, func(san string) bool {
+		n, err := normalizeDNSName(san, true)
+		return err == nil && n == name
+	}) {
+		cd.DNSSan = append(cd.DNSSan, cn)
+	}
+}
+
+func (cm *certMgr) NewRootCA(ctx context.Context, cd *CertData) error {
 	if err := check(cd,
 		requireSubject(),
 		requireAlias(),
@@ -143,10 +319,10 @@ func (cm *certMgr) NewRootCA(cd *CertData) error {
 	}
 	cd.SelfSigned = true
 	cd.IsCA = true
-	return cm.create(cd)
+	return cm.create(ctx, cd)
 }
 
-func (cm *certMgr) NewIntermediateCA(cd *CertData) error {
+func (cm *certMgr) NewIntermediateCA(ctx context.Context, cd *CertData) error {
 	if err := check(cd,
 		requireSubject(),
 		requireAlias(),
@@ -156,19 +332,26 @@ func (cm *certMgr) NewIntermediateCA(cd *CertData) error {
 	}
 	cd.SelfSigned = false
 	cd.IsCA = true
-	return cm.create(cd)
+	return cm.create(ctx, cd)
 }
 
-func (cm *certMgr) NewLeaf(cd *CertData) error {
+func (cm *certMgr) NewLeaf(ctx context.Context, cd *CertData) error {
 	if err := check(cd, requireSubject(),
 		requireAlias(),
 		requireParentAlias(),
-		validAtLeastYears(1)); err != nil {
+		requireValidity()); err != nil {
+		return err
+	}
+	copyCommonName(cd)
+	if err := normalizeSANs(cd); err != nil {
+		return err
+	}
+	if err := check(cd, validWildcards()); err != nil {
 		return err
 	}
 	cd.SelfSigned = false
 	cd.IsCA = false
-	return cm.create(cd)
+	return cm.create(ctx, cd)
 }
 
 func getKeyUsage(cd *CertData) x509.KeyUsage {
@@ -179,131 +362,386 @@ func getKeyUsage(cd *CertData) x509.KeyUsage {
 	}
 }
 
-// create creates new certificate based on input data.
-func (cm *certMgr) create(cd *CertData) error {
-	var (
+// noExpiry is end of validity of certificate without well-defined expiration date
+var noExpiry = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// notAfter computes end of validity period of certificate that starts at given time.
+func notAfter(cd *CertData, from time.Time) time.Time {
+	if cd.NoExpiry {
+		return noExpiry
+	}
+	if cd.Validity > 0 {
+		return from.Add(cd.Validity)
+	}
+	return from.AddDate(cd.ValidYears, 0, 0)
+}
+
+// generateKey generates new RSA private key, giving up when ctx is done.
+// Key generation itself can't be interrupted, so it's left to finish in background.
+func generateKey(ctx context.Context, bits int) (_ *rsa.PrivateKey, err error) {
+	ctx, end := traced(ctx, "certmgr.generateKey", keygenDuration, attribute.Int("bits", bits))
+	defer func() {
+		end(err)
+	}()
+	type result struct {
+		key *rsa.PrivateKey
 		err error
-		ch  *PairHolder
-	)
+	}
+	ch := make(chan result, 1)
+	go func() {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		ch <- result{key, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.key, r.err
+	}
+}
+
+// newTemplate creates template of new certificate based on input data.
+func newTemplate(cd *CertData) (*x509.Certificate, error) {
+	var err error
+	now := time.Now()
 	newCert := &x509.Certificate{
 		Subject:               cd.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(cd.ValidYears, 0, 0),
+		NotBefore:             now,
+		NotAfter:              notAfter(cd, now),
 		IsCA:                  cd.IsCA,
 		KeyUsage:              getKeyUsage(cd),
 		BasicConstraintsValid: true,
 	}
 
-	if !cd.SelfSigned {
-		ch, err = cm.load(cd.ParentAlias)
-		if err != nil {
-			return err
-		}
-		newCert.Issuer = ch.Cert.Subject
-	} else {
-		newCert.Issuer = cd.Issuer
-	}
-
 	if cd.Serial != 0 {
 		newCert.SerialNumber = big.NewInt(cd.Serial)
-	} else {
-		newCert.SerialNumber = big.NewInt(0)
+	} else if newCert.SerialNumber, err = nextSerial(); err != nil {
+		return nil, err
 	}
 
 	if !cd.IsCA {
-		newCert.ExtKeyUsage = []x509.ExtKeyUsage{
-			x509.ExtKeyUsageClientAuth,
-			x509.ExtKeyUsageServerAuth,
+		newCert.ExtKeyUsage = cd.ExtKeyUsage
+		newCert.UnknownExtKeyUsage = cd.UnknownExtKeyUsage
+		if len(newCert.ExtKeyUsage)+len(newCert.UnknownExtKeyUsage) == 0 {
+			newCert.ExtKeyUsage = []x509.ExtKeyUsage{
+				x509.ExtKeyUsageClientAuth,
+				x509.ExtKeyUsageServerAuth,
+			}
+		}
+		if newCert.DNSNames, err = toASCIINames(cd.DNSSan); err != nil {
+			return nil, err
 		}
-		newCert.DNSNames = cd.DNSSan
 		newCert.IPAddresses = cd.IPSan
+		newCert.URIs = cd.URISan
+		newCert.EmailAddresses = cd.EmailSan
+		if hasOtherNames(cd) {
+			ext, err := marshalSAN(newCert, cd)
+			if err != nil {
+				return nil, err
+			}
+			newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
+		}
+		if cd.DelegationUsage {
+			newCert.ExtraExtensions = append(newCert.ExtraExtensions, delegationUsageExt)
+		}
+		if len(cd.SecurityID) > 0 {
+			ext, err := marshalSecurityExt(cd.SecurityID)
+			if err != nil {
+				return nil, err
+			}
+			newCert.ExtraExtensions = append(newCert.ExtraExtensions, ext)
+		}
 	}
+	return newCert, nil
+}
 
-	newKey, err := rsa.GenerateKey(rand.Reader, cd.KeySize)
+// loadParent loads issuing CA for given alias.
+func (cm *certMgr) loadParent(ctx context.Context, alias string) (*PairHolder, error) {
+	ch, err := cm.load(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if !ch.Cert.IsCA {
+		return nil, fmt.Errorf("%w: %s", ErrParentNotCA, alias)
+	}
+	return ch, nil
+}
+
+// create creates new certificate based on input data.
+func (cm *certMgr) create(ctx context.Context, cd *CertData) (err error) {
+	issuer := cd.ParentAlias
+	if cd.SelfSigned {
+		issuer = ""
+	}
+	ctx, end := traceIssuance(ctx, "create", issuer)
+	defer func() {
+		end(err)
+	}()
+	var ch *PairHolder
+	if err = cm.reserve(ctx, cd.Alias); err != nil {
+		return err
+	}
+	defer cm.release(cd.Alias)
+	newCert, err := newTemplate(cd)
+	if err != nil {
+		return err
+	}
+
+	if !cd.SelfSigned {
+		if ch, err = cm.loadParent(ctx, cd.ParentAlias); err != nil {
+			return err
+		}
+	}
+
+	keyType := "rsa" + strconv.Itoa(cd.KeySize)
+	if cd.Key != nil {
+		keyType = KeyType(cd.Key.Public())
+	}
+	if err = cm.enforcePolicy(ctx, issuer, newCert, keyType); err != nil {
+		return err
+	}
+	constraints, err := cm.enforceConstraints(ctx, issuer, newCert, cd.Profile, cd.Constraints)
 	if err != nil {
 		return err
 	}
 
-	var (
-		parentCert *x509.Certificate
-		privateKey *rsa.PrivateKey
-	)
+	newKey := cd.Key
+	if newKey == nil {
+		task := fmt.Sprintf("generating %d-bit RSA key for '%s'", cd.KeySize, cd.Alias)
+		cm.progress.Begin(task)
+		newKey, err = generateKey(ctx, cd.KeySize)
+		cm.progress.End(task, err)
+		if err != nil {
+			return err
+		}
+	}
 
 	if cd.SelfSigned {
-		parentCert = newCert
-		privateKey = newKey
-	} else {
-		privateKey = ch.Key
-		parentCert = ch.Cert
+		ch = &PairHolder{Cert: newCert, Key: newKey}
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, newCert, ch.Cert, newKey.Public(), ch.Key)
+	if err != nil {
+		return err
 	}
-	certBytes, err := x509.CreateCertificate(rand.Reader, newCert, parentCert, &newKey.PublicKey, privateKey)
+	cert, err := x509.ParseCertificate(certBytes)
 	if err != nil {
 		return err
 	}
-	return cm.save(certBytes, x509.MarshalPKCS1PrivateKey(newKey), cd.Alias)
+	// sealed before anything is saved, so that certificate is never issued without escrow
+	var sealed []byte
+	if cd.Key == nil && !cd.IsCA {
+		if sealed, err = cm.sealKey(cd.Alias, cd.Profile, newKey); err != nil {
+			return err
+		}
+	}
+	storedKey := newKey
+	if cd.DiscardKey {
+		storedKey = nil
+	}
+	if err = cm.save(ctx, certBytes, storedKey, cd.Alias); err != nil {
+		return err
+	}
+	if err = cm.saveConstraints(ctx, cd.Alias, constraints); err != nil {
+		return err
+	}
+	var params map[string]string
+	if !cd.SelfSigned {
+		params = map[string]string{"parent": cd.ParentAlias}
+	}
+	if sealed != nil {
+		if err = cm.store.Write(ctx, cd.Alias, store.KindEscrow, sealed); err != nil {
+			return err
+		}
+		params["escrow"] = "true"
+	}
+	if cd.WriteChain && !cd.IsCA {
+		aliases, err := cm.writeChain(ctx, cd.Alias, cert)
+		if err != nil {
+			return err
+		}
+		if len(aliases) > 0 {
+			params["chain"] = strings.Join(aliases, ",")
+		}
+	}
+	if err = cm.audit(ctx, AuditCreate, cd.Alias, newCert, params); err != nil {
+		return err
+	}
+	return cm.fire(ctx, EventCreate, cd.Alias, cert)
 }
 
-func (cm *certMgr) save(cert []byte, key []byte, alias string) error {
-	certPem := new(bytes.Buffer)
-	err := pem.Encode(certPem, &pem.Block{
+// save saves certificate and private key of alias. Private key is not saved when nil.
+func (cm *certMgr) save(ctx context.Context, cert []byte, key crypto.Signer, alias string) error {
+	var keyPem []byte
+	if key != nil {
+		block, err := marshalKey(key)
+		if err != nil {
+			return err
+		}
+		keyPem = pem.EncodeToMemory(block)
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if err := cm.store.Write(ctx, alias, store.KindCert, pem.EncodeToMemory(&pem.Block{
 		Type:  typeCert,
 		Bytes: cert,
-	})
-	if err != nil {
+	})); err != nil {
 		return err
 	}
+	if keyPem == nil {
+		return nil
+	}
+	return cm.store.Write(ctx, alias, store.KindKey, keyPem)
+}
+
+// ParseCertificatePEM parses first PEM-encoded certificate found in data.
+// Any other PEM blocks preceding certificate (like private key) are skipped.
+func ParseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, &PEMError{Type: typeCert}
+		}
+		if block.Type == typeCert {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, &PEMError{Type: typeCert, Err: err}
+			}
+			return cert, nil
+		}
+	}
+}
 
-	keyPem := new(bytes.Buffer)
-	err = pem.Encode(keyPem, &pem.Block{
-		Type:  typeRsaPrivateKey,
-		Bytes: key,
-	})
+// ParsePairPEM parses PEM-encoded certificate and optional private key from data.
+// Key is nil in returned PairHolder when data contains no private key.
+func ParsePairPEM(data []byte) (*PairHolder, error) {
+	cert, err := ParseCertificatePEM(data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	ph := &PairHolder{Cert: cert}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return ph, nil
+		}
+		if isKeyBlock(block.Type) {
+			if ph.Key, err = parseKey(context.Background(), block); err != nil {
+				return nil, &PEMError{Type: block.Type, Err: err}
+			}
+			if !keyMatches(ph.Key, cert) {
+				return nil, ErrKeyMismatch
+			}
+			return ph, nil
+		}
 	}
-	err = os.WriteFile(cm.aliasToFile(alias, false), certPem.Bytes(), 0o640)
+}
+
+// read reads object of alias from store.
+func (cm *certMgr) read(ctx context.Context, alias string, kind store.Kind) ([]byte, error) {
+	if err := ValidateAlias(alias); err != nil {
+		return nil, err
+	}
+	data, err := cm.store.Read(ctx, alias, kind)
 	if err != nil {
-		return err
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, notFound(alias, err)
+		}
+		return nil, err
 	}
-	return os.WriteFile(cm.aliasToFile(alias, true), keyPem.Bytes(), 0o400)
+	return data, nil
 }
 
-// load loads both certificate and private key for given alias
-func (cm *certMgr) load(alias string) (*PairHolder, error) {
-	name := fmt.Sprintf("%s/%s.pem", cm.dir, alias)
-	data, err := os.ReadFile(name)
+// describe gets human-readable location of object, for use in error messages.
+func (cm *certMgr) describe(alias string, kind store.Kind) string {
+	if l, ok := cm.store.(store.Locator); ok {
+		return l.Path(alias, kind)
+	}
+	return fmt.Sprintf("%s (%s)", alias, kind)
+}
+
+func (cm *certMgr) GetCert(ctx context.Context, alias string) (*x509.Certificate, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.loadCert(ctx, alias)
+}
+
+// loadCert loads certificate of given alias, without its private key. Caller must hold lock.
+// Returned certificate may be shared with other callers, so it must not be modified.
+func (cm *certMgr) loadCert(ctx context.Context, alias string) (*x509.Certificate, error) {
+	data, err := cm.read(ctx, alias, store.KindCert)
 	if err != nil {
 		return nil, err
 	}
-	block, _ := pem.Decode(data)
-	if block == nil || block.Type != typeCert {
-		return nil, fmt.Errorf("can't load CA certificate from %s", name)
+	if cert := cm.cache.get(alias, data); cert != nil {
+		return cert, nil
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := ParseCertificatePEM(data)
 	if err != nil {
+		var pe *PEMError
+		if errors.As(err, &pe) {
+			pe.File = cm.describe(alias, store.KindCert)
+		}
 		return nil, err
 	}
-	name = fmt.Sprintf("%s/%s.key", cm.dir, alias)
-	data, err = os.ReadFile(name)
+	cm.cache.put(alias, data, cert)
+	return cert, nil
+}
+
+// load loads both certificate and private key for given alias
+func (cm *certMgr) load(ctx context.Context, alias string) (*PairHolder, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	cert, err := cm.loadCert(ctx, alias)
 	if err != nil {
 		return nil, err
 	}
-	block, _ = pem.Decode(data)
-	if block == nil || block.Type != typeRsaPrivateKey {
-		return nil, fmt.Errorf("can't load CA private key from %s", name)
+	if key, ok := cm.keys[alias]; ok {
+		if !keyMatches(key, cert) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyMismatch, alias)
+		}
+		return &PairHolder{Alias: alias, Cert: cert, Key: key}, nil
 	}
-	pKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	data, err := cm.read(ctx, alias, store.KindKey)
 	if err != nil {
 		return nil, err
 	}
+	block, _ := pem.Decode(data)
+	if block == nil || !isKeyBlock(block.Type) {
+		return nil, &PEMError{File: cm.describe(alias, store.KindKey), Type: typeRsaPrivateKey}
+	}
+	pKey, err := parseKey(ctx, block)
+	if err != nil {
+		return nil, &PEMError{File: cm.describe(alias, store.KindKey), Type: block.Type, Err: err}
+	}
+	if !keyMatches(pKey, cert) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyMismatch, alias)
+	}
 	return &PairHolder{
-		Cert: cert,
-		Key:  pKey,
+		Alias: alias,
+		Cert:  cert,
+		Key:   pKey,
 	}, nil
 }
 
-func New(dir string) Interface {
-	return &certMgr{
-		dir: dir,
+// New creates certificate manager operating on given directory.
+// Directory in form "exec:/path/to/plugin" denotes store backed by external plugin, see package store.
+// Returned manager is safe for concurrent use by multiple goroutines.
+// Note that this guarantee doesn't extend to multiple managers (or processes) sharing the same directory.
+func New(dir string, opts ...Option) Interface {
+	return NewWithStore(store.New(dir), opts...)
+}
+
+// NewWithStore creates certificate manager operating on given store.
+func NewWithStore(s store.Interface, opts ...Option) Interface {
+	cm := &certMgr{
+		store:    s,
+		pending:  map[string]struct{}{},
+		progress: noProgress{},
+	}
+	for _, opt := range opts {
+		opt(cm)
 	}
+	return cm
 }
@@ -0,0 +1,226 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"slices"
+)
+
+// IsSelfSigned checks whether certificate is issued by itself.
+func IsSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer)
+}
+
+// IsIssuedBy checks whether cert was issued by candidate.
+// Subject of candidate must match issuer of cert, key identifiers must match if both present
+// and public key of candidate must verify signature of cert.
+func IsIssuedBy(cert, candidate *x509.Certificate) bool {
+	if !bytes.Equal(candidate.RawSubject, cert.RawIssuer) {
+		return false
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(candidate.SubjectKeyId) > 0 &&
+		!bytes.Equal(cert.AuthorityKeyId, candidate.SubjectKeyId) {
+		return false
+	}
+	return cert.CheckSignatureFrom(candidate) == nil
+}
+
+// findIssuer looks up certificate that issued given certificate. Returns nil if there is no such certificate.
+// Private key of returned issuer is not loaded.
+func findIssuer(ctx context.Context, r Reader, cert *x509.Certificate) (*PairHolder, error) {
+	aliases, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, alias := range aliases {
+		candidate, err := r.GetCert(ctx, alias)
+		if err != nil {
+			if errors.Is(err, ErrAliasNotFound) {
+				// alias with private key only
+				continue
+			}
+			return nil, err
+		}
+		if IsIssuedBy(cert, candidate) {
+			return &PairHolder{Alias: alias, Cert: candidate}, nil
+		}
+	}
+	return nil, nil
+}
+
+// BuildChain resolves chain of certificates starting with ph, up to the root CA.
+// Only certificates of issuers are resolved, not their private keys.
+// When chain can't be completed, resolved part of chain is returned together with error wrapping ErrChainBroken.
+func BuildChain(ctx context.Context, r Reader, ph *PairHolder) ([]*PairHolder, error) {
+	chain := []*PairHolder{ph}
+	seen := map[string]bool{ph.Alias: true}
+	for !IsSelfSigned(ph.Cert) {
+		parent, err := findIssuer(ctx, r, ph.Cert)
+		if err != nil {
+			return chain, err
+		}
+		if parent == nil {
+			return chain, fmt.Errorf("%w at '%s': issuer '%s' not found", ErrChainBroken, ph.Alias, ph.Cert.Issuer.String())
+		}
+		if seen[parent.Alias] {
+			return chain, fmt.Errorf("%w at '%s': loop detected via '%s'", ErrChainBroken, ph.Alias, parent.Alias)
+		}
+		seen[parent.Alias] = true
+		chain = append(chain, parent)
+		ph = parent
+	}
+	return chain, nil
+}
+
+// GetChain resolves chain using certificates stored under own aliases first.
+// When that doesn't complete chain, certificates stored as chain of alias, like imported intermediates, are used.
+// Such certificates have empty alias.
+func (cm *certMgr) GetChain(ctx context.Context, alias string) ([]*PairHolder, error) {
+	cert, err := cm.GetCert(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := BuildChain(ctx, cm, &PairHolder{Alias: alias, Cert: cert})
+	if !errors.Is(err, ErrChainBroken) {
+		return chain, err
+	}
+	stored, serr := cm.storedChain(ctx, alias)
+	if serr != nil || len(stored) == 0 {
+		return chain, err
+	}
+	for last := chain[len(chain)-1].Cert; !IsSelfSigned(last); {
+		idx := slices.IndexFunc(stored, func(c *x509.Certificate) bool {
+			return IsIssuedBy(last, c)
+		})
+		if idx < 0 {
+			return chain, fmt.Errorf("%w: issuer '%s' not found", ErrChainBroken, last.Issuer.String())
+		}
+		last = stored[idx]
+		stored = slices.Delete(stored, idx, idx+1)
+		chain = append(chain, &PairHolder{Cert: last})
+	}
+	return chain, nil
+}
+
+// storedChain gets certificates stored as chain of alias, if any.
+func (cm *certMgr) storedChain(ctx context.Context, alias string) ([]*x509.Certificate, error) {
+	cm.mu.RLock()
+	data, err := cm.read(ctx, alias, store.KindChain)
+	cm.mu.RUnlock()
+	if err != nil {
+		if errors.Is(err, ErrAliasNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return certs, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, &PEMError{File: cm.describe(alias, store.KindChain), Type: typeCert, Err: err}
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// writeChain stores certificates of intermediate CAs that issued certificate of alias as its chain,
+// so that certificate can be deployed along with them. Root CA is left out.
+// Aliases of stored certificates are returned, nothing is stored when certificate was issued by root CA directly.
+func (cm *certMgr) writeChain(ctx context.Context, alias string, cert *x509.Certificate) ([]string, error) {
+	chain, err := BuildChain(ctx, cm, &PairHolder{Alias: alias, Cert: cert})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		data    []byte
+		aliases []string
+	)
+	for _, ph := range chain[1:] {
+		if IsSelfSigned(ph.Cert) {
+			continue
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: typeCert, Bytes: ph.Cert.Raw})...)
+		aliases = append(aliases, ph.Alias)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return aliases, cm.store.Write(ctx, alias, store.KindChain, data)
+}
+
+// TrustPools gets CA certificates readable by r, for verification of certificates issued outside of store.
+// Self-signed CAs are returned as roots, other CAs as intermediates.
+func TrustPools(ctx context.Context, r Reader) (roots, intermediates *x509.CertPool, err error) {
+	aliases, err := r.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	roots, intermediates = x509.NewCertPool(), x509.NewCertPool()
+	for _, alias := range aliases {
+		cert, err := r.GetCert(ctx, alias)
+		if err != nil {
+			if errors.Is(err, ErrAliasNotFound) {
+				continue
+			}
+			return nil, nil, err
+		}
+		if !cert.IsCA {
+			continue
+		}
+		if IsSelfSigned(cert) {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	return roots, intermediates, nil
+}
+
+// TLSCertificate gets certificate of alias along with its private key and chain, as sent by TLS peer.
+// Root CA is left out of chain, since peers are expected to have it already.
+func TLSCertificate(ctx context.Context, r Reader, alias string) (*tls.Certificate, error) {
+	ph, err := r.Get(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := r.GetChain(ctx, alias)
+	if err != nil && !errors.Is(err, ErrChainBroken) {
+		return nil, err
+	}
+	res := &tls.Certificate{PrivateKey: ph.Key, Leaf: ph.Cert}
+	for i, e := range chain {
+		if i > 0 && IsSelfSigned(e.Cert) {
+			break
+		}
+		res.Certificate = append(res.Certificate, e.Cert.Raw)
+	}
+	return res, nil
+}
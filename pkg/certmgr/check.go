@@ -17,20 +17,51 @@ limitations under the License.
 package certmgr
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"github.com/samber/lo"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxPublicTLSValidity is maximal validity of publicly trusted TLS server certificate, as set by CA/Browser Forum
+	MaxPublicTLSValidity = 398 * 24 * time.Hour
+	// minPublicRSAKeySize is minimal size of RSA key of publicly trusted certificate
+	minPublicRSAKeySize = 2048
 )
 
 // function type to validate aspect of CertData
 type checkFunc func(data *CertData) error
 
-// requireAlias makes sure that alias is set
+// reservedSuffixes can't end alias, since such alias would name object of another alias in file store.
+var reservedSuffixes = []string{store.ChainSuffix}
+
+// ValidateAlias checks that alias can't name file outside of store, or object of another alias.
+// Aliases come from remote clients too, so every operation on alias must validate it first.
+func ValidateAlias(alias string) error {
+	if len(alias) == 0 {
+		return ErrAliasMissing
+	}
+	if strings.ContainsAny(alias, "/\\\x00") || strings.Contains(alias, "..") {
+		return fmt.Errorf("%w: %s, path separators and '..' are not allowed", ErrInvalidAlias, alias)
+	}
+	for _, suffix := range reservedSuffixes {
+		if strings.HasSuffix(alias, suffix) {
+			return fmt.Errorf("%w: %s, suffix %s is reserved", ErrInvalidAlias, alias, suffix)
+		}
+	}
+	return nil
+}
+
+// requireAlias makes sure that alias is set and valid
 func requireAlias() checkFunc {
 	return func(data *CertData) error {
-		if len(data.Alias) == 0 {
-			return common.ErrAliasMissing
-		}
-		return nil
+		return ValidateAlias(data.Alias)
 	}
 }
 
@@ -38,7 +69,7 @@ func requireAlias() checkFunc {
 func requireSubject() checkFunc {
 	return func(data *CertData) error {
 		if len(data.Subject.String()) == 0 {
-			return common.ErrSubjectMissing
+			return ErrSubjectMissing
 		}
 		return nil
 	}
@@ -48,7 +79,7 @@ func requireSubject() checkFunc {
 func requireParentAlias() checkFunc {
 	return func(data *CertData) error {
 		if len(data.ParentAlias) == 0 {
-			return common.ErrParentAliasMissing
+			return ErrParentAliasMissing
 		}
 		return nil
 	}
@@ -57,10 +88,86 @@ func requireParentAlias() checkFunc {
 func validAtLeastYears(years int) checkFunc {
 	return func(data *CertData) error {
 		if data.ValidYears < years {
-			return fmt.Errorf("invalid ValidYears: %d, should be at least %d", data.ValidYears, years)
+			return fmt.Errorf("%w: ValidYears is %d, should be at least %d", ErrInvalidValidity, data.ValidYears, years)
+		}
+		return nil
+	}
+}
+
+// requireValidity makes sure that some validity period is set
+func requireValidity() checkFunc {
+	return func(data *CertData) error {
+		if data.Validity <= 0 && data.ValidYears < 1 && !data.NoExpiry {
+			return fmt.Errorf("%w: either ValidYears or Validity must be positive", ErrInvalidValidity)
+		}
+		return nil
+	}
+}
+
+// validWildcards makes sure that wildcard DNS names are only used where clients accept them:
+// as the whole leftmost label of a DNS SAN, followed by at least two labels.
+// Wildcard in common name is rejected unless certificate has some DNS SAN, since clients ignore common name then.
+func validWildcards() checkFunc {
+	return func(data *CertData) error {
+		if data.AllowAnyWildcard {
+			return nil
 		}
+		for _, name := range data.DNSSan {
+			if err := checkWildcard(name); err != nil {
+				return err
+			}
+		}
+		if strings.Contains(data.Subject.CommonName, "*") && len(data.DNSSan) == 0 {
+			return fmt.Errorf("%w: wildcard common name %s requires DNS SAN", ErrInvalidWildcard, data.Subject.CommonName)
+		}
+		return nil
+	}
+}
+
+func checkWildcard(name string) error {
+	if !strings.Contains(name, "*") {
+		return nil
+	}
+	rest, ok := strings.CutPrefix(name, "*.")
+	if !ok || strings.Contains(rest, "*") {
+		return fmt.Errorf("%w: %s, only whole leftmost label can be wildcard", ErrInvalidWildcard, name)
+	}
+	labels := strings.Split(rest, ".")
+	if len(labels) < 2 || lo.Contains(labels, "") {
+		return fmt.Errorf("%w: %s, wildcard must be followed by at least two non-empty labels", ErrInvalidWildcard, name)
+	}
+	return nil
+}
+
+// IsWildcard tells whether DNS name is wildcard name.
+func IsWildcard(name string) bool {
+	return strings.HasPrefix(name, "*.")
+}
+
+// CheckPublicTrust checks leaf certificate for TLS server authentication against limits that browsers enforce
+// for publicly trusted certificates, like maximal validity. All exceeded limits are reported, each matching ErrPublicTrustLimit.
+// Certificates not intended for server authentication always pass.
+func CheckPublicTrust(cd *CertData, now time.Time) error {
+	if len(cd.ExtKeyUsage) > 0 && !slices.Contains(cd.ExtKeyUsage, x509.ExtKeyUsageServerAuth) {
 		return nil
 	}
+	var errs []error
+	if validity := notAfter(cd, now).Sub(now); validity > MaxPublicTLSValidity {
+		errs = append(errs, fmt.Errorf("%w: validity of %d days exceeds %d days",
+			ErrPublicTrustLimit, validity/(24*time.Hour), MaxPublicTLSValidity/(24*time.Hour)))
+	}
+	bits := cd.KeySize
+	if cd.Key != nil {
+		bits = 0
+		if pub, ok := cd.Key.Public().(*rsa.PublicKey); ok {
+			bits = pub.N.BitLen()
+		}
+	}
+	if bits > 0 && bits < minPublicRSAKeySize {
+		errs = append(errs, fmt.Errorf("%w: RSA key of %d bits is shorter than %d bits",
+			ErrPublicTrustLimit, bits, minPublicRSAKeySize))
+	}
+	return errors.Join(errs...)
 }
 
 func check(data *CertData, checks ...checkFunc) error {
@@ -0,0 +1,175 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Constraints restrict certificates issued by CA. They are kept with CA and enforced whenever it issues certificate,
+// regardless of command or server used. Empty constraint doesn't restrict anything.
+type Constraints struct {
+	// MaxLeafValidity is maximal validity period of leaf certificates, like 2160h
+	MaxLeafValidity time.Duration `yaml:"maxLeafValidity,omitempty"`
+	// DNSSuffixes are domains that DNS names of leaf certificates must belong to, like example.com
+	DNSSuffixes []string `yaml:"dnsSuffixes,omitempty"`
+	// Profiles are names of profiles leaf certificates must be issued with, like tls-server
+	Profiles []string `yaml:"profiles,omitempty"`
+}
+
+// IsZero tells whether constraints don't restrict anything.
+func (c *Constraints) IsZero() bool {
+	return c == nil || (c.MaxLeafValidity == 0 && len(c.DNSSuffixes) == 0 && len(c.Profiles) == 0)
+}
+
+// withinSuffixes tells whether DNS name belongs to any of domains.
+func withinSuffixes(name string, suffixes []string) bool {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	return lo.ContainsBy(suffixes, func(suffix string) bool {
+		suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+		return name == suffix || strings.HasSuffix(name, "."+suffix)
+	})
+}
+
+// narrow gets constraints of intermediate CA issued by CA with parent constraints.
+// Constraints that are not set are inherited, those that are set must not be looser than inherited ones,
+// so that constrained CA can't get rid of its constraints by issuing intermediate CA.
+func (c *Constraints) narrow(parent *Constraints) (*Constraints, error) {
+	var res Constraints
+	if c != nil {
+		res = *c
+	}
+	if parent == nil {
+		return &res, nil
+	}
+	if res.MaxLeafValidity == 0 {
+		res.MaxLeafValidity = parent.MaxLeafValidity
+	} else if parent.MaxLeafValidity > 0 && res.MaxLeafValidity > parent.MaxLeafValidity {
+		return nil, fmt.Errorf("%w: maximal leaf validity %s exceeds %s of issuer", ErrConstraintViolation, res.MaxLeafValidity, parent.MaxLeafValidity)
+	}
+	if len(res.DNSSuffixes) == 0 {
+		res.DNSSuffixes = parent.DNSSuffixes
+	} else if len(parent.DNSSuffixes) > 0 {
+		for _, s := range res.DNSSuffixes {
+			if !withinSuffixes(s, parent.DNSSuffixes) {
+				return nil, fmt.Errorf("%w: DNS suffix %s is not within %s allowed by issuer", ErrConstraintViolation, s, strings.Join(parent.DNSSuffixes, ", "))
+			}
+		}
+	}
+	if len(res.Profiles) == 0 {
+		res.Profiles = parent.Profiles
+	} else if len(parent.Profiles) > 0 {
+		for _, p := range res.Profiles {
+			if !slices.Contains(parent.Profiles, p) {
+				return nil, fmt.Errorf("%w: profile %s is not among %s allowed by issuer", ErrConstraintViolation, p, strings.Join(parent.Profiles, ", "))
+			}
+		}
+	}
+	return &res, nil
+}
+
+// Check checks leaf certificate about to be issued with given profile. Certificates of intermediate CAs are checked by narrow.
+func (c *Constraints) Check(tmpl *x509.Certificate, profile string) error {
+	if tmpl.IsCA {
+		return nil
+	}
+	if validity := tmpl.NotAfter.Sub(tmpl.NotBefore); c.MaxLeafValidity > 0 && validity > c.MaxLeafValidity {
+		return fmt.Errorf("%w: validity %s exceeds %s", ErrConstraintViolation, validity, c.MaxLeafValidity)
+	}
+	if len(c.DNSSuffixes) > 0 {
+		for _, name := range tmpl.DNSNames {
+			if !withinSuffixes(name, c.DNSSuffixes) {
+				return fmt.Errorf("%w: DNS name %s is not within %s", ErrConstraintViolation, name, strings.Join(c.DNSSuffixes, ", "))
+			}
+		}
+	}
+	if len(c.Profiles) > 0 && !slices.Contains(c.Profiles, profile) {
+		if len(profile) == 0 {
+			return fmt.Errorf("%w: profile is required, one of %s", ErrConstraintViolation, strings.Join(c.Profiles, ", "))
+		}
+		return fmt.Errorf("%w: profile %s is not one of %s", ErrConstraintViolation, profile, strings.Join(c.Profiles, ", "))
+	}
+	return nil
+}
+
+func (cm *certMgr) GetConstraints(ctx context.Context, alias string) (*Constraints, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.loadConstraints(ctx, alias)
+}
+
+// loadConstraints loads constraints of CA, nil is returned when CA has none. Caller must hold lock.
+func (cm *certMgr) loadConstraints(ctx context.Context, alias string) (*Constraints, error) {
+	data, err := cm.read(ctx, alias, store.KindConstraints)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var c Constraints
+	if err = yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid constraints of %s: %w", alias, err)
+	}
+	return &c, nil
+}
+
+// saveConstraints stores constraints of CA, unless they don't restrict anything.
+func (cm *certMgr) saveConstraints(ctx context.Context, alias string, c *Constraints) error {
+	if c.IsZero() {
+		return nil
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return cm.store.Write(ctx, alias, store.KindConstraints, data)
+}
+
+// enforceConstraints checks certificate about to be issued by given CA against constraints of that CA.
+// Constraints of new intermediate CA, derived from those of issuer, are returned.
+func (cm *certMgr) enforceConstraints(ctx context.Context, issuer string, tmpl *x509.Certificate, profile string, own *Constraints) (*Constraints, error) {
+	var (
+		parent *Constraints
+		err    error
+	)
+	if len(issuer) > 0 {
+		cm.mu.RLock()
+		parent, err = cm.loadConstraints(ctx, issuer)
+		cm.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !tmpl.IsCA {
+		if parent == nil {
+			return nil, nil
+		}
+		return nil, parent.Check(tmpl, profile)
+	}
+	return own.narrow(parent)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// CSRHolder wraps certificate signing request and corresponding private key.
+type CSRHolder struct {
+	CSR *x509.CertificateRequest
+	Key *rsa.PrivateKey
+}
+
+// SignOptions control certificate issued by SignCSR.
+type SignOptions struct {
+	// Alias under which issued certificate is stored. Certificate is not stored when empty.
+	Alias      string
+	ValidYears int
+	// Validity, when set, takes precedence over ValidYears
+	Validity time.Duration
+	IsCA     bool
+	Serial   int64
+	// ExtKeyUsage of leaf certificate. When empty, both client and server authentication is allowed.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// AllowAnyWildcard disables validation of wildcard DNS names of leaf certificate
+	AllowAnyWildcard bool
+	// AllowUnderscore allows underscores in DNS names of leaf certificate
+	AllowUnderscore bool
+	// Profile is name of profile leaf certificate is issued with, checked against constraints of issuing CA
+	Profile string
+}
+
+// CreateCSR generates new private key and certificate signing request
+// using subject and subject alternative names from cd.
+// Common name is copied into subject alternative names the same way as NewLeaf does.
+func CreateCSR(ctx context.Context, cd *CertData) (*CSRHolder, error) {
+	if err := check(cd, requireSubject()); err != nil {
+		return nil, err
+	}
+	copyCommonName(cd)
+	if err := normalizeSANs(cd); err != nil {
+		return nil, err
+	}
+	key, err := generateKey(ctx, cd.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     cd.Subject,
+		DNSNames:    cd.DNSSan,
+		IPAddresses: cd.IPSan,
+		URIs:        cd.URISan,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CSRHolder{CSR: csr, Key: key}, nil
+}
+
+func (cm *certMgr) SignCSR(ctx context.Context, parent string, csr *x509.CertificateRequest, opts *SignOptions) (_ *x509.Certificate, err error) {
+	ctx, end := traceIssuance(ctx, "signCSR", parent)
+	defer func() {
+		end(err)
+	}()
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid signature of CSR: %w", err)
+	}
+	cd := &CertData{
+		ValidYears:  opts.ValidYears,
+		Validity:    opts.Validity,
+		IPSan:       csr.IPAddresses,
+		DNSSan:      csr.DNSNames,
+		URISan:      csr.URIs,
+		Alias:       opts.Alias,
+		ParentAlias: parent,
+		IsCA:        opts.IsCA,
+		Subject:     csr.Subject,
+		Serial:      opts.Serial,
+		ExtKeyUsage: opts.ExtKeyUsage,
+
+		AllowAnyWildcard: opts.AllowAnyWildcard,
+		AllowUnderscore:  opts.AllowUnderscore,
+	}
+	checks := []checkFunc{requireParentAlias(), requireValidity()}
+	if !cd.IsCA {
+		if err := normalizeSANs(cd); err != nil {
+			return nil, err
+		}
+		checks = append(checks, validWildcards())
+	}
+	if err := check(cd, checks...); err != nil {
+		return nil, err
+	}
+	if len(cd.Alias) > 0 {
+		if err := cm.reserve(ctx, cd.Alias); err != nil {
+			return nil, err
+		}
+		defer cm.release(cd.Alias)
+	}
+	tmpl, err := newTemplate(cd)
+	if err != nil {
+		return nil, err
+	}
+	if err = cm.enforcePolicy(ctx, parent, tmpl, KeyType(csr.PublicKey)); err != nil {
+		return nil, err
+	}
+	constraints, err := cm.enforceConstraints(ctx, parent, tmpl, opts.Profile, nil)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := cm.loadParent(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ch.Cert, csr.PublicKey, ch.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(cd.Alias) > 0 {
+		if err = cm.save(ctx, der, nil, cd.Alias); err != nil {
+			return nil, err
+		}
+		if err = cm.saveConstraints(ctx, cd.Alias, constraints); err != nil {
+			return nil, err
+		}
+	}
+	if err = cm.audit(ctx, AuditSign, cd.Alias, cert, map[string]string{"parent": parent}); err != nil {
+		return nil, err
+	}
+	if len(cd.Alias) > 0 {
+		if err = cm.fire(ctx, EventCreate, cd.Alias, cert); err != nil {
+			return nil, err
+		}
+	}
+	return cert, nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// oidDelegationUsage marks certificate that can issue delegated credentials, see RFC 9345, section 4.2
+var oidDelegationUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44363, 44}
+
+// delegationUsageExt is DelegationUsage extension, its value is NULL
+var delegationUsageExt = pkix.Extension{Id: oidDelegationUsage, Value: []byte{asn1.TagNull, 0}}
+
+// HasDelegationUsage tells whether certificate is allowed to issue delegated credentials.
+// Besides DelegationUsage extension, certificate must allow digital signatures.
+func HasDelegationUsage(cert *x509.Certificate) bool {
+	if cert.IsCA || cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return false
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidDelegationUsage) {
+			return true
+		}
+	}
+	return false
+}
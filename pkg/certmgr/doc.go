@@ -0,0 +1,28 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certmgr implements issuance and storage of X.509 certificates and their private keys.
+//
+// Certificates are referenced by aliases and kept in store (see package store), usually directory on local filesystem:
+//
+//	cm := certmgr.New("/etc/pki/mine")
+//	err := cm.NewRootCA(ctx, &certmgr.CertData{
+//		Alias:      "rootCA",
+//		Subject:    pkix.Name{CommonName: "My Root CA"},
+//		ValidYears: 10,
+//		KeySize:    4096,
+//	})
+package certmgr
@@ -0,0 +1,124 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// Drift compares certificate with one that would be issued for cd and describes every difference found,
+// like changed subject alternative names or extended key usage. Nil is returned when certificate matches.
+// Only properties that cd defines are compared, validity and key are not.
+func Drift(cd *CertData, cert *x509.Certificate) ([]string, error) {
+	var res []string
+	if cert.Subject.String() != cd.Subject.String() {
+		res = append(res, fmt.Sprintf("subject changed from '%s' to '%s'", cert.Subject, cd.Subject))
+	}
+	if cert.IsCA != cd.IsCA {
+		res = append(res, fmt.Sprintf("CA changed from %t to %t", cert.IsCA, cd.IsCA))
+	}
+	if cd.IsCA {
+		return res, nil
+	}
+	// normalize copy the same way NewLeaf does, so that equivalent names don't count as change
+	want := *cd
+	want.IPSan = slices.Clone(cd.IPSan)
+	want.DNSSan = slices.Clone(cd.DNSSan)
+	copyCommonName(&want)
+	if err := normalizeSANs(&want); err != nil {
+		return nil, err
+	}
+	dns, err := toASCIINames(want.DNSSan)
+	if err != nil {
+		return nil, err
+	}
+	res = appendSetDrift(res, "DNS names", cert.DNSNames, dns)
+	res = appendSetDrift(res, "IP addresses", ipStrings(cert.IPAddresses), ipStrings(want.IPSan))
+	res = appendSetDrift(res, "email addresses", cert.EmailAddresses, want.EmailSan)
+	upns, err := UPNs(cert)
+	if err != nil {
+		return nil, err
+	}
+	res = appendSetDrift(res, "user principal names", upns, want.UPNSan)
+	eku := want.ExtKeyUsage
+	if len(eku)+len(want.UnknownExtKeyUsage) == 0 {
+		eku = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	}
+	if !sameSet(cert.ExtKeyUsage, eku) || !sameSetFunc(cert.UnknownExtKeyUsage, want.UnknownExtKeyUsage, asn1.ObjectIdentifier.Equal) {
+		res = append(res, "extended key usage changed")
+	}
+	if HasDelegationUsage(cert) != want.DelegationUsage {
+		res = append(res, fmt.Sprintf("delegation usage changed from %t to %t", HasDelegationUsage(cert), want.DelegationUsage))
+	}
+	return res, nil
+}
+
+// appendSetDrift appends description of difference between sets of names, if there is any.
+func appendSetDrift(res []string, what string, have, want []string) []string {
+	var added, removed []string
+	for _, n := range want {
+		if !slices.Contains(have, n) {
+			added = append(added, n)
+		}
+	}
+	for _, n := range have {
+		if !slices.Contains(want, n) {
+			removed = append(removed, n)
+		}
+	}
+	if len(added) > 0 {
+		res = append(res, fmt.Sprintf("%s added: %v", what, added))
+	}
+	if len(removed) > 0 {
+		res = append(res, fmt.Sprintf("%s removed: %v", what, removed))
+	}
+	return res
+}
+
+func sameSet[T comparable](a, b []T) bool {
+	return sameSetFunc(a, b, func(x, y T) bool { return x == y })
+}
+
+// sameSetFunc tells whether both slices contain the same elements, regardless of order.
+func sameSetFunc[T any](a, b []T, eq func(T, T) bool) bool {
+	contains := func(s []T, v T) bool {
+		return slices.ContainsFunc(s, func(e T) bool { return eq(e, v) })
+	}
+	for _, v := range a {
+		if !contains(b, v) {
+			return false
+		}
+	}
+	for _, v := range b {
+		if !contains(a, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func ipStrings(ips []net.IP) []string {
+	res := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		res = append(res, ip.String())
+	}
+	return res
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"slices"
+)
+
+const (
+	typeEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	// pbkdf2Iterations is iteration count used when encrypting keys, same as used by OpenSSL 3 for PKCS#12.
+	pbkdf2Iterations = 100000
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHmacWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+	aesKeySizes = map[string]int{
+		oidAES128CBC.String(): 16,
+		oidAES192CBC.String(): 24,
+		oidAES256CBC.String(): 32,
+	}
+)
+
+// encryptedPrivateKeyInfo is defined in RFC 5958, section 3.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params is defined in RFC 8018, appendix A.4.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params is defined in RFC 8018, appendix A.2.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// MarshalEncryptedKeyPEM encodes private key as PKCS#8 encrypted using password,
+// with PBES2 scheme (PBKDF2 with HMAC-SHA256 and AES-256-CBC).
+func MarshalEncryptedKeyPEM(key crypto.Signer, password []byte) ([]byte, error) {
+	der, err := marshalEncryptedKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typeEncryptedPrivateKey, Bytes: der}), nil
+}
+
+// marshalEncryptedKey encodes private key as DER-encoded EncryptedPrivateKeyInfo.
+func marshalEncryptedKey(key crypto.Signer, password []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	alg, data, err := encryptPBES2(der, password)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: data})
+}
+
+// encryptPBES2 encrypts data using password with PBES2 scheme (PBKDF2 with HMAC-SHA256 and AES-256-CBC).
+func encryptPBES2(der []byte, password []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	var alg pkix.AlgorithmIdentifier
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return alg, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return alg, nil, err
+	}
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return alg, nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return alg, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return alg, nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(password, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return alg, nil, err
+	}
+	padLen := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(slices.Clone(der), make([]byte, padLen)...)
+	for i := len(der); i < len(data); i++ {
+		data[i] = byte(padLen)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	alg = pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return alg, data, nil
+}
+
+// ParseEncryptedKeyPEM parses first private key found in PEM data, decrypting it using password when needed.
+// Both PKCS#8 with PBES2 scheme and legacy OpenSSL encryption (Proc-Type and DEK-Info headers) are supported.
+// Unencrypted keys are parsed as they are.
+func ParseEncryptedKeyPEM(data []byte, password []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, &PEMError{Type: "PRIVATE KEY"}
+		}
+		switch {
+		case block.Type == typeEncryptedPrivateKey:
+			der, err := decryptPBES2(block.Bytes, password)
+			if err != nil {
+				return nil, &PEMError{Type: block.Type, Err: err}
+			}
+			return parseKey(context.Background(), &pem.Block{Type: typePkcs8PrivateKey, Bytes: der})
+		case isKeyBlock(block.Type) && len(block.Headers["DEK-Info"]) > 0:
+			// legacy encryption is insecure, but it's still found in keys written by older tools
+			der, err := x509.DecryptPEMBlock(block, password) //nolint:staticcheck
+			if err != nil {
+				return nil, &PEMError{Type: block.Type, Err: fmt.Errorf("%w: %w", ErrDecryptionFailed, err)}
+			}
+			return parseKey(context.Background(), &pem.Block{Type: block.Type, Bytes: der})
+		case isKeyBlock(block.Type):
+			return parseKey(context.Background(), block)
+		}
+	}
+}
+
+// decryptPBES2 decrypts DER-encoded EncryptedPrivateKeyInfo using password.
+func decryptPBES2(der []byte, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption algorithm %s", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+	keyLen, ok := aesKeySizes[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key encryption cipher %s", params.EncryptionScheme.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHmacWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHmacWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudo-random function %s", kdf.PRF.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != aes.BlockSize || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrDecryptionFailed
+	}
+	block, err := aes.NewCipher(pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	padLen := int(out[len(out)-1])
+	if padLen == 0 || padLen > aes.BlockSize {
+		return nil, ErrDecryptionFailed
+	}
+	for _, b := range out[len(out)-padLen:] {
+		if int(b) != padLen {
+			return nil, ErrDecryptionFailed
+		}
+	}
+	return out[:len(out)-padLen], nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by certificate manager, to be matched using errors.Is.
+var (
+	ErrAliasNotFound       = errors.New("alias not found")
+	ErrAliasExists         = errors.New("alias already exists")
+	ErrKeyMismatch         = errors.New("private key does not match certificate")
+	ErrParentNotCA         = errors.New("parent certificate is not CA")
+	ErrInvalidValidity     = errors.New("invalid validity")
+	ErrChainBroken         = errors.New("chain is broken")
+	ErrAlreadyRevoked      = errors.New("certificate is already revoked")
+	ErrDecryptionFailed    = errors.New("can't decrypt private key, password is likely wrong")
+	ErrInvalidWildcard     = errors.New("invalid wildcard DNS name")
+	ErrInvalidDNSName      = errors.New("invalid DNS name")
+	ErrDuplicateSAN        = errors.New("duplicate subject alternative name")
+	ErrInvalidEmail        = errors.New("invalid email address")
+	ErrInvalidUPN          = errors.New("invalid user principal name")
+	ErrInvalidSID          = errors.New("invalid security identifier")
+	ErrAuditUnsupported    = errors.New("store doesn't keep audit log")
+	ErrInvalidPolicy       = errors.New("invalid issuance policy")
+	ErrPolicyViolation     = errors.New("issuance policy violation")
+	ErrConstraintViolation = errors.New("CA constraint violation")
+	ErrPublicTrustLimit    = errors.New("exceeds limit of publicly trusted TLS certificate")
+	ErrNoEscrow            = errors.New("private key was not escrowed")
+
+	ErrAliasMissing       = errors.New("certificate alias is required")
+	ErrInvalidAlias       = errors.New("invalid certificate alias")
+	ErrSubjectMissing     = errors.New("certificate subject is required")
+	ErrParentAliasMissing = errors.New("parent certificate alias is required")
+)
+
+// PEMError is returned when file doesn't contain expected PEM block, or block can't be parsed.
+type PEMError struct {
+	// File is name of offending file, if known
+	File string
+	// Type is type of PEM block that was expected
+	Type string
+	// Err is underlying cause, if any
+	Err error
+}
+
+func (e *PEMError) Error() string {
+	msg := fmt.Sprintf("can't load %s", e.Type)
+	if len(e.File) > 0 {
+		msg += " from " + e.File
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *PEMError) Unwrap() error {
+	return e.Err
+}
+
+// notFound wraps error caused by missing file of alias, so that it matches both ErrAliasNotFound and original error.
+func notFound(alias string, err error) error {
+	return fmt.Errorf("%w: %s: %w", ErrAliasNotFound, alias, err)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"io/fs"
+	"slices"
+)
+
+// Sealer encrypts DER-encoded PKCS #8 private key for recovery agent.
+// Result is stored as is, like DER encoded CMS enveloped data.
+type Sealer func(der []byte) ([]byte, error)
+
+type escrow struct {
+	seal     Sealer
+	profiles []string
+}
+
+// WithEscrow keeps copy of private key encrypted by seal along with leaf certificate,
+// when key is generated for certificate issued with one of given profiles.
+// Keys given by caller, like those held by plugins, are never escrowed. Neither are keys of CAs.
+func WithEscrow(seal Sealer, profiles []string) Option {
+	return func(cm *certMgr) {
+		cm.escrow = &escrow{seal: seal, profiles: profiles}
+	}
+}
+
+// sealKey seals key generated for alias, when profile opted in to escrow. Nil is returned otherwise.
+func (cm *certMgr) sealKey(alias, profile string, key crypto.Signer) ([]byte, error) {
+	if cm.escrow == nil || len(profile) == 0 || !slices.Contains(cm.escrow.profiles, profile) {
+		return nil, nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := cm.escrow.seal(der)
+	if err != nil {
+		return nil, fmt.Errorf("escrow of private key of '%s' failed: %w", alias, err)
+	}
+	return sealed, nil
+}
+
+func (cm *certMgr) GetEscrow(ctx context.Context, alias string) ([]byte, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	data, err := cm.read(ctx, alias, store.KindEscrow)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNoEscrow, alias)
+		}
+		return nil, err
+	}
+	return data, nil
+}
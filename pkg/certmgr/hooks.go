@@ -0,0 +1,125 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// EventType is type of lifecycle event.
+type EventType string
+
+const (
+	EventCreate EventType = "create"
+	EventDelete EventType = "delete"
+	EventRevoke EventType = "revoke"
+	// EventRenew is fired for short-lived certificates that are not kept in store, so alias is empty
+	EventRenew EventType = "renew"
+)
+
+// Event describes lifecycle event that happened to alias.
+// File names are only set when store keeps objects in local files.
+type Event struct {
+	Type     EventType
+	Alias    string
+	CertFile string
+	KeyFile  string
+	// Cert is certificate event happened to, nil when it's not known, like when deleted alias had none
+	Cert *x509.Certificate
+}
+
+// Hook is invoked after lifecycle event happened.
+// Error returned from hook is returned to caller of operation that triggered the event.
+type Hook func(ctx context.Context, ev *Event) error
+
+// Option configures certificate manager.
+type Option func(*certMgr)
+
+// WithHook registers hook that is invoked after each lifecycle event.
+func WithHook(h Hook) Option {
+	return func(cm *certMgr) {
+		cm.hooks = append(cm.hooks, h)
+	}
+}
+
+// Progress receives notifications about long-running operations, like key generation.
+type Progress interface {
+	// Begin is called when task is started.
+	Begin(task string)
+	// End is called when task is finished, err is nil on success.
+	End(task string, err error)
+}
+
+type noProgress struct{}
+
+func (noProgress) Begin(string)      {}
+func (noProgress) End(string, error) {}
+
+// WithProgress registers receiver of progress notifications.
+func WithProgress(p Progress) Option {
+	return func(cm *certMgr) {
+		cm.progress = p
+	}
+}
+
+// ExecHook creates hook that runs command using system shell.
+// Details of event are passed to command via environment variables
+// PKITOOL_EVENT, PKITOOL_ALIAS, PKITOOL_CERT_FILE and PKITOOL_KEY_FILE.
+func ExecHook(command string, stdout, stderr io.Writer) Hook {
+	return func(ctx context.Context, ev *Event) error {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", command)
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		cmd.Env = append(os.Environ(),
+			"PKITOOL_EVENT="+string(ev.Type),
+			"PKITOOL_ALIAS="+ev.Alias,
+			"PKITOOL_CERT_FILE="+ev.CertFile,
+			"PKITOOL_KEY_FILE="+ev.KeyFile,
+		)
+		return cmd.Run()
+	}
+}
+
+// fire invokes all registered hooks for event of given type, stopping at first error.
+func (cm *certMgr) fire(ctx context.Context, t EventType, alias string, cert *x509.Certificate) error {
+	ev := &Event{
+		Type:  t,
+		Alias: alias,
+		Cert:  cert,
+	}
+	if l, ok := cm.store.(store.Locator); ok {
+		ev.CertFile = l.Path(alias, store.KindCert)
+		ev.KeyFile = l.Path(alias, store.KindKey)
+	}
+	for _, h := range cm.hooks {
+		if err := h(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
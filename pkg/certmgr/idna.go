@@ -0,0 +1,82 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"strings"
+)
+
+// idnaProfile converts internationalized names the way resolvers do, but allows underscores
+// that are common in service names.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false), idna.VerifyDNSLength(true))
+
+// ToASCII converts internationalized DNS name to its ASCII (punycode) form.
+// Names that are already ASCII are returned unchanged. Wildcard label is preserved.
+func ToASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	prefix := ""
+	if IsWildcard(name) {
+		prefix, name = "*.", name[2:]
+	}
+	a, err := idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidDNSName, err)
+	}
+	return prefix + a, nil
+}
+
+// ToUnicode converts DNS name with punycode labels to its Unicode form.
+// When name has no such labels or can't be converted, it's returned unchanged.
+func ToUnicode(name string) string {
+	if !strings.Contains(name, "xn--") {
+		return name
+	}
+	u, err := idna.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return u
+}
+
+// toASCIINames converts all names using ToASCII.
+func toASCIINames(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return names, nil
+	}
+	res := make([]string, len(names))
+	for i, name := range names {
+		a, err := ToASCII(name)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = a
+	}
+	return res, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
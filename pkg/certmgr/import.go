@@ -0,0 +1,63 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"strconv"
+)
+
+func (cm *certMgr) Import(ctx context.Context, alias string, cert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate) error {
+	if err := ValidateAlias(alias); err != nil {
+		return err
+	}
+	if key != nil && !keyMatches(key, cert) {
+		return fmt.Errorf("%w: %s", ErrKeyMismatch, alias)
+	}
+	if err := cm.reserve(ctx, alias); err != nil {
+		return err
+	}
+	defer cm.release(alias)
+	if len(chain) > 0 {
+		var data []byte
+		for _, c := range chain {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: typeCert, Bytes: c.Raw})...)
+		}
+		if err := func() error {
+			cm.mu.Lock()
+			defer cm.mu.Unlock()
+			return cm.store.Write(ctx, alias, store.KindChain, data)
+		}(); err != nil {
+			return err
+		}
+	}
+	if err := cm.save(ctx, cert.Raw, key, alias); err != nil {
+		return err
+	}
+	if err := cm.audit(ctx, AuditImport, alias, cert, map[string]string{
+		"issuer":  cert.Issuer.String(),
+		"withKey": strconv.FormatBool(key != nil),
+	}); err != nil {
+		return err
+	}
+	return cm.fire(ctx, EventCreate, alias, cert)
+}
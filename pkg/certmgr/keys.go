@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/samber/lo"
+	"runtime"
+	"sync"
+)
+
+const (
+	typeEcPrivateKey    = "EC PRIVATE KEY"
+	typePkcs8PrivateKey = "PRIVATE KEY"
+)
+
+// pemMarshaler is implemented by keys that know how to represent themselves in PEM form,
+// like references to keys held by plugins.
+type pemMarshaler interface {
+	MarshalPEM() (*pem.Block, error)
+}
+
+// marshalKey encodes private key into PEM block.
+// RSA keys are encoded using PKCS#1, EC keys using SEC 1 and anything else using PKCS#8.
+func marshalKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case pemMarshaler:
+		return k.MarshalPEM()
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: typeRsaPrivateKey, Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: typeEcPrivateKey, Bytes: der}, nil
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: typePkcs8PrivateKey, Bytes: der}, nil
+	}
+}
+
+// MarshalKeyPEM encodes private key in PEM format, the same way as it is stored.
+func MarshalKeyPEM(key crypto.Signer) ([]byte, error) {
+	block, err := marshalKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// isKeyBlock checks whether PEM block type denotes supported private key.
+func isKeyBlock(t string) bool {
+	switch t {
+	case typeRsaPrivateKey, typeEcPrivateKey, typePkcs8PrivateKey, plugin.PEMType:
+		return true
+	}
+	return false
+}
+
+// parseKey parses private key from PEM block.
+func parseKey(ctx context.Context, block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case typeRsaPrivateKey:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case typeEcPrivateKey:
+		return x509.ParseECPrivateKey(block.Bytes)
+	case typePkcs8PrivateKey:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(crypto.Signer); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	case plugin.PEMType:
+		return plugin.ParsePEM(ctx, block)
+	}
+	return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+}
+
+// keyMatches checks whether private key corresponds to public key in certificate.
+func keyMatches(key crypto.Signer, cert *x509.Certificate) bool {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+// GenerateKeys generates RSA keys of all certificates that don't have one yet, concurrently using up to workers goroutines.
+// Non-positive workers means GOMAXPROCS. Certificates are then created using generated keys, so when creating many of them,
+// expensive key generation is not done one by one.
+func GenerateKeys(ctx context.Context, p Progress, workers int, cds ...*CertData) error {
+	pending := lo.Filter(cds, func(cd *CertData, _ int) bool {
+		return cd.Key == nil
+	})
+	if len(pending) == 0 {
+		return nil
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(pending))
+	task := fmt.Sprintf("generating %d RSA keys using %d workers", len(pending), workers)
+	p.Begin(task)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan *CertData)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cd := range jobs {
+				key, err := generateKey(ctx, cd.KeySize)
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				cd.Key = key
+			}
+		}()
+	}
+	func() {
+		defer close(jobs)
+		for _, cd := range pending {
+			select {
+			case jobs <- cd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	err := <-errs
+	if err == nil {
+		err = ctx.Err()
+	}
+	p.End(task, err)
+	return err
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// oidUPN is Microsoft user principal name, carried as otherName SAN
+	oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+	// oidHardwareModuleName identifies hardware module, see RFC 4108, section 5
+	oidHardwareModuleName = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 4}
+	// oidNTDSCASecurityExt is szOID_NTDS_CA_SECURITY_EXT extension, carrying SID of account certificate is issued for
+	oidNTDSCASecurityExt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 25, 2}
+	// oidNTDSObjectSID is szOID_NTDS_OBJECTSID, otherName type of SID within oidNTDSCASecurityExt
+	oidNTDSObjectSID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 25, 2, 1}
+
+	sidPattern = regexp.MustCompile(`^S-1-[0-9]+(-[0-9]+)+$`)
+)
+
+// tags of GeneralName choices, see RFC 5280, section 4.2.1.6
+const (
+	nameTagOther = 0
+	nameTagEmail = 1
+	nameTagDNS   = 2
+	nameTagURI   = 6
+	nameTagIP    = 7
+)
+
+// otherName is GeneralName of type not known to RFC 5280. Value is explicitly tagged [0].
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue
+}
+
+// HardwareModuleName identifies hardware module by its type and serial number,
+// as used by IEEE 802.1AR device identities.
+type HardwareModuleName struct {
+	// Type is object identifier of hardware module type, assigned by its manufacturer
+	Type asn1.ObjectIdentifier
+	// SerialNum is serial number of hardware module
+	SerialNum []byte
+}
+
+// String formats name as type:serial, serial is hex-encoded unless it's printable ASCII.
+func (h HardwareModuleName) String() string {
+	serial := string(h.SerialNum)
+	if !isASCII(serial) || strings.ContainsFunc(serial, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+		serial = hex.EncodeToString(h.SerialNum)
+	}
+	return fmt.Sprintf("%s:%s", h.Type, serial)
+}
+
+// hasOtherNames tells whether there are any SANs crypto/x509 can't encode.
+func hasOtherNames(cd *CertData) bool {
+	return len(cd.UPNSan)+len(cd.HardwareModuleSan) > 0
+}
+
+// marshalOtherName encodes otherName GeneralName of given type, with DER-encoded value.
+func marshalOtherName(typeID asn1.ObjectIdentifier, value []byte) (asn1.RawValue, error) {
+	on, err := asn1.Marshal(otherName{
+		TypeID: typeID,
+		Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+	})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	// otherName is implicitly tagged, so universal SEQUENCE tag is replaced by context-specific one
+	on[0] = asn1.ClassContextSpecific<<6 | 0x20 | nameTagOther
+	return asn1.RawValue{FullBytes: on}, nil
+}
+
+// marshalSAN encodes subject alternative names of template together with user principal names and hardware module names.
+// It's only needed when there are any of these, since crypto/x509 can't encode otherName.
+func marshalSAN(tmpl *x509.Certificate, cd *CertData) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	for _, name := range tmpl.DNSNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagDNS, Bytes: []byte(name)})
+	}
+	for _, email := range tmpl.EmailAddresses {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagEmail, Bytes: []byte(email)})
+	}
+	for _, ip := range tmpl.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagIP, Bytes: ip})
+	}
+	for _, uri := range tmpl.URIs {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: nameTagURI, Bytes: []byte(uri.String())})
+	}
+	for _, upn := range cd.UPNSan {
+		value, err := asn1.MarshalWithParams(upn, "utf8")
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		name, err := marshalOtherName(oidUPN, value)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		names = append(names, name)
+	}
+	for _, hw := range cd.HardwareModuleSan {
+		value, err := asn1.Marshal(hw)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		name, err := marshalOtherName(oidHardwareModuleName, value)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		names = append(names, name)
+	}
+	value, err := asn1.Marshal(names)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	// extension must be critical when subject is empty
+	return pkix.Extension{Id: oidSubjectAltName, Critical: len(tmpl.Subject.ToRDNSequence()) == 0, Value: value}, nil
+}
+
+// otherNames gets values of otherName SANs of given type.
+func otherNames(cert *x509.Certificate, typeID asn1.ObjectIdentifier) ([]asn1.RawValue, error) {
+	var values []asn1.RawValue
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if name.Class != asn1.ClassContextSpecific || name.Tag != nameTagOther {
+				continue
+			}
+			var on otherName
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &on, "tag:0"); err != nil {
+				return nil, err
+			}
+			if on.TypeID.Equal(typeID) && on.Value.Class == asn1.ClassContextSpecific && on.Value.Tag == 0 {
+				values = append(values, on.Value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// UPNs gets user principal names from subject alternative names of certificate.
+func UPNs(cert *x509.Certificate) ([]string, error) {
+	values, err := otherNames(cert, oidUPN)
+	if err != nil {
+		return nil, err
+	}
+	var upns []string
+	for _, v := range values {
+		var upn string
+		if _, err := asn1.Unmarshal(v.Bytes, &upn); err != nil {
+			return nil, fmt.Errorf("invalid user principal name: %w", err)
+		}
+		upns = append(upns, upn)
+	}
+	return upns, nil
+}
+
+// HardwareModuleNames gets hardware module names from subject alternative names of certificate.
+func HardwareModuleNames(cert *x509.Certificate) ([]HardwareModuleName, error) {
+	values, err := otherNames(cert, oidHardwareModuleName)
+	if err != nil {
+		return nil, err
+	}
+	var res []HardwareModuleName
+	for _, v := range values {
+		var hw HardwareModuleName
+		if _, err := asn1.Unmarshal(v.Bytes, &hw); err != nil {
+			return nil, fmt.Errorf("invalid hardware module name: %w", err)
+		}
+		res = append(res, hw)
+	}
+	return res, nil
+}
+
+// ParseHardwareModuleName parses hardware module name given as type:serial, like 1.3.6.1.4.1.99999.1:SN0001.
+// Serial number is taken as is.
+func ParseHardwareModuleName(s string) (HardwareModuleName, error) {
+	var hw HardwareModuleName
+	typ, serial, found := strings.Cut(s, ":")
+	if !found || len(serial) == 0 {
+		return hw, fmt.Errorf("invalid hardware module name %q, expected type:serial", s)
+	}
+	for _, arc := range strings.Split(typ, ".") {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return hw, fmt.Errorf("invalid hardware module type %q", typ)
+		}
+		hw.Type = append(hw.Type, n)
+	}
+	if len(hw.Type) < 2 {
+		return hw, fmt.Errorf("invalid hardware module type %q", typ)
+	}
+	hw.SerialNum = []byte(serial)
+	return hw, nil
+}
+
+// ParseSecurityID validates security identifier of Windows account in its string form, like S-1-5-21-1004336348-1177238915-682003330-512.
+func ParseSecurityID(sid string) (string, error) {
+	sid = strings.ToUpper(strings.TrimSpace(sid))
+	if !sidPattern.MatchString(sid) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSID, sid)
+	}
+	return sid, nil
+}
+
+// marshalSecurityExt encodes szOID_NTDS_CA_SECURITY_EXT extension with given SID,
+// which domain controllers use for strong mapping of certificate to account (KB5014754).
+func marshalSecurityExt(sid string) (pkix.Extension, error) {
+	sid, err := ParseSecurityID(sid)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	value, err := asn1.Marshal([]byte(sid))
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	name, err := marshalOtherName(oidNTDSObjectSID, value)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	ext, err := asn1.Marshal([]asn1.RawValue{name})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidNTDSCASecurityExt, Value: ext}, nil
+}
+
+// SecurityID gets SID of account from szOID_NTDS_CA_SECURITY_EXT extension of certificate, if there is any.
+func SecurityID(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidNTDSCASecurityExt) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return "", err
+		}
+		for _, name := range names {
+			var on otherName
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &on, "tag:0"); err != nil {
+				return "", err
+			}
+			if !on.TypeID.Equal(oidNTDSObjectSID) {
+				continue
+			}
+			var sid []byte
+			if _, err := asn1.Unmarshal(on.Value.Bytes, &sid); err != nil {
+				return "", fmt.Errorf("invalid security identifier: %w", err)
+			}
+			return string(sid), nil
+		}
+	}
+	return "", nil
+}
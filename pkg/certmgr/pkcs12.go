@@ -0,0 +1,251 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"unicode/utf16"
+)
+
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	errPKCS12EmptyPassword = errors.New("PKCS#12 password must not be empty")
+)
+
+// structures below are defined in RFC 7292, raw values are explicitly tagged [0]
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+	MacSalt    []byte
+	Iterations int
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// MarshalPKCS12 encodes private key, its certificate and chain of issuing CAs as PKCS#12 archive protected by password.
+// Both key and certificates are encrypted using PBES2 (PBKDF2 with HMAC-SHA256 and AES-256-CBC),
+// integrity is protected by HMAC-SHA256, which is what OpenSSL 3 uses by default.
+func MarshalPKCS12(key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate, friendlyName string, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errPKCS12EmptyPassword
+	}
+	keyID := sha256.Sum256(cert.Raw)
+	attrs, err := bagAttributes(keyID[:20], friendlyName)
+	if err != nil {
+		return nil, err
+	}
+	var certBags []safeBag
+	for i, c := range append([]*x509.Certificate{cert}, chain...) {
+		bag, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: c.Raw})
+		if err != nil {
+			return nil, err
+		}
+		sb := safeBag{ID: oidCertBag, Value: explicit(bag)}
+		if i == 0 {
+			sb.Attributes = attrs
+		}
+		certBags = append(certBags, sb)
+	}
+	certs, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	alg, data, err := encryptPBES2(certs, password)
+	if err != nil {
+		return nil, err
+	}
+	ed, err := asn1.Marshal(encryptedData{
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: alg,
+			EncryptedContent:           data,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := marshalEncryptedKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := asn1.Marshal([]safeBag{{ID: oidShroudedKeyBag, Value: explicit(shrouded), Attributes: attrs}})
+	if err != nil {
+		return nil, err
+	}
+	keysData, err := asn1.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]contentInfo{
+		{ContentType: oidEncryptedData, Content: explicit(ed)},
+		{ContentType: oidData, Content: explicit(keysData)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafeData, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	p := pfx{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidData, Content: explicit(authSafeData)},
+	}
+	p.MacData.MacSalt = make([]byte, 16)
+	if _, err = rand.Read(p.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	p.MacData.Iterations = pbkdf2Iterations
+	p.MacData.Mac.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	mac := hmac.New(sha256.New, pkcs12KDF(bmpPassword(password), p.MacData.MacSalt, p.MacData.Iterations, 3, sha256.Size))
+	mac.Write(authSafe)
+	p.MacData.Mac.Digest = mac.Sum(nil)
+	return asn1.Marshal(p)
+}
+
+// explicit wraps DER-encoded value into explicit context-specific tag [0].
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// bagAttributes gets attributes that pair key with its certificate.
+func bagAttributes(keyID []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	id, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []pkcs12Attribute{{ID: oidLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: id}}}
+	if len(friendlyName) > 0 {
+		name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(friendlyName)})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, pkcs12Attribute{ID: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: name}})
+	}
+	return attrs, nil
+}
+
+// bmpString encodes s as UTF-16 big endian.
+func bmpString(s string) []byte {
+	var res []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		res = append(res, byte(c>>8), byte(c))
+	}
+	return res
+}
+
+// bmpPassword encodes password as null-terminated BMPString, as required by PKCS#12 key derivation.
+func bmpPassword(password []byte) []byte {
+	return append(bmpString(string(password)), 0, 0)
+}
+
+// pkcs12KDF derives key of given size from password using SHA-256, see RFC 7292, appendix B.2.
+func pkcs12KDF(password, salt []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha256.Size, 64
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		res := make([]byte, v*((len(b)+v-1)/v))
+		for i := range res {
+			res[i] = b[i%len(b)]
+		}
+		return res
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	in := append(fill(salt), fill(password)...)
+	var res []byte
+	one := big.NewInt(1)
+	for len(res) < size {
+		a := sha256.Sum256(append(d, in...))
+		for i := 1; i < iterations; i++ {
+			a = sha256.Sum256(a[:])
+		}
+		res = append(res, a[:]...)
+		b := new(big.Int).SetBytes(fill(a[:u]))
+		b.Add(b, one)
+		for j := 0; j < len(in); j += v {
+			ij := new(big.Int).SetBytes(in[j : j+v])
+			ij.Add(ij, b)
+			buf := ij.Bytes()
+			// keep v least significant bytes
+			if len(buf) > v {
+				buf = buf[len(buf)-v:]
+			}
+			copy(in[j:j+v], make([]byte, v))
+			copy(in[j+v-len(buf):j+v], buf)
+		}
+	}
+	return res[:size]
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is set of issuance rules kept in store, enforced whenever certificate is created or signed.
+type Policy struct {
+	// Default rules apply to all certificates
+	Default PolicyRules `yaml:"default"`
+	// CAs maps alias of issuing CA to rules of certificates it issues.
+	// Rules that are set override default ones, the rest is inherited.
+	CAs map[string]PolicyRules `yaml:"cas,omitempty"`
+}
+
+// PolicyRules restrict certificates. Empty rule doesn't restrict anything.
+type PolicyRules struct {
+	// MaxValidity is maximal validity period, like 8760h
+	MaxValidity time.Duration `yaml:"maxValidity,omitempty"`
+	// KeyTypes are allowed types of keys, like rsa4096, ec-p256 or ed25519
+	KeyTypes []string `yaml:"keyTypes,omitempty"`
+	// RequiredEKUs are extended key usages every leaf certificate must have, like serverAuth or clientAuth
+	RequiredEKUs []string `yaml:"requiredEKUs,omitempty"`
+	// SANSuffixes are domains that DNS names of leaf certificates must belong to, like example.com
+	SANSuffixes []string `yaml:"sanSuffixes,omitempty"`
+}
+
+var ekuNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+var keyTypes = []string{"rsa2048", "rsa3072", "rsa4096", "ec-p256", "ec-p384", "ec-p521", "ed25519"}
+
+// KeyType gets name of type of public key, like rsa2048, ec-p256 or ed25519.
+func KeyType(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa" + strconv.Itoa(k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ec-" + strings.ToLower(strings.ReplaceAll(k.Curve.Params().Name, "-", ""))
+	case ed25519.PublicKey:
+		return "ed25519"
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// ParsePolicy parses policy in YAML format. Unknown fields, key types and extended key usages are rejected.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+	}
+	for _, r := range append([]PolicyRules{p.Default}, lo.Values(p.CAs)...) {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+func (r *PolicyRules) validate() error {
+	if r.MaxValidity < 0 {
+		return fmt.Errorf("%w: maxValidity can't be negative", ErrInvalidPolicy)
+	}
+	for _, kt := range r.KeyTypes {
+		if !slices.Contains(keyTypes, kt) {
+			return fmt.Errorf("%w: unknown key type %s, expected one of %s", ErrInvalidPolicy, kt, strings.Join(keyTypes, ", "))
+		}
+	}
+	for _, eku := range r.RequiredEKUs {
+		if _, ok := ekuNames[eku]; !ok {
+			names := lo.Keys(ekuNames)
+			slices.Sort(names)
+			return fmt.Errorf("%w: unknown extended key usage %s, expected one of %s", ErrInvalidPolicy, eku, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// RulesFor gets rules of certificates issued by given CA. Self-signed certificates, with empty issuer, get default rules.
+func (p *Policy) RulesFor(issuer string) *PolicyRules {
+	r := p.Default
+	ca, ok := p.CAs[issuer]
+	if len(issuer) == 0 || !ok {
+		return &r
+	}
+	if ca.MaxValidity > 0 {
+		r.MaxValidity = ca.MaxValidity
+	}
+	if len(ca.KeyTypes) > 0 {
+		r.KeyTypes = ca.KeyTypes
+	}
+	if len(ca.RequiredEKUs) > 0 {
+		r.RequiredEKUs = ca.RequiredEKUs
+	}
+	if len(ca.SANSuffixes) > 0 {
+		r.SANSuffixes = ca.SANSuffixes
+	}
+	return &r
+}
+
+// Check checks certificate about to be issued along with type of its key.
+// Extended key usages and DNS names are only checked for leaf certificates.
+func (r *PolicyRules) Check(tmpl *x509.Certificate, keyType string) error {
+	if validity := tmpl.NotAfter.Sub(tmpl.NotBefore); r.MaxValidity > 0 && validity > r.MaxValidity {
+		return fmt.Errorf("%w: validity %s exceeds %s", ErrPolicyViolation, validity, r.MaxValidity)
+	}
+	if len(r.KeyTypes) > 0 && !slices.Contains(r.KeyTypes, keyType) {
+		return fmt.Errorf("%w: key type %s is not one of %s", ErrPolicyViolation, keyType, strings.Join(r.KeyTypes, ", "))
+	}
+	if tmpl.IsCA {
+		return nil
+	}
+	for _, name := range r.RequiredEKUs {
+		if !slices.Contains(tmpl.ExtKeyUsage, ekuNames[name]) {
+			return fmt.Errorf("%w: extended key usage %s is required", ErrPolicyViolation, name)
+		}
+	}
+	if len(r.SANSuffixes) > 0 {
+		for _, name := range tmpl.DNSNames {
+			if !lo.ContainsBy(r.SANSuffixes, func(suffix string) bool {
+				suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+				return name == suffix || strings.HasSuffix(name, "."+suffix)
+			}) {
+				return fmt.Errorf("%w: DNS name %s is not within %s", ErrPolicyViolation, name, strings.Join(r.SANSuffixes, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// policyRules loads policy from store and gets rules of certificates issued by given CA.
+// Nil is returned when store has no policy.
+func (cm *certMgr) policyRules(ctx context.Context, issuer string) (*PolicyRules, error) {
+	ps, ok := cm.store.(store.PolicySource)
+	if !ok {
+		return nil, nil
+	}
+	data, err := ps.ReadPolicy(ctx)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.RulesFor(issuer), nil
+}
+
+// enforcePolicy checks certificate about to be issued by given CA against policy kept in store, if any.
+func (cm *certMgr) enforcePolicy(ctx context.Context, issuer string, tmpl *x509.Certificate, keyType string) error {
+	r, err := cm.policyRules(ctx, issuer)
+	if err != nil || r == nil {
+		return err
+	}
+	return r.Check(tmpl, keyType)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"io/fs"
+	"strconv"
+	"time"
+)
+
+// RevocationReason is reason code of revocation, as defined in RFC 5280, section 5.3.1.
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+)
+
+// Revocation is record of certificate revocation.
+type Revocation struct {
+	Time   time.Time        `json:"time"`
+	Reason RevocationReason `json:"reason"`
+}
+
+func (cm *certMgr) Revoke(ctx context.Context, alias string, reason RevocationReason) error {
+	var cert *x509.Certificate
+	if err := func() (err error) {
+		cm.mu.Lock()
+		defer cm.mu.Unlock()
+		if cert, err = cm.loadCert(ctx, alias); err != nil {
+			return err
+		}
+		found, err := cm.store.Exists(ctx, alias, store.KindRevocation)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("%w: %s", ErrAlreadyRevoked, alias)
+		}
+		data, err := json.Marshal(&Revocation{Time: time.Now().UTC(), Reason: reason})
+		if err != nil {
+			return err
+		}
+		return cm.store.Write(ctx, alias, store.KindRevocation, data)
+	}(); err != nil {
+		return err
+	}
+	if err := cm.audit(ctx, AuditRevoke, alias, cert, map[string]string{
+		"reason": strconv.Itoa(int(reason)),
+	}); err != nil {
+		return err
+	}
+	return cm.fire(ctx, EventRevoke, alias, cert)
+}
+
+func (cm *certMgr) GetRevocation(ctx context.Context, alias string) (*Revocation, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	data, err := cm.read(ctx, alias, store.KindRevocation)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r Revocation
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid revocation record of %s: %w", alias, err)
+	}
+	return &r, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+const (
+	maxDNSNameLen  = 253
+	maxDNSLabelLen = 63
+)
+
+// normalizeSANs converts DNS names to lowercase ASCII form and IP addresses to canonical form,
+// removing duplicates. Names that can't be valid hostnames are rejected,
+// as is the same value passed as both DNS name and IP address.
+// Domains of email addresses and user principal names are converted the same way as DNS names.
+func normalizeSANs(cd *CertData) error {
+	var ips []net.IP
+	for _, ip := range cd.IPSan {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if !slices.ContainsFunc(ips, ip.Equal) {
+			ips = append(ips, ip)
+		}
+	}
+	var names []string
+	for _, san := range cd.DNSSan {
+		name, err := normalizeDNSName(san, cd.AllowUnderscore)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(name); ip != nil {
+			if slices.ContainsFunc(ips, ip.Equal) {
+				return fmt.Errorf("%w: %s is given as both DNS name and IP address", ErrDuplicateSAN, san)
+			}
+			return fmt.Errorf("%w: %s is IP address, use IP SAN instead", ErrInvalidDNSName, san)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	emails, err := normalizeMailboxes(cd.EmailSan, ErrInvalidEmail)
+	if err != nil {
+		return err
+	}
+	upns, err := normalizeMailboxes(cd.UPNSan, ErrInvalidUPN)
+	if err != nil {
+		return err
+	}
+	cd.IPSan = ips
+	cd.DNSSan = names
+	cd.EmailSan = emails
+	cd.UPNSan = upns
+	return nil
+}
+
+// normalizeMailboxes converts domains of names in user@domain form to lowercase ASCII, removing duplicates.
+// Local part is kept as is, since it may be case-sensitive.
+func normalizeMailboxes(sans []string, errInvalid error) ([]string, error) {
+	var res []string
+	for _, san := range sans {
+		i := strings.LastIndexByte(san, '@')
+		if i <= 0 || strings.ContainsFunc(san[:i], func(r rune) bool {
+			return r <= ' ' || r == 0x7f
+		}) {
+			return nil, fmt.Errorf("%w: %q", errInvalid, san)
+		}
+		domain, err := normalizeDNSName(san[i+1:], false)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", errInvalid, san, err)
+		}
+		if name := san[:i+1] + domain; !slices.Contains(res, name) {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// normalizeDNSName converts name to lowercase ASCII form without trailing dot and validates its syntax.
+// Placement of wildcard is left to validWildcards.
+func normalizeDNSName(san string, allowUnderscore bool) (string, error) {
+	name, err := ToASCII(strings.TrimSuffix(san, "."))
+	if err != nil {
+		return "", err
+	}
+	name = strings.ToLower(name)
+	if len(name) == 0 || len(name) > maxDNSNameLen {
+		return "", fmt.Errorf("%w: %q, length must be between 1 and %d", ErrInvalidDNSName, san, maxDNSNameLen)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > maxDNSLabelLen {
+			return "", fmt.Errorf("%w: %q, label length must be between 1 and %d", ErrInvalidDNSName, san, maxDNSLabelLen)
+		}
+		if i := strings.IndexFunc(label, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '*' || r == '_' && allowUnderscore)
+		}); i >= 0 {
+			return "", fmt.Errorf("%w: %q, character %q is not allowed", ErrInvalidDNSName, san, label[i])
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/shamir"
+	"strconv"
+)
+
+const (
+	typeKeyShare          = "PKITOOL KEY SHARE"
+	typeEncryptedKeyShare = "ENCRYPTED PKITOOL KEY SHARE"
+
+	headerAlias     = "Alias"
+	headerShare     = "Share"
+	headerThreshold = "Threshold"
+)
+
+// MarshalKeySharesPEM splits private key of alias into n shares, any threshold of which can reconstruct it.
+// Share i is encrypted using passwords[i] (PBES2 scheme, as used for private keys), unless it's missing or empty.
+func MarshalKeySharesPEM(key crypto.Signer, alias string, n, threshold int, passwords [][]byte) ([][]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := shamir.Split(der, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, 0, n)
+	for i, share := range shares {
+		block := &pem.Block{
+			Type: typeKeyShare,
+			Headers: map[string]string{
+				headerAlias:     alias,
+				headerShare:     fmt.Sprintf("%d/%d", i+1, n),
+				headerThreshold: strconv.Itoa(threshold),
+			},
+			Bytes: share,
+		}
+		if i < len(passwords) && len(passwords[i]) > 0 {
+			alg, data, err := encryptPBES2(share, passwords[i])
+			if err != nil {
+				return nil, err
+			}
+			if block.Bytes, err = asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: data}); err != nil {
+				return nil, err
+			}
+			block.Type = typeEncryptedKeyShare
+		}
+		res = append(res, pem.EncodeToMemory(block))
+	}
+	return res, nil
+}
+
+// ParseKeySharesPEM reconstructs private key from PEM-encoded shares, decrypting share i using passwords[i] when it's encrypted.
+// Alias that key belongs to is returned too. All shares must belong to the same alias, and there must be at least threshold of them.
+func ParseKeySharesPEM(shares [][]byte, passwords [][]byte) (string, crypto.Signer, error) {
+	var (
+		alias     string
+		threshold int
+		raw       [][]byte
+	)
+	for i, data := range shares {
+		block, _ := pem.Decode(data)
+		if block == nil || (block.Type != typeKeyShare && block.Type != typeEncryptedKeyShare) {
+			return "", nil, &PEMError{Type: typeKeyShare}
+		}
+		t, err := strconv.Atoi(block.Headers[headerThreshold])
+		if err != nil {
+			return "", nil, &PEMError{Type: block.Type, Err: fmt.Errorf("invalid %s header: %w", headerThreshold, err)}
+		}
+		if i == 0 {
+			alias, threshold = block.Headers[headerAlias], t
+		} else if block.Headers[headerAlias] != alias || t != threshold {
+			return "", nil, fmt.Errorf("%w: shares belong to different keys", shamir.ErrInvalidShares)
+		}
+		share := block.Bytes
+		if block.Type == typeEncryptedKeyShare {
+			var password []byte
+			if i < len(passwords) {
+				password = passwords[i]
+			}
+			if len(password) == 0 {
+				return "", nil, fmt.Errorf("share %s of '%s' is encrypted, password is required", block.Headers[headerShare], alias)
+			}
+			if share, err = decryptPBES2(share, password); err != nil {
+				return "", nil, &PEMError{Type: block.Type, Err: err}
+			}
+		}
+		raw = append(raw, share)
+	}
+	if len(raw) < threshold {
+		return "", nil, fmt.Errorf("%w: %d shares of '%s' are required, got %d", shamir.ErrInvalidShares, threshold, alias, len(raw))
+	}
+	der, err := shamir.Combine(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := parseKey(context.Background(), &pem.Block{Type: typePkcs8PrivateKey, Bytes: der})
+	if err != nil {
+		// wrong password can still yield valid padding, so it's likely cause too
+		return "", nil, fmt.Errorf("%w: can't reconstruct private key of '%s', shares or passwords are wrong", shamir.ErrInvalidShares, alias)
+	}
+	return alias, key, nil
+}
+
+// WithKey provides private key of alias that is not kept in store, like one reconstructed from shares.
+// It's used instead of key in store whenever alias issues certificate.
+func WithKey(alias string, key crypto.Signer) Option {
+	return func(cm *certMgr) {
+		if cm.keys == nil {
+			cm.keys = map[string]crypto.Signer{}
+		}
+		cm.keys[alias] = key
+	}
+}
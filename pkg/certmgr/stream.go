@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"io"
+	"slices"
+)
+
+// ListOptions controls what is yielded by ListStream.
+type ListOptions struct {
+	// ParseCert requests certificate of every alias to be loaded and parsed
+	ParseCert bool
+	// Offset is number of aliases to skip. Aliases are sorted by name when set.
+	Offset int
+	// Limit is maximum number of aliases to yield, zero means no limit. Aliases are sorted by name when set.
+	Limit int
+}
+
+// paginated checks whether only part of aliases is requested.
+func (o *ListOptions) paginated() bool {
+	return o.Offset > 0 || o.Limit > 0
+}
+
+// ListEntry is single item yielded by ListStream.
+type ListEntry struct {
+	Alias string
+	// Cert is certificate of alias, only set when ListOptions.ParseCert is true
+	Cert *x509.Certificate
+}
+
+// Stream iterates over aliases without loading all of them into memory first.
+// Aliases created or deleted during iteration may or may not be seen.
+// When only page of aliases is requested, names of all aliases are loaded to sort them,
+// but certificates are still only parsed for aliases within page, as they are yielded.
+//
+//	s, err := cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true})
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	for s.Next() {
+//		e := s.Entry()
+//		...
+//	}
+//	return s.Err()
+type Stream struct {
+	ctx  context.Context
+	cm   *certMgr
+	it   store.Iterator
+	opts ListOptions
+	cur  *ListEntry
+	err  error
+	// total number of aliases, only known for paginated stream
+	total int
+}
+
+// Next advances to the next entry. It returns false when there are no more entries or error occurred.
+func (s *Stream) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	alias, err := s.it.Next(s.ctx)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = err
+		}
+		s.cur = nil
+		return false
+	}
+	e := &ListEntry{Alias: alias}
+	if s.opts.ParseCert {
+		s.cm.mu.RLock()
+		e.Cert, err = s.cm.loadCert(s.ctx, alias)
+		s.cm.mu.RUnlock()
+		if err != nil {
+			s.err = err
+			s.cur = nil
+			return false
+		}
+	}
+	s.cur = e
+	return true
+}
+
+// Entry gets current entry.
+func (s *Stream) Entry() *ListEntry {
+	return s.cur
+}
+
+// Err gets error that stopped iteration, if any.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Total gets number of all aliases in store, regardless of offset and limit.
+// It's -1 unless only page of aliases was requested.
+func (s *Stream) Total() int {
+	return s.total
+}
+
+// Close releases resources held by stream.
+func (s *Stream) Close() error {
+	return s.it.Close()
+}
+
+func (cm *certMgr) ListStream(ctx context.Context, opts *ListOptions) (*Stream, error) {
+	it, err := store.Stream(ctx, cm.store)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{ctx: ctx, cm: cm, it: it, total: -1}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if !s.opts.paginated() {
+		return s, nil
+	}
+	defer func() {
+		_ = it.Close()
+	}()
+	var aliases []string
+	for {
+		alias, err := it.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	slices.Sort(aliases)
+	s.total = len(aliases)
+	aliases = aliases[min(max(s.opts.Offset, 0), len(aliases)):]
+	if s.opts.Limit > 0 {
+		aliases = aliases[:min(s.opts.Limit, len(aliases))]
+	}
+	s.it = store.NewSliceIterator(aliases)
+	return s, nil
+}
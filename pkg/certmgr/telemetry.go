@@ -0,0 +1,68 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certmgr
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// instrumentationScope names spans and metrics recorded by certificate manager.
+// Global providers are used, so they are no-op unless server enables telemetry.
+const instrumentationScope = "github.com/rkosegi/pkitool/pkg/certmgr"
+
+var (
+	tracer = otel.Tracer(instrumentationScope)
+	meter  = otel.Meter(instrumentationScope)
+
+	// RSA key generation takes from milliseconds to tens of seconds, depending on key size
+	durationBuckets = metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60)
+
+	issuanceDuration, _ = meter.Float64Histogram("pkitool.issuance.duration", metric.WithUnit("s"), durationBuckets,
+		metric.WithDescription("Duration of certificate issuance, including key generation"))
+	keygenDuration, _ = meter.Float64Histogram("pkitool.keygen.duration", metric.WithUnit("s"), durationBuckets,
+		metric.WithDescription("Duration of private key generation"))
+)
+
+// traced starts span of operation and returns function that ends it, recording its duration into histogram.
+// Outcome of operation is attribute of both span and recorded duration.
+func traced(ctx context.Context, name string, h metric.Float64Histogram, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		outcome := attribute.String("outcome", "success")
+		if err != nil {
+			outcome = attribute.String("outcome", "error")
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(outcome)
+		span.End()
+		h.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(append(attrs, outcome)...))
+	}
+}
+
+// traceIssuance traces issuance of certificate by CA, which is empty for self-signed certificates.
+func traceIssuance(ctx context.Context, operation, ca string) (context.Context, func(error)) {
+	return traced(ctx, "certmgr."+operation, issuanceDuration,
+		attribute.String("operation", operation), attribute.String("ca", ca))
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package check implements check plugins for classic monitoring systems.
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// State is result of check, as defined by Nagios plugin API. Values are used as exit codes.
+type State int
+
+const (
+	StateOK State = iota
+	StateWarning
+	StateCritical
+	StateUnknown
+)
+
+var stateNames = map[State]string{
+	StateOK:       "OK",
+	StateWarning:  "WARNING",
+	StateCritical: "CRITICAL",
+	StateUnknown:  "UNKNOWN",
+}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
+type nagiosData struct {
+	w        io.Writer
+	dir      string
+	aliases  []string
+	warning  int
+	critical int
+}
+
+type result struct {
+	alias   string
+	state   State
+	message string
+	days    int
+}
+
+func checkCert(ctx context.Context, d *nagiosData, cm certmgr.Reader, alias string, now time.Time) *result {
+	r := &result{alias: alias}
+	cert, err := cm.GetCert(ctx, alias)
+	if err != nil {
+		r.state, r.message = StateUnknown, err.Error()
+		return r
+	}
+	rev, err := cm.GetRevocation(ctx, alias)
+	if err != nil {
+		r.state, r.message = StateUnknown, err.Error()
+		return r
+	}
+	r.days = int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24))
+	switch {
+	case rev != nil:
+		r.state, r.message = StateCritical, fmt.Sprintf("%s was revoked at %s", alias, rev.Time.Format(time.DateOnly))
+	case now.After(cert.NotAfter):
+		r.state, r.message = StateCritical, fmt.Sprintf("%s expired at %s", alias, cert.NotAfter.Format(time.DateOnly))
+	case now.Before(cert.NotBefore):
+		r.state, r.message = StateCritical, fmt.Sprintf("%s is not valid before %s", alias, cert.NotBefore.Format(time.DateOnly))
+	default:
+		r.message = fmt.Sprintf("%s expires in %d days (%s)", alias, r.days, cert.NotAfter.Format(time.DateOnly))
+		if r.days < d.critical {
+			r.state = StateCritical
+		} else if r.days < d.warning {
+			r.state = StateWarning
+		}
+	}
+	return r
+}
+
+// nagios checks certificates and prints results in format of Nagios plugin output.
+// Worst state of all certificates is returned.
+func nagios(ctx context.Context, d *nagiosData) (State, error) {
+	cm := certmgr.New(d.dir)
+	aliases := d.aliases
+	if len(aliases) == 0 {
+		var err error
+		if aliases, err = cm.List(ctx); err != nil {
+			_, _ = fmt.Fprintf(d.w, "CERT UNKNOWN - %v\n", err)
+			return StateUnknown, nil
+		}
+		slices.Sort(aliases)
+	}
+	now := time.Now()
+	results := make([]*result, 0, len(aliases))
+	worst := StateOK
+	for _, alias := range aliases {
+		r := checkCert(ctx, d, cm, alias, now)
+		results = append(results, r)
+		worst = max(worst, r.state)
+	}
+	// problems go first, so that summary line is meaningful
+	slices.SortStableFunc(results, func(a, b *result) int {
+		return int(b.state) - int(a.state)
+	})
+	var summary string
+	switch {
+	case len(results) == 0:
+		summary = "no certificates found"
+	case len(results) == 1:
+		summary = results[0].message
+	default:
+		summary = fmt.Sprintf("%d certificates checked, %s", len(results), results[0].message)
+	}
+	perf := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.state != StateUnknown {
+			perf = append(perf, fmt.Sprintf("'%s'=%dd;%d;%d", r.alias, r.days, d.warning, d.critical))
+		}
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CERT %s - %s", worst, summary)
+	if len(perf) > 0 {
+		fmt.Fprintf(&sb, " | %s", strings.Join(perf, " "))
+	}
+	sb.WriteString("\n")
+	if len(results) > 1 {
+		for _, r := range results {
+			fmt.Fprintf(&sb, "%s: %s\n", r.state, r.message)
+		}
+	}
+	_, err := io.WriteString(d.w, sb.String())
+	return worst, err
+}
+
+func validateNagios(d *nagiosData) error {
+	if d.critical > d.warning {
+		return errors.New("critical threshold must not be greater than warning threshold")
+	}
+	return nil
+}
+
+func newNagiosSubCommand(w io.Writer) *cobra.Command {
+	d := &nagiosData{
+		w:        w,
+		dir:      ".",
+		warning:  30,
+		critical: 7,
+	}
+	cmd := &cobra.Command{
+		Use:   "nagios",
+		Short: "Check expiry of certificates as Nagios/Icinga plugin",
+		Long: "Check expiry of certificates as Nagios/Icinga plugin.\n" +
+			"Exit code is 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN)",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// plugin output format is given by Nagios
+			err := common.RequireTable(cmd)
+			if err == nil {
+				err = validateNagios(d)
+			}
+			if err != nil {
+				_, _ = fmt.Fprintf(d.w, "CERT %s - %v\n", StateUnknown, err)
+				return &common.ExitError{Code: int(StateUnknown)}
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state, err := nagios(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if state != StateOK {
+				return &common.ExitError{Code: int(state)}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.aliases, "alias", d.aliases, "Alias of certificate to check, can be repeated. All certificates are checked when not set")
+	cmd.Flags().IntVarP(&d.warning, "warning", "w", d.warning, "Warn when certificate expires in less than given number of days")
+	cmd.Flags().IntVarP(&d.critical, "critical", "c", d.critical, "Report critical state when certificate expires in less than given number of days")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check certificates for monitoring systems",
+	}
+	cmd.AddCommand(newDuplicatesSubCommand(out))
+	cmd.AddCommand(newExpirySubCommand(out))
+	cmd.AddCommand(newNagiosSubCommand(out))
+	cmd.AddCommand(newPermsSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	// duplicateCert is group of aliases holding identical certificate
+	duplicateCert = "certificate"
+	// duplicateKey is group of aliases holding different certificates of the same public key
+	duplicateKey = "key"
+)
+
+type duplicatesData struct {
+	w   io.Writer
+	dir string
+	of  common.OutputFormat
+	// ignoreKeyReuse disables reporting of certificates that share key, like those renewed without rekeying
+	ignoreKeyReuse bool
+}
+
+// duplicate is group of aliases sharing certificate or public key.
+type duplicate struct {
+	Type string `json:"type" yaml:"type"`
+	// Fingerprint is SHA-256 of certificate or of subject public key info
+	Fingerprint string   `json:"fingerprint" yaml:"fingerprint"`
+	Aliases     []string `json:"aliases" yaml:"aliases"`
+}
+
+func fingerprintOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// groupsOf collects groups with more than one alias, ordered by first alias.
+func groupsOf(typ string, groups map[string][]string) []duplicate {
+	var res []duplicate
+	for fp, aliases := range groups {
+		if len(aliases) > 1 {
+			slices.Sort(aliases)
+			res = append(res, duplicate{Type: typ, Fingerprint: fp, Aliases: aliases})
+		}
+	}
+	slices.SortFunc(res, func(a, b duplicate) int {
+		return strings.Compare(a.Aliases[0], b.Aliases[0])
+	})
+	return res
+}
+
+// findDuplicates groups aliases by certificate fingerprint and by public key.
+// Aliases with identical certificate count as single certificate when looking for key reuse.
+func findDuplicates(ctx context.Context, d *duplicatesData) ([]duplicate, error) {
+	cm := certmgr.New(d.dir)
+	s, err := cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	certs := map[string][]string{}
+	// public key fingerprint -> certificate fingerprint -> aliases
+	keys := map[string]map[string][]string{}
+	for s.Next() {
+		e := s.Entry()
+		cfp := fingerprintOf(e.Cert.Raw)
+		kfp := fingerprintOf(e.Cert.RawSubjectPublicKeyInfo)
+		certs[cfp] = append(certs[cfp], e.Alias)
+		if keys[kfp] == nil {
+			keys[kfp] = map[string][]string{}
+		}
+		keys[kfp][cfp] = append(keys[kfp][cfp], e.Alias)
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	res := groupsOf(duplicateCert, certs)
+	if d.ignoreKeyReuse {
+		return res, nil
+	}
+	reused := map[string][]string{}
+	for kfp, byCert := range keys {
+		if len(byCert) < 2 {
+			continue
+		}
+		for _, aliases := range byCert {
+			reused[kfp] = append(reused[kfp], aliases...)
+		}
+	}
+	return append(res, groupsOf(duplicateKey, reused)...), nil
+}
+
+// duplicates reports duplicates, returning true when any was found.
+func duplicates(ctx context.Context, d *duplicatesData) (bool, error) {
+	res, err := findDuplicates(ctx, d)
+	if err != nil {
+		return false, err
+	}
+	if res == nil {
+		res = []duplicate{}
+	}
+	return len(res) > 0, common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Type", "Fingerprint", "Aliases"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		tbl.SetAutoWrapText(false)
+		for _, e := range res {
+			tbl.Append([]string{e.Type, e.Fingerprint, strings.Join(e.Aliases, ", ")})
+		}
+	})
+}
+
+func newDuplicatesSubCommand(w io.Writer) *cobra.Command {
+	d := &duplicatesData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "duplicates",
+		Short: "Report aliases holding identical certificates and certificates reusing the same key",
+		Long: "Report aliases holding identical certificates and different certificates reusing the same key.\n" +
+			"Fingerprints are SHA-256 of certificate or of its subject public key info. Exit code is 1 when any duplicate is found.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			found, err := duplicates(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if found {
+				// duplicates were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&d.ignoreKeyReuse, "ignore-key-reuse", d.ignoreKeyReuse, "Only report identical certificates, "+
+		"not certificates renewed with the same key")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
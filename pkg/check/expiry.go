@@ -0,0 +1,276 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/notify"
+	"github.com/spf13/cobra"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+type expiryData struct {
+	w          io.Writer
+	errw       io.Writer
+	dir        string
+	config     string
+	aliases    []string
+	thresholds []int
+	stateFile  string
+	interval   time.Duration
+	timeout    time.Duration
+}
+
+// notified is last threshold certificate was notified about, so that every threshold is notified only once.
+type notified struct {
+	Serial    string `json:"serial"`
+	Threshold int    `json:"threshold"`
+}
+
+// expiryState maps aliases to notifications sent.
+type expiryState map[string]notified
+
+func loadState(path string) (expiryState, error) {
+	st := expiryState{}
+	if len(path) == 0 {
+		return st, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return st, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// save writes state to temporary file first, so that existing one isn't left incomplete on error.
+func (st expiryState) save(path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".expiry-state-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// crossed gets the lowest threshold that certificate expiring in given number of days crossed.
+// Zero is returned for expired certificate and -1 when no threshold was crossed.
+func crossed(thresholds []int, days int, expired bool) int {
+	if expired {
+		return 0
+	}
+	res := -1
+	for _, t := range thresholds {
+		if days <= t && (res == -1 || t < res) {
+			res = t
+		}
+	}
+	return res
+}
+
+// alerts finds certificates that crossed threshold they weren't notified about yet.
+// Revoked certificates are skipped, they are not going to be renewed.
+func alerts(ctx context.Context, d *expiryData, cm certmgr.Reader, st expiryState, now time.Time) ([]*notify.Alert, error) {
+	aliases := d.aliases
+	if len(aliases) == 0 {
+		var err error
+		if aliases, err = cm.List(ctx); err != nil {
+			return nil, err
+		}
+		slices.Sort(aliases)
+	}
+	var res []*notify.Alert
+	for _, alias := range aliases {
+		cert, err := cm.GetCert(ctx, alias)
+		if err != nil {
+			return nil, err
+		}
+		rev, err := cm.GetRevocation(ctx, alias)
+		if err != nil {
+			return nil, err
+		}
+		if rev != nil {
+			continue
+		}
+		a := &notify.Alert{
+			Alias:    alias,
+			Subject:  cert.Subject.String(),
+			Serial:   cert.SerialNumber.String(),
+			NotAfter: cert.NotAfter,
+			Days:     int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24)),
+			Expired:  now.After(cert.NotAfter),
+		}
+		a.Threshold = crossed(d.thresholds, a.Days, a.Expired)
+		if a.Threshold == -1 {
+			continue
+		}
+		// renewed certificate starts over
+		if last, ok := st[alias]; ok && last.Serial == a.Serial && last.Threshold <= a.Threshold {
+			continue
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// checkExpiry notifies about certificates that crossed expiry threshold.
+// Alerts that failed to be delivered are retried on the next check.
+func checkExpiry(ctx context.Context, d *expiryData, n notify.Notifier, cm certmgr.Reader, st expiryState) error {
+	found, err := alerts(ctx, d, cm, st, time.Now())
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, a := range found {
+		if err = n.Notify(ctx, a); err != nil {
+			errs = append(errs, fmt.Errorf("notification about '%s' failed: %w", a.Alias, err))
+			continue
+		}
+		st[a.Alias] = notified{Serial: a.Serial, Threshold: a.Threshold}
+		if _, err = fmt.Fprintf(d.w, "%s notified about '%s' expiring in %d days (%s)\n",
+			time.Now().Format(time.RFC3339), a.Alias, a.Days, a.NotAfter.Format(time.DateOnly)); err != nil {
+			return err
+		}
+	}
+	if len(found) > len(errs) {
+		if err = st.save(d.stateFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expiry checks certificates once, or periodically when interval is set.
+// Errors of periodic checks are only reported, so that single failure doesn't stop notifications.
+func expiry(ctx context.Context, d *expiryData) error {
+	cfg, err := config.Load(d.config)
+	if err != nil {
+		return err
+	}
+	n, err := notify.NewMulti(&cfg.Notify, d.timeout)
+	if err != nil {
+		return err
+	}
+	if len(d.thresholds) == 0 {
+		if err = validateThresholds(cfg.Notify.Thresholds); err != nil {
+			return err
+		}
+		d.thresholds = cfg.Notify.Thresholds
+	}
+	if len(d.thresholds) == 0 {
+		d.thresholds = notify.DefaultThresholds
+	}
+	st, err := loadState(d.stateFile)
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	if d.interval == 0 {
+		return checkExpiry(ctx, d, n, cm, st)
+	}
+	for {
+		if err = checkExpiry(ctx, d, n, cm, st); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			_, _ = fmt.Fprintf(d.errw, "%s %v\n", time.Now().Format(time.RFC3339), err)
+		}
+		t := time.NewTimer(d.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func validateThresholds(thresholds []int) error {
+	for _, t := range thresholds {
+		if t <= 0 {
+			return fmt.Errorf("invalid threshold: %d, must be positive number of days", t)
+		}
+	}
+	return nil
+}
+
+func newExpirySubCommand(w io.Writer) *cobra.Command {
+	d := &expiryData{
+		w:       w,
+		dir:     ".",
+		timeout: 30 * time.Second,
+	}
+	cmd := &cobra.Command{
+		Use:   "expiry",
+		Short: "Notify about certificates crossing expiry thresholds using notifiers configured in pkitool.yaml",
+		Long: "Notify about certificates crossing expiry thresholds using webhook, Slack or e-mail notifiers configured in pkitool.yaml.\n" +
+			"Every threshold is notified only once per certificate when state file is used, or when running periodically.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateThresholds(d.thresholds)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			if len(d.config) == 0 {
+				d.config = config.PathFor(d.dir)
+			}
+			return expiry(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.aliases, "alias", d.aliases, "Alias of certificate to check, can be repeated. All certificates are checked when not set")
+	cmd.Flags().IntSliceVar(&d.thresholds, "threshold", d.thresholds, "Days before expiration to notify at, like 30,7,1. Overrides thresholds from configuration")
+	cmd.Flags().StringVar(&d.config, "config", d.config, "Configuration file with notifiers, defaults to "+config.FileName+" in directory")
+	cmd.Flags().StringVar(&d.stateFile, "state-file", d.stateFile, "File to remember sent notifications in, so that they are not repeated by subsequent runs")
+	cmd.Flags().DurationVar(&d.interval, "interval", d.interval, "How often to check certificates. Only checked once when not set")
+	cmd.Flags().DurationVar(&d.timeout, "timeout", d.timeout, "Timeout of HTTP requests")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
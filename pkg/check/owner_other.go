@@ -0,0 +1,31 @@
+//go:build !unix
+
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"os"
+)
+
+// openNoFollow is not supported, fixed file is compared with result of Lstat instead.
+const openNoFollow = 0
+
+// ownerOf gets numeric IDs of user and group that own file, which is only supported on unix systems.
+func ownerOf(os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/store"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type permsData struct {
+	w      io.Writer
+	dir    string
+	config string
+	fix    bool
+	of     common.OutputFormat
+}
+
+// keyPolicy is resolved policy of private key files. Negative IDs are not checked.
+type keyPolicy struct {
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// permsEntry is private key file that violates policy.
+type permsEntry struct {
+	Alias    string   `json:"alias" yaml:"alias"`
+	File     string   `json:"file" yaml:"file"`
+	Mode     string   `json:"mode" yaml:"mode"`
+	Problems []string `json:"problems" yaml:"problems"`
+	Fixed    bool     `json:"fixed" yaml:"fixed"`
+	Error    string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// resolveID resolves user or group given by name or numeric ID, -1 is returned when it's not set.
+func resolveID(name string, lookup func(string) (string, error)) (int, error) {
+	if len(name) == 0 {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+func policyOf(p *config.Permissions) (*keyPolicy, error) {
+	mode, err := p.Mode(store.Perm(store.KindKey))
+	if err != nil {
+		return nil, err
+	}
+	kp := &keyPolicy{mode: mode}
+	if kp.uid, err = resolveID(p.Owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}); err != nil {
+		return nil, err
+	}
+	if kp.gid, err = resolveID(p.Group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	}); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// fixKeyFile changes ownership (unless uid and gid are negative) and mode of file described by fi.
+// File is changed through its descriptor and symbolic links are not followed,
+// so that link planted in directory can't redirect fix to other file.
+func fixKeyFile(file string, fi os.FileInfo, uid, gid int, mode os.FileMode) error {
+	f, err := os.OpenFile(file, os.O_RDONLY|openNoFollow, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	ofi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(fi, ofi) {
+		return errors.New("file was replaced during check")
+	}
+	// ownership goes first, as chown may clear setuid/setgid bits
+	if uid >= 0 || gid >= 0 {
+		if err = f.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	return f.Chmod(mode)
+}
+
+// checkKeyFile checks single private key file and fixes it when requested. Nil is returned when file complies with policy.
+func checkKeyFile(d *permsData, kp *keyPolicy, alias, file string) (*permsEntry, error) {
+	fi, err := os.Lstat(file)
+	if err != nil {
+		return nil, err
+	}
+	e := &permsEntry{Alias: alias, File: file, Mode: fmt.Sprintf("%04o", fi.Mode().Perm())}
+	// symbolic links and other special files are never fixed, fix could change file they point to
+	if !fi.Mode().IsRegular() {
+		e.Problems = append(e.Problems, "not a regular file")
+		return e, nil
+	}
+	if extra := fi.Mode().Perm() &^ kp.mode; extra != 0 {
+		e.Problems = append(e.Problems, fmt.Sprintf("mode is more permissive than %04o", kp.mode))
+	}
+	uid, gid := -1, -1
+	if kp.uid >= 0 || kp.gid >= 0 {
+		fuid, fgid, ok := ownerOf(fi)
+		if !ok {
+			return nil, errors.New("ownership of files can't be checked on this system")
+		}
+		if kp.uid >= 0 && fuid != kp.uid {
+			e.Problems = append(e.Problems, fmt.Sprintf("owned by user %d instead of %d", fuid, kp.uid))
+			uid = kp.uid
+		}
+		if kp.gid >= 0 && fgid != kp.gid {
+			e.Problems = append(e.Problems, fmt.Sprintf("owned by group %d instead of %d", fgid, kp.gid))
+			gid = kp.gid
+		}
+	}
+	if len(e.Problems) == 0 {
+		return nil, nil
+	}
+	if !d.fix {
+		return e, nil
+	}
+	// only bits allowed by policy are kept, so that fix never makes file more accessible
+	if err = fixKeyFile(file, fi, uid, gid, fi.Mode().Perm()&kp.mode); err != nil {
+		e.Error = err.Error()
+		return e, nil
+	}
+	e.Fixed = true
+	return e, nil
+}
+
+// perms checks all private key files in directory. Returns true when any violation remains.
+func perms(ctx context.Context, d *permsData) (bool, error) {
+	cfg, err := config.Load(d.config)
+	if err != nil {
+		return false, err
+	}
+	kp, err := policyOf(&cfg.Permissions)
+	if err != nil {
+		return false, err
+	}
+	s := store.New(d.dir)
+	l, ok := s.(store.Locator)
+	if !ok {
+		return false, fmt.Errorf("store %s doesn't keep private keys in local files", d.dir)
+	}
+	aliases, err := s.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	slices.Sort(aliases)
+	res := []*permsEntry{}
+	remaining := false
+	for _, alias := range aliases {
+		found, err := s.Exists(ctx, alias, store.KindKey)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			continue
+		}
+		e, err := checkKeyFile(d, kp, alias, l.Path(alias, store.KindKey))
+		if err != nil {
+			return false, err
+		}
+		if e != nil {
+			res = append(res, e)
+			remaining = remaining || !e.Fixed
+		}
+	}
+	return remaining, common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Alias", "File", "Mode", "Problems", "Result"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range res {
+			result := "not fixed"
+			switch {
+			case e.Fixed:
+				result = "fixed"
+			case len(e.Error) > 0:
+				result = "failed: " + e.Error
+			}
+			tbl.Append([]string{e.Alias, e.File, e.Mode, strings.Join(e.Problems, ", "), result})
+		}
+	})
+}
+
+func newPermsSubCommand(w io.Writer) *cobra.Command {
+	d := &permsData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "perms",
+		Short: "Check that private key files are not accessible to others and optionally fix them",
+		Long: "Check that private key files have mode and ownership required by permissions policy in pkitool.yaml.\n" +
+			fmt.Sprintf("Without policy, mode must not be more permissive than %04o and ownership is not checked.\n", store.Perm(store.KindKey)) +
+			"Exit code is 1 when any violation remains.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			if len(d.config) == 0 {
+				d.config = config.PathFor(d.dir)
+			}
+			remaining, err := perms(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if remaining {
+				// violations were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&d.fix, "fix", d.fix, "Correct mode and ownership of violating files")
+	cmd.Flags().StringVar(&d.config, "config", d.config, "Configuration file with permissions policy, defaults to "+config.FileName+" in directory")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -17,23 +17,83 @@ limitations under the License.
 package cmd
 
 import (
+	"github.com/rkosegi/pkitool/pkg/acme"
+	"github.com/rkosegi/pkitool/pkg/auditlog"
+	"github.com/rkosegi/pkitool/pkg/bench"
+	"github.com/rkosegi/pkitool/pkg/bootstrap"
+	"github.com/rkosegi/pkitool/pkg/check"
+	"github.com/rkosegi/pkitool/pkg/cmp"
+	"github.com/rkosegi/pkitool/pkg/cms"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/create"
+	"github.com/rkosegi/pkitool/pkg/ct"
+	"github.com/rkosegi/pkitool/pkg/delegated"
+	"github.com/rkosegi/pkitool/pkg/devcert"
+	"github.com/rkosegi/pkitool/pkg/docs"
+	"github.com/rkosegi/pkitool/pkg/escrow"
+	"github.com/rkosegi/pkitool/pkg/export"
+	"github.com/rkosegi/pkitool/pkg/fingerprint"
+	"github.com/rkosegi/pkitool/pkg/importer"
+	"github.com/rkosegi/pkitool/pkg/iot"
+	"github.com/rkosegi/pkitool/pkg/k8s"
+	"github.com/rkosegi/pkitool/pkg/list"
+	"github.com/rkosegi/pkitool/pkg/migrate"
+	"github.com/rkosegi/pkitool/pkg/monitor"
+	"github.com/rkosegi/pkitool/pkg/offline"
+	"github.com/rkosegi/pkitool/pkg/reconcile"
+	"github.com/rkosegi/pkitool/pkg/remove"
+	"github.com/rkosegi/pkitool/pkg/selfupdate"
+	"github.com/rkosegi/pkitool/pkg/serve"
+	"github.com/rkosegi/pkitool/pkg/shortlived"
+	"github.com/rkosegi/pkitool/pkg/show"
+	"github.com/rkosegi/pkitool/pkg/timestamp"
+	"github.com/rkosegi/pkitool/pkg/tlstest"
+	"github.com/rkosegi/pkitool/pkg/version"
 	"github.com/spf13/cobra"
 	"io"
-	"pkitool/pkg/create"
-	"pkitool/pkg/list"
-	"pkitool/pkg/remove"
-	"pkitool/pkg/show"
 )
 
-func New(in io.Reader, out, _ io.Writer) *cobra.Command {
+func New(in io.Reader, out, errOut io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Short: "CLI tool to manipulate PKI objects (certificates, private keys) in directory",
 		Use:   "pkitool",
 	}
+	cmd.SetErr(errOut)
 	cmd.ResetFlags()
+	of := common.OutputTable
+	common.AddOutputFlag(&of, cmd.PersistentFlags())
+	cm := common.ColorAuto
+	common.AddColorFlag(&cm, cmd.PersistentFlags())
+	cmd.AddCommand(bootstrap.NewCommand(out))
 	cmd.AddCommand(create.NewCommand(in, out))
-	cmd.AddCommand(show.NewCommand(out))
+	cmd.AddCommand(devcert.NewCommand(out))
+	cmd.AddCommand(escrow.NewCommand(out))
+	cmd.AddCommand(show.NewCommand(in, out))
+	cmd.AddCommand(timestamp.NewCommand(out))
 	cmd.AddCommand(list.NewCommand(out))
 	cmd.AddCommand(remove.NewCommand(out))
+	cmd.AddCommand(fingerprint.NewCommand(in, out))
+	cmd.AddCommand(version.NewCommand(out))
+	cmd.AddCommand(docs.NewCommand(out))
+	cmd.AddCommand(selfupdate.NewCommand(out))
+	cmd.AddCommand(shortlived.NewCommand(out))
+	cmd.AddCommand(serve.NewCommand(out))
+	cmd.AddCommand(acme.NewCommand(out))
+	cmd.AddCommand(check.NewCommand(out))
+	cmd.AddCommand(cmp.NewCommand(out))
+	cmd.AddCommand(cms.NewCommand(in, out))
+	cmd.AddCommand(export.NewCommand(out))
+	cmd.AddCommand(tlstest.NewCommand(out))
+	cmd.AddCommand(ct.NewCommand(out))
+	cmd.AddCommand(migrate.NewCommand(out))
+	cmd.AddCommand(bench.NewCommand(out))
+	cmd.AddCommand(auditlog.NewCommand(out))
+	cmd.AddCommand(importer.NewCommand(out))
+	cmd.AddCommand(k8s.NewCommand(out))
+	cmd.AddCommand(iot.NewCommand(in, out))
+	cmd.AddCommand(delegated.NewCommand(out))
+	cmd.AddCommand(reconcile.NewCommand(in, out))
+	cmd.AddCommand(offline.NewCommand(in, out))
+	cmd.AddCommand(monitor.NewCommand(out))
 	return cmd
 }
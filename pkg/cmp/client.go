@@ -0,0 +1,316 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	contentType = "application/pkixcmp"
+	nonceSize   = 16
+	// limit of response size, CMP responses are small
+	maxResponseSize = 1 << 20
+)
+
+// client talks to CMP server over HTTP transport, RFC 6712.
+type client struct {
+	url        string
+	httpClient *http.Client
+	// recipient is encoded DN of CA, NULL-DN is used when empty
+	recipient []byte
+	protector protector
+}
+
+// transaction holds state shared by messages of single CMP transaction.
+type transaction struct {
+	c      *client
+	id     []byte
+	sender asn1.RawValue
+	// last nonce received from server
+	recipNonce []byte
+}
+
+// response is verified response of server.
+type response struct {
+	header     *pkiHeader
+	body       asn1.RawValue
+	extraCerts []*x509.Certificate
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func (c *client) newTransaction(sender []byte) (*transaction, error) {
+	id, err := randomBytes(nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{c: c, id: id, sender: directoryName(sender)}, nil
+}
+
+func (c *client) post(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CMP server responded with HTTP status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != contentType {
+		return nil, fmt.Errorf("unexpected content type of CMP response: '%s'", ct)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+}
+
+// exchange sends body to server and returns verified response.
+func (t *transaction) exchange(ctx context.Context, body asn1.RawValue) (*response, error) {
+	nonce, err := randomBytes(nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	h := &pkiHeader{
+		PVNO:          pvno2000,
+		Sender:        t.sender,
+		Recipient:     directoryName(t.c.recipient),
+		MessageTime:   time.Now().UTC().Truncate(time.Second),
+		TransactionID: t.id,
+		SenderNonce:   nonce,
+		RecipNonce:    t.recipNonce,
+	}
+	if err = t.c.protector.prepare(h); err != nil {
+		return nil, err
+	}
+	hdr, err := asn1.Marshal(*h)
+	if err != nil {
+		return nil, err
+	}
+	msg := &pkiMessage{Header: asn1.RawValue{FullBytes: hdr}, Body: body}
+	part, err := msg.protectedPart()
+	if err != nil {
+		return nil, err
+	}
+	protection, err := t.c.protector.protect(part)
+	if err != nil {
+		return nil, err
+	}
+	msg.Protection = asn1.BitString{Bytes: protection, BitLength: len(protection) * 8}
+	for _, der := range t.c.protector.extraCerts() {
+		msg.ExtraCerts = append(msg.ExtraCerts, asn1.RawValue{FullBytes: der})
+	}
+	data, err := asn1.Marshal(*msg)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = t.c.post(ctx, data); err != nil {
+		return nil, err
+	}
+	return t.parse(data, nonce)
+}
+
+// parse decodes response and checks that it belongs to transaction and is correctly protected.
+func (t *transaction) parse(data, nonce []byte) (*response, error) {
+	var msg pkiMessage
+	if err := unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("invalid CMP response: %w", err)
+	}
+	resp := &response{header: &pkiHeader{}, body: msg.Body}
+	if err := unmarshal(msg.Header.FullBytes, resp.header); err != nil {
+		return nil, fmt.Errorf("invalid header of CMP response: %w", err)
+	}
+	for _, der := range parseCertificates(msg.ExtraCerts) {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		resp.extraCerts = append(resp.extraCerts, cert)
+	}
+	// errors are reported even when they are not protected, there is nothing to lose
+	if msg.Body.Tag == bodyError {
+		var content errorMsgContent
+		if err := unmarshal(msg.Body.Bytes, &content); err != nil {
+			return nil, err
+		}
+		content.Status.StatusString = append(content.Status.StatusString, content.ErrorDetails...)
+		return nil, &content.Status
+	}
+	if !bytes.Equal(resp.header.TransactionID, t.id) {
+		return nil, errors.New("transaction ID of CMP response doesn't match request")
+	}
+	if !bytes.Equal(resp.header.RecipNonce, nonce) {
+		return nil, errors.New("recipient nonce of CMP response doesn't match request")
+	}
+	if msg.Protection.BitLength == 0 {
+		return nil, errProtectionMissing
+	}
+	part, err := msg.protectedPart()
+	if err != nil {
+		return nil, err
+	}
+	if err = t.c.protector.verify(resp.header, part, msg.Protection.Bytes, resp.extraCerts); err != nil {
+		return nil, err
+	}
+	t.recipNonce = resp.header.SenderNonce
+	return resp, nil
+}
+
+// newCertReqMsg creates certificate request with proof of possession of key from CSR.
+// Old certificate, when given, identifies certificate being updated.
+func newCertReqMsg(csr *certmgr.CSRHolder, old *x509.Certificate) (*certReqMsg, error) {
+	var (
+		req certRequest
+		err error
+	)
+	if req.CertTemplate.Subject, err = tagged(5, csr.CSR.RawSubject, true); err != nil {
+		return nil, err
+	}
+	if req.CertTemplate.PublicKey, err = tagged(6, csr.CSR.RawSubjectPublicKeyInfo, false); err != nil {
+		return nil, err
+	}
+	if len(csr.CSR.Extensions) > 0 {
+		exts, err := asn1.Marshal(csr.CSR.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		if req.CertTemplate.Extensions, err = tagged(9, exts, false); err != nil {
+			return nil, err
+		}
+	}
+	if old != nil {
+		id, err := asn1.Marshal(certID{Issuer: directoryName(old.RawIssuer), SerialNumber: old.SerialNumber})
+		if err != nil {
+			return nil, err
+		}
+		req.Controls = []attributeTypeAndValue{{Type: oidRegCtrlOldCertID, Value: asn1.RawValue{FullBytes: id}}}
+	}
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	alg, _, err := signatureAlgorithm(csr.Key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(csr.Key, der)
+	if err != nil {
+		return nil, err
+	}
+	popo, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{alg, asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}})
+	if err != nil {
+		return nil, err
+	}
+	pop, err := tagged(1, popo, false)
+	if err != nil {
+		return nil, err
+	}
+	return &certReqMsg{CertReq: asn1.RawValue{FullBytes: der}, POP: pop}, nil
+}
+
+// enroll requests certificate for key from CSR using given type of request (ir, cr or kur),
+// then confirms its acceptance. Issued certificate is returned along with other certificates sent by server.
+func (c *client) enroll(ctx context.Context, reqType int, csr *certmgr.CSRHolder, old *x509.Certificate) (*x509.Certificate, []*x509.Certificate, error) {
+	sender := csr.CSR.RawSubject
+	if sp, ok := c.protector.(*signatureProtector); ok {
+		sender = sp.cert.RawSubject
+	}
+	t, err := c.newTransaction(sender)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := newCertReqMsg(csr, old)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := newBody(reqType, []certReqMsg{*msg})
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := t.exchange(ctx, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	// each request type has response type with next tag
+	if resp.body.Tag != reqType+1 {
+		return nil, nil, fmt.Errorf("unexpected type of CMP response: %d", resp.body.Tag)
+	}
+	var rep certRepMessage
+	if err = unmarshal(resp.body.Bytes, &rep); err != nil {
+		return nil, nil, err
+	}
+	if len(rep.Response) != 1 || rep.Response[0].CertReqID != 0 {
+		return nil, nil, errors.New("CMP response doesn't contain response to request")
+	}
+	cr := &rep.Response[0]
+	if !cr.Status.ok() {
+		return nil, nil, &cr.Status
+	}
+	coec := cr.CertifiedKeyPair.CertOrEncCert
+	if coec.Class != asn1.ClassContextSpecific || coec.Tag != 0 {
+		return nil, nil, errors.New("CMP response doesn't contain plain certificate")
+	}
+	cert, err := x509.ParseCertificate(coec.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(csr.Key.Public()) {
+		return nil, nil, errors.New("CMP server issued certificate for different key than requested")
+	}
+	others := resp.extraCerts
+	for _, der := range parseCertificates(rep.CAPubs) {
+		ca, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, err
+		}
+		others = append(others, ca)
+	}
+	if body, err = newBody(bodyCertConf, []certStatus{{CertHash: certHash(cert), CertReqID: 0}}); err != nil {
+		return nil, nil, err
+	}
+	if resp, err = t.exchange(ctx, body); err != nil {
+		return nil, nil, err
+	}
+	if resp.body.Tag != bodyPKIConf {
+		return nil, nil, fmt.Errorf("unexpected type of CMP response: %d", resp.body.Tag)
+	}
+	return cert, others, nil
+}
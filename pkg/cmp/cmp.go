@@ -0,0 +1,306 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmp
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/webhook"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"net"
+	"net/http"
+	"os"
+)
+
+var requestTypes = map[string]int{
+	"ir": bodyIR,
+	"cr": bodyCR,
+}
+
+type commonCmpData struct {
+	w        io.Writer
+	errw     io.Writer
+	dir      string
+	alias    string
+	server   string
+	serverCA string
+	bits     int
+	of       common.OutputFormat
+	// issued records obtained certificate, for machine-readable output
+	issued *common.IssuedRecorder
+}
+
+type requestData struct {
+	commonCmpData
+	reqType    string
+	subject    pkix.Name
+	ipSan      []net.IP
+	dnsSan     []string
+	reference  string
+	secretFile string
+	authAlias  string
+}
+
+// newClient creates client for server, protecting messages using given protector.
+func (d *commonCmpData) newClient(ctx context.Context, cm certmgr.Reader, p protector) (*client, error) {
+	c := &client{url: d.server, httpClient: http.DefaultClient, protector: p}
+	if len(d.serverCA) > 0 {
+		ca, err := cm.GetCert(ctx, d.serverCA)
+		if err != nil {
+			return nil, err
+		}
+		c.recipient = ca.RawSubject
+		if sp, ok := p.(*signatureProtector); ok {
+			sp.roots = x509.NewCertPool()
+			sp.roots.AddCert(ca)
+		}
+	}
+	return c, nil
+}
+
+// chainOf picks issuers of cert from other certificates sent by server, up to (but excluding) root.
+func chainOf(cert *x509.Certificate, others []*x509.Certificate) []*x509.Certificate {
+	var chain []*x509.Certificate
+	for cur := cert; ; {
+		var next *x509.Certificate
+		for _, c := range others {
+			if !bytes.Equal(c.Raw, cur.Raw) && certmgr.IsIssuedBy(cur, c) {
+				next = c
+				break
+			}
+		}
+		if next == nil || certmgr.IsIssuedBy(next, next) || len(chain) == len(others) {
+			return chain
+		}
+		chain = append(chain, next)
+		cur = next
+	}
+}
+
+// signatureProtectorFor creates protector that signs messages using certificate and key stored under alias.
+func signatureProtectorFor(ctx context.Context, cm certmgr.Reader, alias string) (*signatureProtector, error) {
+	ph, err := cm.Get(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	return &signatureProtector{cert: ph.Cert, key: ph.Key}, nil
+}
+
+// manager creates certificate manager that records issued aliases and delivers events to webhooks.
+func (d *commonCmpData) manager() (certmgr.Interface, error) {
+	opts, err := webhook.Options(config.PathFor(d.dir), d.errw)
+	if err != nil {
+		return nil, err
+	}
+	return certmgr.New(d.dir, append(opts, certmgr.WithHook(d.issued.Hook))...), nil
+}
+
+func request(ctx context.Context, d *requestData) error {
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	var p protector
+	if len(d.authAlias) > 0 {
+		p, err = signatureProtectorFor(ctx, cm, d.authAlias)
+	} else {
+		var secret []byte
+		if secret, err = os.ReadFile(d.secretFile); err != nil {
+			return err
+		}
+		p, err = newMACProtector(d.reference, bytes.TrimRight(secret, "\r\n"))
+	}
+	if err != nil {
+		return err
+	}
+	c, err := d.newClient(ctx, cm, p)
+	if err != nil {
+		return err
+	}
+	cd := &certmgr.CertData{KeySize: d.bits, Subject: d.subject, IPSan: d.ipSan, DNSSan: d.dnsSan}
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return err
+	}
+	task := "requesting certificate from " + d.server
+	s := common.NewSpinner(d.errw)
+	s.Begin(task)
+	cert, others, err := c.enroll(ctx, requestTypes[d.reqType], csr, nil)
+	s.End(task, err)
+	if err != nil {
+		return err
+	}
+	if err = cm.Import(ctx, d.alias, cert, csr.Key, chainOf(cert, others)); err != nil {
+		return err
+	}
+	if d.of != common.OutputTable {
+		return d.issued.RenderIssued(ctx, d.w, d.of, cm)
+	}
+	_, err = fmt.Fprintf(d.w, "Obtained certificate '%s' for %s, valid to %s\n", d.alias, cert.Subject, cert.NotAfter)
+	return err
+}
+
+// renew requests key update of stored certificate, authenticated by its current key.
+// Certificate and key are replaced in store only after CA confirms new certificate.
+func renew(ctx context.Context, d *commonCmpData) error {
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	p, err := signatureProtectorFor(ctx, cm, d.alias)
+	if err != nil {
+		return err
+	}
+	c, err := d.newClient(ctx, cm, p)
+	if err != nil {
+		return err
+	}
+	old := p.cert
+	cd := &certmgr.CertData{KeySize: d.bits, Subject: old.Subject, IPSan: old.IPAddresses, DNSSan: old.DNSNames}
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return err
+	}
+	task := "renewing certificate using " + d.server
+	s := common.NewSpinner(d.errw)
+	s.Begin(task)
+	cert, others, err := c.enroll(ctx, bodyKUR, csr, old)
+	s.End(task, err)
+	if err != nil {
+		return err
+	}
+	if err = cm.Delete(ctx, d.alias); err != nil {
+		return err
+	}
+	if err = cm.Import(ctx, d.alias, cert, csr.Key, chainOf(cert, others)); err != nil {
+		return err
+	}
+	if d.of != common.OutputTable {
+		return d.issued.RenderIssued(ctx, d.w, d.of, cm)
+	}
+	_, err = fmt.Fprintf(d.w, "Renewed certificate '%s', valid to %s\n", d.alias, cert.NotAfter)
+	return err
+}
+
+func validateCommon(d *commonCmpData) error {
+	if len(d.server) == 0 {
+		return errors.New("URL of CMP server is required")
+	}
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	return nil
+}
+
+func validateRequest(d *requestData) error {
+	if err := validateCommon(&d.commonCmpData); err != nil {
+		return err
+	}
+	if _, ok := requestTypes[d.reqType]; !ok {
+		return fmt.Errorf("unsupported request type: '%s'", d.reqType)
+	}
+	if len(d.subject.String()) == 0 {
+		return common.ErrSubjectMissing
+	}
+	if len(d.authAlias) == 0 && (len(d.reference) == 0 || len(d.secretFile) == 0) {
+		return errors.New("either --auth-alias or both --reference and --secret-file are required")
+	}
+	return nil
+}
+
+func addCommonFlags(d *commonCmpData, pf *pflag.FlagSet) {
+	pf.StringVar(&d.server, "server", d.server, "URL of CMP server, like http://ca.example.com/pkix/")
+	pf.StringVar(&d.serverCA, "server-ca", d.serverCA, "Alias of CA certificate used as recipient of requests and to verify signed responses")
+	pf.IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	common.AddDirFlag(&d.dir, pf)
+}
+
+func defData(w io.Writer) commonCmpData {
+	return commonCmpData{
+		w:      w,
+		dir:    ".",
+		bits:   2048,
+		issued: &common.IssuedRecorder{},
+	}
+}
+
+func newRequestSubCommand(w io.Writer) *cobra.Command {
+	d := &requestData{
+		commonCmpData: defData(w),
+		reqType:       "ir",
+	}
+	cmd := &cobra.Command{
+		Use:   "request",
+		Short: "Request new certificate from CMP server",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateRequest(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return request(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias for new certificate. Must be unique within directory")
+	cmd.Flags().StringVar(&d.reqType, "type", d.reqType, "Type of request, either 'ir' (initialization) or 'cr' (certification)")
+	cmd.Flags().StringVar(&d.reference, "reference", d.reference, "Reference number (user name) assigned by CA for MAC-based authentication")
+	cmd.Flags().StringVar(&d.secretFile, "secret-file", d.secretFile, "File with secret shared with CA for MAC-based authentication")
+	cmd.Flags().StringVar(&d.authAlias, "auth-alias", d.authAlias, "Alias of existing certificate to sign request with, instead of MAC-based authentication")
+	cmd.Flags().IPSliceVar(&d.ipSan, "ip-san", d.ipSan, "Optional IP subject alternative name")
+	cmd.Flags().StringArrayVar(&d.dnsSan, "dns-san", d.dnsSan, "Optional DNS subject alternative name")
+	common.AddDNFlags("subject", &d.subject, cmd.Flags(), "")
+	addCommonFlags(&d.commonCmpData, cmd.Flags())
+	return cmd
+}
+
+func newRenewSubCommand(w io.Writer) *cobra.Command {
+	d := defData(w)
+	cmd := &cobra.Command{
+		Use:   "renew",
+		Short: "Renew stored certificate using CMP key update request",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateCommon(&d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return renew(cmd.Context(), &d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of certificate to renew")
+	addCommonFlags(&d, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cmp",
+		Short: "Interact with certificate authorities using Certificate Management Protocol (RFC 4210)",
+	}
+	cmd.AddCommand(newRequestSubCommand(out))
+	cmd.AddCommand(newRenewSubCommand(out))
+	return cmd
+}
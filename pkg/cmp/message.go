@@ -0,0 +1,245 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmp
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const pvno2000 = 2
+
+// Tags of PKIBody choices, RFC 4210 section 5.1.2.
+const (
+	bodyIR       = 0
+	bodyIP       = 1
+	bodyCR       = 2
+	bodyCP       = 3
+	bodyKUR      = 7
+	bodyKUP      = 8
+	bodyPKIConf  = 19
+	bodyError    = 23
+	bodyCertConf = 24
+)
+
+// PKIStatus values, RFC 4210 section 5.2.3.
+const (
+	statusAccepted         = 0
+	statusGrantedWithMods  = 1
+	statusRejection        = 2
+	statusWaiting          = 3
+	statusRevocationWarn   = 4
+	statusRevocationNotice = 5
+	statusKeyUpdateWarning = 6
+)
+
+var (
+	oidPasswordBasedMac = asn1.ObjectIdentifier{1, 2, 840, 113533, 7, 66, 13}
+	oidRegCtrlOldCertID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 5, 1, 5}
+
+	statusNames = map[int]string{
+		statusAccepted:         "accepted",
+		statusGrantedWithMods:  "grantedWithMods",
+		statusRejection:        "rejection",
+		statusWaiting:          "waiting",
+		statusRevocationWarn:   "revocationWarning",
+		statusRevocationNotice: "revocationNotification",
+		statusKeyUpdateWarning: "keyUpdateWarning",
+	}
+	failInfoNames = []string{
+		"badAlg", "badMessageCheck", "badRequest", "badTime", "badCertId", "badDataFormat", "wrongAuthority",
+		"incorrectData", "missingTimeStamp", "badPOP", "certRevoked", "certConfirmed", "wrongIntegrity",
+		"badRecipientNonce", "timeNotAvailable", "unacceptedPolicy", "unacceptedExtension", "addInfoNotAvailable",
+		"badSenderNonce", "badCertTemplate", "signerNotTrusted", "transactionIdInUse", "unsupportedVersion",
+		"notAuthorized", "systemUnavail", "systemFailure", "duplicateCertReq",
+	}
+)
+
+type pkiHeader struct {
+	PVNO          int
+	Sender        asn1.RawValue
+	Recipient     asn1.RawValue
+	MessageTime   time.Time                `asn1:"generalized,explicit,optional,tag:0"`
+	ProtectionAlg pkix.AlgorithmIdentifier `asn1:"explicit,optional,tag:1"`
+	SenderKID     []byte                   `asn1:"explicit,optional,tag:2"`
+	RecipKID      []byte                   `asn1:"explicit,optional,tag:3"`
+	TransactionID []byte                   `asn1:"explicit,optional,tag:4"`
+	SenderNonce   []byte                   `asn1:"explicit,optional,tag:5"`
+	RecipNonce    []byte                   `asn1:"explicit,optional,tag:6"`
+	FreeText      []string                 `asn1:"explicit,optional,tag:7,utf8"`
+	GeneralInfo   []asn1.RawValue          `asn1:"explicit,optional,tag:8"`
+}
+
+// pkiMessage keeps header and body in encoded form, since protection is computed over their exact encoding.
+type pkiMessage struct {
+	Header     asn1.RawValue
+	Body       asn1.RawValue
+	Protection asn1.BitString  `asn1:"explicit,optional,tag:0"`
+	ExtraCerts []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+}
+
+// protectedPart gets encoding of data covered by protection.
+func (m *pkiMessage) protectedPart() ([]byte, error) {
+	return asn1.Marshal(struct {
+		Header asn1.RawValue
+		Body   asn1.RawValue
+	}{m.Header, m.Body})
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+func (si *pkiStatusInfo) ok() bool {
+	return si.Status == statusAccepted || si.Status == statusGrantedWithMods
+}
+
+func (si *pkiStatusInfo) Error() string {
+	name, ok := statusNames[si.Status]
+	if !ok {
+		name = fmt.Sprintf("status %d", si.Status)
+	}
+	parts := []string{name}
+	for i, fi := range failInfoNames {
+		if si.FailInfo.At(i) == 1 {
+			parts = append(parts, fi)
+		}
+	}
+	parts = append(parts, si.StatusString...)
+	return "CMP server responded with " + strings.Join(parts, ": ")
+}
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// certTemplate holds fields of CertTemplate, RFC 4211 section 5, which are already tagged.
+type certTemplate struct {
+	Subject    asn1.RawValue `asn1:"optional"`
+	PublicKey  asn1.RawValue `asn1:"optional"`
+	Extensions asn1.RawValue `asn1:"optional"`
+}
+
+type certRequest struct {
+	CertReqID    int
+	CertTemplate certTemplate
+	Controls     []attributeTypeAndValue `asn1:"optional"`
+}
+
+type certReqMsg struct {
+	CertReq asn1.RawValue
+	POP     asn1.RawValue
+}
+
+type certID struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type certifiedKeyPair struct {
+	CertOrEncCert asn1.RawValue
+}
+
+type certResponse struct {
+	CertReqID        int
+	Status           pkiStatusInfo
+	CertifiedKeyPair certifiedKeyPair `asn1:"optional"`
+}
+
+type certRepMessage struct {
+	CAPubs   []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+	Response []certResponse
+}
+
+type certStatus struct {
+	CertHash  []byte
+	CertReqID int
+}
+
+type errorMsgContent struct {
+	Status       pkiStatusInfo
+	ErrorCode    int      `asn1:"optional"`
+	ErrorDetails []string `asn1:"optional,utf8"`
+}
+
+type pbmParameter struct {
+	Salt           []byte
+	OWF            pkix.AlgorithmIdentifier
+	IterationCount int
+	MAC            pkix.AlgorithmIdentifier
+}
+
+// tagged wraps encoded value into context-specific tag.
+// Implicit tagging replaces tag of value, explicit keeps whole value as content.
+func tagged(tag int, der []byte, explicit bool) (asn1.RawValue, error) {
+	if explicit {
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der}, nil
+	}
+	var rv asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &rv); err != nil {
+		return rv, err
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: rv.IsCompound, Bytes: rv.Bytes}, nil
+}
+
+// directoryName creates GeneralName from encoded distinguished name.
+func directoryName(rawName []byte) asn1.RawValue {
+	if len(rawName) == 0 {
+		// NULL-DN
+		rawName = []byte{0x30, 0x00}
+	}
+	rv, _ := tagged(4, rawName, true)
+	return rv
+}
+
+// newBody encodes content as PKIBody of given type.
+func newBody(tag int, content interface{}) (asn1.RawValue, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return tagged(tag, der, true)
+}
+
+func parseCertificates(raws []asn1.RawValue) [][]byte {
+	res := make([][]byte, 0, len(raws))
+	for _, raw := range raws {
+		res = append(res, raw.FullBytes)
+	}
+	return res
+}
+
+var errTrailingData = errors.New("trailing data after CMP structure")
+
+func unmarshal(der []byte, v interface{}) error {
+	rest, err := asn1.Unmarshal(der, v)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errTrailingData
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+const (
+	pbmIterations = 10000
+	pbmSaltSize   = 16
+	// upper bound of iterations accepted from server, to avoid doing excessive work for bogus response
+	pbmMaxIterations = 100000
+)
+
+var (
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 1, 2}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	owfHashes = map[string]crypto.Hash{
+		oidSHA1.String():   crypto.SHA1,
+		oidSHA256.String(): crypto.SHA256,
+		oidSHA384.String(): crypto.SHA384,
+		oidSHA512.String(): crypto.SHA512,
+	}
+	macHashes = map[string]crypto.Hash{
+		oidHMACSHA1.String():   crypto.SHA1,
+		oidHMACSHA256.String(): crypto.SHA256,
+		oidHMACSHA384.String(): crypto.SHA384,
+		oidHMACSHA512.String(): crypto.SHA512,
+	}
+	signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		oidSHA256WithRSA.String():   x509.SHA256WithRSA,
+		oidSHA384WithRSA.String():   x509.SHA384WithRSA,
+		oidSHA512WithRSA.String():   x509.SHA512WithRSA,
+		oidECDSAWithSHA256.String(): x509.ECDSAWithSHA256,
+		oidECDSAWithSHA384.String(): x509.ECDSAWithSHA384,
+		oidECDSAWithSHA512.String(): x509.ECDSAWithSHA512,
+		oidEd25519.String():         x509.PureEd25519,
+	}
+
+	errProtectionMissing = errors.New("CMP response is not protected")
+	errProtectionInvalid = errors.New("protection of CMP response is invalid")
+)
+
+// protector computes and verifies protection of messages.
+type protector interface {
+	// prepare fills protection-related fields of header
+	prepare(h *pkiHeader) error
+	protect(data []byte) ([]byte, error)
+	// extraCerts gets certificates to send along with message
+	extraCerts() [][]byte
+	// verify checks protection of response, extraCerts are certificates that came with response
+	verify(h *pkiHeader, data, protection []byte, extraCerts []*x509.Certificate) error
+}
+
+// macProtector protects messages using password-based MAC (RFC 4211 section 4.4),
+// keyed by shared secret known to both client and CA.
+type macProtector struct {
+	reference []byte
+	secret    []byte
+	params    pbmParameter
+}
+
+func newMACProtector(reference string, secret []byte) (*macProtector, error) {
+	salt := make([]byte, pbmSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &macProtector{
+		reference: []byte(reference),
+		secret:    secret,
+		params: pbmParameter{
+			Salt:           salt,
+			OWF:            pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			IterationCount: pbmIterations,
+			MAC:            pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256},
+		},
+	}, nil
+}
+
+func (mp *macProtector) prepare(h *pkiHeader) error {
+	params, err := asn1.Marshal(mp.params)
+	if err != nil {
+		return err
+	}
+	h.ProtectionAlg = pkix.AlgorithmIdentifier{Algorithm: oidPasswordBasedMac, Parameters: asn1.RawValue{FullBytes: params}}
+	h.SenderKID = mp.reference
+	return nil
+}
+
+// mac computes password-based MAC of data using given parameters.
+func (mp *macProtector) mac(params *pbmParameter, data []byte) ([]byte, error) {
+	owf, ok := owfHashes[params.OWF.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported one-way function %s", params.OWF.Algorithm)
+	}
+	mac, ok := macHashes[params.MAC.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported MAC algorithm %s", params.MAC.Algorithm)
+	}
+	if params.IterationCount < 1 || params.IterationCount > pbmMaxIterations {
+		return nil, fmt.Errorf("unsupported iteration count %d", params.IterationCount)
+	}
+	h := owf.New()
+	h.Write(mp.secret)
+	h.Write(params.Salt)
+	key := h.Sum(nil)
+	for i := 1; i < params.IterationCount; i++ {
+		h.Reset()
+		h.Write(key)
+		key = h.Sum(nil)
+	}
+	m := hmac.New(func() hash.Hash { return mac.New() }, key)
+	m.Write(data)
+	return m.Sum(nil), nil
+}
+
+func (mp *macProtector) protect(data []byte) ([]byte, error) {
+	return mp.mac(&mp.params, data)
+}
+
+func (mp *macProtector) extraCerts() [][]byte {
+	return nil
+}
+
+func (mp *macProtector) verify(h *pkiHeader, data, protection []byte, _ []*x509.Certificate) error {
+	if !h.ProtectionAlg.Algorithm.Equal(oidPasswordBasedMac) {
+		return fmt.Errorf("%w: expected password-based MAC, got %s", errProtectionInvalid, h.ProtectionAlg.Algorithm)
+	}
+	var params pbmParameter
+	if err := unmarshal(h.ProtectionAlg.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	expected, err := mp.mac(&params, data)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, protection) {
+		return errProtectionInvalid
+	}
+	return nil
+}
+
+// signatureProtector protects messages by signing them using key of existing certificate.
+type signatureProtector struct {
+	cert  *x509.Certificate
+	key   crypto.Signer
+	roots *x509.CertPool
+}
+
+func (sp *signatureProtector) prepare(h *pkiHeader) error {
+	alg, _, err := signatureAlgorithm(sp.key.Public())
+	if err != nil {
+		return err
+	}
+	h.ProtectionAlg = alg
+	h.SenderKID = sp.cert.SubjectKeyId
+	return nil
+}
+
+func (sp *signatureProtector) protect(data []byte) ([]byte, error) {
+	return sign(sp.key, data)
+}
+
+func (sp *signatureProtector) extraCerts() [][]byte {
+	return [][]byte{sp.cert.Raw}
+}
+
+func (sp *signatureProtector) verify(h *pkiHeader, data, protection []byte, extraCerts []*x509.Certificate) error {
+	return verifySignature(h, data, protection, extraCerts, sp.roots)
+}
+
+// verifySignature checks signature of response using certificate of sender, which is expected among extraCerts.
+// When roots are given, sender's certificate must be issued by one of them.
+func verifySignature(h *pkiHeader, data, protection []byte, extraCerts []*x509.Certificate, roots *x509.CertPool) error {
+	alg, ok := signatureAlgorithms[h.ProtectionAlg.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %s", errProtectionInvalid, h.ProtectionAlg.Algorithm)
+	}
+	for _, cert := range extraCerts {
+		if len(h.SenderKID) > 0 && len(cert.SubjectKeyId) > 0 && string(h.SenderKID) != string(cert.SubjectKeyId) {
+			continue
+		}
+		if cert.CheckSignature(alg, data, protection) != nil {
+			continue
+		}
+		if roots == nil {
+			return nil
+		}
+		intermediates := x509.NewCertPool()
+		for _, c := range extraCerts {
+			intermediates.AddCert(c)
+		}
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %v", errProtectionInvalid, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no certificate of sender matches signature", errProtectionInvalid)
+}
+
+// signatureAlgorithm gets algorithm used to sign using given public key, along with hash function.
+func signatureAlgorithm(pub crypto.PublicKey) (pkix.AlgorithmIdentifier, crypto.Hash, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}, crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, crypto.SHA256, nil
+	case ed25519.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, crypto.Hash(0), nil
+	default:
+		return pkix.AlgorithmIdentifier{}, 0, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+func sign(key crypto.Signer, data []byte) ([]byte, error) {
+	_, h, err := signatureAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if h == 0 {
+		return key.Sign(rand.Reader, data, h)
+	}
+	d := h.New()
+	d.Write(data)
+	return key.Sign(rand.Reader, d.Sum(nil), h)
+}
+
+// certHash computes hash of certificate for confirmation, using hash function of its signature algorithm.
+func certHash(cert *x509.Certificate) []byte {
+	h := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.ECDSAWithSHA1:
+		h = crypto.SHA1
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512, x509.PureEd25519:
+		h = crypto.SHA512
+	}
+	d := h.New()
+	d.Write(cert.Raw)
+	return d.Sum(nil)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cms
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+// PEMType is type of PEM block with CMS structure, as used by OpenSSL.
+const PEMType = "CMS"
+
+type signData struct {
+	w     io.Writer
+	in    io.Reader
+	dir   string
+	alias string
+	input string
+	out   string
+	pem   bool
+	chain bool
+}
+
+func sign(ctx context.Context, d *signData) error {
+	content, err := common.ReadInput(d.input, d.in)
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	ph, err := cm.Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	var chain []*x509.Certificate
+	if d.chain {
+		phs, err := cm.GetChain(ctx, d.alias)
+		if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+			return err
+		}
+		// root is left out, recipient must trust it anyway
+		for _, e := range phs[1:] {
+			if !certmgr.IsIssuedBy(e.Cert, e.Cert) {
+				chain = append(chain, e.Cert)
+			}
+		}
+	}
+	sig, err := SignDetached(content, ph.Cert, ph.Key, chain)
+	if err != nil {
+		return err
+	}
+	if d.pem {
+		sig = pem.EncodeToMemory(&pem.Block{Type: PEMType, Bytes: sig})
+	}
+	if err = writeOutput(d.out, d.w, sig); err != nil || d.out == common.StdinMarker {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Signature of %s created by '%s' saved to %s\n", d.input, d.alias, d.out)
+	return err
+}
+
+func validateSign(d *signData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.input) == 0 {
+		return errors.New("file to sign is required")
+	}
+	if len(d.out) == 0 {
+		if d.input == common.StdinMarker {
+			return errors.New("output file is required when signing standard input")
+		}
+		d.out = d.input + ".p7s"
+	}
+	return nil
+}
+
+func newSignSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &signData{
+		w:     w,
+		in:    in,
+		dir:   ".",
+		chain: true,
+	}
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Create detached CMS (PKCS#7) signature of file",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateSign(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sign(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of signer's certificate and private key")
+	cmd.Flags().StringVar(&d.input, "in", d.input, "File to sign. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write signature to, defaults to <in>.p7s. Use '-' to write to standard output")
+	cmd.Flags().BoolVar(&d.pem, "pem", d.pem, "Whether to write signature in PEM format instead of DER")
+	cmd.Flags().BoolVar(&d.chain, "include-chain", d.chain, "Whether to include intermediate CA certificates in signature")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(in io.Reader, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cms",
+		Short: "Sign, verify and encrypt files using Cryptographic Message Syntax (PKCS#7)",
+	}
+	cmd.AddCommand(newSignSubCommand(in, out))
+	cmd.AddCommand(newVerifySubCommand(in, out))
+	cmd.AddCommand(newEncryptSubCommand(in, out))
+	cmd.AddCommand(newDecryptSubCommand(in, out))
+	return cmd
+}
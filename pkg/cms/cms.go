@@ -0,0 +1,289 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cms implements subset of Cryptographic Message Syntax (RFC 5652) needed to work with signed and enveloped data.
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	OIDData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	digestAlgorithms = map[string]crypto.Hash{
+		oidSHA1.String():   crypto.SHA1,
+		oidSHA256.String(): crypto.SHA256,
+		oidSHA384.String(): crypto.SHA384,
+		oidSHA512.String(): crypto.SHA512,
+	}
+	// signature algorithms that name hash function explicitly
+	signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		oidSHA1WithRSA.String():     x509.SHA1WithRSA,
+		oidSHA256WithRSA.String():   x509.SHA256WithRSA,
+		oidSHA384WithRSA.String():   x509.SHA384WithRSA,
+		oidSHA512WithRSA.String():   x509.SHA512WithRSA,
+		oidECDSAWithSHA256.String(): x509.ECDSAWithSHA256,
+		oidECDSAWithSHA384.String(): x509.ECDSAWithSHA384,
+		oidECDSAWithSHA512.String(): x509.ECDSAWithSHA512,
+		oidEd25519.String():         x509.PureEd25519,
+	}
+	// RSA signature algorithms by hash function, for signers that only specify rsaEncryption
+	rsaAlgorithms = map[crypto.Hash]x509.SignatureAlgorithm{
+		crypto.SHA1:   x509.SHA1WithRSA,
+		crypto.SHA256: x509.SHA256WithRSA,
+		crypto.SHA384: x509.SHA384WithRSA,
+		crypto.SHA512: x509.SHA512WithRSA,
+	}
+
+	ErrNotSignedData     = errors.New("content is not CMS signed data")
+	ErrNoSignature       = errors.New("signed data contains no signature")
+	ErrNoContent         = errors.New("signature is detached, but content was not given")
+	ErrDigestMismatch    = errors.New("digest of content doesn't match signed digest")
+	ErrSignerNotFound    = errors.New("certificate of signer not found")
+	ErrUnsupportedDigest = errors.New("unsupported digest algorithm")
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// Signer describes signer of verified signed data.
+type Signer struct {
+	Certificate *x509.Certificate
+	// SigningTime is time claimed by signer, zero when not present
+	SigningTime time.Time
+}
+
+// SignedData is parsed CMS signed-data content.
+type SignedData struct {
+	// ContentType is type of encapsulated content
+	ContentType asn1.ObjectIdentifier
+	// Content is encapsulated content, nil for detached signature
+	Content []byte
+	// Certificates are certificates included by signer
+	Certificates []*x509.Certificate
+	signers      []signerInfo
+}
+
+// Parse parses DER-encoded ContentInfo with signed data.
+func Parse(der []byte) (*SignedData, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(OIDSignedData) {
+		return nil, ErrNotSignedData
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	res := &SignedData{ContentType: sd.EncapContentInfo.EContentType, signers: sd.SignerInfos}
+	if len(sd.EncapContentInfo.EContent.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &res.Content); err != nil {
+			return nil, err
+		}
+	}
+	if len(sd.Certificates.Bytes) > 0 {
+		var err error
+		if res.Certificates, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// findCert finds certificate identified by signer identifier.
+func findCert(sid asn1.RawValue, certs []*x509.Certificate) (*x509.Certificate, error) {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, sid.Bytes) {
+				return c, nil
+			}
+		}
+		return nil, ErrSignerNotFound
+	}
+	var ias issuerAndSerial
+	if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+		return nil, err
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.SerialNumber) == 0 {
+			return c, nil
+		}
+	}
+	return nil, ErrSignerNotFound
+}
+
+// signatureAlgorithm determines algorithm of signature made by signer.
+func signatureAlgorithm(si *signerInfo, h crypto.Hash) (x509.SignatureAlgorithm, error) {
+	if alg, ok := signatureAlgorithms[si.SignatureAlgorithm.Algorithm.String()]; ok {
+		return alg, nil
+	}
+	if si.SignatureAlgorithm.Algorithm.Equal(oidRSAEncryption) {
+		if alg, ok := rsaAlgorithms[h]; ok {
+			return alg, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm %s", si.SignatureAlgorithm.Algorithm)
+}
+
+// verifySigner checks signature of single signer over content.
+func verifySigner(si *signerInfo, content []byte, signer *Signer, contentType asn1.ObjectIdentifier) error {
+	cert := signer.Certificate
+	h, ok := digestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return ErrUnsupportedDigest
+	}
+	alg, err := signatureAlgorithm(si, h)
+	if err != nil {
+		return err
+	}
+	if len(si.SignedAttrs.FullBytes) == 0 {
+		// without signed attributes, signature covers content itself
+		return cert.CheckSignature(alg, content, si.Signature)
+	}
+	// signature covers attributes encoded as SET OF, rather than with implicit tag
+	signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	var attrs []attribute
+	if _, err = asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return err
+	}
+	var digest []byte
+	for _, a := range attrs {
+		if len(a.Values) != 1 {
+			continue
+		}
+		switch {
+		case a.Type.Equal(oidAttrMessageDigest):
+			if _, err = asn1.Unmarshal(a.Values[0].FullBytes, &digest); err != nil {
+				return err
+			}
+		case a.Type.Equal(oidAttrContentType):
+			var ct asn1.ObjectIdentifier
+			if _, err = asn1.Unmarshal(a.Values[0].FullBytes, &ct); err != nil {
+				return err
+			}
+			if !ct.Equal(contentType) {
+				return errors.New("signed content type doesn't match encapsulated content type")
+			}
+		case a.Type.Equal(oidAttrSigningTime):
+			if _, err = asn1.Unmarshal(a.Values[0].FullBytes, &signer.SigningTime); err != nil {
+				return err
+			}
+		}
+	}
+	d := h.New()
+	d.Write(content)
+	if !bytes.Equal(d.Sum(nil), digest) {
+		return ErrDigestMismatch
+	}
+	return cert.CheckSignature(alg, signed, si.Signature)
+}
+
+// Verify checks signatures of all signers and returns them.
+// Content must be given for detached signature, otherwise encapsulated content is used.
+// Certificates of signers are looked up among included certificates and extra ones.
+// Trust in returned certificates is not established, that's up to caller.
+func (sd *SignedData) Verify(content []byte, extra ...*x509.Certificate) ([]*Signer, error) {
+	if content == nil {
+		content = sd.Content
+	}
+	if content == nil {
+		return nil, ErrNoContent
+	}
+	if len(sd.signers) == 0 {
+		return nil, ErrNoSignature
+	}
+	certs := append(append([]*x509.Certificate{}, sd.Certificates...), extra...)
+	res := make([]*Signer, 0, len(sd.signers))
+	for i := range sd.signers {
+		cert, err := findCert(sd.signers[i].SID, certs)
+		if err != nil {
+			return nil, err
+		}
+		signer := &Signer{Certificate: cert}
+		if err = verifySigner(&sd.signers[i], content, signer, sd.ContentType); err != nil {
+			return nil, err
+		}
+		res = append(res, signer)
+	}
+	return res, nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+type encryptData struct {
+	w          io.Writer
+	in         io.Reader
+	dir        string
+	recipients []string
+	input      string
+	out        string
+	pem        bool
+}
+
+type decryptData struct {
+	w     io.Writer
+	in    io.Reader
+	dir   string
+	alias string
+	input string
+	out   string
+}
+
+// writeOutput writes data either to file or to w when name is StdinMarker.
+func writeOutput(name string, w io.Writer, data []byte) error {
+	if name == common.StdinMarker {
+		_, err := w.Write(data)
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+func encrypt(ctx context.Context, d *encryptData) error {
+	content, err := common.ReadInput(d.input, d.in)
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	certs := make([]*x509.Certificate, 0, len(d.recipients))
+	for _, alias := range d.recipients {
+		cert, err := cm.GetCert(ctx, alias)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	data, err := Encrypt(content, certs)
+	if err != nil {
+		return err
+	}
+	if d.pem {
+		data = pem.EncodeToMemory(&pem.Block{Type: PEMType, Bytes: data})
+	}
+	if err = writeOutput(d.out, d.w, data); err != nil || d.out == common.StdinMarker {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Content of %s encrypted for %s saved to %s\n", d.input, strings.Join(d.recipients, ", "), d.out)
+	return err
+}
+
+func decrypt(ctx context.Context, d *decryptData) error {
+	data, err := common.ReadInput(d.input, d.in)
+	if err != nil {
+		return err
+	}
+	ph, err := certmgr.New(d.dir).Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	key, ok := ph.Key.(crypto.Decrypter)
+	if !ok {
+		return fmt.Errorf("private key of '%s' can't be used for decryption", d.alias)
+	}
+	content, err := Decrypt(decodeDER(data), ph.Cert, key)
+	if err != nil {
+		return err
+	}
+	if err = writeOutput(d.out, d.w, content); err != nil || d.out == common.StdinMarker {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Content of %s decrypted by '%s' saved to %s\n", d.input, d.alias, d.out)
+	return err
+}
+
+func validateEncrypt(d *encryptData) error {
+	if len(d.recipients) == 0 {
+		return errors.New("at least one recipient alias is required")
+	}
+	if len(d.input) == 0 {
+		return errors.New("file to encrypt is required")
+	}
+	if len(d.out) == 0 {
+		if d.input == common.StdinMarker {
+			return errors.New("output file is required when encrypting standard input")
+		}
+		d.out = d.input + ".p7m"
+	}
+	return nil
+}
+
+func validateDecrypt(d *decryptData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.input) == 0 {
+		return errors.New("file to decrypt is required")
+	}
+	if len(d.out) == 0 {
+		if !strings.HasSuffix(d.input, ".p7m") {
+			return errors.New("output file is required when input doesn't have .p7m extension")
+		}
+		d.out = strings.TrimSuffix(d.input, ".p7m")
+	}
+	return nil
+}
+
+func newEncryptSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &encryptData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt file for one or more recipients using CMS (PKCS#7) enveloped data",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateEncrypt(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return encrypt(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringSliceVar(&d.recipients, "recipient-alias", d.recipients, "Alias of recipient's certificate, can be repeated")
+	cmd.Flags().StringVar(&d.input, "in", d.input, "File to encrypt. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write encrypted content to, defaults to <in>.p7m. Use '-' to write to standard output")
+	cmd.Flags().BoolVar(&d.pem, "pem", d.pem, "Whether to write encrypted content in PEM format instead of DER")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func newDecryptSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &decryptData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "decrypt",
+		Short: "Decrypt CMS (PKCS#7) enveloped data using stored private key",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateDecrypt(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return decrypt(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of recipient's certificate and private key")
+	cmd.Flags().StringVar(&d.input, "in", d.input, "File to decrypt, in DER or PEM format. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write decrypted content to, defaults to <in> without .p7m extension. Use '-' to write to standard output")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	OIDEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidRSAESOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+	// content encryption algorithms by key size
+	aesAlgorithms = map[string]int{
+		oidAES128CBC.String(): 16,
+		oidAES192CBC.String(): 24,
+		oidAES256CBC.String(): 32,
+	}
+
+	ErrNotEnvelopedData   = errors.New("content is not CMS enveloped data")
+	ErrRecipientNotFound  = errors.New("content is not encrypted for given recipient")
+	ErrUnsupportedCipher  = errors.New("unsupported content encryption algorithm")
+	ErrDecryptionFailed   = errors.New("decryption failed")
+	ErrNoRecipients       = errors.New("at least one recipient is required")
+	ErrUnsupportedKeyWrap = errors.New("unsupported key encryption algorithm")
+)
+
+type envelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"optional,tag:0"`
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// rsaesOAEPParams is defined in RFC 4055 section 4.1
+type rsaesOAEPParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+// oaepParameters are parameters used to encrypt content key, RSAES-OAEP with SHA-256 is used for both hash and MGF1.
+func oaepParameters() (asn1.RawValue, error) {
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	mgfParams, err := asn1.Marshal(sha256)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	der, err := asn1.Marshal(rsaesOAEPParams{
+		HashAlgorithm:    sha256,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+	})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	return asn1.RawValue{FullBytes: der}, nil
+}
+
+// parseOAEPParameters gets hash functions from RSAES-OAEP parameters, SHA-1 is default for both.
+func parseOAEPParameters(params asn1.RawValue) (*rsa.OAEPOptions, error) {
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	if len(params.FullBytes) == 0 || bytes.Equal(params.FullBytes, asn1.NullBytes) {
+		return opts, nil
+	}
+	var p rsaesOAEPParams
+	if _, err := asn1.Unmarshal(params.FullBytes, &p); err != nil {
+		return nil, err
+	}
+	if len(p.PSourceAlgorithm.Algorithm) > 0 {
+		return nil, ErrUnsupportedKeyWrap
+	}
+	if len(p.HashAlgorithm.Algorithm) > 0 {
+		h, ok := digestAlgorithms[p.HashAlgorithm.Algorithm.String()]
+		if !ok {
+			return nil, ErrUnsupportedDigest
+		}
+		opts.Hash = h
+	}
+	if len(p.MaskGenAlgorithm.Algorithm) > 0 {
+		var mgfHash pkix.AlgorithmIdentifier
+		if !p.MaskGenAlgorithm.Algorithm.Equal(oidMGF1) {
+			return nil, ErrUnsupportedKeyWrap
+		}
+		if _, err := asn1.Unmarshal(p.MaskGenAlgorithm.Parameters.FullBytes, &mgfHash); err != nil {
+			return nil, err
+		}
+		h, ok := digestAlgorithms[mgfHash.Algorithm.String()]
+		if !ok {
+			return nil, ErrUnsupportedDigest
+		}
+		opts.MGFHash = h
+	}
+	return opts, nil
+}
+
+func pad(data []byte, size int) []byte {
+	n := size - len(data)%size
+	return append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte, size int) ([]byte, error) {
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, ErrDecryptionFailed
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > size {
+		return nil, ErrDecryptionFailed
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, ErrDecryptionFailed
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+// Encrypt creates DER-encoded CMS enveloped data with content encrypted using AES-256-CBC.
+// Content encryption key is encrypted for each recipient using RSAES-OAEP, only RSA keys are supported.
+func Encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	oaep, err := oaepParameters()
+	if err != nil {
+		return nil, err
+	}
+	ris := make([]asn1.RawValue, 0, len(recipients))
+	for _, r := range recipients {
+		pub, ok := r.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T of recipient '%s'", r.PublicKey, r.Subject)
+		}
+		ek, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		rid, err := asn1.Marshal(issuerAndSerial{Issuer: asn1.RawValue{FullBytes: r.RawIssuer}, SerialNumber: r.SerialNumber})
+		if err != nil {
+			return nil, err
+		}
+		ri, err := asn1.Marshal(keyTransRecipientInfo{
+			RID:                    asn1.RawValue{FullBytes: rid},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: oaep},
+			EncryptedKey:           ek,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ris = append(ris, asn1.RawValue{FullBytes: ri})
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	ct := pad(bytes.Clone(content), aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ct, ct)
+	ivDer, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	ed, err := asn1.Marshal(envelopedData{
+		RecipientInfos: ris,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                OIDData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDer}},
+			EncryptedContent:           ct,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: ed},
+	})
+}
+
+// decryptKey decrypts content encryption key using private key of recipient.
+func decryptKey(ri *keyTransRecipientInfo, key crypto.Decrypter) ([]byte, error) {
+	var opts crypto.DecrypterOpts
+	switch {
+	case ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption):
+		opts = &rsa.PKCS1v15DecryptOptions{}
+	case ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP):
+		oaep, err := parseOAEPParameters(ri.KeyEncryptionAlgorithm.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		opts = oaep
+	default:
+		return nil, ErrUnsupportedKeyWrap
+	}
+	return key.Decrypt(rand.Reader, ri.EncryptedKey, opts)
+}
+
+// Decrypt decrypts DER-encoded CMS enveloped data using certificate and private key of one of recipients.
+func Decrypt(der []byte, cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(OIDEnvelopedData) {
+		return nil, ErrNotEnvelopedData
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, err
+	}
+	var ri *keyTransRecipientInfo
+	for _, raw := range ed.RecipientInfos {
+		// other kinds of recipients are tagged, key transport is plain sequence
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			continue
+		}
+		var ktri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ktri); err != nil {
+			return nil, err
+		}
+		if _, err := findCert(ktri.RID, []*x509.Certificate{cert}); err == nil {
+			ri = &ktri
+			break
+		}
+	}
+	if ri == nil {
+		return nil, ErrRecipientNotFound
+	}
+	eci := ed.EncryptedContentInfo
+	size, ok := aesAlgorithms[eci.ContentEncryptionAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, ErrUnsupportedCipher
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, ErrUnsupportedCipher
+	}
+	cek, err := decryptKey(ri, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(cek) != size {
+		return nil, ErrDecryptionFailed
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(eci.EncryptedContent) == 0 || len(eci.EncryptedContent)%aes.BlockSize != 0 {
+		return nil, ErrDecryptionFailed
+	}
+	pt := bytes.Clone(eci.EncryptedContent)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(pt, pt)
+	return unpad(pt, aes.BlockSize)
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// signerAlgorithms determines digest and signature algorithms for key.
+func signerAlgorithms(pub crypto.PublicKey) (crypto.Hash, pkix.AlgorithmIdentifier, pkix.AlgorithmIdentifier, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return crypto.SHA256, pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		return crypto.SHA256, pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	case ed25519.PublicKey:
+		// RFC 8419 section 3.1
+		return crypto.SHA512, pkix.AlgorithmIdentifier{Algorithm: oidSHA512},
+			pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, nil
+	default:
+		return 0, pkix.AlgorithmIdentifier{}, pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// marshalAttributes encodes attributes as DER SET OF, which requires elements to be sorted by their encoding.
+func marshalAttributes(attrs []attribute) ([]byte, error) {
+	encoded := make([][]byte, 0, len(attrs))
+	for _, a := range attrs {
+		der, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	slices.SortFunc(encoded, bytes.Compare)
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+}
+
+func newAttribute(t asn1.ObjectIdentifier, v interface{}) (attribute, error) {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: t, Values: []asn1.RawValue{{FullBytes: der}}}, nil
+}
+
+// SignDetached creates DER-encoded CMS signed data with detached signature of content.
+// Certificates in chain are included to help recipient build path to trusted root.
+func SignDetached(content []byte, cert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate) ([]byte, error) {
+	h, digestAlg, sigAlg, err := signerAlgorithms(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	d := h.New()
+	d.Write(content)
+	var attrs []attribute
+	for _, e := range []struct {
+		t asn1.ObjectIdentifier
+		v interface{}
+	}{
+		{oidAttrContentType, OIDData},
+		{oidAttrSigningTime, time.Now().UTC()},
+		{oidAttrMessageDigest, d.Sum(nil)},
+	} {
+		a, err := newAttribute(e.t, e.v)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, a)
+	}
+	signed, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+	var signedSet asn1.RawValue
+	if _, err = asn1.Unmarshal(signed, &signedSet); err != nil {
+		return nil, err
+	}
+	var sig []byte
+	if sigAlg.Algorithm.Equal(oidEd25519) {
+		// Ed25519 signs message itself, not its digest
+		sig, err = key.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		d = h.New()
+		d.Write(signed)
+		sig, err = key.Sign(rand.Reader, d.Sum(nil), h)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sid, err := asn1.Marshal(issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber})
+	if err != nil {
+		return nil, err
+	}
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{cert}, chain...) {
+		certs = append(certs, c.Raw...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: encapContentInfo{EContentType: OIDData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedSet.Bytes},
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cms
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type verifyData struct {
+	w         io.Writer
+	in        io.Reader
+	dir       string
+	input     string
+	signature string
+	of        common.OutputFormat
+}
+
+type signerResult struct {
+	Subject     string     `json:"subject" yaml:"subject"`
+	Issuer      string     `json:"issuer" yaml:"issuer"`
+	Serial      string     `json:"serial" yaml:"serial"`
+	SigningTime *time.Time `json:"signingTime,omitempty" yaml:"signingTime,omitempty"`
+	Chain       []string   `json:"chain" yaml:"chain"`
+}
+
+// decodeDER gets DER form of CMS structure, PEM form is detected automatically.
+func decodeDER(data []byte) []byte {
+	if b, _ := pem.Decode(data); b != nil && (b.Type == PEMType || b.Type == "PKCS7") {
+		return b.Bytes
+	}
+	return data
+}
+
+func verify(ctx context.Context, d *verifyData) error {
+	content, err := common.ReadInput(d.input, d.in)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(d.signature)
+	if err != nil {
+		return err
+	}
+	sd, err := Parse(decodeDER(data))
+	if err != nil {
+		return err
+	}
+	signers, err := sd.Verify(content)
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	roots, intermediates, err := certmgr.TrustPools(ctx, certmgr.New(d.dir))
+	if err != nil {
+		return err
+	}
+	for _, c := range sd.Certificates {
+		intermediates.AddCert(c)
+	}
+	res := make([]signerResult, 0, len(signers))
+	for _, s := range signers {
+		// without signing time, certificate must be valid now
+		at := time.Now()
+		if !s.SigningTime.IsZero() {
+			at = s.SigningTime
+		}
+		chains, err := s.Certificate.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   at,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("signer '%s' is not trusted: %w", s.Certificate.Subject, err)
+		}
+		r := signerResult{
+			Subject: s.Certificate.Subject.String(),
+			Issuer:  s.Certificate.Issuer.String(),
+			Serial:  s.Certificate.SerialNumber.String(),
+		}
+		if !s.SigningTime.IsZero() {
+			r.SigningTime = &s.SigningTime
+		}
+		for _, c := range chains[0] {
+			r.Chain = append(r.Chain, c.Subject.String())
+		}
+		res = append(res, r)
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Signer", "Issuer", "Serial", "Signing time", "Chain",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, r := range res {
+			st := "N/A"
+			if r.SigningTime != nil {
+				st = r.SigningTime.String()
+			}
+			tbl.Append([]string{r.Subject, r.Issuer, r.Serial, st, strings.Join(r.Chain, " -> ")})
+		}
+	})
+}
+
+func validateVerify(d *verifyData) error {
+	if len(d.input) == 0 {
+		return errors.New("signed file is required")
+	}
+	if len(d.signature) == 0 {
+		if d.input == common.StdinMarker {
+			return errors.New("signature file is required when verifying standard input")
+		}
+		d.signature = d.input + ".p7s"
+	}
+	return nil
+}
+
+func newVerifySubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &verifyData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify detached CMS (PKCS#7) signature of file against CAs in store",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateVerify(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			return verify(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.input, "in", d.input, "Signed file. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.signature, "signature", d.signature, "File with signature in DER or PEM format, defaults to <in>.p7s")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// CaddyDataDir gets data directory of Caddy, where its certificate storage is kept, the same way as Caddy does.
+func CaddyDataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); len(dir) > 0 {
+		return filepath.Join(dir, "caddy")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("AppData"); len(dir) > 0 {
+			return filepath.Join(dir, "Caddy")
+		}
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Caddy")
+	}
+	return filepath.Join(home, ".local", "share", "caddy")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"os"
+	"time"
+)
+
+// ColorMode controls whether output is colored.
+type ColorMode string
+
+// Status describes validity of certificate at certain point in time.
+type Status string
+
+const (
+	ColorAuto   ColorMode = "auto"
+	ColorAlways ColorMode = "always"
+	ColorNever  ColorMode = "never"
+
+	StatusValid       Status = "valid"
+	StatusExpiring    Status = "expiring"
+	StatusExpired     Status = "expired"
+	StatusNotYetValid Status = "not yet valid"
+
+	// ExpiringThreshold is time before expiration when certificate is considered to be expiring.
+	ExpiringThreshold = 30 * 24 * time.Hour
+
+	colorFlag = "color"
+
+	ansiReset  = "\033[0m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiBlue   = "\033[34m"
+)
+
+func (c *ColorMode) String() string {
+	return string(*c)
+}
+
+func (c *ColorMode) Set(s string) error {
+	switch ColorMode(s) {
+	case ColorAuto, ColorAlways, ColorNever:
+		*c = ColorMode(s)
+		return nil
+	default:
+		return fmt.Errorf("unsupported color mode: %s", s)
+	}
+}
+
+func (c *ColorMode) Type() string {
+	return "mode"
+}
+
+// AddColorFlag adds flag to control colored output.
+func AddColorFlag(c *ColorMode, pf *pflag.FlagSet) {
+	pf.Var(c, colorFlag, "Whether to use colors in output, one of auto, always, never")
+}
+
+// ColorModeOf gets color mode in effect for given command.
+func ColorModeOf(cmd *cobra.Command) ColorMode {
+	if f := cmd.Flag(colorFlag); f != nil {
+		return ColorMode(f.Value.String())
+	}
+	return ColorAuto
+}
+
+// Colorizer wraps text into ANSI escape sequences, if enabled.
+type Colorizer struct {
+	enabled bool
+}
+
+// isTerminal checks if w is character device, like interactive console.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// NewColorizer creates Colorizer for given mode and writer.
+// In auto mode, colors are enabled only when w is terminal and NO_COLOR environment variable is not set to non-empty value.
+func NewColorizer(mode ColorMode, w io.Writer) *Colorizer {
+	switch mode {
+	case ColorAlways:
+		return &Colorizer{enabled: true}
+	case ColorNever:
+		return &Colorizer{enabled: false}
+	default:
+		// per no-color.org, variable set to empty string doesn't disable colors
+		noColor := os.Getenv("NO_COLOR") != ""
+		return &Colorizer{enabled: !noColor && isTerminal(w)}
+	}
+}
+
+func (c *Colorizer) wrap(code, s string) string {
+	if !c.enabled {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Status renders status of certificate, using color that corresponds to its severity.
+func (c *Colorizer) Status(s Status) string {
+	switch s {
+	case StatusValid:
+		return c.wrap(ansiGreen, string(s))
+	case StatusExpiring:
+		return c.wrap(ansiYellow, string(s))
+	default:
+		return c.wrap(ansiRed, string(s))
+	}
+}
+
+// Kind renders kind of certificate, CA or leaf.
+func (c *Colorizer) Kind(isCA bool) string {
+	if isCA {
+		return c.wrap(ansiBlue, "CA")
+	}
+	return "leaf"
+}
+
+// StatusOf computes status of certificate at given time.
+func StatusOf(cert *x509.Certificate, now time.Time) Status {
+	switch {
+	case now.Before(cert.NotBefore):
+		return StatusNotYetValid
+	case now.After(cert.NotAfter):
+		return StatusExpired
+	case cert.NotAfter.Sub(now) < ExpiringThreshold:
+		return StatusExpiring
+	default:
+		return StatusValid
+	}
+}
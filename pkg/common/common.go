@@ -1,17 +1,57 @@
 package common
 
 import (
+	"crypto/x509/pkix"
 	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
 	"github.com/spf13/pflag"
+	"io"
+	"os"
 )
 
+// StdinMarker is file name which denotes standard input.
+const StdinMarker = "-"
+
 var (
 	ErrIssuerMissing      = errors.New("value for issuer is required")
-	ErrAliasMissing       = errors.New("certificate alias is required")
-	ErrSubjectMissing     = errors.New("certificate subject is required")
-	ErrParentAliasMissing = errors.New("parent certificate alias is required")
+	ErrAliasMissing       = certmgr.ErrAliasMissing
+	ErrSubjectMissing     = certmgr.ErrSubjectMissing
+	ErrParentAliasMissing = certmgr.ErrParentAliasMissing
+	ErrAliasOrFileMissing = errors.New("either certificate alias or file is required")
 )
 
 func AddDirFlag(d *string, pf *pflag.FlagSet) {
 	pf.StringVar(d, "directory", *d, "Directory to operate on")
 }
+
+// AddDNFlags adds flags to set components of distinguished name, like --subject-common-name.
+func AddDNFlags(prefix string, pm *pkix.Name, pf *pflag.FlagSet, helpSuffix string) {
+	pf.StringArrayVar(&pm.Locality, prefix+"-locality", pm.Country, "Locality components of "+prefix+" DN."+helpSuffix)
+	pf.StringArrayVar(&pm.Province, prefix+"-province", pm.Province, "Province components of "+prefix+" DN."+helpSuffix)
+	pf.StringArrayVar(&pm.Country, prefix+"-country", pm.Country, "Country components of "+prefix+" DN."+helpSuffix)
+	pf.StringArrayVar(&pm.StreetAddress, prefix+"-street-address", pm.StreetAddress, "Street address components of "+prefix+" DN."+helpSuffix)
+	pf.StringArrayVar(&pm.PostalCode, prefix+"-postal-code", pm.PostalCode, "Postal code components of "+prefix+" DN."+helpSuffix)
+	pf.StringArrayVar(&pm.Organization, prefix+"-organization", pm.Organization, "Organization components of "+prefix+" DN."+helpSuffix)
+	pf.StringArrayVar(&pm.OrganizationalUnit, prefix+"-organizational-unit", pm.OrganizationalUnit, "Organizational unit components of "+prefix+" DN."+helpSuffix)
+	pf.StringVar(&pm.CommonName, prefix+"-common-name", pm.CommonName, "Common name components of "+prefix+" DN."+helpSuffix)
+	pf.StringVar(&pm.SerialNumber, prefix+"-serial-number", pm.SerialNumber, "Serial number component of "+prefix+" DN, like device serial number."+helpSuffix)
+}
+
+// ReadInput reads whole content of named file, or content of in when name is "-".
+func ReadInput(name string, in io.Reader) ([]byte, error) {
+	if name == StdinMarker {
+		return io.ReadAll(in)
+	}
+	return os.ReadFile(name)
+}
+
+// ExitError signals that process should exit with given code.
+// Any output was already written by command, so there is nothing else to report.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
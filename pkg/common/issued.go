@@ -0,0 +1,93 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"io"
+	"sync"
+	"time"
+)
+
+// Issued describes certificate created by command, so that scripts don't have to parse files to learn about it.
+// All values are strings, as expected by Terraform external data source.
+type Issued struct {
+	Alias       string `json:"alias" yaml:"alias"`
+	CertFile    string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile     string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	Serial      string `json:"serial" yaml:"serial"`
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	NotAfter    string `json:"notAfter" yaml:"notAfter"`
+}
+
+// IssuedRecorder records certificates created by certificate manager, when registered as its hook.
+type IssuedRecorder struct {
+	mu     sync.Mutex
+	events []certmgr.Event
+}
+
+// Hook records creation of alias, it's meant to be passed to certmgr.WithHook.
+func (r *IssuedRecorder) Hook(_ context.Context, ev *certmgr.Event) error {
+	if ev.Type == certmgr.EventCreate {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, *ev)
+	}
+	return nil
+}
+
+// Issued describes recorded certificates, in order they were created.
+func (r *IssuedRecorder) Issued(ctx context.Context, cm certmgr.Reader) ([]Issued, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]Issued, 0, len(r.events))
+	for _, ev := range r.events {
+		cert, err := cm.GetCert(ctx, ev.Alias)
+		if err != nil {
+			return nil, err
+		}
+		fp := sha256.Sum256(cert.Raw)
+		res = append(res, Issued{
+			Alias:       ev.Alias,
+			CertFile:    ev.CertFile,
+			KeyFile:     ev.KeyFile,
+			Serial:      cert.SerialNumber.String(),
+			Fingerprint: hex.EncodeToString(fp[:]),
+			NotAfter:    cert.NotAfter.UTC().Format(time.RFC3339),
+		})
+	}
+	return res, nil
+}
+
+// RenderIssued writes recorded certificates in given format, single certificate as object and more of them as array.
+// Nothing is written in table format, commands print human-readable messages instead.
+func (r *IssuedRecorder) RenderIssued(ctx context.Context, w io.Writer, f OutputFormat, cm certmgr.Reader) error {
+	if f == OutputTable {
+		return nil
+	}
+	issued, err := r.Issued(ctx, cm)
+	if err != nil {
+		return err
+	}
+	if len(issued) == 1 {
+		return Render(w, f, issued[0], nil)
+	}
+	return Render(w, f, issued, nil)
+}
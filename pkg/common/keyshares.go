@@ -0,0 +1,68 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/spf13/pflag"
+	"os"
+	"strings"
+)
+
+// AddKeyShareFlag adds flag to give shares of private key of issuing CA.
+func AddKeyShareFlag(shares *[]string, pf *pflag.FlagSet) {
+	pf.StringArrayVar(shares, "key-share", *shares, "File with share of private key of parent CA, "+
+		"optionally followed by comma and file with password of share. Repeated until threshold of shares is given")
+}
+
+// ReadPasswordFile reads password from file, without trailing line break.
+func ReadPasswordFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// KeyShareOptions reconstructs private key of issuing CA from shares, when they are given.
+// Each share is given as file, optionally followed by comma and file with password of share.
+func KeyShareOptions(keyShares []string) ([]certmgr.Option, error) {
+	if len(keyShares) == 0 {
+		return nil, nil
+	}
+	var shares, passwords [][]byte
+	for _, s := range keyShares {
+		file, passwordFile, _ := strings.Cut(s, ",")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var password []byte
+		if len(passwordFile) > 0 {
+			if password, err = ReadPasswordFile(passwordFile); err != nil {
+				return nil, err
+			}
+		}
+		shares = append(shares, data)
+		passwords = append(passwords, password)
+	}
+	alias, key, err := certmgr.ParseKeySharesPEM(shares, passwords)
+	if err != nil {
+		return nil, err
+	}
+	return []certmgr.Option{certmgr.WithKey(alias, key)}, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+// OutputFormat is format used to render results of command.
+type OutputFormat string
+
+const (
+	OutputTable OutputFormat = "table"
+	OutputJSON  OutputFormat = "json"
+	OutputYAML  OutputFormat = "yaml"
+
+	outputFlag = "output"
+)
+
+var outputFormats = []OutputFormat{OutputTable, OutputJSON, OutputYAML}
+
+func (o *OutputFormat) String() string {
+	return string(*o)
+}
+
+func (o *OutputFormat) Set(s string) error {
+	for _, f := range outputFormats {
+		if string(f) == s {
+			*o = f
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported output format: %s", s)
+}
+
+func (o *OutputFormat) Type() string {
+	return "format"
+}
+
+// AddOutputFlag adds flag to choose output format.
+func AddOutputFlag(o *OutputFormat, pf *pflag.FlagSet) {
+	pf.VarP(o, outputFlag, "o", "Output format, one of table, json, yaml")
+}
+
+// OutputFormatOf gets output format in effect for given command.
+// Value is inherited from persistent flag of root command, table is used when flag is not present.
+func OutputFormatOf(cmd *cobra.Command) OutputFormat {
+	if f := cmd.Flag(outputFlag); f != nil {
+		return OutputFormat(f.Value.String())
+	}
+	return OutputTable
+}
+
+// RequireTable returns error when output format other than table is requested for command,
+// which only produces textual output.
+func RequireTable(cmd *cobra.Command) error {
+	if f := OutputFormatOf(cmd); f != OutputTable {
+		return fmt.Errorf("output format %s is not supported by command %s", f, cmd.CommandPath())
+	}
+	return nil
+}
+
+// Render writes v to w using given format.
+// For table format, v is ignored and table function is called to populate table instead.
+func Render(w io.Writer, f OutputFormat, v interface{}, table func(*tablewriter.Table)) error {
+	switch f {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		tbl := tablewriter.NewWriter(w)
+		table(tbl)
+		tbl.Render()
+		return nil
+	}
+}
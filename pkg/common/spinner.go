@@ -0,0 +1,87 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// Spinner displays animated indicator while task is running.
+// It does nothing unless writer is terminal, so that it doesn't pollute logs.
+type Spinner struct {
+	w       io.Writer
+	enabled bool
+	mu      sync.Mutex
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// NewSpinner creates spinner that writes to w.
+func NewSpinner(w io.Writer) *Spinner {
+	return &Spinner{
+		w:       w,
+		enabled: isTerminal(w),
+	}
+}
+
+func (s *Spinner) Begin(task string) {
+	if !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.halt()
+	s.stop = make(chan struct{})
+	s.done.Add(1)
+	go func(stop chan struct{}) {
+		defer s.done.Done()
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for i := 0; ; i++ {
+			_, _ = fmt.Fprintf(s.w, "\r%s %s", spinnerFrames[i%len(spinnerFrames)], task)
+			select {
+			case <-stop:
+				_, _ = fmt.Fprint(s.w, "\r\033[K")
+				return
+			case <-t.C:
+			}
+		}
+	}(s.stop)
+}
+
+func (s *Spinner) End(_ string, _ error) {
+	if !s.enabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.halt()
+}
+
+// halt stops running animation, if any. Caller must hold lock.
+func (s *Spinner) halt() {
+	if s.stop != nil {
+		close(s.stop)
+		s.done.Wait()
+		s.stop = nil
+	}
+}
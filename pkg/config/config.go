@@ -0,0 +1,318 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads optional configuration file kept in directory with certificates.
+package config
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileName is name of configuration file within directory with certificates.
+const FileName = "pkitool.yaml"
+
+const envPrefix = "env:"
+
+// Config is content of configuration file.
+type Config struct {
+	ACME   ACME   `yaml:"acme"`
+	SPIFFE SPIFFE `yaml:"spiffe"`
+	Notify Notify `yaml:"notify"`
+	DN     DN     `yaml:"dn"`
+	// Permissions is policy of private key files in directory
+	Permissions Permissions `yaml:"permissions"`
+	Escrow      Escrow      `yaml:"escrow"`
+	Approval    Approval    `yaml:"approval"`
+	// Limits protect CA from runaway automation in server modes
+	Limits Limits `yaml:"limits,omitempty"`
+	// Webhooks are notified about lifecycle events of certificates
+	Webhooks []Webhook `yaml:"webhooks,omitempty"`
+	// Deploy are actions run after certificate is renewed, so that services pick it up
+	Deploy []Deploy `yaml:"deploy,omitempty"`
+}
+
+// Webhook configures HTTP endpoint that receives lifecycle events of certificates.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Secret is key of HMAC-SHA256 signature of payload.
+	// Value in form "env:NAME" is read from environment variable NAME, so that secret doesn't have to be kept in file.
+	Secret string `yaml:"secret,omitempty"`
+	// Events to deliver, any of create, renew, revoke and delete. All events are delivered when empty.
+	Events []string `yaml:"events,omitempty"`
+	// Timeout of single delivery, defaults to 10 seconds
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// SecretValue gets secret, resolving reference to environment variable.
+func (w *Webhook) SecretValue() string {
+	return option(map[string]string{"secret": w.Secret}, "secret", "")
+}
+
+// Deploy configures actions run after certificates of matching aliases are written.
+// Actions of single entry run in order scp, exec, systemdReload, so that service is reloaded after files are copied.
+type Deploy struct {
+	// Alias of certificate, can be glob pattern like "web-*"
+	Alias string `yaml:"alias"`
+	// Exec is shell command, file names are passed in PKITOOL_CERT_FILE and PKITOOL_KEY_FILE environment variables
+	Exec string `yaml:"exec,omitempty"`
+	// SystemdReload is systemd unit to reload, like nginx.service
+	SystemdReload string `yaml:"systemdReload,omitempty"`
+	// SCP is target to copy certificate and key files to, like deploy@web1:/etc/ssl/web/
+	SCP string `yaml:"scp,omitempty"`
+	// Timeout of every action, defaults to 1 minute
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Approval configures dual control of sensitive operations performed by servers.
+type Approval struct {
+	// Operations that require approval, any of issue, issue-ca, revoke and revoke-ca
+	Operations []string `yaml:"operations,omitempty"`
+	// Approvers is number of distinct identities, other than requester, that must approve operation. Defaults to 2.
+	Approvers int `yaml:"approvers,omitempty"`
+	// TTL is how long request can be approved and used, like 8h. Defaults to 24 hours.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
+// RateLimit is token bucket of single client, refilled at Rate tokens per minute up to Burst tokens.
+type RateLimit struct {
+	// Rate is how many issuance requests per minute client can make, unlimited when not set
+	Rate float64 `yaml:"rate,omitempty"`
+	// Burst is how many requests client can make at once, defaults to Rate
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// Quota limits how many certificates CA issues per period.
+type Quota struct {
+	// CA is alias of issuing CA
+	CA  string `yaml:"ca"`
+	Max int    `yaml:"max"`
+	// Period is sliding window quota applies to, like 24h. Defaults to 24 hours.
+	Period time.Duration `yaml:"period,omitempty"`
+}
+
+// Limits configures rate limiting of clients and issuance quotas of CAs in server modes.
+type Limits struct {
+	// RateLimit applies to each client that has no own limit
+	RateLimit `yaml:",inline"`
+	// Clients are limits of particular clients, keyed by name from client certificate or "token:<hash prefix>"
+	Clients map[string]RateLimit `yaml:"clients,omitempty"`
+	Quotas  []Quota              `yaml:"quotas,omitempty"`
+}
+
+// Escrow configures escrow of private keys generated for leaf certificates.
+type Escrow struct {
+	// Recipient is file with certificate of recovery agent, relative paths are resolved against directory of configuration file
+	Recipient string `yaml:"recipient,omitempty"`
+	// Profiles opt in to escrow, only keys of certificates issued with one of them are escrowed
+	Profiles []string `yaml:"profiles,omitempty"`
+}
+
+// Permissions is policy of private key files, enforced by "check perms".
+type Permissions struct {
+	// KeyMode is the most permissive mode private key file can have, like "0400"
+	KeyMode string `yaml:"keyMode,omitempty"`
+	// Owner is name or numeric ID of user that must own private key files, ownership is not checked when empty
+	Owner string `yaml:"owner,omitempty"`
+	// Group is name or numeric ID of group that must own private key files, ownership is not checked when empty
+	Group string `yaml:"group,omitempty"`
+}
+
+// Mode parses KeyMode, def is returned when it's not set.
+func (p *Permissions) Mode(def os.FileMode) (os.FileMode, error) {
+	if len(p.KeyMode) == 0 {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(p.KeyMode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("invalid key mode: '%s'", p.KeyMode)
+	}
+	return os.FileMode(m), nil
+}
+
+// ACME configures ACME client.
+type ACME struct {
+	// Solvers are tried in order, first one that supports domain and challenge offered by server is used
+	Solvers []Solver `yaml:"solvers"`
+}
+
+// Solver configures solver of dns-01 challenges.
+type Solver struct {
+	// Provider is name of DNS provider, like "cloudflare"
+	Provider string `yaml:"provider"`
+	// Domains limits solver to given domains and their subdomains. Solver is used for any domain when empty.
+	Domains []string `yaml:"domains,omitempty"`
+	// Options are provider-specific settings.
+	// Value in form "env:NAME" is read from environment variable NAME, so that secrets don't have to be kept in file.
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+// Option gets value of provider-specific option, or def if option is not set.
+func (s *Solver) Option(name, def string) string {
+	return option(s.Options, name, def)
+}
+
+// option gets value of option, resolving references to environment variables.
+func option(options map[string]string, name, def string) string {
+	v, ok := options[name]
+	if !ok {
+		return def
+	}
+	if strings.HasPrefix(v, envPrefix) {
+		return os.Getenv(strings.TrimPrefix(v, envPrefix))
+	}
+	return v
+}
+
+// SPIFFE configures SPIFFE Workload API server.
+type SPIFFE struct {
+	// Entries grant SPIFFE IDs to local workloads
+	Entries []WorkloadEntry `yaml:"entries"`
+}
+
+// WorkloadEntry grants SPIFFE ID to processes that match all selectors. At least one selector must be set.
+type WorkloadEntry struct {
+	// ID is SPIFFE ID, like spiffe://example.org/web
+	ID string `yaml:"id"`
+	// UID selects processes running as given user
+	UID *uint32 `yaml:"uid,omitempty"`
+	// GID selects processes running with given primary group
+	GID *uint32 `yaml:"gid,omitempty"`
+	// Hint is passed to workload to tell SVIDs apart, when it gets more than one.
+	Hint string `yaml:"hint,omitempty"`
+}
+
+// Notify configures notifications about expiring certificates.
+type Notify struct {
+	// Thresholds are numbers of days before expiration when notification is sent, like [30, 7, 1]
+	Thresholds []int `yaml:"thresholds,omitempty"`
+	// Notifiers are all used to deliver every notification
+	Notifiers []Notifier `yaml:"notifiers"`
+}
+
+// Notifier configures single notification channel.
+type Notifier struct {
+	// Type is type of channel, one of "webhook", "slack" or "email"
+	Type string `yaml:"type"`
+	// Template is Go text/template of message, default message is used when empty
+	Template string `yaml:"template,omitempty"`
+	// Options are type-specific settings, like URL of webhook.
+	// Value in form "env:NAME" is read from environment variable NAME, so that secrets don't have to be kept in file.
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+// Option gets value of type-specific option, or def if option is not set.
+func (n *Notifier) Option(name, def string) string {
+	return option(n.Options, name, def)
+}
+
+// DN configures components of subject DN that create commands merge with those given by flags.
+type DN struct {
+	// Defaults are merged into subject of every created certificate
+	Defaults DNTemplate `yaml:"defaults"`
+	// Templates are selected by name, their components take precedence over defaults
+	Templates map[string]DNTemplate `yaml:"templates,omitempty"`
+}
+
+// DNTemplate is set of DN components, common name is always specific to certificate.
+type DNTemplate struct {
+	Country            []string `yaml:"country,omitempty"`
+	Province           []string `yaml:"province,omitempty"`
+	Locality           []string `yaml:"locality,omitempty"`
+	StreetAddress      []string `yaml:"streetAddress,omitempty"`
+	PostalCode         []string `yaml:"postalCode,omitempty"`
+	Organization       []string `yaml:"organization,omitempty"`
+	OrganizationalUnit []string `yaml:"organizationalUnit,omitempty"`
+}
+
+// Template gets named template merged with defaults, or just defaults when name is empty.
+func (dn *DN) Template(name string) (*DNTemplate, error) {
+	res := dn.Defaults
+	if len(name) == 0 {
+		return &res, nil
+	}
+	t, ok := dn.Templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown DN template: '%s'", name)
+	}
+	overlay := func(dst *[]string, src []string) {
+		if len(src) > 0 {
+			*dst = src
+		}
+	}
+	overlay(&res.Country, t.Country)
+	overlay(&res.Province, t.Province)
+	overlay(&res.Locality, t.Locality)
+	overlay(&res.StreetAddress, t.StreetAddress)
+	overlay(&res.PostalCode, t.PostalCode)
+	overlay(&res.Organization, t.Organization)
+	overlay(&res.OrganizationalUnit, t.OrganizationalUnit)
+	return &res, nil
+}
+
+// Apply sets components of name that are not set yet, so that explicitly given ones take precedence.
+func (t *DNTemplate) Apply(name *pkix.Name) {
+	if t == nil {
+		return
+	}
+	fill := func(dst *[]string, src []string) {
+		if len(*dst) == 0 && len(src) > 0 {
+			*dst = append([]string{}, src...)
+		}
+	}
+	fill(&name.Country, t.Country)
+	fill(&name.Province, t.Province)
+	fill(&name.Locality, t.Locality)
+	fill(&name.StreetAddress, t.StreetAddress)
+	fill(&name.PostalCode, t.PostalCode)
+	fill(&name.Organization, t.Organization)
+	fill(&name.OrganizationalUnit, t.OrganizationalUnit)
+}
+
+// PathFor gets path of configuration file for given directory with certificates.
+// Locations that are not directories, like plugins, have no default configuration file.
+func PathFor(dir string) string {
+	if strings.Contains(dir, ":") && !filepath.IsAbs(dir) {
+		return ""
+	}
+	return filepath.Join(dir, FileName)
+}
+
+// Load loads configuration from file. Missing file results in empty configuration.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if len(path) == 0 {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package create
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/spf13/cobra"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type createBulkData struct {
+	commonCreateData
+	in      io.Reader
+	input   string
+	profile string
+	org     []string
+	workers int
+	// anyWildcard disables validation of wildcard DNS names
+	anyWildcard bool
+	// underscore allows underscores in DNS names
+	underscore bool
+}
+
+// bulkRow is single certificate to issue, as read from input.
+type bulkRow struct {
+	CN    string   `json:"cn"`
+	Alias string   `json:"alias"`
+	SANs  []string `json:"sans"`
+}
+
+// bulkResult is outcome of single row.
+type bulkResult struct {
+	Row   int    `json:"row" yaml:"row"`
+	Alias string `json:"alias" yaml:"alias"`
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// parseBulkJSON parses array of rows.
+func parseBulkJSON(data []byte) ([]bulkRow, error) {
+	var rows []bulkRow
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rows); err != nil {
+		return nil, fmt.Errorf("invalid JSON input: %w", err)
+	}
+	return rows, nil
+}
+
+// parseBulkCSV parses CSV with header naming columns "cn", "alias" and "sans".
+// Multiple SANs are separated by space or semicolon.
+func parseBulkCSV(data []byte) ([]bulkRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV input: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["cn"]; !ok {
+		return nil, errors.New("CSV input must have header with 'cn' column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	rows := make([]bulkRow, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		rows = append(rows, bulkRow{
+			CN:    field(rec, "cn"),
+			Alias: field(rec, "alias"),
+			SANs: strings.FieldsFunc(field(rec, "sans"), func(r rune) bool {
+				return r == ' ' || r == ';'
+			}),
+		})
+	}
+	return rows, nil
+}
+
+func readBulkInput(d *createBulkData) ([]bulkRow, error) {
+	data, err := common.ReadInput(d.input, d.in)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(d.input, ".json") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return parseBulkJSON(data)
+	}
+	return parseBulkCSV(data)
+}
+
+// issue issues certificate for single row.
+func (d *createBulkData) issue(ctx context.Context, cm certmgr.Issuer, p *profiles.Profile, row bulkRow) error {
+	if len(row.CN) == 0 {
+		return errors.New("common name is empty")
+	}
+	cd := &certmgr.CertData{
+		KeySize:     d.bits,
+		ValidYears:  d.validYears,
+		Alias:       row.Alias,
+		ParentAlias: d.parent,
+		Subject:     d.subjectOf(pkix.Name{CommonName: row.CN, Organization: d.org}),
+
+		AllowAnyWildcard: d.anyWildcard,
+		AllowUnderscore:  d.underscore,
+		WriteChain:       d.writeChain,
+	}
+	if len(cd.Alias) == 0 {
+		cd.Alias = row.CN
+	}
+	sans := row.SANs
+	if len(sans) == 0 {
+		sans = []string{row.CN}
+	}
+	for _, san := range sans {
+		cd.AddSAN(san)
+	}
+	p.Apply(cd)
+	if err := d.checkCAA(ctx, cd); err != nil {
+		return err
+	}
+	return cm.NewLeaf(ctx, cd)
+}
+
+// createBulk issues certificate for every row of input using pool of workers.
+// Returns true when any row failed.
+func createBulk(ctx context.Context, d *createBulkData) (bool, error) {
+	p, err := profiles.Get(d.profile)
+	if err != nil {
+		return false, err
+	}
+	rows, err := readBulkInput(d)
+	if err != nil {
+		return false, err
+	}
+	if err = d.loadDN(); err != nil {
+		return false, err
+	}
+	// limits only depend on properties shared by all rows
+	sample := &certmgr.CertData{KeySize: d.bits, ValidYears: d.validYears}
+	p.Apply(sample)
+	if err = d.checkPublicTrust(sample, "certificates"); err != nil {
+		return false, err
+	}
+	// progress of concurrent key generation can't be shown by single spinner
+	opts, err := d.options()
+	if err != nil {
+		return false, err
+	}
+	if len(d.postCreate) > 0 {
+		opts = append(opts, certmgr.WithHook(certmgr.ExecHook(d.postCreate, d.w, d.errw)))
+	}
+	cm := certmgr.New(d.dir, opts...)
+	res := make([]bulkResult, len(rows))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(d.workers, len(rows)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res[i] = bulkResult{Row: i + 1, Alias: rows[i].Alias}
+				if len(res[i].Alias) == 0 {
+					res[i].Alias = rows[i].CN
+				}
+				if err := d.issue(ctx, cm, p, rows[i]); err != nil {
+					res[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+	for i := range rows {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	failed := false
+	for _, r := range res {
+		failed = failed || len(r.Error) > 0
+	}
+	return failed, common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Row", "Alias", "Result",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, r := range res {
+			result := "created"
+			if len(r.Error) > 0 {
+				result = "failed: " + r.Error
+			}
+			tbl.Append([]string{strconv.Itoa(r.Row), r.Alias, result})
+		}
+	})
+}
+
+func validateBulk(d *createBulkData) error {
+	if len(d.input) == 0 {
+		return errors.New("input file is required")
+	}
+	if len(d.parent) == 0 {
+		return common.ErrParentAliasMissing
+	}
+	if d.workers < 1 {
+		d.workers = runtime.GOMAXPROCS(0)
+	}
+	return nil
+}
+
+func newBulkSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &createBulkData{
+		commonCreateData: defData(w, false),
+		in:               in,
+		profile:          profiles.Default,
+	}
+	cmd := &cobra.Command{
+		Use:   "bulk",
+		Short: "Create leaf certificate for every row of CSV or JSON input, all issued by the same CA",
+		Long: "Create leaf certificate for every row of input, all issued by the same CA.\n" +
+			"CSV input must have header with 'cn' column, and optional 'alias' and 'sans' columns. Multiple SANs are separated by space or semicolon.\n" +
+			"JSON input is array of objects with 'cn', 'alias' and 'sans' (array) properties.\n" +
+			"Alias defaults to common name, which is also used as SAN when row has none.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateBulk(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			failed, err := createBulk(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if failed {
+				// failures were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&d.input, "input", d.input, "CSV or JSON file with certificates to create. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate")
+	cmd.Flags().StringVar(&d.profile, "profile", d.profile, "Profile of certificates, one of "+strings.Join(profiles.Names(), ", "))
+	cmd.Flags().StringArrayVar(&d.org, "organization", d.org, "Organization components of subject DN of all certificates")
+	addDNTemplateFlags(&d.commonCreateData, cmd.Flags())
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.validYears, "years", d.validYears, "How meany years should new certificates be valid for")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many certificates to create concurrently, defaults to number of CPUs")
+	addWildcardFlag(&d.anyWildcard, cmd.Flags())
+	addUnderscoreFlag(&d.underscore, cmd.Flags())
+	addPublicTrustFlag(&d.commonCreateData, cmd.Flags())
+	addWriteChainFlag(&d.commonCreateData, cmd.Flags())
+	addCAAFlags(&d.commonCreateData, cmd.Flags())
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	addPostCreateFlag(&d.commonCreateData, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
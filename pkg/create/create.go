@@ -17,17 +17,40 @@ limitations under the License.
 package create
 
 import (
+	"context"
+	"crypto"
+	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/caa"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/escrow"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/rkosegi/pkitool/pkg/signer"
+	"github.com/rkosegi/pkitool/pkg/spiffe"
+	"github.com/rkosegi/pkitool/pkg/webhook"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"io"
 	"net"
-	"pkitool/pkg/certmgr"
-	"pkitool/pkg/common"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 )
 
+// caaTimeout is timeout of single DNS query for CAA records
+const caaTimeout = 5 * time.Second
+
 type commonCreateData struct {
 	w          io.Writer
+	errw       io.Writer
 	alias      string
 	parent     string
 	validYears int
@@ -36,22 +59,253 @@ type commonCreateData struct {
 	bits       int
 	dir        string
 	serial     int64
+	postCreate string
+	keyPlugin  string
+	keyId      string
+	// strictTrust turns warnings about limits of publicly trusted certificates into errors
+	strictTrust bool
+	// writeChain stores issuing chain of leaf certificate alongside it
+	writeChain bool
+	// caaIssuer enables checking of CAA records, when set
+	caaIssuer string
+	caaServer string
+	// caaWarn turns CAA errors into warnings
+	caaWarn bool
+	of      common.OutputFormat
+	// issued records created certificates, for machine-readable output
+	issued *common.IssuedRecorder
+	// config is path to configuration file with DN templates
+	config     string
+	dnTemplate string
+	// dn is resolved DN template, merged into subjects of created certificates
+	dn *config.DNTemplate
+	// keyShares are files with shares of private key of issuing CA, each optionally followed by comma and password file
+	keyShares []string
+}
+
+// loadDN resolves DN template from configuration file.
+func (d *commonCreateData) loadDN() error {
+	path := d.config
+	if len(path) == 0 {
+		path = config.PathFor(d.dir)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	d.dn, err = cfg.DN.Template(d.dnTemplate)
+	return err
+}
+
+// subjectOf merges DN template into name.
+func (d *commonCreateData) subjectOf(name pkix.Name) pkix.Name {
+	d.dn.Apply(&name)
+	return name
+}
+
+// checkCAA checks that CAA records of DNS names allow issuance, when CAA issuer is configured.
+// Common name is checked too, if it's DNS name that is going to be copied into SANs.
+func (d *commonCreateData) checkCAA(ctx context.Context, cd *certmgr.CertData) error {
+	if len(d.caaIssuer) == 0 {
+		return nil
+	}
+	names := slices.Clone(cd.DNSSan)
+	if cn := cd.Subject.CommonName; !cd.NoCommonNameSAN && strings.Contains(cn, ".") && net.ParseIP(cn) == nil && !slices.Contains(names, cn) {
+		names = append(names, cn)
+	}
+	c := &caa.Checker{Issuer: d.caaIssuer, Server: d.caaServer, Timeout: caaTimeout}
+	for _, name := range names {
+		ascii, err := certmgr.ToASCII(name)
+		if err != nil {
+			return err
+		}
+		if err = c.Check(ctx, ascii); err != nil {
+			if !d.caaWarn {
+				return err
+			}
+			if _, err = fmt.Fprintf(d.errw, "Warning: %v\n", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPublicTrust warns when TLS server certificate exceeds limits of publicly trusted certificates.
+// In strict mode, error is returned instead.
+func (d *commonCreateData) checkPublicTrust(cd *certmgr.CertData, what string) error {
+	err := certmgr.CheckPublicTrust(cd, time.Now())
+	if err == nil || d.strictTrust {
+		return err
+	}
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if _, err = fmt.Fprintf(d.errw, "Warning: %s %s\n", what, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options creates options of certificate manager shared by all create commands,
+// which reconstruct key of issuing CA from shares, escrow generated keys and deliver events to webhooks.
+func (d *commonCreateData) options() ([]certmgr.Option, error) {
+	opts, err := common.KeyShareOptions(d.keyShares)
+	if err != nil {
+		return nil, err
+	}
+	path := d.config
+	if len(path) == 0 {
+		path = config.PathFor(d.dir)
+	}
+	escrowOpts, err := escrow.Options(path)
+	if err != nil {
+		return nil, err
+	}
+	webhookOpts, err := webhook.Options(path, d.errw)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(opts, escrowOpts...), webhookOpts...), nil
+}
+
+// manager creates certificate manager, with post-create hook and key reconstructed from shares if configured.
+func (d *commonCreateData) manager() (certmgr.Interface, error) {
+	opts, err := d.options()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		certmgr.WithProgress(common.NewSpinner(d.errw)),
+		certmgr.WithHook(d.issued.Hook),
+	)
+	if len(d.postCreate) > 0 {
+		opts = append(opts, certmgr.WithHook(certmgr.ExecHook(d.postCreate, d.w, d.errw)))
+	}
+	return certmgr.New(d.dir, opts...), nil
+}
+
+// report writes certificates created by command to output, when JSON or YAML output is requested.
+func (d *commonCreateData) report(ctx context.Context, cm certmgr.Reader) error {
+	return d.issued.RenderIssued(ctx, d.w, d.of, cm)
+}
+
+// key gets key held by plugin, if configured. Otherwise, new key will be generated by manager.
+func (d *commonCreateData) key(ctx context.Context) (crypto.Signer, error) {
+	if len(d.keyPlugin) == 0 {
+		return nil, nil
+	}
+	return plugin.NewSigner(ctx, d.keyPlugin, d.keyId)
 }
 
 type createLeafData struct {
 	commonCreateData
-	ipSan  []net.IP
-	dnsSan []string
+	ipSan    []net.IP
+	dnsSan   []string
+	emailSan []string
+	upnSan   []string
+	hwSan    []string
+	sid      string
+	profile  string
+	// delegation allows issuing delegated credentials
+	delegation bool
+	// anyWildcard disables validation of wildcard DNS names
+	anyWildcard bool
+	// noCNSan disables copying of common name into SANs
+	noCNSan bool
+	// underscore allows underscores in DNS names
+	underscore bool
+	// remote issues certificate by signing server, when enabled
+	remote signer.Options
+}
+
+type createMtlsData struct {
+	commonCreateData
+	server      string
+	client      string
+	serverAlias string
+	clientAlias string
+	org         []string
+	workers     int
+}
+
+type createSvidData struct {
+	commonCreateData
+	id  string
+	ttl time.Duration
 }
 
 type createCaData struct {
 	commonCreateData
 	imCA bool
+	// constraints of certificates issued by new CA
+	constraints certmgr.Constraints
+	// shares is number of shares private key is split into, key is stored as whole when zero
+	shares    int
+	threshold int
+	// sharePasswordFiles are files with passwords of shares, in order of shares
+	sharePasswordFiles []string
+	sharesDir          string
 }
 
-func createCA(d *createCaData) error {
-	cm := certmgr.New(d.dir)
+// writeShares splits private key into shares and writes each into new file <alias>.share-<n> in shares directory.
+// Names of written files are returned, so that they can be removed when CA can't be created.
+func writeShares(d *createCaData, key crypto.Signer) ([]string, error) {
+	passwords := make([][]byte, 0, len(d.sharePasswordFiles))
+	for _, file := range d.sharePasswordFiles {
+		password, err := common.ReadPasswordFile(file)
+		if err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, password)
+	}
+	shares, err := certmgr.MarshalKeySharesPEM(key, d.alias, d.shares, d.threshold, passwords)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for i, share := range shares {
+		name := filepath.Join(d.sharesDir, fmt.Sprintf("%s.share-%d", d.alias, i+1))
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o400)
+		if err == nil {
+			files = append(files, name)
+			_, err = f.Write(share)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			removeFiles(files)
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func removeFiles(files []string) {
+	for _, f := range files {
+		_ = os.Remove(f)
+	}
+}
+
+func createCA(ctx context.Context, d *createCaData) error {
+	if err := d.loadDN(); err != nil {
+		return err
+	}
+	d.subject = d.subjectOf(d.subject)
+	if !d.imCA {
+		// root CA issues itself, issuer defaults to subject
+		d.issuer = d.subjectOf(d.issuer)
+	}
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	key, err := d.key(ctx)
+	if err != nil {
+		return err
+	}
 	cd := &certmgr.CertData{
+		Key:         key,
 		KeySize:     d.bits,
 		ValidYears:  d.validYears,
 		Alias:       d.alias,
@@ -59,39 +313,246 @@ func createCA(d *createCaData) error {
 		Issuer:      d.issuer,
 		Subject:     d.subject,
 		Serial:      d.serial,
+		Constraints: &d.constraints,
+	}
+	var shareFiles []string
+	if d.shares > 0 {
+		// key is generated upfront, so that it's never stored as whole
+		if err = certmgr.GenerateKeys(ctx, common.NewSpinner(d.errw), 1, cd); err != nil {
+			return err
+		}
+		if shareFiles, err = writeShares(d, cd.Key); err != nil {
+			return err
+		}
+		cd.DiscardKey = true
 	}
 	if d.imCA {
-		return cm.NewIntermediateCA(cd)
+		err = cm.NewIntermediateCA(ctx, cd)
 	} else {
-		return cm.NewRootCA(cd)
+		err = cm.NewRootCA(ctx, cd)
+	}
+	if err != nil {
+		removeFiles(shareFiles)
+		return err
+	}
+	if len(shareFiles) > 0 && d.of == common.OutputTable {
+		if _, err = fmt.Fprintf(d.w, "Private key of '%s' was split into %d shares, %d of them are needed to issue certificates:\n%s\n",
+			d.alias, d.shares, d.threshold, strings.Join(shareFiles, "\n")); err != nil {
+			return err
+		}
 	}
+	return d.report(ctx, cm)
 }
 
-func createLeaf(d *createLeafData) error {
-	cm := certmgr.New(d.dir)
+func createLeaf(ctx context.Context, d *createLeafData) error {
+	p, err := profiles.Get(d.profile)
+	if err != nil {
+		return err
+	}
+	if err = d.loadDN(); err != nil {
+		return err
+	}
+	d.subject = d.subjectOf(d.subject)
+	var hwSan []certmgr.HardwareModuleName
+	for _, s := range d.hwSan {
+		hw, err := certmgr.ParseHardwareModuleName(s)
+		if err != nil {
+			return err
+		}
+		hwSan = append(hwSan, hw)
+	}
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	key, err := d.key(ctx)
+	if err != nil {
+		return err
+	}
 	cd := &certmgr.CertData{
+		Key:         key,
 		KeySize:     d.bits,
 		ValidYears:  d.validYears,
 		IPSan:       d.ipSan,
 		DNSSan:      d.dnsSan,
+		EmailSan:    d.emailSan,
+		UPNSan:      d.upnSan,
 		Alias:       d.alias,
 		ParentAlias: d.parent,
 		Issuer:      d.issuer,
 		Subject:     d.subject,
 		Serial:      d.serial,
+
+		HardwareModuleSan: hwSan,
+		SecurityID:        d.sid,
+		DelegationUsage:   d.delegation,
+		AllowAnyWildcard:  d.anyWildcard,
+		NoCommonNameSAN:   d.noCNSan,
+		AllowUnderscore:   d.underscore,
+		WriteChain:        d.writeChain,
+	}
+	p.Apply(cd)
+	if p.Name == profiles.SmartcardLogon && (len(cd.UPNSan) == 0 || len(cd.SecurityID) == 0) {
+		_, _ = fmt.Fprintf(d.errw, "Warning: certificate '%s' needs both --upn-san and --sid, "+
+			"domain controllers won't map it to account otherwise\n", d.alias)
 	}
-	return cm.NewLeaf(cd)
+	if err = d.checkPublicTrust(cd, fmt.Sprintf("certificate '%s'", d.alias)); err != nil {
+		return err
+	}
+	if err = d.checkCAA(ctx, cd); err != nil {
+		return err
+	}
+	if d.remote.Enabled() {
+		err = d.signRemote(ctx, cm, cd)
+	} else {
+		err = cm.NewLeaf(ctx, cd)
+	}
+	if err != nil {
+		return err
+	}
+	if !cd.HasSAN() {
+		if _, err = fmt.Fprintf(d.errw, "Warning: certificate '%s' has no subject alternative name, "+
+			"modern clients won't match it against any host\n", d.alias); err != nil {
+			return err
+		}
+	}
+	return d.report(ctx, cm)
 }
 
-func addDnFlags(prefix string, pm *pkix.Name, pf *pflag.FlagSet, helpSuffix string) {
-	pf.StringArrayVar(&pm.Locality, prefix+"-locality", pm.Country, "Locality components of "+prefix+" DN."+helpSuffix)
-	pf.StringArrayVar(&pm.Province, prefix+"-province", pm.Province, "Province components of "+prefix+" DN."+helpSuffix)
-	pf.StringArrayVar(&pm.Country, prefix+"-country", pm.Country, "Country components of "+prefix+" DN."+helpSuffix)
-	pf.StringArrayVar(&pm.StreetAddress, prefix+"-street-address", pm.StreetAddress, "Street address components of "+prefix+" DN."+helpSuffix)
-	pf.StringArrayVar(&pm.PostalCode, prefix+"-postal-code", pm.PostalCode, "Postal code components of "+prefix+" DN."+helpSuffix)
-	pf.StringArrayVar(&pm.Organization, prefix+"-organization", pm.Organization, "Organization components of "+prefix+" DN."+helpSuffix)
-	pf.StringArrayVar(&pm.OrganizationalUnit, prefix+"-organizational-unit", pm.OrganizationalUnit, "Organizational unit components of "+prefix+" DN."+helpSuffix)
-	pf.StringVar(&pm.CommonName, prefix+"-common-name", pm.CommonName, "Common name components of "+prefix+" DN."+helpSuffix)
+// signRemote sends CSR to signing server and stores issued certificate along with locally generated private key.
+func (d *createLeafData) signRemote(ctx context.Context, cm certmgr.Interface, cd *certmgr.CertData) error {
+	var unsupported []string
+	for flag, set := range map[string]bool{
+		"--key-plugin":       len(d.keyPlugin) > 0,
+		"--serial":           d.serial != 0,
+		"--email-san":        len(d.emailSan) > 0,
+		"--upn-san":          len(d.upnSan) > 0,
+		"--hardware-module":  len(d.hwSan) > 0,
+		"--sid":              len(d.sid) > 0,
+		"--delegation-usage": d.delegation,
+	} {
+		if set {
+			unsupported = append(unsupported, flag)
+		}
+	}
+	if len(unsupported) > 0 {
+		slices.Sort(unsupported)
+		return fmt.Errorf("%s can't be used with remote signer", strings.Join(unsupported, ", "))
+	}
+	if len(cd.Alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	// don't waste signature when certificate can't be stored anyway
+	if _, err := cm.GetCert(ctx, cd.Alias); err == nil {
+		return fmt.Errorf("%w: %s", certmgr.ErrAliasExists, cd.Alias)
+	}
+	c, err := d.remote.NewClient(ctx, cm)
+	if err != nil {
+		return err
+	}
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return err
+	}
+	cert, chain, err := c.Sign(ctx, &signer.SignRequest{
+		Parent:           d.parent,
+		CSR:              string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.CSR.Raw})),
+		Profile:          d.profile,
+		ValidYears:       d.validYears,
+		AllowAnyWildcard: d.anyWildcard,
+		AllowUnderscore:  d.underscore,
+		ApprovalID:       d.remote.ApprovalID,
+	})
+	if err != nil {
+		return err
+	}
+	return cm.Import(ctx, cd.Alias, cert, csr.Key, chain)
+}
+
+func createSvid(ctx context.Context, d *createSvidData) error {
+	id, err := spiffe.ParseID(d.id)
+	if err != nil {
+		return err
+	}
+	cd := spiffe.CertData(id, d.ttl, d.bits)
+	cd.Alias = d.alias
+	cd.ParentAlias = d.parent
+	cd.Serial = d.serial
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	if err = cm.NewLeaf(ctx, cd); err != nil {
+		return err
+	}
+	return d.report(ctx, cm)
+}
+
+func createMtls(ctx context.Context, d *createMtlsData) error {
+	if err := d.loadDN(); err != nil {
+		return err
+	}
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	server := &certmgr.CertData{
+		KeySize:     d.bits,
+		ValidYears:  d.validYears,
+		Alias:       d.serverAlias,
+		ParentAlias: d.parent,
+		Subject:     d.subjectOf(pkix.Name{CommonName: d.server, Organization: d.org}),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	server.AddSAN(d.server)
+	client := &certmgr.CertData{
+		KeySize:     d.bits,
+		ValidYears:  d.validYears,
+		Alias:       d.clientAlias,
+		ParentAlias: d.parent,
+		Subject:     d.subjectOf(pkix.Name{CommonName: d.client, Organization: d.org}),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if err := d.checkPublicTrust(server, fmt.Sprintf("server certificate '%s'", d.serverAlias)); err != nil {
+		return err
+	}
+	if err := d.checkCAA(ctx, server); err != nil {
+		return err
+	}
+	if err := certmgr.GenerateKeys(ctx, common.NewSpinner(d.errw), d.workers, server, client); err != nil {
+		return err
+	}
+	if err := cm.NewLeaf(ctx, server); err != nil {
+		return err
+	}
+	if err := cm.NewLeaf(ctx, client); err != nil {
+		return err
+	}
+	if d.of != common.OutputTable {
+		return d.report(ctx, cm)
+	}
+	_, err = fmt.Fprintf(d.w, "Created server certificate '%s' and client certificate '%s' issued by '%s'\n",
+		d.serverAlias, d.clientAlias, d.parent)
+	return err
+}
+
+func validateMtls(d *createMtlsData) error {
+	if len(d.server) == 0 || len(d.client) == 0 {
+		return errors.New("both server and client names are required")
+	}
+	if len(d.parent) == 0 {
+		return common.ErrParentAliasMissing
+	}
+	if len(d.serverAlias) == 0 {
+		d.serverAlias = d.server
+	}
+	if len(d.clientAlias) == 0 {
+		d.clientAlias = d.client
+	}
+	if d.serverAlias == d.clientAlias {
+		return errors.New("server and client aliases must differ")
+	}
+	return nil
 }
 
 func addCommonFlags(d *commonCreateData, pf *pflag.FlagSet) {
@@ -99,10 +560,125 @@ func addCommonFlags(d *commonCreateData, pf *pflag.FlagSet) {
 	pf.IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
 	pf.StringVar(&d.alias, "alias", "", "Alias for new certificate. Must be unique within directory")
 	pf.IntVar(&d.validYears, "years", d.validYears, "How meany years should new certificate be valid for")
+	pf.StringVar(&d.keyPlugin, "key-plugin", d.keyPlugin, "Path to plugin executable that holds private key, instead of generating new one")
+	pf.StringVar(&d.keyId, "key-id", d.keyId, "Identifier of key within plugin given by --key-plugin")
+	common.AddKeyShareFlag(&d.keyShares, pf)
+	addPostCreateFlag(d, pf)
 	common.AddDirFlag(&d.dir, pf)
 }
 
+func addPostCreateFlag(d *commonCreateData, pf *pflag.FlagSet) {
+	pf.StringVar(&d.postCreate, "post-create-exec", d.postCreate, "Shell command to run after certificate is created. "+
+		"Alias and file names are passed in PKITOOL_ALIAS, PKITOOL_CERT_FILE and PKITOOL_KEY_FILE environment variables")
+}
+
+func addWildcardFlag(anyWildcard *bool, pf *pflag.FlagSet) {
+	pf.BoolVar(anyWildcard, "allow-any-wildcard", *anyWildcard, "Skip validation of wildcard DNS names, "+
+		"which otherwise must have wildcard as whole leftmost label only, like *.example.com")
+}
+
+func addPublicTrustFlag(d *commonCreateData, pf *pflag.FlagSet) {
+	pf.BoolVar(&d.strictTrust, "strict-public-trust", d.strictTrust, "Fail instead of warning when TLS server certificate "+
+		"exceeds limits of publicly trusted certificates, like validity of 398 days")
+}
+
+func addWriteChainFlag(d *commonCreateData, pf *pflag.FlagSet) {
+	pf.BoolVar(&d.writeChain, "write-chain", d.writeChain, "Also write certificates of intermediate CAs that issued new certificate "+
+		"into <alias>-chain.pem, so that they can be deployed together")
+}
+
+func addCAAFlags(d *commonCreateData, pf *pflag.FlagSet) {
+	pf.StringVar(&d.caaIssuer, "caa-issuer", d.caaIssuer, "Check that CAA records of DNS names allow issuance by CA identified by given domain, "+
+		"like ca.example.com. Certificate is not created otherwise")
+	pf.StringVar(&d.caaServer, "caa-resolver", d.caaServer, "DNS resolver to look up CAA records with, like 10.0.0.1:53. Defaults to system resolver")
+	pf.BoolVar(&d.caaWarn, "caa-warn", d.caaWarn, "Only warn when CAA records don't allow issuance")
+}
+
+func addDNTemplateFlags(d *commonCreateData, pf *pflag.FlagSet) {
+	pf.StringVar(&d.dnTemplate, "dn-template", d.dnTemplate, "Name of DN template from configuration file, "+
+		"its components are used when not given by flags. Defaults from configuration file are used when not set")
+	pf.StringVar(&d.config, "config", d.config, "Configuration file with DN templates, defaults to "+config.FileName+" in directory")
+}
+
+func addUnderscoreFlag(underscore *bool, pf *pflag.FlagSet) {
+	pf.BoolVar(underscore, "allow-underscore", *underscore, "Allow underscores in DNS names, like in service names")
+}
+
+func validateSvid(d *createSvidData) error {
+	if len(d.id) == 0 {
+		return errors.New("SPIFFE ID is required")
+	}
+	if len(d.parent) == 0 {
+		return common.ErrParentAliasMissing
+	}
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if d.ttl <= 0 {
+		return errors.New("lifetime must be positive")
+	}
+	return nil
+}
+
+// isWithin tells whether dir is the same as parent, or is its subdirectory.
+func isWithin(dir, parent string) (bool, error) {
+	abs := func(p string) (string, error) {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		// directory may not exist yet
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			p = resolved
+		}
+		return p, nil
+	}
+	dir, err := abs(dir)
+	if err != nil {
+		return false, err
+	}
+	if parent, err = abs(parent); err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(parent, dir)
+	if err != nil {
+		return false, nil
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))), nil
+}
+
 func validateCa(d *createCaData) error {
+	if d.shares > 0 {
+		if d.threshold < 2 || d.threshold > d.shares {
+			return errors.New("threshold must be between 2 and number of key shares")
+		}
+		if len(d.keyPlugin) > 0 {
+			return errors.New("private key held by plugin can't be split into shares")
+		}
+		if len(d.sharePasswordFiles) > 0 && len(d.sharePasswordFiles) != d.shares {
+			return errors.New("password file must be given for every key share, or for none of them")
+		}
+		if len(d.sharesDir) == 0 {
+			return errors.New("directory to write key shares into must be given using --key-shares-dir")
+		}
+		// anyone who can read store could reconstruct key from unencrypted shares next to it
+		within, err := isWithin(d.sharesDir, d.dir)
+		if err != nil {
+			return err
+		}
+		if within && len(d.sharePasswordFiles) == 0 {
+			return errors.New("unencrypted key shares can't be written into directory with certificates, " +
+				"use --key-shares-dir outside of it or --key-share-password-file")
+		}
+	}
+	if d.constraints.MaxLeafValidity < 0 {
+		return errors.New("maximal leaf validity can't be negative")
+	}
+	for _, name := range d.constraints.Profiles {
+		if _, err := profiles.Get(name); err != nil {
+			return err
+		}
+	}
 	if !d.imCA {
 		if len(d.issuer.String()) == 0 {
 			d.issuer = d.subject
@@ -114,6 +690,7 @@ func validateCa(d *createCaData) error {
 func defData(w io.Writer, isCA bool) commonCreateData {
 	d := commonCreateData{
 		w:          w,
+		issued:     &common.IssuedRecorder{},
 		bits:       4096,
 		dir:        ".",
 		validYears: 1,
@@ -135,42 +712,148 @@ func newCaSubCommand(w io.Writer) *cobra.Command {
 			return validateCa(d)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return createCA(d)
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return createCA(cmd.Context(), d)
 		},
 	}
 	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate. Only taken into account for intermediate CA")
 	cmd.Flags().BoolVar(&d.imCA, "intermediate", d.imCA, "Whether new CA is intermediate")
 	addCommonFlags(&d.commonCreateData, cmd.Flags())
-	addDnFlags("issuer", &d.issuer, cmd.Flags(), " Only taken into account for root CA")
-	addDnFlags("subject", &d.subject, cmd.Flags(), "")
+	cmd.Flags().DurationVar(&d.constraints.MaxLeafValidity, "max-leaf-validity", d.constraints.MaxLeafValidity,
+		"Maximal validity of leaf certificates issued by CA, like 2160h")
+	cmd.Flags().StringArrayVar(&d.constraints.DNSSuffixes, "allowed-dns-suffix", d.constraints.DNSSuffixes,
+		"Domain that DNS names of leaf certificates issued by CA must belong to, like example.com. Can be repeated")
+	cmd.Flags().StringArrayVar(&d.constraints.Profiles, "allowed-profile", d.constraints.Profiles,
+		"Profile leaf certificates issued by CA must use, one of "+strings.Join(profiles.Names(), ", ")+". Can be repeated")
+	cmd.Flags().IntVar(&d.shares, "key-shares", d.shares, "Split private key into given number of shares (Shamir's secret sharing) instead of storing it. "+
+		"Only shares are written, into <alias>.share-<n> files")
+	cmd.Flags().IntVar(&d.threshold, "key-threshold", d.threshold, "How many key shares are needed to reconstruct private key")
+	cmd.Flags().StringArrayVar(&d.sharePasswordFiles, "key-share-password-file", d.sharePasswordFiles,
+		"File with password to encrypt key share with, repeated for every share in order")
+	cmd.Flags().StringVar(&d.sharesDir, "key-shares-dir", d.sharesDir, "Directory to write key shares into, required with --key-shares. "+
+		"Unencrypted shares can't be written into directory with certificates")
+	common.AddDNFlags("issuer", &d.issuer, cmd.Flags(), " Only taken into account for root CA")
+	common.AddDNFlags("subject", &d.subject, cmd.Flags(), "")
+	addDNTemplateFlags(&d.commonCreateData, cmd.Flags())
 	return cmd
 }
 
 func newLeafSubCommand(w io.Writer) *cobra.Command {
 	d := &createLeafData{
 		commonCreateData: defData(w, false),
+		profile:          profiles.Default,
 	}
 	cmd := &cobra.Command{
 		Use:   "leaf",
 		Short: "Create new leaf certificate/private key",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return createLeaf(d)
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return createLeaf(cmd.Context(), d)
 		},
 	}
 	addCommonFlags(&d.commonCreateData, cmd.Flags())
-	addDnFlags("subject", &d.subject, cmd.Flags(), "")
+	common.AddDNFlags("subject", &d.subject, cmd.Flags(), "")
+	addDNTemplateFlags(&d.commonCreateData, cmd.Flags())
 	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate")
 	cmd.Flags().IPSliceVar(&d.ipSan, "ip-san", d.ipSan, "Optional IP subject alternative name")
 	cmd.Flags().StringArrayVar(&d.dnsSan, "dns-san", d.dnsSan, "Optional DNS subject alternative name")
+	cmd.Flags().StringArrayVar(&d.emailSan, "email-san", d.emailSan, "Optional email subject alternative name")
+	cmd.Flags().StringArrayVar(&d.upnSan, "upn-san", d.upnSan, "Optional user principal name (like user@ad.acme.tld) as subject alternative name, "+
+		"used by EAP-TLS and Windows to map certificate to account")
+	cmd.Flags().StringArrayVar(&d.hwSan, "hardware-module", d.hwSan, "Optional hardware module name as type:serial (like 1.3.6.1.4.1.99999.1:SN0001) "+
+		"subject alternative name, identifying device in IEEE 802.1AR device identity")
+	cmd.Flags().StringVar(&d.sid, "sid", d.sid, "Security identifier of Active Directory account, like S-1-5-21-1004336348-1177238915-682003330-512. "+
+		"Domain controllers use it for strong mapping of smart card logon certificate to account")
+	cmd.Flags().StringVar(&d.profile, "profile", d.profile, "Profile of certificate, one of "+strings.Join(profiles.Names(), ", "))
+	addWildcardFlag(&d.anyWildcard, cmd.Flags())
+	addUnderscoreFlag(&d.underscore, cmd.Flags())
+	addPublicTrustFlag(&d.commonCreateData, cmd.Flags())
+	addWriteChainFlag(&d.commonCreateData, cmd.Flags())
+	addCAAFlags(&d.commonCreateData, cmd.Flags())
+	cmd.Flags().BoolVar(&d.delegation, "delegation-usage", d.delegation, "Add DelegationUsage extension, "+
+		"so that certificate can sign delegated credentials for TLS (RFC 9345)")
+	signer.AddFlags(&d.remote, cmd.Flags())
+	cmd.Flags().BoolVar(&d.noCNSan, "no-cn-san", d.noCNSan, "Don't copy subject common name into subject alternative names. "+
+		"By default, common name that is DNS name or IP address is added as SAN, unless it's already there")
+	return cmd
+}
+
+func newMtlsSubCommand(w io.Writer) *cobra.Command {
+	d := &createMtlsData{
+		commonCreateData: defData(w, false),
+	}
+	cmd := &cobra.Command{
+		Use:   "mtls",
+		Short: "Create pair of server and client certificates for mutual TLS, issued by the same CA",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateMtls(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return createMtls(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.server, "server", "", "DNS name or IP address of server. Used as common name and SAN of server certificate")
+	cmd.Flags().StringVar(&d.client, "client", "", "Common name of client certificate")
+	cmd.Flags().StringVar(&d.serverAlias, "server-alias", "", "Alias of server certificate, defaults to server name")
+	cmd.Flags().StringVar(&d.clientAlias, "client-alias", "", "Alias of client certificate, defaults to client name")
+	cmd.Flags().StringArrayVar(&d.org, "organization", d.org, "Organization components of subject DN of both certificates")
+	addDNTemplateFlags(&d.commonCreateData, cmd.Flags())
+	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.validYears, "years", d.validYears, "How meany years should new certificates be valid for")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many keys to generate concurrently, defaults to number of CPUs")
+	addPublicTrustFlag(&d.commonCreateData, cmd.Flags())
+	addCAAFlags(&d.commonCreateData, cmd.Flags())
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	addPostCreateFlag(&d.commonCreateData, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func newSvidSubCommand(w io.Writer) *cobra.Command {
+	d := &createSvidData{
+		commonCreateData: defData(w, false),
+		ttl:              time.Hour,
+	}
+	d.bits = 2048
+	cmd := &cobra.Command{
+		Use:   "svid",
+		Short: "Create new short-lived X.509 SVID (SPIFFE identity) with SPIFFE ID as URI SAN",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateSvid(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return createSvid(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.id, "id", d.id, "SPIFFE ID, like spiffe://example.org/web")
+	cmd.Flags().DurationVar(&d.ttl, "ttl", d.ttl, "Lifetime of SVID")
+	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate")
+	cmd.Flags().StringVar(&d.alias, "alias", "", "Alias for new certificate. Must be unique within directory")
+	cmd.Flags().Int64Var(&d.serial, "serial", d.serial, "Certificate serial number")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	addPostCreateFlag(&d.commonCreateData, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
 	return cmd
 }
 
-func NewCommand(_ io.Reader, out io.Writer) *cobra.Command {
+func NewCommand(in io.Reader, out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create new certificate",
 	}
 	cmd.AddCommand(newCaSubCommand(out))
 	cmd.AddCommand(newLeafSubCommand(out))
+	cmd.AddCommand(newMtlsSubCommand(out))
+	cmd.AddCommand(newEtcdSubCommand(out))
+	cmd.AddCommand(newSvidSubCommand(out))
+	cmd.AddCommand(newBulkSubCommand(in, out))
 	return cmd
 }
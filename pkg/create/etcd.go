@@ -0,0 +1,188 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package create
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"strings"
+)
+
+// etcdLocalNames are added to server certificates, so that etcdctl works on member host itself.
+var etcdLocalNames = []string{"localhost", "127.0.0.1", "::1"}
+
+type createEtcdData struct {
+	commonCreateData
+	members []string
+	dnsSan  []string
+	clients []string
+	org     []string
+	workers int
+}
+
+// etcdMember is single member of cluster, with addresses it's reachable on.
+type etcdMember struct {
+	name  string
+	addrs []string
+}
+
+// parseEtcdMember parses member given as name=addr[,addr...], where name alone is used as its only address.
+func parseEtcdMember(s string) (*etcdMember, error) {
+	name, addrs, found := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("invalid member %q: name is required", s)
+	}
+	m := &etcdMember{name: name}
+	if !found {
+		m.addrs = []string{name}
+		return m, nil
+	}
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			m.addrs = append(m.addrs, addr)
+		}
+	}
+	if len(m.addrs) == 0 {
+		return nil, fmt.Errorf("invalid member %q: no address given", s)
+	}
+	return m, nil
+}
+
+func (d *createEtcdData) certData(alias, cn string, ekus ...x509.ExtKeyUsage) *certmgr.CertData {
+	return &certmgr.CertData{
+		KeySize:     d.bits,
+		ValidYears:  d.validYears,
+		Alias:       alias,
+		ParentAlias: d.parent,
+		Subject:     d.subjectOf(pkix.Name{CommonName: cn, Organization: d.org}),
+		ExtKeyUsage: ekus,
+	}
+}
+
+// createEtcd issues server and peer certificate for every member and client certificates.
+// Server certificate is valid for member addresses, extra DNS names and localhost, peer certificate for member addresses only.
+// Both are usable for client authentication too, since etcd members connect to each other.
+func createEtcd(ctx context.Context, d *createEtcdData) error {
+	if err := d.loadDN(); err != nil {
+		return err
+	}
+	var (
+		cds   []*certmgr.CertData
+		kinds []string
+	)
+	for _, s := range d.members {
+		m, err := parseEtcdMember(s)
+		if err != nil {
+			return err
+		}
+		server := d.certData(m.name+"-server", m.name, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
+		peer := d.certData(m.name+"-peer", m.name, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
+		if net.ParseIP(m.name) == nil {
+			server.AddSAN(m.name)
+			peer.AddSAN(m.name)
+		}
+		for _, addr := range m.addrs {
+			server.AddSAN(addr)
+			peer.AddSAN(addr)
+		}
+		for _, name := range append(etcdLocalNames, d.dnsSan...) {
+			server.AddSAN(name)
+		}
+		cds = append(cds, server, peer)
+		kinds = append(kinds, "server", "peer")
+	}
+	for _, client := range d.clients {
+		cds = append(cds, d.certData(client, client, x509.ExtKeyUsageClientAuth))
+		kinds = append(kinds, "client")
+	}
+	cm, err := d.manager()
+	if err != nil {
+		return err
+	}
+	if err := certmgr.GenerateKeys(ctx, common.NewSpinner(d.errw), d.workers, cds...); err != nil {
+		return err
+	}
+	for i, cd := range cds {
+		if err := cm.NewLeaf(ctx, cd); err != nil {
+			return err
+		}
+		if d.of != common.OutputTable {
+			continue
+		}
+		if _, err := fmt.Fprintf(d.w, "Created %s certificate '%s'\n", kinds[i], cd.Alias); err != nil {
+			return err
+		}
+	}
+	return d.report(ctx, cm)
+}
+
+func validateEtcd(d *createEtcdData) error {
+	if len(d.members) == 0 {
+		return errors.New("at least one member is required")
+	}
+	if len(d.parent) == 0 {
+		return common.ErrParentAliasMissing
+	}
+	return nil
+}
+
+func newEtcdSubCommand(w io.Writer) *cobra.Command {
+	d := &createEtcdData{
+		commonCreateData: defData(w, false),
+	}
+	d.bits = 2048
+	cmd := &cobra.Command{
+		Use:   "etcd",
+		Short: "Create server and peer certificates of etcd members and client certificates, all issued by the same CA",
+		Long: "Create server and peer certificate for every etcd member, aliased <name>-server and <name>-peer,\n" +
+			"valid for member name and addresses. Server certificates are valid for localhost and --dns-san names too.\n" +
+			"Client certificates, aliased by client name, are meant for etcdctl or API server.",
+		Example: "pkitool create etcd --parent etcd-ca --member etcd-0=10.0.0.10 --member etcd-1=10.0.0.11 " +
+			"--member etcd-2=10.0.0.12 --client kube-apiserver-etcd-client",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateEtcd(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			return createEtcd(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.members, "member", d.members, "Member as name=address[,address...], "+
+		"where address is IP address or DNS name. Name alone is also its address. Can be repeated")
+	cmd.Flags().StringArrayVar(&d.dnsSan, "dns-san", d.dnsSan, "Additional name of all server certificates, like name of load balancer")
+	cmd.Flags().StringArrayVar(&d.clients, "client", d.clients, "Common name and alias of client certificate. Can be repeated")
+	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate")
+	cmd.Flags().StringArrayVar(&d.org, "organization", d.org, "Organization components of subject DN of all certificates")
+	addDNTemplateFlags(&d.commonCreateData, cmd.Flags())
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.validYears, "years", d.validYears, "How meany years should new certificates be valid for")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many keys to generate concurrently, defaults to number of CPUs")
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	addPostCreateFlag(&d.commonCreateData, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
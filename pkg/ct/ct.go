@@ -0,0 +1,297 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ct monitors Certificate Transparency logs for certificates of owned domains that were not issued through store.
+package ct
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultSearchURL is crt.sh, which aggregates all publicly trusted CT logs and offers search by domain.
+const defaultSearchURL = "https://crt.sh/"
+
+type monitorData struct {
+	w          io.Writer
+	dir        string
+	domains    []string
+	searchURL  string
+	subdomains bool
+	interval   time.Duration
+	timeout    time.Duration
+	of         common.OutputFormat
+}
+
+// entry is certificate found in CT logs, as returned by crt.sh JSON API.
+type entry struct {
+	ID         int64  `json:"id"`
+	IssuerName string `json:"issuer_name"`
+	NameValue  string `json:"name_value"`
+	Serial     string `json:"serial_number"`
+	NotBefore  string `json:"not_before"`
+	NotAfter   string `json:"not_after"`
+}
+
+// finding is certificate found in CT logs, that is not present in store.
+type finding struct {
+	ID        int64    `json:"id" yaml:"id"`
+	Issuer    string   `json:"issuer" yaml:"issuer"`
+	Names     []string `json:"names" yaml:"names"`
+	Serial    string   `json:"serial" yaml:"serial"`
+	NotBefore string   `json:"notBefore" yaml:"notBefore"`
+	NotAfter  string   `json:"notAfter" yaml:"notAfter"`
+}
+
+// eachCert calls fn for every certificate in store, without holding all of them in memory.
+func eachCert(ctx context.Context, cm certmgr.Reader, fn func(*x509.Certificate)) error {
+	s, err := cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	for s.Next() {
+		fn(s.Entry().Cert)
+	}
+	return s.Err()
+}
+
+// managedDomains gets DNS names of leaf certificates in store, wildcards are reduced to their base domain.
+func managedDomains(ctx context.Context, cm certmgr.Reader) ([]string, error) {
+	seen := map[string]bool{}
+	if err := eachCert(ctx, cm, func(c *x509.Certificate) {
+		if c.IsCA {
+			return
+		}
+		for _, name := range c.DNSNames {
+			seen[strings.TrimPrefix(name, "*.")] = true
+		}
+	}); err != nil {
+		return nil, err
+	}
+	res := lo.Keys(seen)
+	slices.Sort(res)
+	return res, nil
+}
+
+// search finds unexpired certificates with given identity in CT logs.
+func search(ctx context.Context, client *http.Client, base, identity string) ([]entry, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("identity", identity)
+	q.Set("output", "json")
+	q.Set("exclude", "expired")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status while searching CT logs for %s: %s", identity, resp.Status)
+	}
+	var res []entry
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("invalid response while searching CT logs for %s: %w", identity, err)
+	}
+	return res, nil
+}
+
+// serialOf converts hexadecimal serial number to decimal form.
+func serialOf(e *entry) string {
+	n, ok := new(big.Int).SetString(e.Serial, 16)
+	if !ok {
+		return e.Serial
+	}
+	return n.String()
+}
+
+// unknown finds certificates logged for domains that are not present in store.
+// Precertificate and final certificate share serial number, so each is reported only once.
+func unknown(ctx context.Context, d *monitorData, client *http.Client, cm certmgr.Reader) ([]finding, error) {
+	known := map[string]bool{}
+	if err := eachCert(ctx, cm, func(c *x509.Certificate) {
+		known[c.SerialNumber.String()] = true
+	}); err != nil {
+		return nil, err
+	}
+	var res []finding
+	for _, domain := range d.domains {
+		identities := []string{domain}
+		if d.subdomains {
+			identities = append(identities, "%."+domain)
+		}
+		for _, identity := range identities {
+			entries, err := search(ctx, client, d.searchURL, identity)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				serial := serialOf(&e)
+				if !known[serial] {
+					known[serial] = true
+					res = append(res, finding{
+						ID:        e.ID,
+						Issuer:    e.IssuerName,
+						Names:     strings.Split(e.NameValue, "\n"),
+						Serial:    e.Serial,
+						NotBefore: e.NotBefore,
+						NotAfter:  e.NotAfter,
+					})
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func render(d *monitorData, findings []finding) error {
+	return common.Render(d.w, d.of, findings, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"CT ID", "Issuer", "Names", "Not before", "Not after", "Serial",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, f := range findings {
+			tbl.Append([]string{
+				fmt.Sprint(f.ID), f.Issuer, strings.Join(f.Names, ", "), f.NotBefore, f.NotAfter, f.Serial,
+			})
+		}
+	})
+}
+
+// monitor checks CT logs once, or periodically when interval is set.
+// Returns true when certificates not present in store were found.
+func monitor(ctx context.Context, d *monitorData) (bool, error) {
+	cm := certmgr.New(d.dir)
+	if len(d.domains) == 0 {
+		var err error
+		if d.domains, err = managedDomains(ctx, cm); err != nil {
+			return false, err
+		}
+		if len(d.domains) == 0 {
+			return false, errors.New("no domains to monitor, there are no leaf certificates with DNS names in store")
+		}
+	}
+	client := &http.Client{Timeout: d.timeout}
+	if d.interval == 0 {
+		findings, err := unknown(ctx, d, client, cm)
+		if err != nil {
+			return false, err
+		}
+		if len(findings) == 0 {
+			_, err = fmt.Fprintf(d.w, "No unknown certificates found for %s\n", strings.Join(d.domains, ", "))
+			return false, err
+		}
+		return true, render(d, findings)
+	}
+	// certificates are reported only when first seen
+	reported := map[int64]bool{}
+	for {
+		findings, err := unknown(ctx, d, client, cm)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, f := range findings {
+			if !reported[f.ID] {
+				reported[f.ID] = true
+				if _, err = fmt.Fprintf(d.w, "%s unknown certificate for %s issued by %s (CT ID %d, serial %s)\n",
+					time.Now().Format(time.RFC3339), strings.Join(f.Names, ", "), f.Issuer, f.ID, f.Serial); err != nil {
+					return false, err
+				}
+			}
+		}
+		t := time.NewTimer(d.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return false, nil
+		case <-t.C:
+		}
+	}
+}
+
+func newMonitorSubCommand(w io.Writer) *cobra.Command {
+	d := &monitorData{
+		w:          w,
+		dir:        ".",
+		searchURL:  defaultSearchURL,
+		subdomains: true,
+		timeout:    time.Minute,
+	}
+	cmd := &cobra.Command{
+		Use:   "monitor",
+		Short: "Find certificates of domains in Certificate Transparency logs that are not present in store (possible mis-issuance)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			found, err := monitor(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if found {
+				// certificates were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.domains, "domain", d.domains, "Domain to monitor, can be repeated. Defaults to DNS names of leaf certificates in store")
+	cmd.Flags().BoolVar(&d.subdomains, "include-subdomains", d.subdomains, "Whether to monitor subdomains too")
+	cmd.Flags().StringVar(&d.searchURL, "search-url", d.searchURL, "URL of CT log search service compatible with crt.sh JSON API")
+	cmd.Flags().DurationVar(&d.interval, "interval", d.interval, "How often to check CT logs. Only checked once when not set")
+	cmd.Flags().DurationVar(&d.timeout, "timeout", d.timeout, "Timeout of HTTP requests")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ct",
+		Short: "Work with Certificate Transparency logs",
+	}
+	cmd.AddCommand(newMonitorSubCommand(out))
+	return cmd
+}
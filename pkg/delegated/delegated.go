@@ -0,0 +1,253 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package delegated issues delegated credentials for TLS (RFC 9345).
+package delegated
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxValidity is maximal validity of delegated credential, see RFC 9345, section 4.1.3
+const MaxValidity = 7 * 24 * time.Hour
+
+// signature schemes, see RFC 8446, section 4.2.3
+const (
+	schemeECDSAP256SHA256  uint16 = 0x0403
+	schemeECDSAP384SHA384  uint16 = 0x0503
+	schemeECDSAP521SHA512  uint16 = 0x0603
+	schemeRSAPSSRSAESHA256 uint16 = 0x0804
+	schemeEd25519          uint16 = 0x0807
+)
+
+// signaturePrefix is prepended to data signed by certificate, see RFC 9345, section 4.1.3
+var signaturePrefix = append(bytes.Repeat([]byte{0x20}, 64), []byte("TLS, server delegated credentials\x00")...)
+
+// keyTypes are types of key delegated credential can have
+var keyTypes = []string{"ec-p256", "ec-p384", "ec-p521", "ed25519"}
+
+type createData struct {
+	w        io.Writer
+	dir      string
+	alias    string
+	keyType  string
+	validity time.Duration
+	out      string
+	now      func() time.Time
+}
+
+// scheme gets signature scheme used by key, along with hash and signer options it needs.
+func scheme(pub crypto.PublicKey) (uint16, crypto.SignerOpts, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return schemeECDSAP256SHA256, crypto.SHA256, nil
+		case elliptic.P384():
+			return schemeECDSAP384SHA384, crypto.SHA384, nil
+		case elliptic.P521():
+			return schemeECDSAP521SHA512, crypto.SHA512, nil
+		}
+	case ed25519.PublicKey:
+		return schemeEd25519, crypto.Hash(0), nil
+	case *rsa.PublicKey:
+		return schemeRSAPSSRSAESHA256, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// generateKey generates key of delegated credential.
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ec-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ec-p521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", keyType)
+}
+
+// Create creates delegated credential for key dcKey, valid until given time, signed by certificate and its key.
+// Returned value is DelegatedCredential structure, as sent by TLS server.
+func Create(cert *x509.Certificate, key crypto.Signer, dcKey crypto.Signer, until time.Time) ([]byte, error) {
+	if !certmgr.HasDelegationUsage(cert) {
+		return nil, errors.New("certificate doesn't have DelegationUsage extension and digitalSignature key usage")
+	}
+	if until.After(cert.NotAfter) {
+		return nil, fmt.Errorf("delegated credential can't outlive certificate, which expires at %s", cert.NotAfter)
+	}
+	validTime := until.Sub(cert.NotBefore) / time.Second
+	if validTime <= 0 || validTime > 1<<32-1 {
+		return nil, fmt.Errorf("invalid validity end: %s", until)
+	}
+	dcScheme, _, err := scheme(dcKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	if dcScheme == schemeRSAPSSRSAESHA256 {
+		// rsaEncryption keys are not allowed in delegated credentials
+		return nil, errors.New("RSA key can't be used for delegated credential")
+	}
+	spki, err := x509.MarshalPKIXPublicKey(dcKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	if len(spki) >= 1<<24 {
+		return nil, errors.New("public key is too large")
+	}
+	// Credential: valid_time, dc_cert_verify_algorithm, ASN1_subjectPublicKeyInfo<1..2^24-1>
+	cred := binary.BigEndian.AppendUint32(nil, uint32(validTime))
+	cred = binary.BigEndian.AppendUint16(cred, dcScheme)
+	cred = append(cred, byte(len(spki)>>16), byte(len(spki)>>8), byte(len(spki)))
+	cred = append(cred, spki...)
+
+	certScheme, opts, err := scheme(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	msg := append(append(slices.Clone(signaturePrefix), cert.Raw...), cred...)
+	msg = binary.BigEndian.AppendUint16(msg, certScheme)
+	digest := msg
+	if h := opts.HashFunc(); h != 0 {
+		hh := h.New()
+		hh.Write(msg)
+		digest = hh.Sum(nil)
+	}
+	sig, err := key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) >= 1<<16 {
+		return nil, errors.New("signature is too large")
+	}
+	// DelegatedCredential: cred, algorithm, signature<0..2^16-1>
+	dc := binary.BigEndian.AppendUint16(cred, certScheme)
+	dc = binary.BigEndian.AppendUint16(dc, uint16(len(sig)))
+	return append(dc, sig...), nil
+}
+
+// create creates delegated credential of alias and writes it to <out>.dc along with its private key in <out>.key.
+func create(ctx context.Context, d *createData) error {
+	ph, err := certmgr.New(d.dir).Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if ph.Key == nil {
+		return fmt.Errorf("private key of '%s' is not in store", d.alias)
+	}
+	dcKey, err := generateKey(d.keyType)
+	if err != nil {
+		return err
+	}
+	until := d.now().Add(d.validity).Truncate(time.Second)
+	dc, err := Create(ph.Cert, ph.Key, dcKey, until)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := certmgr.MarshalKeyPEM(dcKey)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(d.out+".dc", dc, 0o644); err != nil {
+		return err
+	}
+	if err = os.WriteFile(d.out+".key", keyPEM, 0o600); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Created delegated credential of '%s' valid until %s: %s.dc, %s.key\n",
+		d.alias, until.UTC().Format(time.RFC3339), d.out, d.out)
+	return err
+}
+
+func validateCreate(d *createData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if d.validity <= 0 || d.validity > MaxValidity {
+		return fmt.Errorf("%w: validity must be positive and at most %s", certmgr.ErrInvalidValidity, MaxValidity)
+	}
+	if !slices.Contains(keyTypes, d.keyType) {
+		return fmt.Errorf("unsupported key type: %s", d.keyType)
+	}
+	if len(d.out) == 0 {
+		d.out = d.alias
+	}
+	return nil
+}
+
+func newCreateSubCommand(w io.Writer) *cobra.Command {
+	d := &createData{
+		w:        w,
+		dir:      ".",
+		keyType:  "ec-p256",
+		validity: 24 * time.Hour,
+		now:      time.Now,
+	}
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create short-lived delegated credential signed by stored leaf certificate",
+		Long: "Create delegated credential (RFC 9345) with new key, signed by leaf certificate created with --delegation-usage.\n" +
+			"Credential is written to <out>.dc in wire format, its private key to <out>.key.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateCreate(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return create(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of leaf certificate that signs delegated credential")
+	cmd.Flags().StringVar(&d.keyType, "key-type", d.keyType, "Type of delegated credential key, one of "+strings.Join(keyTypes, ", "))
+	cmd.Flags().DurationVar(&d.validity, "validity", d.validity, "How long should delegated credential be valid for, at most 7 days")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Path prefix of written files, defaults to alias")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delegated-credential",
+		Short: "Manage delegated credentials for TLS (RFC 9345)",
+	}
+	cmd.AddCommand(newCreateSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package deploy runs actions that make services pick up renewed certificates, like reload of systemd unit.
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"io"
+	"os/exec"
+	"path"
+	"time"
+)
+
+const defaultTimeout = time.Minute
+
+// Deploy is set of actions run for certificates of matching aliases.
+type Deploy struct {
+	cfg    *config.Deploy
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// New validates configuration of deploy actions.
+func New(cfg *config.Deploy, stdout, stderr io.Writer) (*Deploy, error) {
+	if len(cfg.Alias) == 0 {
+		return nil, errors.New("deploy entry without alias")
+	}
+	if _, err := path.Match(cfg.Alias, ""); err != nil {
+		return nil, fmt.Errorf("invalid alias pattern of deploy entry: %s", cfg.Alias)
+	}
+	if len(cfg.Exec) == 0 && len(cfg.SystemdReload) == 0 && len(cfg.SCP) == 0 {
+		return nil, fmt.Errorf("deploy entry for '%s' has no action", cfg.Alias)
+	}
+	return &Deploy{cfg: cfg, stdout: stdout, stderr: stderr}, nil
+}
+
+// Matches checks whether actions apply to given alias.
+func (d *Deploy) Matches(alias string) bool {
+	ok, _ := path.Match(d.cfg.Alias, alias)
+	return ok
+}
+
+func (d *Deploy) timeout() time.Duration {
+	if d.cfg.Timeout > 0 {
+		return d.cfg.Timeout
+	}
+	return defaultTimeout
+}
+
+func (d *Deploy) run(ctx context.Context, name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = d.stdout
+	cmd.Stderr = d.stderr
+	return cmd.Run()
+}
+
+// Run runs actions for event, stopping at first failure.
+func (d *Deploy) Run(ctx context.Context, ev *certmgr.Event) error {
+	if len(d.cfg.SCP) > 0 {
+		if len(ev.CertFile) == 0 {
+			return fmt.Errorf("certificate of '%s' is not in file, it can't be copied", ev.Alias)
+		}
+		// batch mode, so that daemon doesn't hang waiting for password
+		args := []string{"-q", "-B", ev.CertFile}
+		if len(ev.KeyFile) > 0 {
+			args = append(args, ev.KeyFile)
+		}
+		if err := d.run(ctx, "scp", append(args, d.cfg.SCP)...); err != nil {
+			return fmt.Errorf("copy to %s failed: %w", d.cfg.SCP, err)
+		}
+	}
+	if len(d.cfg.Exec) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, d.timeout())
+		defer cancel()
+		if err := certmgr.ExecHook(d.cfg.Exec, d.stdout, d.stderr)(ctx, ev); err != nil {
+			return fmt.Errorf("command '%s' failed: %w", d.cfg.Exec, err)
+		}
+	}
+	if len(d.cfg.SystemdReload) > 0 {
+		if err := d.run(ctx, "systemctl", "reload", d.cfg.SystemdReload); err != nil {
+			return fmt.Errorf("reload of %s failed: %w", d.cfg.SystemdReload, err)
+		}
+	}
+	return nil
+}
+
+// Hook runs actions of all entries matching alias of event, after certificate is created or renewed.
+// Every matching entry is run even when previous one failed, errors are joined.
+func Hook(deploys []*Deploy) certmgr.Hook {
+	return func(ctx context.Context, ev *certmgr.Event) error {
+		if (ev.Type != certmgr.EventCreate && ev.Type != certmgr.EventRenew) || len(ev.Alias) == 0 {
+			return nil
+		}
+		var errs []error
+		for _, d := range deploys {
+			if !d.Matches(ev.Alias) {
+				continue
+			}
+			if err := d.Run(ctx, ev); err != nil {
+				errs = append(errs, fmt.Errorf("deploy of '%s' failed: %w", ev.Alias, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Load creates deploy actions configured in configuration file.
+func Load(configPath string, stdout, stderr io.Writer) ([]*Deploy, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Deploy
+	for i := range cfg.Deploy {
+		d, err := New(&cfg.Deploy[i], stdout, stderr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package devcert
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"time"
+)
+
+// names that are always part of development certificate
+var defaultNames = []string{"localhost", "127.0.0.1", "::1"}
+
+type devCertData struct {
+	w       io.Writer
+	errw    io.Writer
+	dir     string
+	caAlias string
+	alias   string
+	bits    int
+	days    int
+	names   []string
+}
+
+// ensureCA creates development root CA, unless it already exists.
+func ensureCA(ctx context.Context, d *devCertData, cm certmgr.Interface) error {
+	_, err := cm.GetCert(ctx, d.caAlias)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, certmgr.ErrAliasNotFound) {
+		return err
+	}
+	subj := pkix.Name{
+		CommonName:   "pkitool development CA",
+		Organization: []string{"pkitool development CA"},
+	}
+	if err = cm.NewRootCA(ctx, &certmgr.CertData{
+		KeySize:    d.bits,
+		ValidYears: 10,
+		Alias:      d.caAlias,
+		Subject:    subj,
+		Issuer:     subj,
+	}); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Created development root CA '%s', add %s/%s.pem to your trust store\n", d.caAlias, d.dir, d.caAlias)
+	return err
+}
+
+func devCert(ctx context.Context, d *devCertData) error {
+	cm := certmgr.New(d.dir, certmgr.WithProgress(common.NewSpinner(d.errw)))
+	if err := ensureCA(ctx, d, cm); err != nil {
+		return err
+	}
+	cd := &certmgr.CertData{
+		KeySize:     d.bits,
+		Validity:    time.Duration(d.days) * 24 * time.Hour,
+		Alias:       d.alias,
+		ParentAlias: d.caAlias,
+		Subject: pkix.Name{
+			CommonName:   d.names[0],
+			Organization: []string{"pkitool development certificate"},
+		},
+	}
+	for _, name := range d.names {
+		cd.AddSAN(name)
+	}
+	// replace any previous certificate, so that command can be re-run
+	if err := cm.Delete(ctx, d.alias); err != nil {
+		return err
+	}
+	if err := cm.NewLeaf(ctx, cd); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(d.w, "Created certificate '%s' valid for %d days: %s/%s.pem, %s/%s.key\n",
+		d.alias, d.days, d.dir, d.alias, d.dir, d.alias)
+	return err
+}
+
+func NewCommand(w io.Writer) *cobra.Command {
+	d := &devCertData{
+		w:       w,
+		dir:     ".",
+		caAlias: "devRootCA",
+		alias:   "dev-cert",
+		bits:    2048,
+		days:    30,
+	}
+	cmd := &cobra.Command{
+		Use:   "dev-cert [names...]",
+		Short: "Issue short-lived certificate for localhost development from local development CA",
+		Long: "Issue short-lived certificate for localhost, 127.0.0.1, ::1 and any additional names given as arguments.\n" +
+			"Development root CA is created on the first run and reused afterwards.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return common.RequireTable(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.names = append(append([]string{}, defaultNames...), args...)
+			d.errw = cmd.ErrOrStderr()
+			return devCert(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.caAlias, "ca-alias", d.caAlias, "Alias of development root CA")
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of issued certificate")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.days, "days", d.days, "How many days should certificate be valid for")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
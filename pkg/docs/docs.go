@@ -0,0 +1,94 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docs
+
+import (
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type docsData struct {
+	w       io.Writer
+	dir     string
+	man     bool
+	md      bool
+	section string
+}
+
+func genDocs(d *docsData, root *cobra.Command) error {
+	root.DisableAutoGenTag = true
+	if d.man {
+		dir := filepath.Join(d.dir, "man")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := doc.GenManTree(root, &doc.GenManHeader{
+			Title:   "PKITOOL",
+			Section: d.section,
+		}, dir); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(d.w, "man pages written to %s\n", dir); err != nil {
+			return err
+		}
+	}
+	if d.md {
+		dir := filepath.Join(d.dir, "markdown")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := doc.GenMarkdownTree(root, dir); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(d.w, "markdown documentation written to %s\n", dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewCommand(w io.Writer) *cobra.Command {
+	d := &docsData{
+		w:       w,
+		dir:     "docs",
+		man:     true,
+		md:      true,
+		section: "1",
+	}
+	cmd := &cobra.Command{
+		Use:    "gen-docs",
+		Short:  "Generate man pages and markdown documentation for all commands",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return common.RequireTable(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return genDocs(d, cmd.Root())
+		},
+	}
+	cmd.Flags().StringVar(&d.dir, "dir", d.dir, "Directory to write documentation into")
+	cmd.Flags().BoolVar(&d.man, "man", d.man, "Whether to generate man pages")
+	cmd.Flags().BoolVar(&d.md, "markdown", d.md, "Whether to generate markdown documentation")
+	cmd.Flags().StringVar(&d.section, "man-section", d.section, "Manual section of generated man pages")
+	return cmd
+}
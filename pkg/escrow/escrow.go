@@ -0,0 +1,209 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package escrow keeps copies of generated private keys encrypted for organizational recovery agent,
+// so that data encrypted to certificate can be recovered after its holder is gone.
+package escrow
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/cms"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Options creates options of certificate manager that escrow keys as configured in configuration file.
+// Nil is returned when escrow is not configured.
+func Options(configPath string) ([]certmgr.Option, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	e := &cfg.Escrow
+	if len(e.Recipient) == 0 {
+		return nil, nil
+	}
+	if len(e.Profiles) == 0 {
+		return nil, errors.New("escrow requires at least one profile to opt in")
+	}
+	for _, name := range e.Profiles {
+		if _, err = profiles.Get(name); err != nil {
+			return nil, fmt.Errorf("invalid escrow profile: %w", err)
+		}
+	}
+	path := e.Recipient
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(configPath), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := certmgr.ParseCertificatePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := recipient.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("certificate of escrow recipient %s must have RSA key", path)
+	}
+	return []certmgr.Option{certmgr.WithEscrow(func(der []byte) ([]byte, error) {
+		return cms.Encrypt(der, []*x509.Certificate{recipient})
+	}, e.Profiles)}, nil
+}
+
+type recoverData struct {
+	w            io.Writer
+	dir          string
+	alias        string
+	certFile     string
+	keyFile      string
+	passwordFile string
+	out          string
+}
+
+// recoverKey decrypts escrowed key of alias using private key of recovery agent.
+func recoverKey(ctx context.Context, d *recoverData) error {
+	data, err := os.ReadFile(d.certFile)
+	if err != nil {
+		return err
+	}
+	agentCert, err := certmgr.ParseCertificatePEM(data)
+	if err != nil {
+		return err
+	}
+	if data, err = os.ReadFile(d.keyFile); err != nil {
+		return err
+	}
+	var password []byte
+	if len(d.passwordFile) > 0 {
+		if password, err = common.ReadPasswordFile(d.passwordFile); err != nil {
+			return err
+		}
+	}
+	agentKey, err := certmgr.ParseEncryptedKeyPEM(data, password)
+	if err != nil {
+		return err
+	}
+	dec, ok := agentKey.(crypto.Decrypter)
+	if !ok {
+		return errors.New("private key of recovery agent can't be used for decryption")
+	}
+	cm := certmgr.New(d.dir)
+	sealed, err := cm.GetEscrow(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	der, err := cms.Decrypt(sealed, agentCert, dec)
+	if err != nil {
+		return err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return err
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported escrowed key type %T", parsed)
+	}
+	cert, err := cm.GetCert(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+		return fmt.Errorf("%w: escrowed key of '%s'", certmgr.ErrKeyMismatch, d.alias)
+	}
+	keyPEM, err := certmgr.MarshalKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	if d.out == common.StdinMarker {
+		_, err = d.w.Write(keyPEM)
+		return err
+	}
+	f, err := os.OpenFile(d.out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(keyPEM); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Private key of '%s' recovered to %s\n", d.alias, d.out)
+	return err
+}
+
+func validateRecover(d *recoverData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.certFile) == 0 || len(d.keyFile) == 0 {
+		return errors.New("both certificate and private key of recovery agent are required")
+	}
+	if len(d.out) == 0 {
+		return errors.New("output file is required")
+	}
+	return nil
+}
+
+func newRecoverSubCommand(w io.Writer) *cobra.Command {
+	d := &recoverData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Recover escrowed private key using key of recovery agent",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateRecover(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return recoverKey(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of certificate whose private key should be recovered")
+	cmd.Flags().StringVar(&d.certFile, "recovery-cert", d.certFile, "Certificate of recovery agent")
+	cmd.Flags().StringVar(&d.keyFile, "recovery-key", d.keyFile, "Private key of recovery agent")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password of private key of recovery agent, if it's encrypted")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write recovered private key to, must not exist yet. Use '-' to write to standard output")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "escrow",
+		Short: "Work with escrowed private keys",
+	}
+	cmd.AddCommand(newRecoverSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"slices"
+	"strings"
+)
+
+type caBundleData struct {
+	w             io.Writer
+	dir           string
+	out           string
+	intermediates bool
+}
+
+// bundleCAs gets CA certificates to include in bundle, sorted by alias. Revoked CAs are left out.
+func bundleCAs(ctx context.Context, d *caBundleData) ([]*certmgr.ListEntry, error) {
+	cm := certmgr.New(d.dir)
+	s, err := cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	var res []*certmgr.ListEntry
+	for s.Next() {
+		e := s.Entry()
+		if !e.Cert.IsCA || !d.intermediates && !certmgr.IsSelfSigned(e.Cert) {
+			continue
+		}
+		rev, err := cm.GetRevocation(ctx, e.Alias)
+		if err != nil {
+			return nil, err
+		}
+		if rev == nil {
+			res = append(res, e)
+		}
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(res, func(a, b *certmgr.ListEntry) int {
+		return strings.Compare(a.Alias, b.Alias)
+	})
+	return res, nil
+}
+
+// writeCABundle writes certificates in PEM format, each preceded by comment with its alias and subject.
+func writeCABundle(w io.Writer, cas []*certmgr.ListEntry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range cas {
+		if _, err := fmt.Fprintf(bw, "# %s: %s\n", e.Alias, e.Cert.Subject); err != nil {
+			return err
+		}
+		if err := pem.Encode(bw, &pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func exportCABundle(ctx context.Context, d *caBundleData) error {
+	cas, err := bundleCAs(ctx, d)
+	if err != nil {
+		return err
+	}
+	if len(cas) == 0 {
+		return fmt.Errorf("no CA certificates found in %s", d.dir)
+	}
+	return writeOutput(d.w, d.out, ".pkitool-*.crt", func(w io.Writer) error {
+		return writeCABundle(w, cas)
+	})
+}
+
+func newCABundleSubCommand(w io.Writer) *cobra.Command {
+	d := &caBundleData{
+		w:   w,
+		dir: ".",
+		out: common.StdinMarker,
+	}
+	cmd := &cobra.Command{
+		Use:   "ca-bundle",
+		Short: "Export all root CA certificates as single PEM bundle, to be used as trust bundle",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportCABundle(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write bundle to, like ca-bundle.crt. Use '-' to write to standard output")
+	cmd.Flags().BoolVar(&d.intermediates, "include-intermediates", d.intermediates, "Whether to include intermediate CA certificates too")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type caddyData struct {
+	w        io.Writer
+	dir      string
+	caddyDir string
+	issuer   string
+	replace  bool
+	aliases  []string
+}
+
+// caddyMeta is metadata certmagic keeps along with certificate.
+type caddyMeta struct {
+	SANs       []string        `json:"sans"`
+	IssuerData json.RawMessage `json:"issuer_data"`
+}
+
+var (
+	caddyKeyReplacer = strings.NewReplacer(" ", "_", "+", "_plus_", "*", "wildcard_", ":", "-", "..", "")
+	caddyInvalidKey  = regexp.MustCompile(`[^\w@.-]`)
+)
+
+// caddySafeKey makes name safe to use in certmagic storage, the same way as certmagic does.
+func caddySafeKey(name string) string {
+	return caddyInvalidKey.ReplaceAllString(caddyKeyReplacer.Replace(strings.ToLower(strings.TrimSpace(name))), "")
+}
+
+// exportCaddySite writes certificate of alias into certmagic storage, under its first DNS name.
+func exportCaddySite(ctx context.Context, d *caddyData, cm certmgr.Reader, alias string) (string, error) {
+	cert, err := cm.GetCert(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	if len(sans) == 0 {
+		return "", fmt.Errorf("certificate '%s' has no DNS names or IP addresses", alias)
+	}
+	crt, key, err := clientPair(ctx, cm, alias)
+	if err != nil {
+		return "", err
+	}
+	name := caddySafeKey(sans[0])
+	site := filepath.Join(d.caddyDir, "certificates", d.issuer, name)
+	if d.replace {
+		if err = os.RemoveAll(site); err != nil {
+			return "", err
+		}
+	}
+	meta, err := json.MarshalIndent(&caddyMeta{SANs: sans, IssuerData: json.RawMessage("null")}, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	if err = writeNew(filepath.Join(site, name+".crt"), crt, 0o600); err != nil {
+		return "", err
+	}
+	if err = writeNew(filepath.Join(site, name+".key"), key, 0o600); err != nil {
+		return "", err
+	}
+	if err = writeNew(filepath.Join(site, name+".json"), meta, 0o600); err != nil {
+		return "", err
+	}
+	return site, nil
+}
+
+func exportCaddy(ctx context.Context, d *caddyData) error {
+	cm := certmgr.New(d.dir)
+	for _, alias := range d.aliases {
+		site, err := exportCaddySite(ctx, d, cm, alias)
+		if err != nil {
+			return fmt.Errorf("can't export '%s': %w", alias, err)
+		}
+		if _, err = fmt.Fprintf(d.w, "Exported '%s' to %s\n", alias, site); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCaddySubCommand(w io.Writer) *cobra.Command {
+	d := &caddyData{
+		w:        w,
+		dir:      ".",
+		caddyDir: common.CaddyDataDir(),
+		issuer:   "local",
+	}
+	cmd := &cobra.Command{
+		Use:   "caddy alias...",
+		Short: "Export certificates into certificate storage of Caddy",
+		Long: "Export certificates with their keys into certificates directory of Caddy file storage, so that Caddy can serve them.\n" +
+			"Certificate is stored under its first DNS name (or IP address) and issuer key given by --issuer, Caddy picks it up\n" +
+			"for sites with that name managed by the same issuer instead of obtaining new one, until it is due for renewal.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return common.ErrAliasMissing
+			}
+			if len(d.issuer) == 0 || caddySafeKey(d.issuer) != d.issuer {
+				return errors.New("issuer key must be non-empty and contain only letters, digits, '.', '-', '_' and '@'")
+			}
+			d.aliases = args
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportCaddy(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.caddyDir, "caddy-dir", d.caddyDir, "Data directory of Caddy, containing certificates directory")
+	cmd.Flags().StringVar(&d.issuer, "issuer", d.issuer, "Key of issuer to store certificates under, "+
+		"like 'local' for Caddy internal CA or 'acme-v02.api.letsencrypt.org-directory'")
+	cmd.Flags().BoolVar(&d.replace, "replace", d.replace, "Whether to replace certificates already stored for the same names")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+const (
+	kindIssuer        = "Issuer"
+	kindClusterIssuer = "ClusterIssuer"
+	csiDriver         = "csi.cert-manager.io"
+)
+
+type certManagerData struct {
+	w                        io.Writer
+	dir                      string
+	alias                    string
+	name                     string
+	namespace                string
+	clusterIssuer            bool
+	clusterResourceNamespace string
+	secretName               string
+}
+
+type csiDriverData struct {
+	w             io.Writer
+	name          string
+	clusterIssuer bool
+	volume        string
+	dnsNames      string
+	commonName    string
+}
+
+type objectMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type secret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+type issuer struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   objectMeta `yaml:"metadata"`
+	Spec       struct {
+		CA struct {
+			SecretName string `yaml:"secretName"`
+		} `yaml:"ca"`
+	} `yaml:"spec"`
+}
+
+type csiVolume struct {
+	Name string `yaml:"name"`
+	CSI  struct {
+		Driver           string            `yaml:"driver"`
+		ReadOnly         bool              `yaml:"readOnly"`
+		VolumeAttributes map[string]string `yaml:"volumeAttributes"`
+	} `yaml:"csi"`
+}
+
+func issuerKind(cluster bool) string {
+	if cluster {
+		return kindClusterIssuer
+	}
+	return kindIssuer
+}
+
+// encodeAll writes values as YAML documents.
+func encodeAll(w io.Writer, values ...interface{}) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// caSecretData gets content of CA secret: CA certificate with intermediates, its key and root certificate.
+func caSecretData(ctx context.Context, cm certmgr.Reader, alias string) (map[string]string, error) {
+	ph, err := cm.Get(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if !ph.Cert.IsCA {
+		return nil, fmt.Errorf("certificate '%s' is not CA", alias)
+	}
+	if _, ok := ph.Key.(*plugin.Signer); ok {
+		return nil, errors.New("private key held by plugin can't be exported")
+	}
+	key, err := certmgr.MarshalKeyPEM(ph.Key)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := cm.GetChain(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	var tlsCrt []byte
+	for _, e := range chain[:len(chain)-1] {
+		tlsCrt = append(tlsCrt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+	}
+	root := chain[len(chain)-1].Cert
+	if len(chain) == 1 {
+		tlsCrt = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	}
+	return map[string]string{
+		"tls.crt": string(tlsCrt),
+		"tls.key": string(key),
+		"ca.crt":  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})),
+	}, nil
+}
+
+func exportCertManager(ctx context.Context, d *certManagerData) error {
+	data, err := caSecretData(ctx, certmgr.New(d.dir), d.alias)
+	if err != nil {
+		return err
+	}
+	// secret referenced by ClusterIssuer must live in cluster resource namespace of cert-manager
+	secretNs := d.namespace
+	if d.clusterIssuer {
+		secretNs = d.clusterResourceNamespace
+	}
+	s := &secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: d.secretName, Namespace: secretNs},
+		Type:       "kubernetes.io/tls",
+		StringData: data,
+	}
+	iss := &issuer{
+		APIVersion: "cert-manager.io/v1",
+		Kind:       issuerKind(d.clusterIssuer),
+		Metadata:   objectMeta{Name: d.name},
+	}
+	if !d.clusterIssuer {
+		iss.Metadata.Namespace = d.namespace
+	}
+	iss.Spec.CA.SecretName = d.secretName
+	return encodeAll(d.w, s, iss)
+}
+
+func exportCsiDriver(d *csiDriverData) error {
+	v := &csiVolume{Name: d.volume}
+	v.CSI.Driver = csiDriver
+	v.CSI.ReadOnly = true
+	v.CSI.VolumeAttributes = map[string]string{
+		csiDriver + "/issuer-name": d.name,
+		csiDriver + "/issuer-kind": issuerKind(d.clusterIssuer),
+	}
+	if len(d.dnsNames) > 0 {
+		v.CSI.VolumeAttributes[csiDriver+"/dns-names"] = d.dnsNames
+	}
+	if len(d.commonName) > 0 {
+		v.CSI.VolumeAttributes[csiDriver+"/common-name"] = d.commonName
+	}
+	return encodeAll(d.w, map[string]interface{}{"volumes": []*csiVolume{v}})
+}
+
+func validateCertManager(d *certManagerData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.name) == 0 {
+		d.name = resourceName(d.alias)
+	}
+	if len(d.secretName) == 0 {
+		d.secretName = d.name + "-ca"
+	}
+	return nil
+}
+
+func newCertManagerSubCommand(w io.Writer) *cobra.Command {
+	d := &certManagerData{
+		w:                        w,
+		dir:                      ".",
+		namespace:                "default",
+		clusterResourceNamespace: "cert-manager",
+	}
+	cmd := &cobra.Command{
+		Use:   "cert-manager",
+		Short: "Export CA as cert-manager Issuer (or ClusterIssuer) and Secret manifests",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateCertManager(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportCertManager(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of CA certificate to export")
+	cmd.Flags().StringVar(&d.name, "name", d.name, "Name of issuer, defaults to alias")
+	cmd.Flags().StringVar(&d.namespace, "namespace", d.namespace, "Namespace of Issuer and its Secret")
+	cmd.Flags().BoolVar(&d.clusterIssuer, "cluster-issuer", d.clusterIssuer, "Whether to create ClusterIssuer instead of namespaced Issuer")
+	cmd.Flags().StringVar(&d.clusterResourceNamespace, "cluster-resource-namespace", d.clusterResourceNamespace,
+		"Namespace of Secret referenced by ClusterIssuer, as configured in cert-manager")
+	cmd.Flags().StringVar(&d.secretName, "secret-name", d.secretName, "Name of Secret with CA certificate and key, defaults to <name>-ca")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func newCsiDriverSubCommand(w io.Writer) *cobra.Command {
+	d := &csiDriverData{
+		w:        w,
+		volume:   "tls",
+		dnsNames: "${POD_NAME}.${POD_NAMESPACE}.svc.cluster.local",
+	}
+	cmd := &cobra.Command{
+		Use:   "csi-driver",
+		Short: "Export pod volume snippet that mounts certificate issued by cert-manager csi-driver",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(d.name) == 0 {
+				return errors.New("name of issuer is required")
+			}
+			d.name = resourceName(d.name)
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportCsiDriver(d)
+		},
+	}
+	cmd.Flags().StringVar(&d.name, "name", d.name, "Name of issuer, as given to (or defaulted by) 'export cert-manager'")
+	cmd.Flags().BoolVar(&d.clusterIssuer, "cluster-issuer", d.clusterIssuer, "Whether issuer is ClusterIssuer")
+	cmd.Flags().StringVar(&d.volume, "volume", d.volume, "Name of volume")
+	cmd.Flags().StringVar(&d.dnsNames, "dns-names", d.dnsNames, "Comma-separated DNS names of certificate, may refer to pod variables")
+	cmd.Flags().StringVar(&d.commonName, "common-name", d.commonName, "Common name of certificate")
+	return cmd
+}
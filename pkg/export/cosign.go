@@ -0,0 +1,213 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	typeSigstorePrivateKey = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	// scrypt parameters used by cosign when generating key pair
+	cosignScryptN = 32768
+	cosignScryptR = 8
+	cosignScryptP = 1
+)
+
+type cosignData struct {
+	w            io.Writer
+	dir          string
+	alias        string
+	out          string
+	passwordFile string
+}
+
+// cosignKey is encrypted private key, as written by "cosign generate-key-pair".
+// Key is PKCS#8 encrypted using NaCl secretbox with key derived by scrypt.
+type cosignKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// marshalCosignKey encrypts private key using password in format cosign reads with --key.
+func marshalCosignKey(key crypto.Signer, password []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var ck cosignKey
+	ck.KDF.Name = "scrypt"
+	ck.KDF.Params.N = cosignScryptN
+	ck.KDF.Params.R = cosignScryptR
+	ck.KDF.Params.P = cosignScryptP
+	ck.KDF.Salt = make([]byte, 32)
+	if _, err = rand.Read(ck.KDF.Salt); err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	ck.Cipher.Name = "nacl/secretbox"
+	ck.Cipher.Nonce = nonce[:]
+	k, err := scrypt.Key(password, ck.KDF.Salt, cosignScryptN, cosignScryptR, cosignScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	var boxKey [32]byte
+	copy(boxKey[:], k)
+	ck.Ciphertext = secretbox.Seal(nil, der, &nonce, &boxKey)
+	data, err := json.Marshal(&ck)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typeSigstorePrivateKey, Bytes: data}), nil
+}
+
+// exportCosign writes key pair of alias as cosign.key, cosign.pub, cosign.crt and cosign-chain.pem,
+// so that artifacts can be signed using "cosign sign --key cosign.key --certificate cosign.crt --certificate-chain cosign-chain.pem".
+func exportCosign(ctx context.Context, d *cosignData) error {
+	password, err := os.ReadFile(d.passwordFile)
+	if err != nil {
+		return err
+	}
+	password = []byte(strings.TrimRight(string(password), "\r\n"))
+	cm := certmgr.New(d.dir)
+	ph, err := cm.Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if ph.Key == nil {
+		return fmt.Errorf("private key of '%s' is not in store", d.alias)
+	}
+	if _, ok := ph.Key.(*plugin.Signer); ok {
+		return errors.New("private key held by plugin can't be exported")
+	}
+	chain, err := cm.GetChain(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	key, err := marshalCosignKey(ph.Key, password)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(ph.Key.Public())
+	if err != nil {
+		return err
+	}
+	// chain goes from issuer up to root, as cosign expects it
+	var cas []byte
+	for _, e := range chain[1:] {
+		cas = append(cas, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+	}
+	files := []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{"cosign.key", key, 0o600},
+		{"cosign.pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o644},
+		{"cosign.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ph.Cert.Raw}), 0o644},
+		{"cosign-chain.pem", cas, 0o644},
+	}
+	for _, f := range files {
+		if len(f.data) == 0 {
+			continue
+		}
+		if err = writeNew(filepath.Join(d.out, f.name), f.data, f.perm); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(d.w, "Exported '%s' to %s, sign using:\n\tCOSIGN_PASSWORD=... cosign sign --key %s",
+		d.alias, d.out, filepath.Join(d.out, "cosign.key"))
+	if err == nil && len(cas) > 0 {
+		_, err = fmt.Fprintf(d.w, " --certificate %s --certificate-chain %s",
+			filepath.Join(d.out, "cosign.crt"), filepath.Join(d.out, "cosign-chain.pem"))
+	}
+	if err == nil {
+		_, err = fmt.Fprintln(d.w, " <image>")
+	}
+	return err
+}
+
+func validateCosign(d *cosignData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.out) == 0 {
+		return errors.New("output directory is required")
+	}
+	if len(d.passwordFile) == 0 {
+		return errors.New("password file is required, cosign keys are always encrypted")
+	}
+	return nil
+}
+
+func newCosignSubCommand(w io.Writer) *cobra.Command {
+	d := &cosignData{
+		w:   w,
+		dir: ".",
+		out: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "cosign",
+		Short: "Export key pair in format used by sigstore cosign, so that artifacts can be signed with 'cosign sign --key'",
+		Long: "Export private key encrypted the way 'cosign generate-key-pair' does as cosign.key, public key as cosign.pub,\n" +
+			"certificate as cosign.crt and its issuing chain as cosign-chain.pem. Existing files are not overwritten.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateCosign(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportCosign(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of certificate whose key signs artifacts")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Directory to write files to")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password to encrypt private key with, "+
+		"cosign reads it from COSIGN_PASSWORD environment variable")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dbLayout is set of file names database client reads its certificates from.
+type dbLayout struct {
+	// name of database, as used in messages
+	name string
+	cert string
+	key  string
+	ca   string
+	// hint tells how to point client to files, it's formatted with cert, key and CA paths
+	hint string
+}
+
+var (
+	postgresqlLayout = &dbLayout{
+		name: "PostgreSQL",
+		cert: "postgresql.crt",
+		key:  "postgresql.key",
+		ca:   "root.crt",
+		hint: "Connect using 'sslmode=verify-full sslcert=%s sslkey=%s sslrootcert=%s'\n",
+	}
+	mysqlLayout = &dbLayout{
+		name: "MySQL",
+		cert: "client-cert.pem",
+		key:  "client-key.pem",
+		ca:   "ca.pem",
+		hint: "Add to [client] section of my.cnf:\n\tssl-mode=VERIFY_IDENTITY\n\tssl-cert=%s\n\tssl-key=%s\n\tssl-ca=%s\n",
+	}
+)
+
+type dbClientData struct {
+	w        io.Writer
+	errw     io.Writer
+	dir      string
+	alias    string
+	serverCA string
+	out      string
+	layout   *dbLayout
+}
+
+// exportDBClient writes client certificate, its key and CA that verifies database server using file names client expects.
+// Key is only readable by owner, libpq refuses to use it otherwise.
+func exportDBClient(ctx context.Context, d *dbClientData) error {
+	cm := certmgr.New(d.dir)
+	crt, key, err := clientPair(ctx, cm, d.alias)
+	if err != nil {
+		return err
+	}
+	cert, err := cm.GetCert(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if len(cert.Subject.CommonName) == 0 {
+		_, _ = fmt.Fprintf(d.errw, "Warning: certificate '%s' has no common name, %s maps it to user by common name\n",
+			d.alias, d.layout.name)
+	}
+	// server is most likely issued under the same root as client, unless told otherwise
+	caAlias := d.alias
+	if len(d.serverCA) > 0 {
+		caAlias = d.serverCA
+	}
+	chain, err := cm.GetChain(ctx, caAlias)
+	if err != nil {
+		return err
+	}
+	root := chain[len(chain)-1].Cert
+	if !root.IsCA {
+		return fmt.Errorf("certificate '%s' is not CA", caAlias)
+	}
+	out := d.out
+	if rest, ok := strings.CutPrefix(out, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		out = filepath.Join(home, rest)
+	}
+	if out, err = filepath.Abs(out); err != nil {
+		return err
+	}
+	names := []string{filepath.Join(out, d.layout.cert), filepath.Join(out, d.layout.key), filepath.Join(out, d.layout.ca)}
+	if err = writeNew(names[0], crt, 0o644); err != nil {
+		return err
+	}
+	if err = writeNew(names[1], key, 0o600); err != nil {
+		return err
+	}
+	if err = writeNew(names[2], pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0o644); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Exported '%s' (user '%s') to %s\n"+d.layout.hint,
+		d.alias, cert.Subject.CommonName, out, names[0], names[1], names[2])
+	return err
+}
+
+func newDBClientSubCommand(w io.Writer, use string, layout *dbLayout, out string) *cobra.Command {
+	d := &dbClientData{
+		w:      w,
+		dir:    ".",
+		out:    out,
+		layout: layout,
+	}
+	cmd := &cobra.Command{
+		Use: use,
+		Short: fmt.Sprintf("Export %s client certificate as %s, %s and %s",
+			layout.name, layout.cert, layout.key, layout.ca),
+		Long: fmt.Sprintf("Export %s client certificate, its key and root CA that verifies server.\n"+
+			"Common name of certificate is database user it authenticates as. Existing files are not overwritten.", layout.name),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(d.alias) == 0 {
+				return common.ErrAliasMissing
+			}
+			if len(d.out) == 0 {
+				return errors.New("output directory is required")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return exportDBClient(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of client certificate")
+	cmd.Flags().StringVar(&d.serverCA, "server-ca", d.serverCA, "Alias of certificate whose root CA verifies server, defaults to client certificate")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Directory to write files to")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func newPostgreSQLSubCommand(w io.Writer) *cobra.Command {
+	// libpq looks there when connection string doesn't tell otherwise
+	return newDBClientSubCommand(w, "postgresql", postgresqlLayout, "~/.postgresql")
+}
+
+func newMySQLSubCommand(w io.Writer) *cobra.Command {
+	return newDBClientSubCommand(w, "mysql", mysqlLayout, ".")
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+type dockerData struct {
+	w        io.Writer
+	dir      string
+	registry string
+	ca       string
+	client   string
+	out      string
+}
+
+// exportDocker writes CA trusted for registry and optional client certificate into certs.d/<host:port>,
+// which is where Docker daemon (and containerd or podman, in its docker-compatible mode) looks for them.
+func exportDocker(ctx context.Context, d *dockerData) error {
+	cm := certmgr.New(d.dir)
+	cas, err := cm.GetChain(ctx, d.ca)
+	if err != nil {
+		return err
+	}
+	if !cas[0].Cert.IsCA {
+		return fmt.Errorf("certificate '%s' is not CA", d.ca)
+	}
+	var ca, crt, key []byte
+	for _, e := range cas {
+		ca = append(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+	}
+	if len(d.client) > 0 {
+		if crt, key, err = clientPair(ctx, cm, d.client); err != nil {
+			return err
+		}
+	}
+	out := filepath.Join(d.out, d.registry)
+	if err = writeNew(filepath.Join(out, "ca.crt"), ca, 0o644); err != nil {
+		return err
+	}
+	if len(d.client) > 0 {
+		if err = writeNew(filepath.Join(out, "client.cert"), crt, 0o644); err != nil {
+			return err
+		}
+		if err = writeNew(filepath.Join(out, "client.key"), key, 0o600); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(d.w, "Exported certificates for registry %s to %s\n", d.registry, out)
+	return err
+}
+
+// clientPair gets PEM encoded client certificate with intermediates and its private key.
+func clientPair(ctx context.Context, cm certmgr.Reader, alias string) ([]byte, []byte, error) {
+	ph, err := cm.Get(ctx, alias)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ph.Cert.IsCA {
+		return nil, nil, fmt.Errorf("certificate '%s' is CA, not client certificate", alias)
+	}
+	if ph.Key == nil {
+		return nil, nil, fmt.Errorf("private key of '%s' is not in store", alias)
+	}
+	if _, ok := ph.Key.(*plugin.Signer); ok {
+		return nil, nil, errors.New("private key held by plugin can't be exported")
+	}
+	key, err := certmgr.MarshalKeyPEM(ph.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := cm.GetChain(ctx, alias)
+	if err != nil {
+		return nil, nil, err
+	}
+	var crt []byte
+	for _, e := range chain {
+		if !certmgr.IsSelfSigned(e.Cert) {
+			crt = append(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+		}
+	}
+	return crt, key, nil
+}
+
+func validateDocker(d *dockerData) error {
+	if len(d.registry) == 0 {
+		return errors.New("registry is required")
+	}
+	// directory name must match registry reference exactly, including port when it's not 443
+	host, port, err := net.SplitHostPort(d.registry)
+	if err != nil {
+		host = d.registry
+	} else if len(port) == 0 {
+		return fmt.Errorf("invalid registry: %s", d.registry)
+	}
+	if len(host) == 0 || strings.ContainsAny(d.registry, "/\\") {
+		return fmt.Errorf("invalid registry: %s", d.registry)
+	}
+	if len(d.ca) == 0 {
+		return errors.New("alias of CA is required")
+	}
+	return nil
+}
+
+func newDockerSubCommand(w io.Writer) *cobra.Command {
+	d := &dockerData{
+		w:   w,
+		dir: ".",
+		out: "/etc/docker/certs.d",
+	}
+	cmd := &cobra.Command{
+		Use:   "docker",
+		Short: "Export certificates of private registry in certs.d/<host:port> layout used by Docker daemon",
+		Long: "Export CA that issued registry certificate as ca.crt and optional client certificate as client.cert and client.key\n" +
+			"into <out>/<registry> directory, where Docker daemon looks for them. Existing files are not overwritten.",
+		Example: "pkitool export docker --registry registry.acme.tld:5000 --ca imCA --client docker-client",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateDocker(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportDocker(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.registry, "registry", d.registry, "Registry as host[:port], exactly as used in image references")
+	cmd.Flags().StringVar(&d.ca, "ca", d.ca, "Alias of CA that issued registry certificate, it's exported with its parents")
+	cmd.Flags().StringVar(&d.client, "client", d.client, "Alias of client certificate, when registry requires client authentication")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Base directory to create registry directory in")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package export renders stored certificates in formats consumed by other tools.
+package export
+
+import (
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// resourceName converts alias into name usable for Kubernetes resources.
+func resourceName(alias string) string {
+	return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(alias), "-"), "-")
+}
+
+// writeOutput calls fn to write either to stdout, when out is "-", or to file out.
+// File is written to temporary file matching pattern first, so that existing one isn't left incomplete on error.
+func writeOutput(stdout io.Writer, out, pattern string, fn func(io.Writer) error) error {
+	if out == common.StdinMarker {
+		return fn(stdout)
+	}
+	f, err := os.CreateTemp(filepath.Dir(out), pattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if err = fn(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), out)
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export certificates for use by other tools",
+		// exported files are written as-is, there is no result to render
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return common.RequireTable(cmd)
+		},
+	}
+	cmd.AddCommand(newCABundleSubCommand(out))
+	cmd.AddCommand(newCaddySubCommand(out))
+	cmd.AddCommand(newCertManagerSubCommand(out))
+	cmd.AddCommand(newCosignSubCommand(out))
+	cmd.AddCommand(newCsiDriverSubCommand(out))
+	cmd.AddCommand(newDockerSubCommand(out))
+	cmd.AddCommand(newFreeRadiusSubCommand(out))
+	cmd.AddCommand(newIcsSubCommand(out))
+	cmd.AddCommand(newK8sCABundleSubCommand(out))
+	cmd.AddCommand(newMetricsSubCommand(out))
+	cmd.AddCommand(newMySQLSubCommand(out))
+	cmd.AddCommand(newPostgreSQLSubCommand(out))
+	cmd.AddCommand(newSpiffeBundleSubCommand(out))
+	cmd.AddCommand(newStepCASubCommand(out))
+	cmd.AddCommand(newVaultPKISubCommand(out))
+	return cmd
+}
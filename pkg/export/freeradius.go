@@ -0,0 +1,159 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type freeRadiusData struct {
+	w            io.Writer
+	errw         io.Writer
+	dir          string
+	alias        string
+	clientCA     string
+	out          string
+	passwordFile string
+}
+
+// exportFreeRadius writes certificate of EAP-TLS server, its key and CAs trusted to issue client certificates
+// as ca.pem, server.pem and server.key, which is what FreeRADIUS eap module is configured with.
+func exportFreeRadius(ctx context.Context, d *freeRadiusData) error {
+	cm := certmgr.New(d.dir)
+	ph, err := cm.Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if ph.Cert.IsCA {
+		return fmt.Errorf("certificate '%s' is CA, not server certificate", d.alias)
+	}
+	if ph.Key == nil {
+		return fmt.Errorf("private key of '%s' is not in store", d.alias)
+	}
+	if _, ok := ph.Key.(*plugin.Signer); ok {
+		return errors.New("private key held by plugin can't be exported")
+	}
+	if len(ph.Cert.ExtKeyUsage) > 0 && !slices.Contains(ph.Cert.ExtKeyUsage, x509.ExtKeyUsageServerAuth) {
+		// Windows supplicants refuse server certificate without serverAuth
+		_, _ = fmt.Fprintf(d.errw, "Warning: certificate '%s' is not meant for server authentication, "+
+			"supplicants will likely reject it\n", d.alias)
+	}
+	chain, err := cm.GetChain(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if len(chain) < 2 {
+		return fmt.Errorf("%w: issuer of '%s' is not in store", certmgr.ErrChainBroken, d.alias)
+	}
+	// client certificates are issued by the same CA as server certificate unless told otherwise
+	cas := chain[1:]
+	if len(d.clientCA) > 0 {
+		if cas, err = cm.GetChain(ctx, d.clientCA); err != nil {
+			return err
+		}
+		if !cas[0].Cert.IsCA {
+			return fmt.Errorf("certificate '%s' is not CA", d.clientCA)
+		}
+	}
+	var key []byte
+	if len(d.passwordFile) > 0 {
+		password, err := os.ReadFile(d.passwordFile)
+		if err != nil {
+			return err
+		}
+		key, err = certmgr.MarshalEncryptedKeyPEM(ph.Key, []byte(strings.TrimRight(string(password), "\r\n")))
+		if err != nil {
+			return err
+		}
+	} else if key, err = certmgr.MarshalKeyPEM(ph.Key); err != nil {
+		return err
+	}
+	// server.pem holds intermediates too, so that supplicants trusting only root can build path
+	var server, ca []byte
+	for _, e := range chain {
+		if !certmgr.IsSelfSigned(e.Cert) {
+			server = append(server, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+		}
+	}
+	for _, e := range cas {
+		ca = append(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+	}
+	if err = writeNew(filepath.Join(d.out, "ca.pem"), ca, 0o644); err != nil {
+		return err
+	}
+	if err = writeNew(filepath.Join(d.out, "server.pem"), server, 0o644); err != nil {
+		return err
+	}
+	if err = writeNew(filepath.Join(d.out, "server.key"), key, 0o600); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Exported '%s' to %s, configure tls-config of eap module with:\n"+
+		"\tprivate_key_file = ${certdir}/server.key\n"+
+		"\tcertificate_file = ${certdir}/server.pem\n"+
+		"\tca_file = ${cadir}/ca.pem\n", d.alias, d.out)
+	if err == nil && len(d.passwordFile) > 0 {
+		_, err = fmt.Fprintln(d.w, "\tprivate_key_password = <content of password file>")
+	}
+	return err
+}
+
+func validateFreeRadius(d *freeRadiusData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.out) == 0 {
+		return errors.New("output directory is required")
+	}
+	return nil
+}
+
+func newFreeRadiusSubCommand(w io.Writer) *cobra.Command {
+	d := &freeRadiusData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "freeradius",
+		Short: "Export EAP-TLS server certificate as ca.pem, server.pem and server.key used by FreeRADIUS",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateFreeRadius(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return exportFreeRadius(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of RADIUS server certificate")
+	cmd.Flags().StringVar(&d.clientCA, "client-ca", d.clientCA, "Alias of CA that issues client certificates, defaults to issuer of server certificate")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Directory to write files to, like /etc/raddb/certs. Existing files are not overwritten")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password to encrypt private key with")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	icsDateFormat     = "20060102"
+	icsDateTimeFormat = "20060102T150405Z"
+	// lines longer than this (in octets) must be folded, RFC 5545 section 3.1
+	icsMaxLine = 75
+)
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+type icsData struct {
+	w            io.Writer
+	dir          string
+	aliases      []string
+	out          string
+	reminderDays []int
+}
+
+// icsWriter writes content lines, taking care of line folding and CRLF line endings.
+// Write errors are remembered by underlying buffered writer and reported when it's flushed.
+type icsWriter struct {
+	bw *bufio.Writer
+}
+
+func (iw *icsWriter) line(name, value string) {
+	l := name + ":" + value
+	for len(l) > icsMaxLine {
+		// don't split multibyte characters
+		i := icsMaxLine
+		for i > 0 && l[i]&0xC0 == 0x80 {
+			i--
+		}
+		_, _ = iw.bw.WriteString(l[:i] + "\r\n ")
+		l = l[i:]
+	}
+	_, _ = iw.bw.WriteString(l + "\r\n")
+}
+
+func (iw *icsWriter) event(e *certmgr.ListEntry, now time.Time, reminderDays []int) {
+	expiry := e.Cert.NotAfter.UTC()
+	serial := ""
+	if e.Cert.SerialNumber != nil {
+		serial = e.Cert.SerialNumber.Text(16)
+	}
+	iw.line("BEGIN", "VEVENT")
+	iw.line("UID", icsEscaper.Replace(fmt.Sprintf("%s-%s@pkitool", e.Alias, serial)))
+	iw.line("DTSTAMP", now.UTC().Format(icsDateTimeFormat))
+	iw.line("DTSTART;VALUE=DATE", expiry.Format(icsDateFormat))
+	iw.line("DTEND;VALUE=DATE", expiry.AddDate(0, 0, 1).Format(icsDateFormat))
+	iw.line("SUMMARY", icsEscaper.Replace(fmt.Sprintf("Certificate '%s' expires", e.Alias)))
+	iw.line("DESCRIPTION", icsEscaper.Replace(fmt.Sprintf("Subject: %s\nIssuer: %s\nSerial: %s\nExpires: %s",
+		e.Cert.Subject, e.Cert.Issuer, serial, expiry.Format(time.RFC3339))))
+	iw.line("TRANSP", "TRANSPARENT")
+	for _, days := range reminderDays {
+		if days <= 0 {
+			continue
+		}
+		iw.line("BEGIN", "VALARM")
+		iw.line("ACTION", "DISPLAY")
+		iw.line("TRIGGER", fmt.Sprintf("-P%dD", days))
+		iw.line("DESCRIPTION", icsEscaper.Replace(fmt.Sprintf("Certificate '%s' expires in %d days", e.Alias, days)))
+		iw.line("END", "VALARM")
+	}
+	iw.line("END", "VEVENT")
+}
+
+// each calls fn for every certificate to export, either given ones or all of them.
+// Certificates are streamed, so that they are not all held in memory.
+func (d *icsData) each(ctx context.Context, cm certmgr.Reader, fn func(*certmgr.ListEntry) error) error {
+	if len(d.aliases) > 0 {
+		for _, alias := range d.aliases {
+			cert, err := cm.GetCert(ctx, alias)
+			if err != nil {
+				return err
+			}
+			if err = fn(&certmgr.ListEntry{Alias: alias, Cert: cert}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, err := cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	for s.Next() {
+		if err = fn(s.Entry()); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+func writeIcs(ctx context.Context, d *icsData, w io.Writer) error {
+	cm := certmgr.New(d.dir)
+	now := time.Now()
+	iw := &icsWriter{bw: bufio.NewWriter(w)}
+	iw.line("BEGIN", "VCALENDAR")
+	iw.line("VERSION", "2.0")
+	iw.line("PRODID", "-//rkosegi//pkitool//EN")
+	iw.line("CALSCALE", "GREGORIAN")
+	iw.line("X-WR-CALNAME", "Certificate expiry")
+	if err := d.each(ctx, cm, func(e *certmgr.ListEntry) error {
+		// there is nothing to renew when certificate was revoked
+		if rev, err := cm.GetRevocation(ctx, e.Alias); err != nil || rev != nil {
+			return err
+		}
+		iw.event(e, now, d.reminderDays)
+		return nil
+	}); err != nil {
+		return err
+	}
+	iw.line("END", "VCALENDAR")
+	return iw.bw.Flush()
+}
+
+func exportIcs(ctx context.Context, d *icsData) error {
+	return writeOutput(d.w, d.out, ".pkitool-*.ics", func(w io.Writer) error {
+		return writeIcs(ctx, d, w)
+	})
+}
+
+func newIcsSubCommand(w io.Writer) *cobra.Command {
+	d := &icsData{
+		w:            w,
+		dir:          ".",
+		out:          common.StdinMarker,
+		reminderDays: []int{30},
+	}
+	cmd := &cobra.Command{
+		Use:   "ics",
+		Short: "Export expiry dates of certificates as iCalendar file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportIcs(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.aliases, "alias", d.aliases, "Alias of certificate to export, can be repeated. All certificates are exported when not set")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write calendar to. Use '-' to write to standard output")
+	cmd.Flags().IntSliceVar(&d.reminderDays, "reminder-days", d.reminderDays, "Number of days before expiry to remind at, can be repeated. Use 0 to disable reminders")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
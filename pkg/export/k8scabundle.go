@@ -0,0 +1,176 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+type k8sCABundleData struct {
+	w             io.Writer
+	dir           string
+	alias         string
+	name          string
+	namespace     string
+	key           string
+	intermediates bool
+	trustManager  bool
+	defaultCAs    bool
+}
+
+type configMap struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type configMapKey struct {
+	Name string `yaml:"name,omitempty"`
+	Key  string `yaml:"key"`
+}
+
+// bundle is trust-manager Bundle, which copies sources into ConfigMap in every namespace.
+type bundle struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   objectMeta `yaml:"metadata"`
+	Spec       struct {
+		Sources []bundleSource `yaml:"sources"`
+		Target  struct {
+			ConfigMap configMapKey `yaml:"configMap"`
+		} `yaml:"target"`
+	} `yaml:"spec"`
+}
+
+type bundleSource struct {
+	ConfigMap     *configMapKey `yaml:"configMap,omitempty"`
+	UseDefaultCAs *bool         `yaml:"useDefaultCAs,omitempty"`
+}
+
+// trustedCAs gets chain of CA alias up to its root, or all root CAs in store when alias is empty.
+func trustedCAs(ctx context.Context, d *k8sCABundleData) ([]*certmgr.ListEntry, error) {
+	if len(d.alias) == 0 {
+		cas, err := bundleCAs(ctx, &caBundleData{dir: d.dir, intermediates: d.intermediates})
+		if err != nil {
+			return nil, err
+		}
+		if len(cas) == 0 {
+			return nil, fmt.Errorf("no CA certificates found in %s", d.dir)
+		}
+		return cas, nil
+	}
+	chain, err := certmgr.New(d.dir).GetChain(ctx, d.alias)
+	if err != nil {
+		return nil, err
+	}
+	if !chain[0].Cert.IsCA {
+		return nil, fmt.Errorf("certificate '%s' is not CA", d.alias)
+	}
+	res := make([]*certmgr.ListEntry, 0, len(chain))
+	for _, e := range chain {
+		res = append(res, &certmgr.ListEntry{Alias: e.Alias, Cert: e.Cert})
+	}
+	return res, nil
+}
+
+func exportK8sCABundle(ctx context.Context, d *k8sCABundleData) error {
+	cas, err := trustedCAs(ctx, d)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = writeCABundle(&buf, cas); err != nil {
+		return err
+	}
+	cfg := &configMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   objectMeta{Name: d.name, Namespace: d.namespace},
+		Data:       map[string]string{d.key: buf.String()},
+	}
+	if !d.trustManager {
+		return encodeAll(d.w, cfg)
+	}
+	// Bundle is cluster-scoped, its ConfigMap sources are read from trust namespace of trust-manager
+	b := &bundle{
+		APIVersion: "trust.cert-manager.io/v1alpha1",
+		Kind:       "Bundle",
+		Metadata:   objectMeta{Name: d.name},
+	}
+	b.Spec.Sources = []bundleSource{{ConfigMap: &configMapKey{Name: d.name, Key: d.key}}}
+	if d.defaultCAs {
+		b.Spec.Sources = append(b.Spec.Sources, bundleSource{UseDefaultCAs: &d.defaultCAs})
+	}
+	b.Spec.Target.ConfigMap = configMapKey{Key: d.key}
+	return encodeAll(d.w, cfg, b)
+}
+
+func validateK8sCABundle(d *k8sCABundleData) error {
+	if len(d.name) == 0 {
+		return errors.New("name of ConfigMap is required")
+	}
+	if len(d.key) == 0 {
+		return errors.New("key of ConfigMap is required")
+	}
+	if d.defaultCAs && !d.trustManager {
+		return errors.New("default CAs can only be included by trust-manager Bundle")
+	}
+	d.name = resourceName(d.name)
+	return nil
+}
+
+func newK8sCABundleSubCommand(w io.Writer) *cobra.Command {
+	d := &k8sCABundleData{
+		w:         w,
+		dir:       ".",
+		namespace: "cert-manager",
+		key:       "ca.crt",
+	}
+	cmd := &cobra.Command{
+		Use:   "k8s-ca-bundle",
+		Short: "Export CA certificates as Kubernetes ConfigMap, optionally distributed by trust-manager Bundle",
+		Long: "Export CA certificates as Kubernetes ConfigMap, so that workloads can trust them.\n" +
+			"With --alias, chain of that CA up to its root is exported, otherwise all root CAs in directory.\n" +
+			"With --trust-manager, cluster-scoped Bundle is added that copies ConfigMap into every namespace.\n" +
+			"ConfigMap must live in trust namespace of trust-manager then, which is cert-manager by default.",
+		Example: "  pkitool export k8s-ca-bundle --name org-ca --namespace kube-system | kubectl apply -f -",
+		Args:    cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateK8sCABundle(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportK8sCABundle(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of CA whose chain to export, all root CAs are exported when not set")
+	cmd.Flags().StringVar(&d.name, "name", d.name, "Name of ConfigMap and Bundle")
+	cmd.Flags().StringVar(&d.namespace, "namespace", d.namespace, "Namespace of ConfigMap")
+	cmd.Flags().StringVar(&d.key, "key", d.key, "Key of ConfigMap that holds PEM bundle")
+	cmd.Flags().BoolVar(&d.intermediates, "include-intermediates", d.intermediates, "Whether to include intermediate CA certificates too, when --alias is not set")
+	cmd.Flags().BoolVar(&d.trustManager, "trust-manager", d.trustManager, "Whether to add trust-manager Bundle resource")
+	cmd.Flags().BoolVar(&d.defaultCAs, "include-default-cas", d.defaultCAs, "Whether trust-manager Bundle should include its default public CAs")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/metrics"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+type metricsData struct {
+	w        io.Writer
+	dir      string
+	textfile string
+}
+
+func exportMetrics(ctx context.Context, d *metricsData) error {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(metrics.NewCollector(ctx, certmgr.New(d.dir))); err != nil {
+		return err
+	}
+	if d.textfile != common.StdinMarker {
+		// file is replaced atomically, so node_exporter never reads partial content
+		return prometheus.WriteToTextfile(d.textfile, reg)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range mfs {
+		if _, err = expfmt.MetricFamilyToText(d.w, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newMetricsSubCommand(w io.Writer) *cobra.Command {
+	d := &metricsData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "metrics",
+		Short: "Write Prometheus metrics about certificates to file for textfile collector of node_exporter",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(d.textfile) == 0 {
+				return errors.New("path of text file is required")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportMetrics(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.textfile, "textfile", d.textfile, "File to write metrics to, like /var/lib/node_exporter/pki.prom. Use '-' to write to standard output")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/spiffe"
+	"github.com/spf13/cobra"
+	"io"
+	"time"
+)
+
+type spiffeBundleData struct {
+	caBundleData
+	sequence    uint64
+	refreshHint time.Duration
+}
+
+func exportSpiffeBundle(ctx context.Context, d *spiffeBundleData) error {
+	entries, err := bundleCAs(ctx, &d.caBundleData)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no CA certificates found in %s", d.dir)
+	}
+	var cas []*x509.Certificate
+	for _, e := range entries {
+		cas = append(cas, e.Cert)
+	}
+	seq := d.sequence
+	if seq == 0 {
+		// consumers only need sequence to increase with each published bundle
+		seq = uint64(time.Now().Unix())
+	}
+	b, err := spiffe.NewBundle(cas, seq, d.refreshHint)
+	if err != nil {
+		return err
+	}
+	data, err := b.Marshal()
+	if err != nil {
+		return err
+	}
+	return writeOutput(d.w, d.out, ".pkitool-*.json", func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func newSpiffeBundleSubCommand(w io.Writer) *cobra.Command {
+	d := &spiffeBundleData{
+		caBundleData: caBundleData{
+			w:   w,
+			dir: ".",
+			out: common.StdinMarker,
+		},
+		refreshHint: 5 * time.Minute,
+	}
+	cmd := &cobra.Command{
+		Use:   "spiffe-bundle",
+		Short: "Export root CA certificates as SPIFFE bundle, so SPIFFE-aware workloads can federate with trust domain",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if d.refreshHint < 0 {
+				return errors.New("refresh hint can't be negative")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportSpiffeBundle(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.out, "out", d.out, "File to write bundle to, like bundle.json. Use '-' to write to standard output")
+	cmd.Flags().BoolVar(&d.intermediates, "include-intermediates", d.intermediates, "Whether to include intermediate CA certificates too")
+	cmd.Flags().Uint64Var(&d.sequence, "sequence", d.sequence, "Sequence number of bundle, current Unix time is used when not set")
+	cmd.Flags().DurationVar(&d.refreshHint, "refresh-hint", d.refreshHint, "How often should consumers check for updated bundle, 0 to leave out")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
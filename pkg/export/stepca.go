@@ -0,0 +1,237 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type stepCAData struct {
+	w              io.Writer
+	dir            string
+	alias          string
+	out            string
+	passwordFile   string
+	includeRootKey bool
+	address        string
+	dnsNames       []string
+}
+
+// stepCAConfig is minimal step-ca configuration (ca.json), as written by "step ca init".
+type stepCAConfig struct {
+	Root     string   `json:"root"`
+	Crt      string   `json:"crt"`
+	Key      string   `json:"key"`
+	Address  string   `json:"address"`
+	DNSNames []string `json:"dnsNames"`
+	Logger   struct {
+		Format string `json:"format"`
+	} `json:"logger"`
+	DB struct {
+		Type       string `json:"type"`
+		DataSource string `json:"dataSource"`
+	} `json:"db"`
+	Authority struct {
+		Provisioners []interface{} `json:"provisioners"`
+	} `json:"authority"`
+}
+
+// stepDefaults is configuration of step CLI (defaults.json), so that it talks to exported CA.
+type stepDefaults struct {
+	CaURL       string `json:"ca-url"`
+	CaConfig    string `json:"ca-config"`
+	Fingerprint string `json:"fingerprint"`
+	Root        string `json:"root"`
+}
+
+// writeNew writes data into new file, refusing to overwrite existing one.
+func writeNew(name string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeNew(name, append(data, '\n'), 0o600)
+}
+
+// exportStepCA writes step path with issuing CA of alias and its root, in the layout created by "step ca init".
+func exportStepCA(ctx context.Context, d *stepCAData) error {
+	password, err := os.ReadFile(d.passwordFile)
+	if err != nil {
+		return err
+	}
+	password = []byte(strings.TrimRight(string(password), "\r\n"))
+	out, err := filepath.Abs(d.out)
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	ph, err := cm.Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	if !ph.Cert.IsCA {
+		return fmt.Errorf("certificate '%s' is not CA", d.alias)
+	}
+	if ph.Key == nil {
+		return fmt.Errorf("private key of '%s' is not in store", d.alias)
+	}
+	if _, ok := ph.Key.(*plugin.Signer); ok {
+		return errors.New("private key held by plugin can't be exported")
+	}
+	chain, err := cm.GetChain(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	root := chain[len(chain)-1]
+	// step-ca may issue directly from root, when alias is root itself
+	issuing := chain[:max(len(chain)-1, 1)]
+	var crt []byte
+	for _, e := range issuing {
+		crt = append(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+	}
+	key, err := certmgr.MarshalEncryptedKeyPEM(ph.Key, password)
+	if err != nil {
+		return err
+	}
+	cfg := &stepCAConfig{
+		Root:     filepath.Join(out, "certs", "root_ca.crt"),
+		Crt:      filepath.Join(out, "certs", "intermediate_ca.crt"),
+		Key:      filepath.Join(out, "secrets", "intermediate_ca_key"),
+		Address:  d.address,
+		DNSNames: d.dnsNames,
+	}
+	cfg.Logger.Format = "text"
+	cfg.DB.Type = "badgerv2"
+	cfg.DB.DataSource = filepath.Join(out, "db")
+	cfg.Authority.Provisioners = []interface{}{}
+	fp := sha256.Sum256(root.Cert.Raw)
+	defaults := &stepDefaults{
+		CaURL:       "https://" + d.dnsNames[0] + d.address[strings.LastIndex(d.address, ":"):],
+		CaConfig:    filepath.Join(out, "config", "ca.json"),
+		Fingerprint: hex.EncodeToString(fp[:]),
+		Root:        cfg.Root,
+	}
+	if err = writeNew(cfg.Root, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Cert.Raw}), 0o644); err != nil {
+		return err
+	}
+	if err = writeNew(cfg.Crt, crt, 0o644); err != nil {
+		return err
+	}
+	if err = writeNew(cfg.Key, key, 0o600); err != nil {
+		return err
+	}
+	if d.includeRootKey && len(chain) > 1 {
+		rph, err := cm.Get(ctx, root.Alias)
+		if err != nil {
+			return err
+		}
+		if rph.Key == nil {
+			return fmt.Errorf("private key of root CA '%s' is not in store", root.Alias)
+		}
+		if key, err = certmgr.MarshalEncryptedKeyPEM(rph.Key, password); err != nil {
+			return err
+		}
+		if err = writeNew(filepath.Join(out, "secrets", "root_ca_key"), key, 0o600); err != nil {
+			return err
+		}
+	}
+	if err = writeJSON(defaults.CaConfig, cfg); err != nil {
+		return err
+	}
+	if err = writeJSON(filepath.Join(out, "config", "defaults.json"), defaults); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Exported '%s' (root '%s') to %s, root fingerprint %s\n"+
+		"Add provisioner using 'STEPPATH=%s step ca provisioner add' and start CA using 'step-ca %s --password-file %s'\n",
+		d.alias, root.Alias, out, defaults.Fingerprint, out, defaults.CaConfig, d.passwordFile)
+	return err
+}
+
+func validateStepCA(d *stepCAData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.out) == 0 {
+		return errors.New("output directory is required")
+	}
+	if len(d.passwordFile) == 0 {
+		return errors.New("password file is required, step-ca keys are always encrypted")
+	}
+	if len(d.dnsNames) == 0 {
+		return errors.New("at least one DNS name is required")
+	}
+	if !strings.Contains(d.address, ":") {
+		return fmt.Errorf("invalid address: %s", d.address)
+	}
+	return nil
+}
+
+func newStepCASubCommand(w io.Writer) *cobra.Command {
+	d := &stepCAData{
+		w:        w,
+		dir:      ".",
+		address:  ":9000",
+		dnsNames: []string{"localhost"},
+	}
+	cmd := &cobra.Command{
+		Use:   "step-ca",
+		Short: "Export CA as configuration of smallstep step-ca, so it can keep issuing under the same hierarchy",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateStepCA(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportStepCA(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of CA that step-ca will issue certificates from")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Step path to create, must not contain step-ca configuration yet")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password to encrypt private keys with")
+	cmd.Flags().BoolVar(&d.includeRootKey, "include-root-key", d.includeRootKey, "Whether to export private key of root CA too, as 'step ca init' does")
+	cmd.Flags().StringVar(&d.address, "address", d.address, "Address step-ca will listen on")
+	cmd.Flags().StringSliceVar(&d.dnsNames, "dns-name", d.dnsNames, "DNS name of step-ca, can be repeated")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
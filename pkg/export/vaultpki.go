@@ -0,0 +1,138 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package export
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+	"github.com/rkosegi/pkitool/pkg/vault"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type vaultPKIData struct {
+	w      io.Writer
+	dir    string
+	alias  string
+	mount  string
+	enable bool
+	vault  vault.Options
+}
+
+// vaultBundle gets PEM bundle of CA certificate of alias, its private key and chain up to root, as accepted by PKI engine.
+func vaultBundle(ctx context.Context, cm certmgr.Reader, alias string) ([]byte, *certmgr.PairHolder, error) {
+	ph, err := cm.Get(ctx, alias)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ph.Cert.IsCA {
+		return nil, nil, fmt.Errorf("certificate '%s' is not CA", alias)
+	}
+	if ph.Key == nil {
+		return nil, nil, fmt.Errorf("private key of '%s' is not in store", alias)
+	}
+	if _, ok := ph.Key.(*plugin.Signer); ok {
+		return nil, nil, errors.New("private key held by plugin can't be exported")
+	}
+	key, err := certmgr.MarshalKeyPEM(ph.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := cm.GetChain(ctx, alias)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ph.Cert.Raw}), key...)
+	for _, e := range chain[1:] {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+	}
+	return bundle, ph, nil
+}
+
+func exportVaultPKI(ctx context.Context, d *vaultPKIData) error {
+	bundle, ph, err := vaultBundle(ctx, certmgr.New(d.dir), d.alias)
+	if err != nil {
+		return err
+	}
+	c, err := vault.NewClient(&d.vault)
+	if err != nil {
+		return err
+	}
+	if d.enable {
+		// certificates issued by engine can't outlive its CA anyway
+		ttl := time.Until(ph.Cert.NotAfter).Truncate(time.Second)
+		if err = c.Do(ctx, http.MethodPost, "sys/mounts/"+d.mount, map[string]interface{}{
+			"type":        "pki",
+			"description": "CA " + ph.Cert.Subject.String() + " exported by pkitool",
+			"config":      map[string]string{"max_lease_ttl": ttl.String()},
+		}, nil); err != nil {
+			return fmt.Errorf("can't enable PKI engine at %s: %w", d.mount, err)
+		}
+	}
+	var resp struct {
+		Data struct {
+			ImportedIssuers []string `json:"imported_issuers"`
+		} `json:"data"`
+	}
+	if err = c.Do(ctx, http.MethodPost, d.mount+"/config/ca", map[string]string{"pem_bundle": string(bundle)}, &resp); err != nil {
+		return fmt.Errorf("can't configure CA of PKI engine at %s: %w", d.mount, err)
+	}
+	msg := fmt.Sprintf("Configured PKI engine at %s with CA '%s' (%s)", d.mount, d.alias, ph.Cert.Subject)
+	if len(resp.Data.ImportedIssuers) > 0 {
+		msg += ", imported issuers: " + strings.Join(resp.Data.ImportedIssuers, ", ")
+	}
+	_, err = fmt.Fprintln(d.w, msg)
+	return err
+}
+
+func newVaultPKISubCommand(w io.Writer) *cobra.Command {
+	d := &vaultPKIData{
+		w:     w,
+		dir:   ".",
+		mount: "pki",
+	}
+	cmd := &cobra.Command{
+		Use:   "vault-pki",
+		Short: "Configure HashiCorp Vault PKI secrets engine to issue certificates from CA",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(d.alias) == 0 {
+				return common.ErrAliasMissing
+			}
+			if d.mount = vault.MountPath(d.mount); len(d.mount) == 0 {
+				return errors.New("mount path of PKI engine is required")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportVaultPKI(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of CA certificate to export")
+	cmd.Flags().StringVar(&d.mount, "mount", d.mount, "Mount path of PKI secrets engine")
+	cmd.Flags().BoolVar(&d.enable, "enable", d.enable, "Whether to enable PKI secrets engine at mount path first")
+	vault.AddFlags(&d.vault, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fingerprint
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+type fingerprintData struct {
+	w   io.Writer
+	in  io.Reader
+	dir string
+	ref string
+	of  common.OutputFormat
+}
+
+type fingerprintEntry struct {
+	Digest string `json:"digest" yaml:"digest"`
+	Hex    string `json:"hex" yaml:"hex"`
+	Base64 string `json:"base64" yaml:"base64"`
+}
+
+type digestFunc func([]byte) []byte
+
+var digests = []struct {
+	name string
+	fn   digestFunc
+}{
+	{"MD5", func(b []byte) []byte { s := md5.Sum(b); return s[:] }},
+	{"SHA1", func(b []byte) []byte { s := sha1.Sum(b); return s[:] }},
+	{"SHA256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }},
+}
+
+// colonHex formats digest in same way as openssl does, e.g. "AB:CD:EF".
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+	return strings.Join(parts, ":")
+}
+
+// resolve loads certificate either from file (or standard input) or from alias within directory.
+// Existing file takes precedence over alias.
+func resolve(ctx context.Context, d *fingerprintData) (*x509.Certificate, error) {
+	if fi, err := os.Stat(d.ref); d.ref == common.StdinMarker || (err == nil && !fi.IsDir()) {
+		data, err := common.ReadInput(d.ref, d.in)
+		if err != nil {
+			return nil, err
+		}
+		return certmgr.ParseCertificatePEM(data)
+	}
+	return certmgr.New(d.dir).GetCert(ctx, d.ref)
+}
+
+func fingerprint(ctx context.Context, d *fingerprintData) error {
+	cert, err := resolve(ctx, d)
+	if err != nil {
+		return err
+	}
+	res := make([]fingerprintEntry, 0, len(digests))
+	for _, dg := range digests {
+		sum := dg.fn(cert.Raw)
+		res = append(res, fingerprintEntry{
+			Digest: dg.name,
+			Hex:    colonHex(sum),
+			Base64: base64.StdEncoding.EncodeToString(sum),
+		})
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Digest", "Hex", "Base64",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		tbl.SetAutoWrapText(false)
+		for _, e := range res {
+			tbl.Append([]string{e.Digest, e.Hex, e.Base64})
+		}
+	})
+}
+
+func NewCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &fingerprintData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "fingerprint <alias|file|->",
+		Short: "Print MD5, SHA1 and SHA256 fingerprints of certificate",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.ref = args[0]
+			d.of = common.OutputFormatOf(cmd)
+			return fingerprint(cmd.Context(), d)
+		},
+	}
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
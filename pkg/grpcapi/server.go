@@ -0,0 +1,470 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grpcapi implements gRPC service defined in api/pkitool/v1/pkitool.proto.
+package grpcapi
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	pkitoolv1 "github.com/rkosegi/pkitool/pkg/api/pkitool/v1"
+	"github.com/rkosegi/pkitool/pkg/approval"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/rkosegi/pkitool/pkg/ratelimit"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeySize   = 2048
+	defaultCAKeySize = 4096
+	defaultCAYears   = 5
+	// number of events buffered for each watcher, events are dropped for watchers that can't keep up
+	watchBuffer = 64
+)
+
+// keySizes are sizes of RSA keys clients may request, as suggested by --bits flag of CLI
+var keySizes = []int{2048, 3072, 4096}
+
+// keySize gets size of key to generate, def when client requested none.
+func keySize(requested int32, def int) (int, error) {
+	if requested == 0 {
+		return def, nil
+	}
+	if !slices.Contains(keySizes, int(requested)) {
+		return 0, status.Errorf(codes.InvalidArgument, "unsupported key size %d, use one of %v", requested, keySizes)
+	}
+	return int(requested), nil
+}
+
+// Server implements pkitoolv1.PKIServiceServer on top of certificate manager.
+type Server struct {
+	pkitoolv1.UnimplementedPKIServiceServer
+	cm       certmgr.Interface
+	mu       sync.Mutex
+	watchers map[chan *pkitoolv1.Event]struct{}
+	// Approvals of sensitive operations, nil means that no operation requires approval
+	Approvals *approval.Manager
+	// Limits of clients and quotas of CAs, nil means that issuance is not limited
+	Limits *ratelimit.Limiter
+}
+
+// NewServer creates server operating on given directory (or any other store location understood by certmgr.New).
+// Only events caused by this server are delivered to watchers.
+func NewServer(dir string, opts ...certmgr.Option) *Server {
+	s := &Server{watchers: map[chan *pkitoolv1.Event]struct{}{}}
+	s.cm = certmgr.New(dir, append(opts, certmgr.WithHook(s.notify))...)
+	return s
+}
+
+// authorize checks that caller may perform operation on target, see approval.Manager.Authorize.
+func (s *Server) authorize(ctx context.Context, op approval.Operation, target, id string, params approval.Params) error {
+	if err := s.Approvals.Authorize(ctx, op, target, rbac.GRPCIdentity(ctx).Name(), id, params); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// limit checks that caller may issue another certificate by CA, see ratelimit.Limiter.Allow.
+func (s *Server) limit(ctx context.Context, ca string) error {
+	if err := s.Limits.Allow(ratelimit.GRPCClient(ctx), ca); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// notify delivers event to all active watchers.
+func (s *Server) notify(_ context.Context, ev *certmgr.Event) error {
+	pe := &pkitoolv1.Event{
+		Type:  string(ev.Type),
+		Alias: ev.Alias,
+		Time:  timestamppb.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- pe:
+		default:
+		}
+	}
+	return nil
+}
+
+// toStatus converts error to gRPC status.
+func toStatus(err error) error {
+	var pending *approval.PendingError
+	switch {
+	case errors.As(err, &pending), errors.Is(err, approval.ErrInvalidApproval):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, approval.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ratelimit.ErrRateLimited), errors.Is(err, ratelimit.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, certmgr.ErrAliasNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, certmgr.ErrAliasExists), errors.Is(err, certmgr.ErrAlreadyRevoked):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, certmgr.ErrPolicyViolation), errors.Is(err, certmgr.ErrConstraintViolation):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, certmgr.ErrParentNotCA):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, certmgr.ErrAliasMissing),
+		errors.Is(err, certmgr.ErrInvalidAlias),
+		errors.Is(err, certmgr.ErrSubjectMissing),
+		errors.Is(err, certmgr.ErrParentAliasMissing),
+		errors.Is(err, certmgr.ErrInvalidValidity),
+		errors.Is(err, profiles.ErrUnknownProfile):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toName(n *pkitoolv1.Name) pkix.Name {
+	return pkix.Name{
+		CommonName:         n.GetCommonName(),
+		Organization:       n.GetOrganization(),
+		OrganizationalUnit: n.GetOrganizationalUnit(),
+		Country:            n.GetCountry(),
+		Province:           n.GetProvince(),
+		Locality:           n.GetLocality(),
+	}
+}
+
+func toCertificate(alias string, cert *x509.Certificate, rev *certmgr.Revocation) *pkitoolv1.Certificate {
+	c := &pkitoolv1.Certificate{
+		Alias:     alias,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: timestamppb.New(cert.NotBefore),
+		NotAfter:  timestamppb.New(cert.NotAfter),
+		IsCa:      cert.IsCA,
+		Pem:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+	}
+	if cert.SerialNumber != nil {
+		c.Serial = cert.SerialNumber.String()
+	}
+	if rev != nil {
+		c.Revocation = &pkitoolv1.Revocation{
+			Time:   timestamppb.New(rev.Time),
+			Reason: int32(rev.Reason),
+		}
+	}
+	return c
+}
+
+// profileOf gets profile by name, or default profile when name is empty.
+func profileOf(name string) (*profiles.Profile, error) {
+	if len(name) == 0 {
+		name = profiles.Default
+	}
+	return profiles.Get(name)
+}
+
+// setValidity sets validity of new certificate, one year is used when not given.
+func setValidity(d *durationpb.Duration, validity *time.Duration, years *int) error {
+	if d == nil {
+		*years = 1
+		return nil
+	}
+	if err := d.CheckValid(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	*validity = d.AsDuration()
+	return nil
+}
+
+func (s *Server) Issue(ctx context.Context, req *pkitoolv1.IssueRequest) (*pkitoolv1.IssueResponse, error) {
+	p, err := profileOf(req.GetProfile())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	cd := &certmgr.CertData{
+		Alias:       req.GetAlias(),
+		ParentAlias: req.GetParent(),
+		Subject:     toName(req.GetSubject()),
+	}
+	if cd.KeySize, err = keySize(req.GetKeySize(), defaultKeySize); err != nil {
+		return nil, err
+	}
+	if err = setValidity(req.GetValidity(), &cd.Validity, &cd.ValidYears); err != nil {
+		return nil, err
+	}
+	for _, san := range req.GetSans() {
+		cd.AddSAN(san)
+	}
+	p.Apply(cd)
+	if err = s.authorize(ctx, approval.OpIssue, cd.Alias, req.GetApprovalId(), approval.CertParams(cd)); err != nil {
+		return nil, err
+	}
+	if err = s.limit(ctx, cd.ParentAlias); err != nil {
+		return nil, err
+	}
+	if err = s.cm.NewLeaf(ctx, cd); err != nil {
+		return nil, toStatus(err)
+	}
+	ph, err := s.cm.Get(ctx, cd.Alias)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	key, err := certmgr.MarshalKeyPEM(ph.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pkitoolv1.IssueResponse{
+		Certificate: toCertificate(ph.Alias, ph.Cert, nil),
+		PrivateKey:  key,
+	}, nil
+}
+
+func (s *Server) Sign(ctx context.Context, req *pkitoolv1.SignRequest) (*pkitoolv1.SignResponse, error) {
+	p, err := profileOf(req.GetProfile())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	der := req.GetCsr()
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid CSR: %v", err)
+	}
+	opts := &certmgr.SignOptions{
+		Alias:       req.GetAlias(),
+		ExtKeyUsage: p.ExtKeyUsage,
+		Profile:     p.Name,
+	}
+	if err = setValidity(req.GetValidity(), &opts.Validity, &opts.ValidYears); err != nil {
+		return nil, err
+	}
+	target := opts.Alias
+	if len(target) == 0 {
+		target = csr.Subject.String()
+	}
+	params := approval.CSRParams(req.GetParent(), csr)
+	params["alias"] = opts.Alias
+	params["profile"] = opts.Profile
+	params["validity"] = opts.Validity.String()
+	params["validYears"] = strconv.Itoa(opts.ValidYears)
+	if err = s.authorize(ctx, approval.OpIssue, target, req.GetApprovalId(), params); err != nil {
+		return nil, err
+	}
+	if err = s.limit(ctx, req.GetParent()); err != nil {
+		return nil, err
+	}
+	cert, err := s.cm.SignCSR(ctx, req.GetParent(), csr, opts)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pkitoolv1.SignResponse{Certificate: toCertificate(opts.Alias, cert, nil)}, nil
+}
+
+func (s *Server) Revoke(ctx context.Context, req *pkitoolv1.RevokeRequest) (*pkitoolv1.RevokeResponse, error) {
+	cert, err := s.cm.GetCert(ctx, req.GetAlias())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	op := approval.OpRevoke
+	if cert.IsCA {
+		op = approval.OpRevokeCA
+	}
+	if err = s.authorize(ctx, op, req.GetAlias(), req.GetApprovalId(), approval.Params{
+		"serial": cert.SerialNumber.String(),
+		"reason": strconv.Itoa(int(req.GetReason())),
+	}); err != nil {
+		return nil, err
+	}
+	if err = s.cm.Revoke(ctx, req.GetAlias(), certmgr.RevocationReason(req.GetReason())); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pkitoolv1.RevokeResponse{}, nil
+}
+
+func (s *Server) IssueCA(ctx context.Context, req *pkitoolv1.IssueCARequest) (*pkitoolv1.IssueCAResponse, error) {
+	// IsCA is set before approval, so that approvers see that CA is being issued
+	cd := &certmgr.CertData{
+		ValidYears:  int(req.GetValidYears()),
+		Alias:       req.GetAlias(),
+		ParentAlias: req.GetParent(),
+		Subject:     toName(req.GetSubject()),
+		IsCA:        true,
+	}
+	var err error
+	if cd.KeySize, err = keySize(req.GetKeySize(), defaultCAKeySize); err != nil {
+		return nil, err
+	}
+	if cd.ValidYears == 0 {
+		cd.ValidYears = defaultCAYears
+	}
+	if err = s.authorize(ctx, approval.OpIssueCA, cd.Alias, req.GetApprovalId(), approval.CertParams(cd)); err != nil {
+		return nil, err
+	}
+	if err = s.limit(ctx, cd.ParentAlias); err != nil {
+		return nil, err
+	}
+	if err = s.cm.NewIntermediateCA(ctx, cd); err != nil {
+		return nil, toStatus(err)
+	}
+	cert, err := s.cm.GetCert(ctx, cd.Alias)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pkitoolv1.IssueCAResponse{Certificate: toCertificate(cd.Alias, cert, nil)}, nil
+}
+
+func toApproval(r *approval.Request) *pkitoolv1.Approval {
+	a := &pkitoolv1.Approval{
+		Id:        r.ID,
+		Operation: string(r.Operation),
+		Target:    r.Target,
+		Params:    r.Params,
+		Digest:    r.Digest,
+		Requester: r.Requester,
+		Required:  int32(r.Required),
+		Created:   timestamppb.New(r.Created),
+		Expires:   timestamppb.New(r.Expires),
+	}
+	for _, e := range r.Approvals {
+		a.ApprovedBy = append(a.ApprovedBy, e.By)
+	}
+	return a
+}
+
+func (s *Server) ListApprovals(ctx context.Context, _ *pkitoolv1.ListApprovalsRequest) (*pkitoolv1.ListApprovalsResponse, error) {
+	pending, err := s.Approvals.Pending(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pkitoolv1.ListApprovalsResponse{}
+	for _, r := range pending {
+		resp.Approvals = append(resp.Approvals, toApproval(r))
+	}
+	return resp, nil
+}
+
+func (s *Server) Approve(ctx context.Context, req *pkitoolv1.ApproveRequest) (*pkitoolv1.ApproveResponse, error) {
+	r, err := s.Approvals.Approve(ctx, req.GetId(), rbac.GRPCIdentity(ctx).Name())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pkitoolv1.ApproveResponse{Approval: toApproval(r)}, nil
+}
+
+// get gets certificate of alias together with its revocation status.
+func (s *Server) get(ctx context.Context, alias string) (*pkitoolv1.Certificate, error) {
+	cert, err := s.cm.GetCert(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := s.cm.GetRevocation(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	return toCertificate(alias, cert, rev), nil
+}
+
+func (s *Server) Get(ctx context.Context, req *pkitoolv1.GetRequest) (*pkitoolv1.GetResponse, error) {
+	c, err := s.get(ctx, req.GetAlias())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pkitoolv1.GetResponse{Certificate: c}
+	if req.GetIncludeChain() {
+		chain, err := s.cm.GetChain(ctx, req.GetAlias())
+		if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+			return nil, toStatus(err)
+		}
+		// certificates stored as chain of alias have no alias of their own, nor revocation status
+		for _, ph := range chain[1:] {
+			var rev *certmgr.Revocation
+			if len(ph.Alias) > 0 {
+				if rev, err = s.cm.GetRevocation(ctx, ph.Alias); err != nil {
+					return nil, toStatus(err)
+				}
+			}
+			resp.Chain = append(resp.Chain, toCertificate(ph.Alias, ph.Cert, rev))
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) List(req *pkitoolv1.ListRequest, stream pkitoolv1.PKIService_ListServer) error {
+	if req.GetOffset() < 0 || req.GetLimit() < 0 {
+		return status.Error(codes.InvalidArgument, "offset and limit can't be negative")
+	}
+	ctx := stream.Context()
+	ls, err := s.cm.ListStream(ctx, &certmgr.ListOptions{
+		ParseCert: true,
+		Offset:    int(req.GetOffset()),
+		Limit:     int(req.GetLimit()),
+	})
+	if err != nil {
+		return toStatus(err)
+	}
+	defer func() {
+		_ = ls.Close()
+	}()
+	for ls.Next() {
+		e := ls.Entry()
+		rev, err := s.cm.GetRevocation(ctx, e.Alias)
+		if err != nil {
+			return toStatus(err)
+		}
+		if err = stream.Send(toCertificate(e.Alias, e.Cert, rev)); err != nil {
+			return err
+		}
+	}
+	if err = ls.Err(); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (s *Server) Watch(_ *pkitoolv1.WatchRequest, stream pkitoolv1.PKIService_WatchServer) error {
+	ch := make(chan *pkitoolv1.Event, watchBuffer)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-ch:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
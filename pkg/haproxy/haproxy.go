@@ -0,0 +1,128 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package haproxy replaces certificates in running HAProxy using its runtime API, so that no reload is needed.
+package haproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Runtime is client of HAProxy runtime API, exposed by "stats socket" in global section of its configuration.
+type Runtime struct {
+	// Address is path of UNIX socket, like /run/haproxy/admin.sock, or host:port of TCP socket
+	Address string
+	// Timeout of single command
+	Timeout time.Duration
+}
+
+func (r *Runtime) network() string {
+	if strings.Contains(r.Address, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// command sends single command, with optional payload, and returns response.
+// Socket is in non-interactive mode, so HAProxy closes connection once it responds.
+func (r *Runtime) command(ctx context.Context, cmd string, payload []byte) (string, error) {
+	dialer := &net.Dialer{Timeout: r.Timeout}
+	conn, err := dialer.DialContext(ctx, r.network(), r.Address)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if r.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(r.Timeout)); err != nil {
+			return "", err
+		}
+	}
+	var req bytes.Buffer
+	req.WriteString(cmd)
+	if payload != nil {
+		// payload is terminated by empty line, so it must not contain any, including whitespace-only ones
+		req.WriteString(" <<\n")
+		for _, line := range bytes.Split(payload, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				req.Write(bytes.TrimRight(line, "\r"))
+				req.WriteString("\n")
+			}
+		}
+	}
+	req.WriteString("\n")
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		return "", err
+	}
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(resp)), nil
+}
+
+// SetCert replaces certificate that HAProxy loaded from file name (as given in "crt" of its configuration) by bundle,
+// which is PEM with certificate, its chain and private key. Running HAProxy only keeps it in memory,
+// so file should be updated too, for the next start.
+func (r *Runtime) SetCert(ctx context.Context, name string, bundle []byte) error {
+	resp, err := r.command(ctx, "set ssl cert "+name, bundle)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp, "Transaction created") && !strings.HasPrefix(resp, "Transaction updated") {
+		return fmt.Errorf("HAProxy refused certificate %s: %s", name, resp)
+	}
+	if resp, err = r.command(ctx, "commit ssl cert "+name, nil); err == nil && !strings.Contains(resp, "Success!") {
+		err = fmt.Errorf("HAProxy failed to commit certificate %s: %s", name, resp)
+	}
+	if err != nil {
+		// don't leave transaction open, so that next attempt can start new one
+		if _, aerr := r.command(ctx, "abort ssl cert "+name, nil); aerr != nil {
+			err = errors.Join(err, aerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Hook creates hook that pushes certificate and private key files of event into HAProxy as its certificate name.
+// Events that don't leave new certificate behind are ignored.
+func Hook(r *Runtime, name string) certmgr.Hook {
+	return func(ctx context.Context, ev *certmgr.Event) error {
+		if ev.Type == certmgr.EventDelete || ev.Type == certmgr.EventRevoke || len(ev.CertFile) == 0 || len(ev.KeyFile) == 0 {
+			return nil
+		}
+		crt, err := os.ReadFile(ev.CertFile)
+		if err != nil {
+			return err
+		}
+		key, err := os.ReadFile(ev.KeyFile)
+		if err != nil {
+			return err
+		}
+		return r.SetCert(ctx, name, append(append(bytes.TrimSpace(crt), '\n'), key...))
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/cms"
+	"io"
+	"net/http"
+)
+
+const (
+	// maxAIADepth limits number of issuers downloaded for single certificate
+	maxAIADepth = 5
+	// maxAIASize limits size of downloaded issuer certificate
+	maxAIASize = 1 << 20
+)
+
+// completeChain downloads issuers missing from chain, following Authority Information Access caIssuers URLs
+// of last certificate in chain, until self-signed certificate is reached.
+// Certificates that could be downloaded are returned along with error of the first issuer that couldn't.
+func completeChain(ctx context.Context, client *http.Client, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	var fetched []*x509.Certificate
+	last := chain[len(chain)-1]
+	for i := 0; i < maxAIADepth && !certmgr.IsSelfSigned(last); i++ {
+		if len(last.IssuingCertificateURL) == 0 {
+			return fetched, fmt.Errorf("certificate '%s' has no caIssuers URL", last.Subject)
+		}
+		issuer, err := fetchIssuer(ctx, client, last)
+		if err != nil {
+			return fetched, err
+		}
+		fetched = append(fetched, issuer)
+		last = issuer
+	}
+	return fetched, nil
+}
+
+// fetchIssuer tries caIssuers URLs of certificate, until one yields its issuer.
+func fetchIssuer(ctx context.Context, client *http.Client, cert *x509.Certificate) (*x509.Certificate, error) {
+	var lastErr error
+	for _, u := range cert.IssuingCertificateURL {
+		certs, err := download(ctx, client, u)
+		if err != nil {
+			lastErr = fmt.Errorf("can't download issuer of '%s' from %s: %w", cert.Subject, u, err)
+			continue
+		}
+		for _, c := range certs {
+			if certmgr.IsIssuedBy(cert, c) {
+				return c, nil
+			}
+		}
+		lastErr = fmt.Errorf("%s doesn't contain issuer of '%s'", u, cert.Subject)
+	}
+	return nil, lastErr
+}
+
+// download gets certificates from URL, which can be DER or PEM encoded certificate, or PKCS#7 bundle.
+func download(ctx context.Context, client *http.Client, u string) ([]*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAIASize))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	} else if !bytes.HasPrefix(data, []byte{0x30}) {
+		return nil, errors.New("unsupported content")
+	}
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+	sd, err := cms.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("neither certificate nor PKCS#7 bundle: %w", err)
+	}
+	return sd.Certificates, nil
+}
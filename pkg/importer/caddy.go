@@ -0,0 +1,197 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type caddyData struct {
+	w        io.Writer
+	dir      string
+	caddyDir string
+	prefix   string
+	withKeys bool
+	replace  bool
+	of       common.OutputFormat
+}
+
+// caddySite is certificate of single site in certmagic storage,
+// kept in certificates/<issuer>/<name>/ as <name>.crt (with chain), <name>.key and <name>.json.
+type caddySite struct {
+	issuer string
+	name   string
+	path   string
+}
+
+// caddyMeta is metadata certmagic keeps along with certificate.
+type caddyMeta struct {
+	SANs []string `json:"sans"`
+}
+
+type caddyEntry struct {
+	Alias string `json:"alias" yaml:"alias"`
+	// Issuer is key of issuer that obtained certificate, like acme-v02.api.letsencrypt.org-directory or local
+	Issuer  string    `json:"issuer" yaml:"issuer"`
+	Domains []string  `json:"domains" yaml:"domains"`
+	ValidTo time.Time `json:"validTo" yaml:"validTo"`
+	// Status is outcome of import, see storeCert
+	Status string `json:"status" yaml:"status"`
+}
+
+// caddySites finds certificates in certmagic storage.
+func caddySites(caddyDir string) ([]*caddySite, error) {
+	crts, err := filepath.Glob(filepath.Join(caddyDir, "certificates", "*", "*", "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	var res []*caddySite
+	for _, crt := range crts {
+		site := filepath.Dir(crt)
+		name := filepath.Base(site)
+		if filepath.Base(crt) != name+".crt" {
+			continue
+		}
+		res = append(res, &caddySite{issuer: filepath.Base(filepath.Dir(site)), name: name, path: filepath.Join(site, name)})
+	}
+	return res, nil
+}
+
+// importSite stores certificate of site under alias named after it, so wildcard certificate for *.example.com
+// is stored as wildcard_.example.com.
+func importSite(ctx context.Context, d *caddyData, cm certmgr.Interface, site *caddySite) (*caddyEntry, error) {
+	certs, err := readCerts(site.path + ".crt")
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, &certmgr.PEMError{File: site.path + ".crt", Type: "CERTIFICATE"}
+	}
+	e := &caddyEntry{
+		Alias:   d.prefix + site.name,
+		Issuer:  site.issuer,
+		Domains: certs[0].DNSNames,
+		ValidTo: certs[0].NotAfter,
+	}
+	if data, err := os.ReadFile(site.path + ".json"); err == nil {
+		var meta caddyMeta
+		if err = json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("invalid metadata %s.json: %w", site.path, err)
+		}
+		if len(meta.SANs) > 0 {
+			e.Domains = meta.SANs
+		}
+	}
+	var key crypto.Signer
+	if d.withKeys {
+		data, err := os.ReadFile(site.path + ".key")
+		if err != nil {
+			return nil, err
+		}
+		if key, err = certmgr.ParseEncryptedKeyPEM(data, nil); err != nil {
+			var pe *certmgr.PEMError
+			if errors.As(err, &pe) {
+				pe.File = site.path + ".key"
+			}
+			return nil, err
+		}
+	}
+	if e.Status, err = storeCert(ctx, cm, e.Alias, certs[0], key, certs[1:], d.replace); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func importCaddy(ctx context.Context, d *caddyData) error {
+	sites, err := caddySites(d.caddyDir)
+	if err != nil {
+		return err
+	}
+	if len(sites) == 0 {
+		return fmt.Errorf("no certificates found in %s", filepath.Join(d.caddyDir, "certificates"))
+	}
+	// site can have certificates from several issuers, the one that expires later is imported
+	latest := map[string]*caddySite{}
+	validTo := map[string]time.Time{}
+	for _, s := range sites {
+		certs, err := readCerts(s.path + ".crt")
+		if err != nil {
+			return err
+		}
+		if len(certs) > 0 && certs[0].NotAfter.After(validTo[s.name]) {
+			latest[s.name], validTo[s.name] = s, certs[0].NotAfter
+		}
+	}
+	cm := certmgr.New(d.dir)
+	var res []*caddyEntry
+	for _, s := range sites {
+		if latest[s.name] != s {
+			continue
+		}
+		e, err := importSite(ctx, d, cm, s)
+		if err != nil {
+			return fmt.Errorf("can't import certificate of '%s': %w", s.name, err)
+		}
+		res = append(res, e)
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Alias", "Domains", "Valid to", "Issuer", "Status"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range res {
+			tbl.Append([]string{e.Alias, strings.Join(e.Domains, ", "), e.ValidTo.String(), e.Issuer, e.Status})
+		}
+	})
+}
+
+func newCaddySubCommand(w io.Writer) *cobra.Command {
+	d := &caddyData{
+		w:        w,
+		dir:      ".",
+		caddyDir: common.CaddyDataDir(),
+	}
+	cmd := &cobra.Command{
+		Use:   "caddy",
+		Short: "Import certificates obtained by Caddy from its certificate storage, so that they can be listed and monitored",
+		Long: "Import certificates kept by Caddy in certificates directory of its file storage, together with their chains.\n" +
+			"Certificate is stored under alias named after site, optionally prefixed. When site has certificates from several\n" +
+			"issuers, the one that expires later is imported. Certificates that changed since previous import are only replaced with --replace.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			return importCaddy(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.caddyDir, "caddy-dir", d.caddyDir, "Data directory of Caddy, containing certificates directory. "+
+		"Caddy running as systemd service usually keeps it in /var/lib/caddy/.local/share/caddy")
+	cmd.Flags().StringVar(&d.prefix, "prefix", d.prefix, "Prefix of aliases, like 'caddy-'")
+	cmd.Flags().BoolVar(&d.withKeys, "with-keys", d.withKeys, "Whether to import private keys too, they are not needed for monitoring")
+	cmd.Flags().BoolVar(&d.replace, "replace", d.replace, "Whether to replace certificates already stored under the same aliases")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
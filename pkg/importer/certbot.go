@@ -0,0 +1,332 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCertbotDir = "/etc/letsencrypt"
+
+// archiveVersion matches numbered certificate in archive directory of lineage
+var archiveVersion = regexp.MustCompile(`^cert(\d+)\.pem$`)
+
+type certbotData struct {
+	w          io.Writer
+	dir        string
+	certbotDir string
+	prefix     string
+	withKeys   bool
+	replace    bool
+	names      []string
+	of         common.OutputFormat
+}
+
+// renewalConf is subset of renewal configuration of certbot lineage (renewal/<name>.conf).
+type renewalConf struct {
+	// Cert, Chain and PrivKey are files of current version, usually symlinks in live directory
+	Cert       string
+	Chain      string
+	PrivKey    string
+	ArchiveDir string
+	// Params are values of [renewalparams] section, like authenticator, server or key_type
+	Params map[string]string
+}
+
+// lineageFiles are files of current version of lineage.
+type lineageFiles struct {
+	cert  string
+	chain string
+	key   string
+}
+
+type certbotEntry struct {
+	Alias         string    `json:"alias" yaml:"alias"`
+	Lineage       string    `json:"lineage" yaml:"lineage"`
+	Domains       []string  `json:"domains" yaml:"domains"`
+	ValidTo       time.Time `json:"validTo" yaml:"validTo"`
+	Authenticator string    `json:"authenticator,omitempty" yaml:"authenticator,omitempty"`
+	Server        string    `json:"server,omitempty" yaml:"server,omitempty"`
+	// Status is outcome of import, see storeCert
+	Status string `json:"status" yaml:"status"`
+}
+
+// parseRenewalConf parses renewal configuration, which is INI-like file written by configobj.
+// Nested sections, like [[webroot_map]], are skipped.
+func parseRenewalConf(data []byte) *renewalConf {
+	rc := &renewalConf{Params: map[string]string{}}
+	var section string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch section {
+		case "":
+			switch k {
+			case "cert":
+				rc.Cert = v
+			case "chain":
+				rc.Chain = v
+			case "privkey":
+				rc.PrivKey = v
+			case "archive_dir":
+				rc.ArchiveDir = v
+			}
+		case "[renewalparams]":
+			rc.Params[k] = v
+		}
+	}
+	return rc
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// findFiles finds files of current version of lineage. Paths from renewal configuration are used when they exist,
+// then those in live directory and finally the newest version in archive directory, so that copies of certbot
+// directory made without symlinks, or moved elsewhere, can be imported too.
+func findFiles(certbotDir, name string, rc *renewalConf) (*lineageFiles, error) {
+	if rc != nil && exists(rc.Cert) {
+		return &lineageFiles{cert: rc.Cert, chain: rc.Chain, key: rc.PrivKey}, nil
+	}
+	live := filepath.Join(certbotDir, "live", name)
+	if exists(filepath.Join(live, "cert.pem")) {
+		return &lineageFiles{
+			cert:  filepath.Join(live, "cert.pem"),
+			chain: filepath.Join(live, "chain.pem"),
+			key:   filepath.Join(live, "privkey.pem"),
+		}, nil
+	}
+	archive := filepath.Join(certbotDir, "archive", name)
+	if rc != nil && exists(rc.ArchiveDir) {
+		archive = rc.ArchiveDir
+	}
+	entries, err := os.ReadDir(archive)
+	if err != nil {
+		return nil, fmt.Errorf("no certificate of lineage '%s' found: %w", name, err)
+	}
+	latest := 0
+	for _, e := range entries {
+		if m := archiveVersion.FindStringSubmatch(e.Name()); m != nil {
+			if v, _ := strconv.Atoi(m[1]); v > latest {
+				latest = v
+			}
+		}
+	}
+	if latest == 0 {
+		return nil, fmt.Errorf("no certificate of lineage '%s' found in %s", name, archive)
+	}
+	v := strconv.Itoa(latest)
+	return &lineageFiles{
+		cert:  filepath.Join(archive, "cert"+v+".pem"),
+		chain: filepath.Join(archive, "chain"+v+".pem"),
+		key:   filepath.Join(archive, "privkey"+v+".pem"),
+	}, nil
+}
+
+// readCerts reads all certificates from PEM file, missing file has none.
+func readCerts(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseCerts(file, data)
+}
+
+// parseCerts parses all certificates in PEM data, read from file.
+func parseCerts(file string, data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, &certmgr.PEMError{File: file, Type: block.Type, Err: err}
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// certbotLineages gets names of lineages, which are those with renewal configuration and those in live directory.
+func certbotLineages(certbotDir string) ([]string, error) {
+	var names []string
+	confs, err := filepath.Glob(filepath.Join(certbotDir, "renewal", "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range confs {
+		names = append(names, strings.TrimSuffix(filepath.Base(c), ".conf"))
+	}
+	entries, err := os.ReadDir(filepath.Join(certbotDir, "live"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() && !slices.Contains(names, e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// importLineage stores current version of lineage under alias, unless it's already there.
+func importLineage(ctx context.Context, d *certbotData, cm certmgr.Interface, name string) (*certbotEntry, error) {
+	var rc *renewalConf
+	data, err := os.ReadFile(filepath.Join(d.certbotDir, "renewal", name+".conf"))
+	switch {
+	case err == nil:
+		rc = parseRenewalConf(data)
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	files, err := findFiles(d.certbotDir, name, rc)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := readCerts(files.cert)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, &certmgr.PEMError{File: files.cert, Type: "CERTIFICATE"}
+	}
+	chain, err := readCerts(files.chain)
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.Signer
+	if d.withKeys {
+		data, err := os.ReadFile(files.key)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = certmgr.ParseEncryptedKeyPEM(data, nil); err != nil {
+			var pe *certmgr.PEMError
+			if errors.As(err, &pe) {
+				pe.File = files.key
+			}
+			return nil, err
+		}
+	}
+	e := &certbotEntry{
+		Alias:   d.prefix + name,
+		Lineage: name,
+		Domains: certs[0].DNSNames,
+		ValidTo: certs[0].NotAfter,
+	}
+	if rc != nil {
+		e.Authenticator, e.Server = rc.Params["authenticator"], rc.Params["server"]
+	}
+	if e.Status, err = storeCert(ctx, cm, e.Alias, certs[0], key, chain, d.replace); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func importCertbot(ctx context.Context, d *certbotData) error {
+	names := d.names
+	if len(names) == 0 {
+		var err error
+		if names, err = certbotLineages(d.certbotDir); err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no certbot lineages found in %s", d.certbotDir)
+		}
+	}
+	cm := certmgr.New(d.dir)
+	res := make([]*certbotEntry, 0, len(names))
+	for _, name := range names {
+		e, err := importLineage(ctx, d, cm, name)
+		if err != nil {
+			return fmt.Errorf("can't import lineage '%s': %w", name, err)
+		}
+		res = append(res, e)
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Alias", "Domains", "Valid to", "Authenticator", "Status"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range res {
+			tbl.Append([]string{e.Alias, strings.Join(e.Domains, ", "), e.ValidTo.String(), e.Authenticator, e.Status})
+		}
+	})
+}
+
+func newCertbotSubCommand(w io.Writer) *cobra.Command {
+	d := &certbotData{
+		w:          w,
+		dir:        ".",
+		certbotDir: defaultCertbotDir,
+	}
+	cmd := &cobra.Command{
+		Use:   "certbot [lineage...]",
+		Short: "Import certificates managed by certbot, so that they can be listed and monitored",
+		Long: "Import current certificate of each certbot lineage (or just of given ones) together with its chain.\n" +
+			"Files are located using renewal configuration, live directory or, when neither is usable, archive directory.\n" +
+			"Lineage is stored under alias of the same name, optionally prefixed. Certificates that changed since\n" +
+			"previous import, because certbot renewed them, are only replaced with --replace.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.names = args
+			d.of = common.OutputFormatOf(cmd)
+			return importCertbot(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.certbotDir, "certbot-dir", d.certbotDir, "Configuration directory of certbot, containing live, archive and renewal directories")
+	cmd.Flags().StringVar(&d.prefix, "prefix", d.prefix, "Prefix of aliases, like 'le-'")
+	cmd.Flags().BoolVar(&d.withKeys, "with-keys", d.withKeys, "Whether to import private keys too, they are not needed for monitoring")
+	cmd.Flags().BoolVar(&d.replace, "replace", d.replace, "Whether to replace certificates already stored under the same aliases")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package importer stores certificates obtained from outside into store.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+// Outcomes of storeCert
+const (
+	statusImported  = "imported"
+	statusUpdated   = "updated"
+	statusUnchanged = "unchanged"
+	statusExists    = "exists"
+)
+
+// storeCert imports certificate managed by other tool under alias, so that repeated imports keep store in sync.
+// Different certificate already stored under alias is only replaced when asked to.
+func storeCert(ctx context.Context, cm certmgr.Interface, alias string, cert *x509.Certificate, key crypto.Signer,
+	chain []*x509.Certificate, replace bool) (string, error) {
+	status := statusImported
+	stored, err := cm.GetCert(ctx, alias)
+	switch {
+	case err == nil && bytes.Equal(stored.Raw, cert.Raw):
+		return statusUnchanged, nil
+	case err == nil && !replace:
+		return statusExists, nil
+	case err == nil:
+		if err = cm.Delete(ctx, alias); err != nil {
+			return "", err
+		}
+		status = statusUpdated
+	case !errors.Is(err, certmgr.ErrAliasNotFound):
+		return "", err
+	}
+	return status, cm.Import(ctx, alias, cert, key, chain)
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import certificates obtained from outside into store",
+	}
+	cmd.AddCommand(newCaddySubCommand(out))
+	cmd.AddCommand(newCertbotSubCommand(out))
+	cmd.AddCommand(newRemoteSubCommand(out))
+	cmd.AddCommand(newTraefikSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultTLSPort = "443"
+
+type remoteData struct {
+	w          io.Writer
+	dir        string
+	addr       string
+	alias      string
+	serverName string
+	timeout    time.Duration
+	aia        bool
+	of         common.OutputFormat
+}
+
+type remoteEntry struct {
+	Subject     string    `json:"subject" yaml:"subject"`
+	Issuer      string    `json:"issuer" yaml:"issuer"`
+	ValidTo     time.Time `json:"validTo" yaml:"validTo"`
+	IsCA        bool      `json:"isCA" yaml:"isCA"`
+	Fingerprint string    `json:"sha256" yaml:"sha256"`
+	// Downloaded is true when certificate was not presented by server, but downloaded from caIssuers URL
+	Downloaded bool `json:"downloaded,omitempty" yaml:"downloaded,omitempty"`
+}
+
+// fetchChain connects to server and gets certificates it presented, starting with its own.
+// Chain is not verified, since its whole point is to capture certificates that are not trusted yet.
+func fetchChain(ctx context.Context, d *remoteData) ([]*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: d.timeout},
+		Config: &tls.Config{
+			ServerName:         d.serverName,
+			InsecureSkipVerify: true, //nolint:gosec // chain is captured, not trusted
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s presented no certificate", d.addr)
+	}
+	return certs, nil
+}
+
+func importRemote(ctx context.Context, d *remoteData) error {
+	certs, err := fetchChain(ctx, d)
+	if err != nil {
+		return err
+	}
+	presented := len(certs)
+	if d.aia {
+		fetched, err := completeChain(ctx, &http.Client{Timeout: d.timeout}, certs)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, fetched...)
+	}
+	if err = certmgr.New(d.dir).Import(ctx, d.alias, certs[0], nil, certs[1:]); err != nil {
+		return err
+	}
+	res := make([]remoteEntry, 0, len(certs))
+	for i, c := range certs {
+		fp := sha256.Sum256(c.Raw)
+		res = append(res, remoteEntry{
+			Subject:     c.Subject.String(),
+			Issuer:      c.Issuer.String(),
+			ValidTo:     c.NotAfter,
+			IsCA:        c.IsCA,
+			Fingerprint: hex.EncodeToString(fp[:]),
+			Downloaded:  i >= presented,
+		})
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"#", "Subject", "Issuer", "Valid to", "SHA-256 fingerprint", "Source",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for i, e := range res {
+			tbl.Append([]string{strconv.Itoa(i), e.Subject, e.Issuer, e.ValidTo.String(), e.Fingerprint, lo.Ternary(e.Downloaded, "downloaded", "presented")})
+		}
+	})
+}
+
+func validateRemote(d *remoteData, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one address in form host[:port] is required")
+	}
+	if len(d.alias) == 0 {
+		return certmgr.ErrAliasMissing
+	}
+	host, port, err := net.SplitHostPort(args[0])
+	if err != nil {
+		host, port = args[0], defaultTLSPort
+	}
+	d.addr = net.JoinHostPort(host, port)
+	if len(d.serverName) == 0 && net.ParseIP(host) == nil {
+		d.serverName = host
+	}
+	return nil
+}
+
+func newRemoteSubCommand(w io.Writer) *cobra.Command {
+	d := &remoteData{
+		w:       w,
+		dir:     ".",
+		timeout: 10 * time.Second,
+	}
+	cmd := &cobra.Command{
+		Use:   "remote host[:port]",
+		Short: "Store certificate chain presented by TLS server, without verifying it",
+		Long: "Connect to TLS server and store certificate chain it presents, so that it can be pinned or trusted.\n" +
+			"Server certificate is stored under given alias, the rest of chain is stored along with it.\n" +
+			"Chain is not verified, check fingerprints before trusting it. Port defaults to " + defaultTLSPort + ".",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateRemote(d, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			return importRemote(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias to store server certificate under")
+	cmd.Flags().StringVar(&d.serverName, "server-name", d.serverName, "Server name to send in TLS handshake, defaults to host")
+	cmd.Flags().DurationVar(&d.timeout, "timeout", d.timeout, "Timeout of connection")
+	cmd.Flags().BoolVar(&d.aia, "aia", d.aia, "Download issuers missing from presented chain, "+
+		"following caIssuers URLs of Authority Information Access extension up to self-signed root")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package importer
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+type traefikData struct {
+	w         io.Writer
+	dir       string
+	file      string
+	resolvers []string
+	prefix    string
+	withKeys  bool
+	replace   bool
+	of        common.OutputFormat
+}
+
+// traefikResolver is state of single certificate resolver in acme.json.
+// Traefik v1 kept single one at top level, with capitalized keys, which decode into the same struct.
+type traefikResolver struct {
+	Certificates []*traefikCert `json:"Certificates"`
+}
+
+type traefikCert struct {
+	Domain struct {
+		Main string   `json:"main"`
+		SANs []string `json:"sans"`
+	} `json:"domain"`
+	// Certificate and Key are base64-encoded PEM, certificate is followed by chain
+	Certificate []byte `json:"certificate"`
+	Key         []byte `json:"key"`
+}
+
+type traefikEntry struct {
+	Alias    string    `json:"alias" yaml:"alias"`
+	Resolver string    `json:"resolver" yaml:"resolver"`
+	Domains  []string  `json:"domains" yaml:"domains"`
+	ValidTo  time.Time `json:"validTo" yaml:"validTo"`
+	// Status is outcome of import, see storeCert
+	Status string `json:"status" yaml:"status"`
+
+	cert *traefikCert
+}
+
+// traefikAlias gets alias for main domain of certificate, wildcard is spelled out, as Caddy does.
+func traefikAlias(prefix, domain string) string {
+	return prefix + strings.ReplaceAll(strings.ToLower(domain), "*", "wildcard_")
+}
+
+// parseAcmeJSON gets certificate resolvers from acme.json, keyed by name.
+func parseAcmeJSON(data []byte) (map[string]*traefikResolver, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	if _, v1 := top["Account"]; v1 {
+		r := &traefikResolver{}
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, err
+		}
+		return map[string]*traefikResolver{"default": r}, nil
+	}
+	res := map[string]*traefikResolver{}
+	for name, raw := range top {
+		r := &traefikResolver{}
+		if err := json.Unmarshal(raw, r); err != nil {
+			return nil, fmt.Errorf("resolver %s: %w", name, err)
+		}
+		res[name] = r
+	}
+	return res, nil
+}
+
+// traefikEntries decodes certificates of selected resolvers. When the same domain was obtained by several resolvers,
+// the certificate that expires later is kept.
+func traefikEntries(d *traefikData, resolvers map[string]*traefikResolver) ([]*traefikEntry, error) {
+	byAlias := map[string]*traefikEntry{}
+	for name, r := range resolvers {
+		if len(d.resolvers) > 0 && !slices.Contains(d.resolvers, name) {
+			continue
+		}
+		for _, c := range r.Certificates {
+			if len(c.Domain.Main) == 0 {
+				continue
+			}
+			certs, err := parseCerts(d.file, c.Certificate)
+			if err != nil {
+				return nil, err
+			}
+			if len(certs) == 0 {
+				return nil, fmt.Errorf("resolver %s: no certificate for %s", name, c.Domain.Main)
+			}
+			e := &traefikEntry{
+				Alias:    traefikAlias(d.prefix, c.Domain.Main),
+				Resolver: name,
+				Domains:  append([]string{c.Domain.Main}, c.Domain.SANs...),
+				ValidTo:  certs[0].NotAfter,
+				cert:     c,
+			}
+			if prev, ok := byAlias[e.Alias]; !ok || e.ValidTo.After(prev.ValidTo) {
+				byAlias[e.Alias] = e
+			}
+		}
+	}
+	res := make([]*traefikEntry, 0, len(byAlias))
+	for _, e := range byAlias {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Alias < res[j].Alias
+	})
+	return res, nil
+}
+
+func importTraefikCert(ctx context.Context, d *traefikData, cm certmgr.Interface, e *traefikEntry) error {
+	certs, err := parseCerts(d.file, e.cert.Certificate)
+	if err != nil {
+		return err
+	}
+	var key crypto.Signer
+	if d.withKeys {
+		if key, err = certmgr.ParseEncryptedKeyPEM(e.cert.Key, nil); err != nil {
+			var pe *certmgr.PEMError
+			if errors.As(err, &pe) {
+				pe.File = d.file
+			}
+			return err
+		}
+	}
+	e.Status, err = storeCert(ctx, cm, e.Alias, certs[0], key, certs[1:], d.replace)
+	return err
+}
+
+func importTraefik(ctx context.Context, d *traefikData) error {
+	data, err := os.ReadFile(d.file)
+	if err != nil {
+		return err
+	}
+	resolvers, err := parseAcmeJSON(data)
+	if err != nil {
+		return fmt.Errorf("invalid ACME storage %s: %w", d.file, err)
+	}
+	res, err := traefikEntries(d, resolvers)
+	if err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("no certificates found in %s", d.file)
+	}
+	cm := certmgr.New(d.dir)
+	for _, e := range res {
+		if err = importTraefikCert(ctx, d, cm, e); err != nil {
+			return fmt.Errorf("can't import certificate of '%s': %w", e.Domains[0], err)
+		}
+	}
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Alias", "Domains", "Valid to", "Resolver", "Status"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range res {
+			tbl.Append([]string{e.Alias, strings.Join(e.Domains, ", "), e.ValidTo.String(), e.Resolver, e.Status})
+		}
+	})
+}
+
+func newTraefikSubCommand(w io.Writer) *cobra.Command {
+	d := &traefikData{
+		w:   w,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "traefik",
+		Short: "Import certificates obtained by Traefik from its ACME storage (acme.json), so that they can be listed and monitored",
+		Long: "Import certificates of all certificate resolvers in acme.json of Traefik, together with their chains.\n" +
+			"Certificate is stored under alias named after its main domain, optionally prefixed. Certificates that changed\n" +
+			"since previous import are only replaced with --replace. Storage of Traefik v1 is supported too, as resolver 'default'.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(d.file) == 0 {
+				return errors.New("ACME storage file is required")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			return importTraefik(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.file, "file", d.file, "ACME storage of Traefik, like /letsencrypt/acme.json")
+	cmd.Flags().StringSliceVar(&d.resolvers, "resolver", d.resolvers, "Name of certificate resolver to import from, can be repeated. All are imported by default")
+	cmd.Flags().StringVar(&d.prefix, "prefix", d.prefix, "Prefix of aliases, like 'traefik-'")
+	cmd.Flags().BoolVar(&d.withKeys, "with-keys", d.withKeys, "Whether to import private keys too, they are not needed for monitoring")
+	cmd.Flags().BoolVar(&d.replace, "replace", d.replace, "Whether to replace certificates already stored under the same aliases")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package iot issues certificates for fleets of devices.
+package iot
+
+import (
+	"github.com/spf13/cobra"
+	"io"
+)
+
+func NewCommand(in io.Reader, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "iot",
+		Short: "Issue certificates for IoT devices",
+	}
+	cmd.AddCommand(newIssueSubCommand(in, out))
+	return cmd
+}
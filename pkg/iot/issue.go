@@ -0,0 +1,337 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	formatPEM    = "pem"
+	formatPKCS12 = "pkcs12"
+)
+
+type issueData struct {
+	w            io.Writer
+	errw         io.Writer
+	in           io.Reader
+	dir          string
+	devices      string
+	parent       string
+	format       string
+	out          string
+	manifest     string
+	passwordFile string
+	password     []byte
+	org          []string
+	bits         int
+	validYears   int
+	workers      int
+	of           common.OutputFormat
+}
+
+// device is single row of inventory.
+type device struct {
+	ID     string
+	CN     string
+	Serial string
+}
+
+// manifestEntry maps device to certificate issued for it.
+type manifestEntry struct {
+	Device      string     `json:"device" yaml:"device"`
+	Alias       string     `json:"alias" yaml:"alias"`
+	Serial      string     `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Fingerprint string     `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	ValidTo     *time.Time `json:"validTo,omitempty" yaml:"validTo,omitempty"`
+	Files       []string   `json:"files,omitempty" yaml:"files,omitempty"`
+	Error       string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// parseDevices parses CSV inventory with header naming columns "id", "cn" and "serial".
+// Common name defaults to device ID, serial is put into serialNumber attribute of subject.
+func parseDevices(data []byte) ([]device, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV input: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["id"]; !ok {
+		return nil, errors.New("CSV input must have header with 'id' column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	devices := make([]device, 0, len(records)-1)
+	seen := map[string]bool{}
+	for i, rec := range records[1:] {
+		dev := device{ID: field(rec, "id"), CN: field(rec, "cn"), Serial: field(rec, "serial")}
+		if len(dev.ID) == 0 || strings.ContainsAny(dev.ID, `/\`) {
+			return nil, fmt.Errorf("row %d: invalid device ID %q", i+1, dev.ID)
+		}
+		if seen[dev.ID] {
+			return nil, fmt.Errorf("row %d: duplicate device ID %s", i+1, dev.ID)
+		}
+		seen[dev.ID] = true
+		if len(dev.CN) == 0 {
+			dev.CN = dev.ID
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// write writes issued certificate and its key into output directory, returning names of written files.
+func (d *issueData) write(ctx context.Context, cm certmgr.Reader, id string) ([]string, error) {
+	ph, err := cm.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := cm.GetChain(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if d.format == formatPKCS12 {
+		var cas []*x509.Certificate
+		for _, e := range chain[1:] {
+			cas = append(cas, e.Cert)
+		}
+		data, err := certmgr.MarshalPKCS12(ph.Key, ph.Cert, cas, id, d.password)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Join(d.out, id+".p12")
+		return []string{name}, os.WriteFile(name, data, 0o600)
+	}
+	var crt []byte
+	for _, e := range chain {
+		if !certmgr.IsSelfSigned(e.Cert) {
+			crt = append(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+		}
+	}
+	key, err := certmgr.MarshalKeyPEM(ph.Key)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{filepath.Join(d.out, id+".crt"), filepath.Join(d.out, id+".key")}
+	if err = os.WriteFile(names[0], crt, 0o644); err != nil {
+		return nil, err
+	}
+	return names, os.WriteFile(names[1], key, 0o600)
+}
+
+// issue issues client certificate of single device and packages it.
+func (d *issueData) issue(ctx context.Context, cm certmgr.Interface, dev device, e *manifestEntry) error {
+	cd := &certmgr.CertData{
+		KeySize:     d.bits,
+		ValidYears:  d.validYears,
+		Alias:       dev.ID,
+		ParentAlias: d.parent,
+		Subject:     pkix.Name{CommonName: dev.CN, SerialNumber: dev.Serial, Organization: d.org},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if err := cm.NewLeaf(ctx, cd); err != nil {
+		return err
+	}
+	cert, err := cm.GetCert(ctx, dev.ID)
+	if err != nil {
+		return err
+	}
+	fp := sha256.Sum256(cert.Raw)
+	e.Serial = cert.SerialNumber.String()
+	e.Fingerprint = hex.EncodeToString(fp[:])
+	e.ValidTo = &cert.NotAfter
+	e.Files, err = d.write(ctx, cm, dev.ID)
+	return err
+}
+
+// issueDevices issues certificate for every device using pool of workers and writes manifest.
+// Returns true when any device failed.
+func issueDevices(ctx context.Context, d *issueData) (bool, error) {
+	data, err := common.ReadInput(d.devices, d.in)
+	if err != nil {
+		return false, err
+	}
+	devices, err := parseDevices(data)
+	if err != nil {
+		return false, err
+	}
+	if len(d.passwordFile) > 0 {
+		password, err := os.ReadFile(d.passwordFile)
+		if err != nil {
+			return false, err
+		}
+		d.password = []byte(strings.TrimRight(string(password), "\r\n"))
+	}
+	if err = os.MkdirAll(d.out, 0o700); err != nil {
+		return false, err
+	}
+	cm := certmgr.New(d.dir)
+	res := make([]manifestEntry, len(devices))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(d.workers, len(devices)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res[i] = manifestEntry{Device: devices[i].ID, Alias: devices[i].ID}
+				if err := d.issue(ctx, cm, devices[i], &res[i]); err != nil {
+					res[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+	for i := range devices {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	manifest, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err = os.WriteFile(d.manifest, append(manifest, '\n'), 0o644); err != nil {
+		return false, err
+	}
+	failed := false
+	for _, e := range res {
+		failed = failed || len(e.Error) > 0
+	}
+	return failed, common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Device", "Serial", "SHA-256", "Result",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range res {
+			result := "issued"
+			if len(e.Error) > 0 {
+				result = "failed: " + e.Error
+			}
+			tbl.Append([]string{e.Device, e.Serial, e.Fingerprint, result})
+		}
+	})
+}
+
+func validateIssue(d *issueData) error {
+	if len(d.devices) == 0 {
+		return errors.New("device inventory is required")
+	}
+	if len(d.parent) == 0 {
+		return common.ErrParentAliasMissing
+	}
+	switch d.format {
+	case formatPEM:
+	case formatPKCS12:
+		if len(d.passwordFile) == 0 {
+			return errors.New("password file is required for PKCS#12 format")
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", d.format)
+	}
+	if len(d.manifest) == 0 {
+		d.manifest = filepath.Join(d.out, "manifest.json")
+	}
+	if d.workers < 1 {
+		d.workers = runtime.GOMAXPROCS(0)
+	}
+	return nil
+}
+
+func newIssueSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &issueData{
+		w:          w,
+		in:         in,
+		dir:        ".",
+		format:     formatPEM,
+		out:        "devices",
+		bits:       2048,
+		validYears: 10,
+	}
+	cmd := &cobra.Command{
+		Use:   "issue",
+		Short: "Issue client certificate for every device of inventory, all issued by the same CA",
+		Long: "Issue client certificate for every device of CSV inventory, aliased by device ID.\n" +
+			"Inventory must have header with 'id' column, and optional 'cn' (defaults to ID) and 'serial' columns.\n" +
+			"Serial is put into serialNumber attribute of subject. Certificates are written to output directory\n" +
+			"as <id>.crt and <id>.key, or <id>.p12, together with manifest mapping devices to certificate serials and fingerprints.",
+		Example: "pkitool iot issue --devices devices.csv --parent device-ca --format pkcs12 --password-file ./p12-password",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateIssue(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			failed, err := issueDevices(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if failed {
+				// failures were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&d.devices, "devices", d.devices, "CSV file with device inventory. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.parent, "parent", "", "Alias of parent (issuing) CA certificate")
+	cmd.Flags().StringVar(&d.format, "format", d.format, "Format of written certificates, one of pem, pkcs12")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Directory to write certificates to")
+	cmd.Flags().StringVar(&d.manifest, "manifest", d.manifest, "Manifest file, defaults to manifest.json in output directory")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password to protect PKCS#12 archives with")
+	cmd.Flags().StringArrayVar(&d.org, "organization", d.org, "Organization components of subject DN of all certificates")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.validYears, "years", d.validYears, "How meany years should new certificates be valid for")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many certificates to create concurrently, defaults to number of CPUs")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
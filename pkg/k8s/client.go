@@ -0,0 +1,262 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// apiError is failure status returned by API server.
+type apiError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API server returned %d %s: %s", e.Code, e.Reason, e.Message)
+}
+
+func isNotFound(err error) bool {
+	var ae *apiError
+	return errors.As(err, &ae) && ae.Code == http.StatusNotFound
+}
+
+// client is minimal client of Kubernetes API server, enough to work with few resources controller needs.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// kubeconfig is subset of kubeconfig file. Exec and auth provider plugins are not supported.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// readData gets content of inline base64 data, or of file relative to kubeconfig.
+func readData(data, file, dir string) ([]byte, error) {
+	if len(data) > 0 {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if len(file) == 0 {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return os.ReadFile(file)
+}
+
+func newClient(server string, caPEM []byte, cert *tls.Certificate, token string, insecure bool) (*client, error) {
+	tc := &tls.Config{InsecureSkipVerify: insecure}
+	if len(caPEM) > 0 {
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificate found in CA bundle of API server")
+		}
+	}
+	if cert != nil {
+		tc.Certificates = []tls.Certificate{*cert}
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tc
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http:   &http.Client{Transport: tr},
+	}, nil
+}
+
+// inClusterClient creates client authenticated by service account of pod.
+func inClusterClient() (*client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, errors.New("not running in Kubernetes cluster, kubeconfig is required")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	return newClient("https://"+net.JoinHostPort(host, port), caPEM, nil, strings.TrimSpace(string(token)), false)
+}
+
+// kubeconfigClient creates client for current context of kubeconfig file.
+func kubeconfigClient(path string) (*client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err = yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for _, c := range kc.Contexts {
+		if c.Name != kc.CurrentContext {
+			continue
+		}
+		for _, cl := range kc.Clusters {
+			if cl.Name != c.Context.Cluster {
+				continue
+			}
+			caPEM, err := readData(cl.Cluster.CertificateAuthorityData, cl.Cluster.CertificateAuthority, dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, u := range kc.Users {
+				if u.Name != c.Context.User {
+					continue
+				}
+				token := u.User.Token
+				if len(token) == 0 && len(u.User.TokenFile) > 0 {
+					if data, err = readData("", u.User.TokenFile, dir); err != nil {
+						return nil, err
+					}
+					token = strings.TrimSpace(string(data))
+				}
+				var cert *tls.Certificate
+				certPEM, err := readData(u.User.ClientCertificateData, u.User.ClientCertificate, dir)
+				if err != nil {
+					return nil, err
+				}
+				if len(certPEM) > 0 {
+					keyPEM, err := readData(u.User.ClientKeyData, u.User.ClientKey, dir)
+					if err != nil {
+						return nil, err
+					}
+					pair, err := tls.X509KeyPair(certPEM, keyPEM)
+					if err != nil {
+						return nil, err
+					}
+					cert = &pair
+				}
+				return newClient(cl.Cluster.Server, caPEM, cert, token, cl.Cluster.InsecureSkipTLSVerify)
+			}
+			return nil, fmt.Errorf("user %s of context %s not found in %s", c.Context.User, c.Name, path)
+		}
+		return nil, fmt.Errorf("cluster %s of context %s not found in %s", c.Context.Cluster, c.Name, path)
+	}
+	return nil, fmt.Errorf("current context %q not found in %s", kc.CurrentContext, path)
+}
+
+// connect creates client from kubeconfig, or for service account of pod when path is empty.
+func connect(path string) (*client, error) {
+	if len(path) == 0 {
+		return inClusterClient()
+	}
+	return kubeconfigClient(path)
+}
+
+func (c *client) request(ctx context.Context, method, path, contentType string, body interface{}) (*http.Response, error) {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		ae := &apiError{}
+		if data, _ := io.ReadAll(resp.Body); json.Unmarshal(data, ae) != nil || ae.Code == 0 {
+			ae.Code, ae.Reason, ae.Message = resp.StatusCode, http.StatusText(resp.StatusCode), strings.TrimSpace(string(data))
+		}
+		return nil, ae
+	}
+	return resp, nil
+}
+
+// do sends request with JSON body, when not nil, and decodes response into out, when not nil.
+func (c *client) do(ctx context.Context, method, path, contentType string, body, out interface{}) error {
+	resp, err := c.request(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
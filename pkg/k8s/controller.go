@@ -0,0 +1,623 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	crdGroup   = "pkitool.rkosegi.github.io"
+	crdVersion = "v1alpha1"
+	// annotationSpec holds hash of Certificate spec that Secret was issued for, so that change of spec causes reissue
+	annotationSpec = crdGroup + "/spec-hash"
+
+	defaultDuration = 90 * 24 * time.Hour
+	minDuration     = time.Hour
+)
+
+// kubeMeta is subset of metadata of Kubernetes object.
+type kubeMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Generation      int64             `json:"generation,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	OwnerReferences []ownerReference  `json:"ownerReferences,omitempty"`
+}
+
+type ownerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Controller bool   `json:"controller"`
+}
+
+type kubeSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeMeta          `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string][]byte `json:"data"`
+}
+
+type subjectSpec struct {
+	CommonName         string   `json:"commonName,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+}
+
+type certificateSpec struct {
+	// SecretName is name of Secret of type kubernetes.io/tls to write certificate to
+	SecretName  string       `json:"secretName"`
+	Subject     *subjectSpec `json:"subject,omitempty"`
+	DNSNames    []string     `json:"dnsNames,omitempty"`
+	IPAddresses []string     `json:"ipAddresses,omitempty"`
+	Profile     string       `json:"profile,omitempty"`
+	// CA is alias of CA to issue from, must be one of those controller is allowed to use
+	CA string `json:"ca,omitempty"`
+	// Duration and RenewBefore are Go durations, like 2160h
+	Duration    string `json:"duration,omitempty"`
+	RenewBefore string `json:"renewBefore,omitempty"`
+}
+
+type condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+}
+
+type certificateStatus struct {
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	SerialNumber       string      `json:"serialNumber,omitempty"`
+	NotAfter           string      `json:"notAfter,omitempty"`
+	RenewalTime        string      `json:"renewalTime,omitempty"`
+	Conditions         []condition `json:"conditions,omitempty"`
+}
+
+type certificate struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeMeta          `json:"metadata"`
+	Spec       certificateSpec   `json:"spec"`
+	Status     certificateStatus `json:"status"`
+}
+
+type certificateList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []*certificate `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type controllerData struct {
+	w          io.Writer
+	errw       io.Writer
+	dir        string
+	kubeconfig string
+	namespace  string
+	cas        []string
+	bits       int
+	resync     time.Duration
+	retry      time.Duration
+	once       bool
+	keyShares  []string
+
+	kc *client
+	cm certmgr.Interface
+}
+
+func (c *certificate) key() string {
+	return c.Metadata.Namespace + "/" + c.Metadata.Name
+}
+
+// certificatesPath gets path of Certificate resources, in all namespaces when namespace is empty.
+func certificatesPath(namespace string) string {
+	if len(namespace) == 0 {
+		return "/apis/" + crdGroup + "/" + crdVersion + "/certificates"
+	}
+	return "/apis/" + crdGroup + "/" + crdVersion + "/namespaces/" + url.PathEscape(namespace) + "/certificates"
+}
+
+func secretPath(namespace, name string) string {
+	p := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets"
+	if len(name) > 0 {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+// issuance is what spec of Certificate asks for.
+type issuance struct {
+	cd          *certmgr.CertData
+	ca          string
+	duration    time.Duration
+	renewBefore time.Duration
+	hash        string
+}
+
+func parseSpec(d *controllerData, spec *certificateSpec) (*issuance, error) {
+	if len(spec.SecretName) == 0 {
+		return nil, errors.New("secretName is required")
+	}
+	is := &issuance{ca: spec.CA, duration: defaultDuration}
+	if len(is.ca) == 0 {
+		is.ca = d.cas[0]
+	} else if !slices.Contains(d.cas, is.ca) {
+		return nil, fmt.Errorf("CA '%s' is not allowed", is.ca)
+	}
+	var err error
+	if len(spec.Duration) > 0 {
+		if is.duration, err = time.ParseDuration(spec.Duration); err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+	}
+	if is.duration < minDuration {
+		return nil, fmt.Errorf("duration must be at least %s", minDuration)
+	}
+	is.renewBefore = is.duration / 3
+	if len(spec.RenewBefore) > 0 {
+		if is.renewBefore, err = time.ParseDuration(spec.RenewBefore); err != nil {
+			return nil, fmt.Errorf("invalid renewBefore: %w", err)
+		}
+	}
+	if is.renewBefore <= 0 || is.renewBefore >= is.duration {
+		return nil, errors.New("renewBefore must be positive and shorter than duration")
+	}
+	p, err := profiles.Get(spec.Profile)
+	if len(spec.Profile) == 0 {
+		p, err = profiles.Get(profiles.Default)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(p.UnknownExtKeyUsage) > 0 || p.NoExpiry {
+		return nil, fmt.Errorf("profile %s can't be used by controller", p.Name)
+	}
+	is.cd = &certmgr.CertData{
+		KeySize:     d.bits,
+		Validity:    is.duration,
+		ParentAlias: is.ca,
+		DNSSan:      spec.DNSNames,
+	}
+	for _, s := range spec.IPAddresses {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", s)
+		}
+		is.cd.IPSan = append(is.cd.IPSan, ip)
+	}
+	if s := spec.Subject; s != nil {
+		is.cd.Subject = pkix.Name{
+			CommonName:         s.CommonName,
+			Organization:       s.Organization,
+			OrganizationalUnit: s.OrganizationalUnit,
+			Country:            s.Country,
+			Province:           s.Province,
+			Locality:           s.Locality,
+		}
+	}
+	if len(is.cd.Subject.CommonName) == 0 && len(spec.DNSNames) > 0 {
+		is.cd.Subject.CommonName = spec.DNSNames[0]
+	}
+	if len(is.cd.Subject.String()) == 0 && len(is.cd.IPSan) == 0 {
+		return nil, errors.New("either subject or subject alternative name is required")
+	}
+	p.Apply(is.cd)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	is.hash = hex.EncodeToString(sum[:])
+	return is, nil
+}
+
+// current gets certificate in Secret, unless it must be reissued because spec or CA changed, or it's due for renewal.
+func current(is *issuance, ca *x509.Certificate, s *kubeSecret) *x509.Certificate {
+	if s.Metadata.Annotations[annotationSpec] != is.hash {
+		return nil
+	}
+	cert, err := certmgr.ParseCertificatePEM(s.Data["tls.crt"])
+	if err != nil || cert.CheckSignatureFrom(ca) != nil {
+		return nil
+	}
+	if !time.Now().Before(cert.NotAfter.Add(-is.renewBefore)) {
+		return nil
+	}
+	return cert
+}
+
+// owned checks that Secret was created by controller for Certificate, so that unrelated Secrets are never overwritten.
+func owned(c *certificate, s *kubeSecret) bool {
+	return slices.ContainsFunc(s.Metadata.OwnerReferences, func(r ownerReference) bool {
+		return r.UID == c.Metadata.UID
+	})
+}
+
+// issue issues certificate from CA and writes it into Secret, which is created when it doesn't exist.
+func (d *controllerData) issue(ctx context.Context, c *certificate, is *issuance, s *kubeSecret) (*x509.Certificate, error) {
+	csr, err := certmgr.CreateCSR(ctx, is.cd)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := d.cm.SignCSR(ctx, is.ca, csr.CSR, &certmgr.SignOptions{
+		Validity:    is.duration,
+		ExtKeyUsage: is.cd.ExtKeyUsage,
+		Profile:     is.cd.Profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	chain, err := d.cm.GetChain(ctx, is.ca)
+	if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+		return nil, err
+	}
+	tlsCrt := pemCert(cert)
+	// trust anchor at the top of chain goes to ca.crt only
+	for _, e := range chain[:max(len(chain)-1, 0)] {
+		tlsCrt = append(tlsCrt, pemCert(e.Cert)...)
+	}
+	key, err := certmgr.MarshalKeyPEM(csr.Key)
+	if err != nil {
+		return nil, err
+	}
+	method, path := "PUT", secretPath(c.Metadata.Namespace, c.Spec.SecretName)
+	if s == nil {
+		s = &kubeSecret{
+			Metadata: kubeMeta{
+				Name:      c.Spec.SecretName,
+				Namespace: c.Metadata.Namespace,
+				OwnerReferences: []ownerReference{{
+					APIVersion: c.APIVersion,
+					Kind:       c.Kind,
+					Name:       c.Metadata.Name,
+					UID:        c.Metadata.UID,
+					Controller: true,
+				}},
+			},
+		}
+		method, path = "POST", secretPath(c.Metadata.Namespace, "")
+	}
+	s.APIVersion, s.Kind, s.Type = "v1", "Secret", "kubernetes.io/tls"
+	if s.Metadata.Annotations == nil {
+		s.Metadata.Annotations = map[string]string{}
+	}
+	s.Metadata.Annotations[annotationSpec] = is.hash
+	s.Data = map[string][]byte{
+		"tls.crt": tlsCrt,
+		"tls.key": key,
+	}
+	if len(chain) > 0 {
+		s.Data["ca.crt"] = pemCert(chain[len(chain)-1].Cert)
+	}
+	if err = d.kc.do(ctx, method, path, "application/json", s, nil); err != nil {
+		return nil, fmt.Errorf("can't write Secret %s: %w", c.Spec.SecretName, err)
+	}
+	return cert, nil
+}
+
+// setStatus updates status of Certificate, unless it would stay the same.
+func (d *controllerData) setStatus(ctx context.Context, c *certificate, cert *x509.Certificate, renewAt time.Time, err error) error {
+	st := certificateStatus{ObservedGeneration: c.Metadata.Generation}
+	cond := condition{Type: "Ready", Status: "True", Reason: "Issued", Message: "Certificate is up to date"}
+	if err != nil {
+		cond = condition{Type: "Ready", Status: "False", Reason: "Failed", Message: err.Error()}
+	}
+	if cert != nil {
+		st.SerialNumber = cert.SerialNumber.Text(16)
+		st.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+		st.RenewalTime = renewAt.UTC().Format(time.RFC3339)
+	} else {
+		st.SerialNumber, st.NotAfter, st.RenewalTime = c.Status.SerialNumber, c.Status.NotAfter, c.Status.RenewalTime
+	}
+	cond.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+	for _, prev := range c.Status.Conditions {
+		if prev.Type == cond.Type && prev.Status == cond.Status {
+			cond.LastTransitionTime = prev.LastTransitionTime
+		}
+	}
+	st.Conditions = []condition{cond}
+	old, _ := json.Marshal(&c.Status)
+	cur, _ := json.Marshal(&st)
+	if bytes.Equal(old, cur) {
+		return nil
+	}
+	return d.kc.do(ctx, "PATCH", certificatesPath(c.Metadata.Namespace)+"/"+url.PathEscape(c.Metadata.Name)+"/status",
+		"application/merge-patch+json", map[string]interface{}{"status": &st}, nil)
+}
+
+// reconcile makes sure that Secret of Certificate holds valid certificate matching its spec.
+// Time when Certificate should be reconciled again is returned.
+func (d *controllerData) reconcile(ctx context.Context, c *certificate) (time.Time, error) {
+	var cert *x509.Certificate
+	is, err := parseSpec(d, &c.Spec)
+	if err == nil {
+		cert, err = d.ensure(ctx, c, is)
+	}
+	renewAt := time.Now().Add(d.retry)
+	if err == nil {
+		renewAt = cert.NotAfter.Add(-is.renewBefore)
+	}
+	if serr := d.setStatus(ctx, c, cert, renewAt, err); serr != nil {
+		err = errors.Join(err, fmt.Errorf("can't update status: %w", serr))
+	}
+	return renewAt, err
+}
+
+func (d *controllerData) ensure(ctx context.Context, c *certificate, is *issuance) (*x509.Certificate, error) {
+	ca, err := d.cm.GetCert(ctx, is.ca)
+	if err != nil {
+		return nil, err
+	}
+	s := &kubeSecret{}
+	if err = d.kc.do(ctx, "GET", secretPath(c.Metadata.Namespace, c.Spec.SecretName), "", nil, s); err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		s = nil
+	} else if !owned(c, s) {
+		return nil, fmt.Errorf("Secret %s exists and doesn't belong to this Certificate", c.Spec.SecretName)
+	}
+	if s != nil {
+		if cert := current(is, ca, s); cert != nil {
+			return cert, nil
+		}
+	}
+	cert, err := d.issue(ctx, c, is, s)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(d.w, "Issued certificate for %s into Secret %s, serial %s, valid to %s\n",
+		c.key(), c.Spec.SecretName, cert.SerialNumber, cert.NotAfter.Format(time.RFC3339))
+	return cert, err
+}
+
+// reconcileOne reconciles Certificate and records when it's due next, failure is reported and retried later.
+func (d *controllerData) reconcileOne(ctx context.Context, c *certificate, due map[string]time.Time) error {
+	at, err := d.reconcile(ctx, c)
+	if err != nil {
+		_, _ = fmt.Fprintf(d.errw, "Reconciliation of %s failed, retrying at %s: %v\n", c.key(), at.Format(time.RFC3339), err)
+	}
+	due[c.key()] = at
+	return err
+}
+
+// watch processes changes of Certificates until context is done or API server ends the watch.
+func (d *controllerData) watch(ctx context.Context, resourceVersion string, due map[string]time.Time) error {
+	q := url.Values{"watch": {"1"}, "resourceVersion": {resourceVersion}, "allowWatchBookmarks": {"false"}}
+	if deadline, ok := ctx.Deadline(); ok {
+		q.Set("timeoutSeconds", fmt.Sprint(max(int(time.Until(deadline).Seconds()), 1)))
+	}
+	resp, err := d.kc.request(ctx, "GET", certificatesPath(d.namespace)+"?"+q.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev watchEvent
+		if err = dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		switch ev.Type {
+		case "ADDED", "MODIFIED":
+			c := &certificate{}
+			if err = json.Unmarshal(ev.Object, c); err != nil {
+				return err
+			}
+			_ = d.reconcileOne(ctx, c, due)
+			if deadline, ok := ctx.Deadline(); ok && due[c.key()].Before(deadline) {
+				// restart watch, so that it ends when this Certificate is due
+				return nil
+			}
+		case "DELETED":
+			c := &certificate{}
+			if err = json.Unmarshal(ev.Object, c); err == nil {
+				// Secret is removed by garbage collector, as Certificate owns it
+				delete(due, c.key())
+			}
+		case "ERROR":
+			// typically resource version that is too old, full resync follows
+			ae := &apiError{}
+			_ = json.Unmarshal(ev.Object, ae)
+			return ae
+		}
+	}
+}
+
+func (d *controllerData) run(ctx context.Context) error {
+	for {
+		list := &certificateList{}
+		err := d.kc.do(ctx, "GET", certificatesPath(d.namespace), "", nil, list)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && d.once {
+			return err
+		}
+		next := time.Now().Add(d.resync)
+		if err != nil {
+			_, _ = fmt.Fprintf(d.errw, "Listing of Certificates failed: %v\n", err)
+			next = time.Now().Add(d.retry)
+		} else {
+			due := map[string]time.Time{}
+			failed := 0
+			for _, c := range list.Items {
+				if d.reconcileOne(ctx, c, due) != nil {
+					failed++
+				}
+			}
+			if d.once {
+				if failed > 0 {
+					return fmt.Errorf("reconciliation of %d out of %d Certificates failed", failed, len(list.Items))
+				}
+				return nil
+			}
+			wctx, cancel := context.WithDeadline(ctx, nextDue(due, next))
+			err = d.watch(wctx, list.Metadata.ResourceVersion, due)
+			cancel()
+			if err == nil || ctx.Err() != nil {
+				// watch ended because renewal or resync is due, or API server closed it
+				continue
+			}
+			_, _ = fmt.Fprintf(d.errw, "Watch of Certificates ended: %v\n", err)
+			next = time.Now().Add(d.retry)
+		}
+		if !wait(ctx, next) {
+			return nil
+		}
+	}
+}
+
+// nextDue gets the earliest time any Certificate is due, but no later than limit.
+func nextDue(due map[string]time.Time, limit time.Time) time.Time {
+	for _, t := range due {
+		if t.Before(limit) {
+			limit = t
+		}
+	}
+	return limit
+}
+
+// wait waits until given time or until context is cancelled, returning false in the latter case.
+func wait(ctx context.Context, until time.Time) bool {
+	t := time.NewTimer(time.Until(until))
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func controller(ctx context.Context, d *controllerData) error {
+	opts, err := common.KeyShareOptions(d.keyShares)
+	if err != nil {
+		return err
+	}
+	d.cm = certmgr.New(d.dir, opts...)
+	for _, ca := range d.cas {
+		cert, err := d.cm.GetCert(ctx, ca)
+		if err != nil {
+			return err
+		}
+		if !cert.IsCA {
+			return fmt.Errorf("certificate '%s' is not CA", ca)
+		}
+	}
+	if d.kc, err = connect(d.kubeconfig); err != nil {
+		return err
+	}
+	return d.run(ctx)
+}
+
+func validateController(d *controllerData) error {
+	if len(d.cas) == 0 {
+		return errors.New("at least one CA is required")
+	}
+	if d.resync <= 0 || d.retry <= 0 {
+		return errors.New("resync and retry intervals must be positive")
+	}
+	if len(d.kubeconfig) == 0 && len(os.Getenv("KUBERNETES_SERVICE_HOST")) == 0 {
+		if d.kubeconfig = os.Getenv("KUBECONFIG"); len(d.kubeconfig) == 0 {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			d.kubeconfig = filepath.Join(home, ".kube", "config")
+		}
+		// only the first file of the list is used
+		d.kubeconfig, _, _ = strings.Cut(d.kubeconfig, string(filepath.ListSeparator))
+	}
+	return nil
+}
+
+func newControllerSubCommand(w io.Writer) *cobra.Command {
+	d := &controllerData{
+		w:      w,
+		dir:    ".",
+		bits:   2048,
+		resync: 10 * time.Minute,
+		retry:  time.Minute,
+	}
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Short: "Issue certificates requested by Certificate resources into Secrets and renew them before they expire",
+		Long: "Watch Certificate resources (" + crdGroup + "/" + crdVersion + ", see 'k8s crd') and keep their Secrets of type\n" +
+			"kubernetes.io/tls populated with certificates issued from stored CA. Certificate is reissued when its spec or CA\n" +
+			"changes and when renewBefore of its lifetime is left. Secrets are owned by Certificates and removed along with them.\n" +
+			"Service account of pod is used in cluster, kubeconfig elsewhere.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateController(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return controller(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.kubeconfig, "kubeconfig", d.kubeconfig, "Kubeconfig to connect with outside of cluster, "+
+		"defaults to $KUBECONFIG or ~/.kube/config. Only tokens and client certificates are supported")
+	cmd.Flags().StringVar(&d.namespace, "namespace", d.namespace, "Namespace to watch, all namespaces when empty")
+	cmd.Flags().StringSliceVar(&d.cas, "ca", d.cas, "Alias of CA that Certificates may request, can be repeated. "+
+		"The first one is used when Certificate doesn't name any")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().DurationVar(&d.resync, "resync-interval", d.resync, "How often all Certificates are reconciled, even without changes")
+	cmd.Flags().DurationVar(&d.retry, "retry-interval", d.retry, "How long to wait before failed reconciliation is retried")
+	cmd.Flags().BoolVar(&d.once, "once", d.once, "Reconcile all Certificates once and exit, like from CronJob")
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"github.com/spf13/cobra"
+	"io"
+)
+
+// crdManifest is definition of Certificate resource reconciled by controller.
+const crdManifest = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.` + crdGroup + `
+spec:
+  group: ` + crdGroup + `
+  names:
+    kind: Certificate
+    listKind: CertificateList
+    plural: certificates
+    singular: certificate
+    shortNames:
+      - pkicert
+  scope: Namespaced
+  versions:
+    - name: ` + crdVersion + `
+      served: true
+      storage: true
+      subresources:
+        status: {}
+      additionalPrinterColumns:
+        - name: Secret
+          type: string
+          jsonPath: .spec.secretName
+        - name: Ready
+          type: string
+          jsonPath: .status.conditions[?(@.type=="Ready")].status
+        - name: Expires
+          type: string
+          jsonPath: .status.notAfter
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required:
+                - secretName
+              properties:
+                secretName:
+                  type: string
+                  description: Name of Secret of type kubernetes.io/tls to write certificate to
+                subject:
+                  type: object
+                  properties:
+                    commonName:
+                      type: string
+                    organization:
+                      type: array
+                      items:
+                        type: string
+                    organizationalUnit:
+                      type: array
+                      items:
+                        type: string
+                    country:
+                      type: array
+                      items:
+                        type: string
+                    province:
+                      type: array
+                      items:
+                        type: string
+                    locality:
+                      type: array
+                      items:
+                        type: string
+                dnsNames:
+                  type: array
+                  items:
+                    type: string
+                ipAddresses:
+                  type: array
+                  items:
+                    type: string
+                profile:
+                  type: string
+                  description: Profile of certificate, like tls-server
+                ca:
+                  type: string
+                  description: Alias of CA to issue from, the default CA of controller when empty
+                duration:
+                  type: string
+                  description: Lifetime of certificate, like 2160h
+                renewBefore:
+                  type: string
+                  description: How long before expiry certificate is renewed, a third of duration by default
+            status:
+              type: object
+              properties:
+                observedGeneration:
+                  type: integer
+                serialNumber:
+                  type: string
+                notAfter:
+                  type: string
+                renewalTime:
+                  type: string
+                conditions:
+                  type: array
+                  items:
+                    type: object
+                    properties:
+                      type:
+                        type: string
+                      status:
+                        type: string
+                      reason:
+                        type: string
+                      message:
+                        type: string
+                      lastTransitionTime:
+                        type: string
+`
+
+func newCrdSubCommand(w io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:   "crd",
+		Short: "Print definition of Certificate resource used by controller",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := io.WriteString(w, crdManifest)
+			return err
+		},
+	}
+}
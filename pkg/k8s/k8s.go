@@ -0,0 +1,39 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package k8s issues certificates for workloads running in Kubernetes.
+package k8s
+
+import (
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "k8s",
+		Short: "Issue certificates for Kubernetes workloads",
+		// subcommands write manifests, secrets and files, there is no result to render
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return common.RequireTable(cmd)
+		},
+	}
+	cmd.AddCommand(newControllerSubCommand(out))
+	cmd.AddCommand(newCrdSubCommand(out))
+	cmd.AddCommand(newWebhookCertsSubCommand(out))
+	return cmd
+}
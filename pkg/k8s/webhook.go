@@ -0,0 +1,196 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"time"
+)
+
+type webhookCertsData struct {
+	w             io.Writer
+	errw          io.Writer
+	dir           string
+	service       string
+	namespace     string
+	clusterDomain string
+	parent        string
+	alias         string
+	secretName    string
+	bits          int
+	days          int
+}
+
+type objectMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type secret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+// serviceNames gets DNS names API server may use to reach service, from the shortest one.
+func serviceNames(service, namespace, clusterDomain string) []string {
+	names := []string{
+		service,
+		service + "." + namespace,
+		service + "." + namespace + ".svc",
+	}
+	if len(clusterDomain) > 0 {
+		names = append(names, service+"."+namespace+".svc."+clusterDomain)
+	}
+	return names
+}
+
+func pemCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// webhookCerts issues serving certificate of webhook service and writes Secret manifest with it.
+// CA bundle that API server should verify webhook with is written to error output, so that manifest can be piped to kubectl.
+func webhookCerts(ctx context.Context, d *webhookCertsData) error {
+	cm := certmgr.New(d.dir, certmgr.WithProgress(common.NewSpinner(d.errw)))
+	names := serviceNames(d.service, d.namespace, d.clusterDomain)
+	cd := &certmgr.CertData{
+		KeySize:     d.bits,
+		Validity:    time.Duration(d.days) * 24 * time.Hour,
+		Alias:       d.alias,
+		ParentAlias: d.parent,
+		Subject:     pkix.Name{CommonName: names[2]},
+		DNSSan:      names,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	// replace any previous certificate, so that command can be re-run to rotate it
+	if err := cm.Delete(ctx, d.alias); err != nil {
+		return err
+	}
+	if err := cm.NewLeaf(ctx, cd); err != nil {
+		return err
+	}
+	ph, err := cm.Get(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	key, err := certmgr.MarshalKeyPEM(ph.Key)
+	if err != nil {
+		return err
+	}
+	chain, err := cm.GetChain(ctx, d.alias)
+	if err != nil {
+		return err
+	}
+	var tlsCrt []byte
+	for _, e := range chain[:max(len(chain)-1, 1)] {
+		tlsCrt = append(tlsCrt, pemCert(e.Cert)...)
+	}
+	caBundle := pemCert(chain[len(chain)-1].Cert)
+	s := &secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: d.secretName, Namespace: d.namespace},
+		Type:       "kubernetes.io/tls",
+		StringData: map[string]string{
+			"tls.crt": string(tlsCrt),
+			"tls.key": string(key),
+			"ca.crt":  string(caBundle),
+		},
+	}
+	enc := yaml.NewEncoder(d.w)
+	enc.SetIndent(2)
+	if err = enc.Encode(s); err != nil {
+		return err
+	}
+	if err = enc.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.errw, "Set clientConfig.caBundle of webhook configuration to:\n%s\n",
+		base64.StdEncoding.EncodeToString(caBundle))
+	return err
+}
+
+func validateWebhookCerts(d *webhookCertsData) error {
+	if len(d.service) == 0 {
+		return errors.New("service name is required")
+	}
+	if len(d.namespace) == 0 {
+		return errors.New("namespace is required")
+	}
+	if len(d.parent) == 0 {
+		return certmgr.ErrParentAliasMissing
+	}
+	if d.days <= 0 {
+		return fmt.Errorf("%w: %d days", certmgr.ErrInvalidValidity, d.days)
+	}
+	if len(d.alias) == 0 {
+		d.alias = d.service + "." + d.namespace + ".svc"
+	}
+	if len(d.secretName) == 0 {
+		d.secretName = d.service + "-tls"
+	}
+	return nil
+}
+
+func newWebhookCertsSubCommand(w io.Writer) *cobra.Command {
+	d := &webhookCertsData{
+		w:             w,
+		dir:           ".",
+		namespace:     "default",
+		clusterDomain: "cluster.local",
+		bits:          2048,
+		days:          365,
+	}
+	cmd := &cobra.Command{
+		Use:   "webhook-certs",
+		Short: "Issue serving certificate of admission webhook and print its Secret manifest and caBundle",
+		Long: "Issue serving certificate of admission webhook service, valid for all DNS names API server may use to reach it.\n" +
+			"Secret manifest is written to output, base64 encoded caBundle for webhook configuration to error output.\n" +
+			"Previous certificate with the same alias is replaced.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateWebhookCerts(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return webhookCerts(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.service, "service", d.service, "Name of webhook service")
+	cmd.Flags().StringVar(&d.namespace, "namespace", d.namespace, "Namespace of webhook service")
+	cmd.Flags().StringVar(&d.clusterDomain, "cluster-domain", d.clusterDomain, "Cluster domain, empty to leave fully qualified name out")
+	cmd.Flags().StringVar(&d.parent, "parent", d.parent, "Alias of parent (issuing) CA certificate")
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias of issued certificate, defaults to <service>.<namespace>.svc")
+	cmd.Flags().StringVar(&d.secretName, "secret-name", d.secretName, "Name of Secret, defaults to <service>-tls")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.days, "days", d.days, "How many days should certificate be valid for")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
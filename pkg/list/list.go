@@ -17,40 +17,97 @@ limitations under the License.
 package list
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
 	"github.com/spf13/cobra"
 	"io"
-	"pkitool/pkg/certmgr"
-	"pkitool/pkg/common"
+	"slices"
+	"strings"
+	"time"
 )
 
 type listData struct {
-	w   io.Writer
-	dir string
+	w      io.Writer
+	dir    string
+	limit  int
+	offset int
+	page   int
+	of     common.OutputFormat
+	cm     common.ColorMode
 }
 
-func list(d *listData) error {
-	cm := certmgr.New(d.dir)
-	ents, err := cm.List()
+type listEntry struct {
+	Alias   string        `json:"alias" yaml:"alias"`
+	Subject string        `json:"subject" yaml:"subject"`
+	Issuer  string        `json:"issuer" yaml:"issuer"`
+	ValidTo time.Time     `json:"validTo" yaml:"validTo"`
+	IsCA    bool          `json:"isCA" yaml:"isCA"`
+	Status  common.Status `json:"status" yaml:"status"`
+}
+
+func list(ctx context.Context, d *listData) error {
+	var cm certmgr.Reader = certmgr.New(d.dir)
+	s, err := cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true, Offset: d.offset, Limit: d.limit})
 	if err != nil {
 		return err
 	}
-	tbl := tablewriter.NewWriter(d.w)
-	tbl.SetHeader([]string{
-		"Subject", "Issuer", "Valid to",
+	defer func() {
+		_ = s.Close()
+	}()
+	now := time.Now()
+	res := make([]listEntry, 0)
+	for s.Next() {
+		e := s.Entry()
+		res = append(res, listEntry{
+			Alias:   e.Alias,
+			Subject: e.Cert.Subject.String(),
+			Issuer:  e.Cert.Issuer.String(),
+			ValidTo: e.Cert.NotAfter,
+			IsCA:    e.Cert.IsCA,
+			Status:  common.StatusOf(e.Cert, now),
+		})
+	}
+	if err = s.Err(); err != nil {
+		return err
+	}
+	// stream yields entries in no particular order
+	slices.SortFunc(res, func(a, b listEntry) int {
+		return strings.Compare(a.Alias, b.Alias)
 	})
-	for _, ent := range ents {
-		ch, err := cm.Get(ent)
-		if err != nil {
-			return err
-		}
-		tbl.Append([]string{
-			ch.Cert.Subject.String(),
-			ch.Cert.Issuer.String(),
-			ch.Cert.NotAfter.String(),
+	c := common.NewColorizer(d.cm, d.w)
+	if err = common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Subject", "Issuer", "Valid to", "Type", "Status",
 		})
+		for _, e := range res {
+			tbl.Append([]string{e.Subject, e.Issuer, e.ValidTo.String(), c.Kind(e.IsCA), c.Status(e.Status)})
+		}
+	}); err != nil {
+		return err
+	}
+	if d.of == common.OutputTable && s.Total() >= 0 {
+		_, err = fmt.Fprintf(d.w, "Showing %d of %d certificates, skipped %d\n", len(res), s.Total(), d.offset)
+	}
+	return err
+}
+
+func validate(d *listData, cmd *cobra.Command) error {
+	if d.limit < 0 || d.offset < 0 || d.page < 0 {
+		return errors.New("limit, offset and page can't be negative")
+	}
+	if d.page > 0 {
+		if d.limit == 0 {
+			return errors.New("--page requires --limit")
+		}
+		if cmd.Flags().Changed("offset") {
+			return errors.New("--page and --offset are mutually exclusive")
+		}
+		d.offset = (d.page - 1) * d.limit
 	}
-	tbl.Render()
 	return nil
 }
 
@@ -62,10 +119,18 @@ func NewCommand(w io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all certificates in given directory",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validate(d, cmd)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return list(d)
+			d.of = common.OutputFormatOf(cmd)
+			d.cm = common.ColorModeOf(cmd)
+			return list(cmd.Context(), d)
 		},
 	}
+	cmd.Flags().IntVar(&d.limit, "limit", d.limit, "Maximum number of certificates to list, sorted by alias. Zero means no limit")
+	cmd.Flags().IntVar(&d.offset, "offset", d.offset, "Number of certificates to skip, sorted by alias")
+	cmd.Flags().IntVar(&d.page, "page", d.page, "Page of certificates to list, starting with 1. Page size is given by --limit")
 	common.AddDirFlag(&d.dir, cmd.Flags())
 	return cmd
 }
@@ -0,0 +1,153 @@
+//go:build linux
+
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is where journald accepts native protocol datagrams
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalHandler sends records to journald using its native protocol, attributes become journal fields.
+type journalHandler struct {
+	conn  *net.UnixConn
+	tag   string
+	level slog.Leveler
+	// attrs are fields added by WithAttrs, already encoded
+	attrs  []byte
+	prefix string
+}
+
+func newJournalHandler(o *Options, ho *slog.HandlerOptions) (closingHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalHandler{conn: conn, tag: o.Tag, level: ho.Level}, nil
+}
+
+// priority maps level to syslog priority used by journal.
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// fieldName converts attribute key into valid journal field name, which consists of upper case letters, digits and underscores.
+func fieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	// fields starting with underscore are trusted, they can only be set by journald itself
+	name = strings.TrimLeft(name, "_")
+	if len(name) == 0 || name[0] <= '9' {
+		name = "F" + name
+	}
+	return name
+}
+
+// writeField encodes field, values spanning multiple lines are prefixed by their length.
+func writeField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+func (h *journalHandler) writeAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if len(a.Key) > 0 {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range v.Group() {
+			h.writeAttr(buf, prefix, ga)
+		}
+		return
+	}
+	if len(a.Key) > 0 {
+		writeField(buf, fieldName(prefix+a.Key), v.String())
+	}
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", r.Message)
+	writeField(&buf, "PRIORITY", strconv.Itoa(priority(r.Level)))
+	writeField(&buf, "SYSLOG_IDENTIFIER", h.tag)
+	buf.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.writeAttr(&buf, h.prefix, a)
+		return true
+	})
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var buf bytes.Buffer
+	buf.Write(h.attrs)
+	for _, a := range attrs {
+		h.writeAttr(&buf, h.prefix, a)
+	}
+	c := *h
+	c.attrs = buf.Bytes()
+	return &c
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return h
+	}
+	c := *h
+	c.prefix += name + "_"
+	return &c
+}
+
+func (h *journalHandler) Close() error {
+	return h.conn.Close()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging sends logs of server modes to stderr, syslog or systemd journal, with structured fields.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/spf13/pflag"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	SinkNone    = "none"
+	SinkStderr  = "stderr"
+	SinkSyslog  = "syslog"
+	SinkJournal = "journald"
+
+	defaultTag = "pkitool"
+)
+
+// Options selects where logs go.
+type Options struct {
+	// Sink is one of none, stderr, syslog and journald
+	Sink string
+	// Level is minimal level of logged records, one of debug, info, warn and error
+	Level string
+	// Address of syslog server, like udp://10.0.0.1:514. Local syslog daemon is used when empty
+	Address string
+	// Tag identifies program in syslog and journal
+	Tag string
+}
+
+// AddFlags adds flags to set options.
+func AddFlags(o *Options, pf *pflag.FlagSet) {
+	if len(o.Sink) == 0 {
+		o.Sink = SinkNone
+	}
+	if len(o.Level) == 0 {
+		o.Level = "info"
+	}
+	if len(o.Tag) == 0 {
+		o.Tag = defaultTag
+	}
+	pf.StringVar(&o.Sink, "log-sink", o.Sink, "Where to send logs of requests and certificate lifecycle events, one of none, stderr, syslog, journald")
+	pf.StringVar(&o.Level, "log-level", o.Level, "Minimal level of logged records, one of debug, info, warn, error")
+	pf.StringVar(&o.Address, "syslog-address", o.Address, "Address of syslog server, like udp://10.0.0.1:514 or tcp://logs.acme.tld:601. "+
+		"Local syslog daemon is used when not set")
+	pf.StringVar(&o.Tag, "log-tag", o.Tag, "Program name reported to syslog or journal")
+}
+
+// closingHandler is handler holding connection to sink.
+type closingHandler interface {
+	slog.Handler
+	io.Closer
+}
+
+// nopCloser is returned for sinks that don't need closing.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// New creates logger writing to configured sink. Closer should be called once logger is no longer used.
+func New(o *Options) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.Level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level: %s", o.Level)
+	}
+	ho := &slog.HandlerOptions{Level: level}
+	switch o.Sink {
+	case SinkNone, "":
+		return slog.New(slog.NewTextHandler(io.Discard, ho)), nopCloser{}, nil
+	case SinkStderr:
+		return slog.New(slog.NewTextHandler(os.Stderr, ho)), nopCloser{}, nil
+	case SinkSyslog:
+		h, err := newSyslogHandler(o, ho)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't connect to syslog: %w", err)
+		}
+		return slog.New(h), h, nil
+	case SinkJournal:
+		h, err := newJournalHandler(o, ho)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't connect to systemd journal: %w", err)
+		}
+		return slog.New(h), h, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log sink: %s", o.Sink)
+	}
+}
+
+// lineHandler formats record as single logfmt line, which is passed to send along with level of record.
+// Time and level are left out, since receiving side records them on its own.
+type lineHandler struct {
+	h      slog.Handler
+	shared *lineBuffer
+}
+
+type lineBuffer struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	send func(level slog.Level, line string) error
+}
+
+func newLineHandler(ho *slog.HandlerOptions, send func(level slog.Level, line string) error) *lineHandler {
+	lb := &lineBuffer{send: send}
+	return &lineHandler{
+		shared: lb,
+		h: slog.NewTextHandler(&lb.buf, &slog.HandlerOptions{
+			Level: ho.Level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}),
+	}
+}
+
+func (h *lineHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *lineHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.shared.mu.Lock()
+	defer h.shared.mu.Unlock()
+	h.shared.buf.Reset()
+	if err := h.h.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.shared.send(r.Level, strings.TrimSuffix(h.shared.buf.String(), "\n"))
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lineHandler{h: h.h.WithAttrs(attrs), shared: h.shared}
+}
+
+func (h *lineHandler) WithGroup(name string) slog.Handler {
+	return &lineHandler{h: h.h.WithGroup(name), shared: h.shared}
+}
+
+// EventHook creates hook of certificate manager that logs lifecycle events.
+func EventHook(l *slog.Logger) certmgr.Hook {
+	return func(ctx context.Context, ev *certmgr.Event) error {
+		attrs := []any{"event", string(ev.Type), "alias", ev.Alias}
+		if ev.Cert != nil {
+			attrs = append(attrs, "serial", ev.Cert.SerialNumber.String(), "subject", ev.Cert.Subject.String())
+		}
+		l.InfoContext(ctx, "certificate "+string(ev.Type), attrs...)
+		return nil
+	}
+}
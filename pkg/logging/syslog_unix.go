@@ -0,0 +1,76 @@
+//go:build !windows && !plan9
+
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"log/slog"
+	"log/syslog"
+	"net"
+	"strings"
+)
+
+type syslogHandler struct {
+	*lineHandler
+	w *syslog.Writer
+}
+
+// syslogAddress splits address like udp://10.0.0.1:514 into network and address. UDP and port 514 are default.
+func syslogAddress(address string) (string, string) {
+	if len(address) == 0 {
+		return "", ""
+	}
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok {
+		network, addr = "udp", address
+	}
+	if strings.HasPrefix(network, "unix") {
+		return network, addr
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "514")
+	}
+	return network, addr
+}
+
+func newSyslogHandler(o *Options, ho *slog.HandlerOptions) (closingHandler, error) {
+	network, addr := syslogAddress(o.Address)
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, o.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHandler{
+		w: w,
+		lineHandler: newLineHandler(ho, func(level slog.Level, line string) error {
+			switch {
+			case level >= slog.LevelError:
+				return w.Err(line)
+			case level >= slog.LevelWarn:
+				return w.Warning(line)
+			case level >= slog.LevelInfo:
+				return w.Info(line)
+			default:
+				return w.Debug(line)
+			}
+		}),
+	}, nil
+}
+
+func (h *syslogHandler) Close() error {
+	return h.w.Close()
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes certificates in store as Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"strconv"
+	"time"
+)
+
+const namespace = "pkitool"
+
+var (
+	certInfoDesc = prometheus.NewDesc(namespace+"_certificate_info",
+		"Information about certificate, value is always 1.",
+		[]string{"alias", "subject", "issuer", "serial", "is_ca"}, nil)
+	certExpiryDesc = prometheus.NewDesc(namespace+"_certificate_expiry_seconds",
+		"Seconds until certificate expires, negative when already expired.",
+		[]string{"alias"}, nil)
+	certNotAfterDesc = prometheus.NewDesc(namespace+"_certificate_not_after_timestamp_seconds",
+		"Time when certificate expires, as Unix timestamp.",
+		[]string{"alias"}, nil)
+	certRevokedDesc = prometheus.NewDesc(namespace+"_certificate_revoked",
+		"Whether certificate is revoked (1) or not (0).",
+		[]string{"alias"}, nil)
+	certKeyBitsDesc = prometheus.NewDesc(namespace+"_certificate_key_bits",
+		"Size of public key of certificate, in bits.",
+		[]string{"alias", "key_type"}, nil)
+	storeCertsDesc = prometheus.NewDesc(namespace+"_store_certificates",
+		"Number of certificates in store.",
+		nil, nil)
+)
+
+// Collector reads certificates from store on every scrape, so metrics reflect changes made by other processes.
+type Collector struct {
+	ctx      context.Context
+	cm       certmgr.Reader
+	scrapes  prometheus.Counter
+	failures prometheus.Counter
+	duration prometheus.Gauge
+}
+
+// NewCollector creates collector of metrics about certificates readable by cm.
+func NewCollector(ctx context.Context, cm certmgr.Reader) *Collector {
+	return &Collector{
+		ctx: ctx,
+		cm:  cm,
+		scrapes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_scrapes_total",
+			Help:      "Number of times store was read.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_scrape_errors_total",
+			Help:      "Number of errors encountered while reading store.",
+		}),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "store_scrape_duration_seconds",
+			Help:      "Duration of last read of store.",
+		}),
+	}
+}
+
+// keyBits gets type and size of public key.
+func keyBits(cert *x509.Certificate) (string, int) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ecdsa", pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "ed25519", 256
+	default:
+		return "unknown", 0
+	}
+}
+
+func (sc *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certInfoDesc
+	ch <- certExpiryDesc
+	ch <- certNotAfterDesc
+	ch <- certRevokedDesc
+	ch <- certKeyBitsDesc
+	ch <- storeCertsDesc
+	sc.scrapes.Describe(ch)
+	sc.failures.Describe(ch)
+	sc.duration.Describe(ch)
+}
+
+func (sc *Collector) collectCert(ch chan<- prometheus.Metric, e *certmgr.ListEntry, now time.Time) error {
+	rev, err := sc.cm.GetRevocation(sc.ctx, e.Alias)
+	if err != nil {
+		return err
+	}
+	revoked := 0.0
+	if rev != nil {
+		revoked = 1
+	}
+	serial := ""
+	if e.Cert.SerialNumber != nil {
+		serial = e.Cert.SerialNumber.String()
+	}
+	keyType, bits := keyBits(e.Cert)
+	ch <- prometheus.MustNewConstMetric(certInfoDesc, prometheus.GaugeValue, 1,
+		e.Alias, e.Cert.Subject.String(), e.Cert.Issuer.String(), serial, strconv.FormatBool(e.Cert.IsCA))
+	ch <- prometheus.MustNewConstMetric(certExpiryDesc, prometheus.GaugeValue, e.Cert.NotAfter.Sub(now).Seconds(), e.Alias)
+	ch <- prometheus.MustNewConstMetric(certNotAfterDesc, prometheus.GaugeValue, float64(e.Cert.NotAfter.Unix()), e.Alias)
+	ch <- prometheus.MustNewConstMetric(certRevokedDesc, prometheus.GaugeValue, revoked, e.Alias)
+	ch <- prometheus.MustNewConstMetric(certKeyBitsDesc, prometheus.GaugeValue, float64(bits), e.Alias, keyType)
+	return nil
+}
+
+func (sc *Collector) collect(ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	s, err := sc.cm.ListStream(sc.ctx, &certmgr.ListOptions{ParseCert: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	count := 0
+	for s.Next() {
+		count++
+		if err = sc.collectCert(ch, s.Entry(), now); err != nil {
+			sc.failures.Inc()
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(storeCertsDesc, prometheus.GaugeValue, float64(count))
+	return s.Err()
+}
+
+func (sc *Collector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	sc.scrapes.Inc()
+	if err := sc.collect(ch); err != nil {
+		sc.failures.Inc()
+	}
+	sc.duration.Set(time.Since(start).Seconds())
+	sc.scrapes.Collect(ch)
+	sc.failures.Collect(ch)
+	sc.duration.Collect(ch)
+}
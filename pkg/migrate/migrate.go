@@ -0,0 +1,59 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migrate imports CA hierarchies managed by other PKI tools into store.
+package migrate
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+// parseCerts parses all PEM-encoded certificates in data, other PEM blocks are skipped.
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, &certmgr.PEMError{Type: block.Type, Err: err}
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, &certmgr.PEMError{Type: "CERTIFICATE"}
+	}
+	return certs, nil
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Import CA hierarchy managed by other PKI tool into store",
+	}
+	cmd.AddCommand(newStepCASubCommand(out))
+	cmd.AddCommand(newVaultPKISubCommand(out))
+	return cmd
+}
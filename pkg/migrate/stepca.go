@@ -0,0 +1,244 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stepRootKey is where "step ca init" stores root CA key. It's not referenced by ca.json, since step-ca doesn't need it.
+const stepRootKey = "secrets/root_ca_key"
+
+type stepCAData struct {
+	w                 io.Writer
+	dir               string
+	stepPath          string
+	passwordFile      string
+	rootAlias         string
+	intermediateAlias string
+}
+
+// stepCAConfig is subset of step-ca configuration (ca.json) describing CA hierarchy.
+type stepCAConfig struct {
+	// Root is path to root certificate, or list of paths
+	Root     json.RawMessage `json:"root"`
+	Crt      string          `json:"crt"`
+	Key      string          `json:"key"`
+	Password string          `json:"password"`
+	DB       *struct {
+		Type       string `json:"type"`
+		DataSource string `json:"dataSource"`
+	} `json:"db"`
+}
+
+// roots gets paths of root certificates.
+func (c *stepCAConfig) roots() ([]string, error) {
+	var one string
+	if err := json.Unmarshal(c.Root, &one); err == nil {
+		return []string{one}, nil
+	}
+	var all []string
+	if err := json.Unmarshal(c.Root, &all); err != nil {
+		return nil, fmt.Errorf("invalid root in step-ca configuration: %w", err)
+	}
+	return all, nil
+}
+
+// defaultStepPath gets location of step configuration, the same way as step CLI does.
+func defaultStepPath() string {
+	if p := os.Getenv("STEPPATH"); len(p) > 0 {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".step"
+	}
+	return filepath.Join(home, ".step")
+}
+
+// resolve makes path from step-ca configuration absolute. Relative paths are relative to step path.
+func resolve(stepPath, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(stepPath, p)
+}
+
+// isKMS checks whether key is URI of key held by KMS, like "awskms:key-id=..." or "pkcs11:...".
+func isKMS(key string) bool {
+	scheme, _, found := strings.Cut(key, ":")
+	return found && len(scheme) > 1 && !strings.ContainsAny(scheme, `/\`)
+}
+
+// readCerts reads all certificates from PEM file.
+func readCerts(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCerts(data)
+	if err != nil {
+		var pe *certmgr.PEMError
+		if errors.As(err, &pe) {
+			pe.File = file
+		}
+		return nil, err
+	}
+	return certs, nil
+}
+
+// readKey reads private key from file, returns nil if file doesn't exist.
+func readKey(file string, password []byte) (crypto.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	key, err := certmgr.ParseEncryptedKeyPEM(data, password)
+	if err != nil {
+		var pe *certmgr.PEMError
+		if errors.As(err, &pe) {
+			pe.File = file
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+func importStepCA(ctx context.Context, d *stepCAData) error {
+	data, err := os.ReadFile(filepath.Join(d.stepPath, "config", "ca.json"))
+	if err != nil {
+		return err
+	}
+	var cfg stepCAConfig
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid step-ca configuration: %w", err)
+	}
+	password := []byte(cfg.Password)
+	if len(d.passwordFile) > 0 {
+		if password, err = os.ReadFile(d.passwordFile); err != nil {
+			return err
+		}
+		password = []byte(strings.TrimRight(string(password), "\r\n"))
+	}
+	intermediates, err := readCerts(resolve(d.stepPath, cfg.Crt))
+	if err != nil {
+		return err
+	}
+	rootFiles, err := cfg.roots()
+	if err != nil {
+		return err
+	}
+	// find root that issued intermediate, ca.json may list several of them
+	var root *x509.Certificate
+	for _, f := range rootFiles {
+		certs, err := readCerts(resolve(d.stepPath, f))
+		if err != nil {
+			return err
+		}
+		if intermediates[len(intermediates)-1].CheckSignatureFrom(certs[0]) == nil {
+			root = certs[0]
+			break
+		}
+	}
+	if root == nil {
+		return fmt.Errorf("%w: none of roots in step-ca configuration issued %s", certmgr.ErrChainBroken, intermediates[0].Subject)
+	}
+	rootKey, err := readKey(resolve(d.stepPath, stepRootKey), password)
+	if err != nil {
+		return err
+	}
+	var key crypto.Signer
+	if isKMS(cfg.Key) {
+		if _, err = fmt.Fprintf(d.w, "Key of intermediate CA is held by KMS (%s), importing certificate only\n", cfg.Key); err != nil {
+			return err
+		}
+	} else if key, err = readKey(resolve(d.stepPath, cfg.Key), password); err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	if err = cm.Import(ctx, d.rootAlias, root, rootKey, nil); err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(d.w, "Imported root CA '%s' (%s), private key: %t\n", d.rootAlias, root.Subject, rootKey != nil); err != nil {
+		return err
+	}
+	if err = cm.Import(ctx, d.intermediateAlias, intermediates[0], key, intermediates[1:]); err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(d.w, "Imported intermediate CA '%s' (%s), private key: %t\n", d.intermediateAlias, intermediates[0].Subject, key != nil); err != nil {
+		return err
+	}
+	if cfg.DB != nil && len(cfg.DB.Type) > 0 {
+		_, err = fmt.Fprintf(d.w, "Certificates issued by step-ca are kept in its %s database (%s), which can't be imported; they remain valid under imported hierarchy\n",
+			cfg.DB.Type, resolve(d.stepPath, cfg.DB.DataSource))
+	}
+	return err
+}
+
+func validateStepCA(d *stepCAData) error {
+	if len(d.stepPath) == 0 {
+		return errors.New("step path is required")
+	}
+	if len(d.rootAlias) == 0 || len(d.intermediateAlias) == 0 || d.rootAlias == d.intermediateAlias {
+		return errors.New("root and intermediate aliases must be given and differ")
+	}
+	return nil
+}
+
+func newStepCASubCommand(w io.Writer) *cobra.Command {
+	d := &stepCAData{
+		w:                 w,
+		dir:               ".",
+		stepPath:          defaultStepPath(),
+		rootAlias:         "step-root-ca",
+		intermediateAlias: "step-intermediate-ca",
+	}
+	cmd := &cobra.Command{
+		Use:   "step-ca",
+		Short: "Import root and intermediate CA of smallstep step-ca, together with their keys",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateStepCA(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return importStepCA(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.stepPath, "step-path", d.stepPath, "Step path, the directory containing config/ca.json. Defaults to $STEPPATH or ~/.step")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password of CA private keys. When not given, password from ca.json is used, if any")
+	cmd.Flags().StringVar(&d.rootAlias, "root-alias", d.rootAlias, "Alias to store root CA under")
+	cmd.Flags().StringVar(&d.intermediateAlias, "intermediate-alias", d.intermediateAlias, "Alias to store intermediate CA under")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/vault"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"slices"
+)
+
+type vaultPKIData struct {
+	w           io.Writer
+	dir         string
+	mount       string
+	aliasPrefix string
+	vault       vault.Options
+	of          common.OutputFormat
+	cm          common.ColorMode
+}
+
+// vaultCert is response of PKI engine to read of certificate.
+type vaultCert struct {
+	Data struct {
+		Certificate    string `json:"certificate"`
+		RevocationTime int64  `json:"revocation_time"`
+	} `json:"data"`
+}
+
+// imported is outcome of import of single certificate.
+type imported struct {
+	Alias   string `json:"alias" yaml:"alias"`
+	Subject string `json:"subject" yaml:"subject"`
+	IsCA    bool   `json:"isCA" yaml:"isCA"`
+	Status  string `json:"status" yaml:"status"`
+}
+
+// aliasOf derives alias from serial number, which is the only identity Vault keeps for certificates.
+func (d *vaultPKIData) aliasOf(cert *x509.Certificate) string {
+	return fmt.Sprintf("%s%x", d.aliasPrefix, cert.SerialNumber.Bytes())
+}
+
+// issuersFirst orders certificates so that every issuer precedes certificates it issued,
+// which lets store link them into chains as they are imported.
+func issuersFirst(certs []*x509.Certificate) []*x509.Certificate {
+	var res []*x509.Certificate
+	for len(certs) > 0 {
+		i := slices.IndexFunc(certs, func(c *x509.Certificate) bool {
+			return !slices.ContainsFunc(certs, func(p *x509.Certificate) bool {
+				return p != c && p.IsCA && c.CheckSignatureFrom(p) == nil
+			})
+		})
+		if i < 0 {
+			// cycle, like cross-signed CAs; keep remaining ones in original order
+			return append(res, certs...)
+		}
+		res = append(res, certs[i])
+		certs = slices.Delete(slices.Clone(certs), i, i+1)
+	}
+	return res
+}
+
+// fetchCerts reads CA chain and all certificates issued by PKI engine, along with revocation times of the latter.
+func fetchCerts(ctx context.Context, c *vault.Client, mount string) ([]*x509.Certificate, map[string]int64, error) {
+	var chain vaultCert
+	if err := c.Do(ctx, http.MethodGet, mount+"/cert/ca_chain", nil, &chain); err != nil {
+		return nil, nil, fmt.Errorf("can't read CA chain of %s: %w", mount, err)
+	}
+	certs, err := parseCerts([]byte(chain.Data.Certificate))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read CA chain of %s: %w", mount, err)
+	}
+	serials, err := c.List(ctx, mount+"/certs")
+	if err != nil {
+		return nil, nil, err
+	}
+	revoked := make(map[string]int64)
+	for _, serial := range serials {
+		var vc vaultCert
+		if err = c.Do(ctx, http.MethodGet, mount+"/cert/"+serial, nil, &vc); err != nil {
+			return nil, nil, err
+		}
+		found, err := parseCerts([]byte(vc.Data.Certificate))
+		if err != nil {
+			return nil, nil, fmt.Errorf("certificate %s: %w", serial, err)
+		}
+		if !slices.ContainsFunc(certs, func(c *x509.Certificate) bool {
+			return c.SerialNumber.Cmp(found[0].SerialNumber) == 0 && c.Issuer.String() == found[0].Issuer.String()
+		}) {
+			certs = append(certs, found[0])
+		}
+		if vc.Data.RevocationTime > 0 {
+			revoked[found[0].SerialNumber.String()] = vc.Data.RevocationTime
+		}
+	}
+	return certs, revoked, nil
+}
+
+func importVaultPKI(ctx context.Context, d *vaultPKIData) error {
+	c, err := vault.NewClient(&d.vault)
+	if err != nil {
+		return err
+	}
+	certs, revoked, err := fetchCerts(ctx, c, d.mount)
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	var res []imported
+	for _, cert := range issuersFirst(certs) {
+		e := imported{Alias: d.aliasOf(cert), Subject: cert.Subject.String(), IsCA: cert.IsCA, Status: "imported"}
+		if err = cm.Import(ctx, e.Alias, cert, nil, nil); err != nil {
+			if !errors.Is(err, certmgr.ErrAliasExists) {
+				return err
+			}
+			e.Status = "exists"
+		}
+		if _, ok := revoked[cert.SerialNumber.String()]; ok {
+			// Vault doesn't keep revocation reason, and store records time of revocation itself
+			if err = cm.Revoke(ctx, e.Alias, certmgr.ReasonUnspecified); err != nil && !errors.Is(err, certmgr.ErrAlreadyRevoked) {
+				return err
+			}
+			e.Status += ", revoked"
+		}
+		res = append(res, e)
+	}
+	col := common.NewColorizer(d.cm, d.w)
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Alias", "Subject", "Type", "Status",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range res {
+			tbl.Append([]string{e.Alias, e.Subject, col.Kind(e.IsCA), e.Status})
+		}
+	})
+}
+
+func newVaultPKISubCommand(w io.Writer) *cobra.Command {
+	d := &vaultPKIData{
+		w:           w,
+		dir:         ".",
+		mount:       "pki",
+		aliasPrefix: "vault-",
+	}
+	cmd := &cobra.Command{
+		Use:   "vault-pki",
+		Short: "Import CA chain and issued certificates of HashiCorp Vault PKI secrets engine",
+		Long: "Import CA chain and certificates issued by HashiCorp Vault PKI secrets engine, stored under alias made of prefix and serial number.\n" +
+			"Private keys are not imported, since Vault never discloses them.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if d.mount = vault.MountPath(d.mount); len(d.mount) == 0 {
+				return errors.New("mount path of PKI engine is required")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			d.cm = common.ColorModeOf(cmd)
+			return importVaultPKI(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.mount, "mount", d.mount, "Mount path of PKI secrets engine")
+	cmd.Flags().StringVar(&d.aliasPrefix, "alias-prefix", d.aliasPrefix, "Prefix of aliases of imported certificates, followed by hexadecimal serial number")
+	vault.AddFlags(&d.vault, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
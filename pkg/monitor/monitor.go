@@ -0,0 +1,338 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package monitor checks that remote TLS endpoints serve expected certificates from store.
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"gopkg.in/yaml.v3"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileName is name of file with monitored endpoints, within directory with certificates.
+const FileName = "monitors.yaml"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Settings apply to every monitor, unless overridden by monitor itself.
+type Settings struct {
+	// WarningDays and CriticalDays are thresholds of remaining validity of served certificate
+	WarningDays  int `yaml:"warningDays,omitempty"`
+	CriticalDays int `yaml:"criticalDays,omitempty"`
+	// MinTLSVersion is the lowest protocol version endpoint may accept, like "1.2"
+	MinTLSVersion string        `yaml:"minTLSVersion,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	// SystemRoots adds trusted roots of system to root CAs from store when chain is verified, for public endpoints
+	SystemRoots *bool `yaml:"systemRoots,omitempty"`
+}
+
+// Monitor is single endpoint to check.
+type Monitor struct {
+	// Name identifies monitor in output, defaults to address
+	Name    string `yaml:"name,omitempty"`
+	Address string `yaml:"address"`
+	// ServerName is sent in SNI and verified against certificate, defaults to host of address
+	ServerName string `yaml:"serverName,omitempty"`
+	// Alias of certificate in store that endpoint should serve
+	Alias string `yaml:"alias,omitempty"`
+	// Fingerprint is SHA-256 fingerprint of certificate that endpoint should serve, colons are optional
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+	Settings    `yaml:",inline"`
+}
+
+// File is content of monitors.yaml.
+type File struct {
+	Defaults Settings  `yaml:"defaults,omitempty"`
+	Monitors []Monitor `yaml:"monitors"`
+}
+
+// PathFor gets path of file with monitors in given directory.
+func PathFor(dir string) string {
+	return filepath.Join(dir, FileName)
+}
+
+// Load reads monitors from file and fills in defaults.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	if err = yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid monitors file %s: %w", path, err)
+	}
+	for i := range f.Monitors {
+		if err = f.Monitors[i].complete(&f.Defaults); err != nil {
+			return nil, fmt.Errorf("invalid monitor #%d in %s: %w", i+1, path, err)
+		}
+	}
+	return f, nil
+}
+
+// complete validates monitor and fills in defaults.
+func (m *Monitor) complete(def *Settings) error {
+	host, _, err := net.SplitHostPort(m.Address)
+	if err != nil {
+		return err
+	}
+	if len(m.Name) == 0 {
+		m.Name = m.Address
+	}
+	if len(m.ServerName) == 0 {
+		m.ServerName = host
+	}
+	m.Fingerprint = strings.ToLower(strings.ReplaceAll(m.Fingerprint, ":", ""))
+	if len(m.Fingerprint) > 0 && len(m.Fingerprint) != 2*sha256.Size {
+		return fmt.Errorf("invalid SHA-256 fingerprint: %s", m.Fingerprint)
+	}
+	m.WarningDays = firstNonZero(m.WarningDays, def.WarningDays, 30)
+	m.CriticalDays = firstNonZero(m.CriticalDays, def.CriticalDays, 7)
+	m.Timeout = firstNonZero(m.Timeout, def.Timeout, 10*time.Second)
+	m.MinTLSVersion = firstNonZero(m.MinTLSVersion, def.MinTLSVersion, "1.2")
+	if _, ok := tlsVersions[m.MinTLSVersion]; !ok {
+		return fmt.Errorf("unsupported TLS version: %s", m.MinTLSVersion)
+	}
+	if m.SystemRoots == nil {
+		m.SystemRoots = def.SystemRoots
+	}
+	if m.CriticalDays > m.WarningDays {
+		return errors.New("critical threshold must not be greater than warning threshold")
+	}
+	return nil
+}
+
+func firstNonZero[T comparable](values ...T) T {
+	var zero T
+	for _, v := range values {
+		if v != zero {
+			return v
+		}
+	}
+	return zero
+}
+
+// State is outcome of check, ordered by severity.
+type State int
+
+const (
+	StateOK State = iota
+	StateWarning
+	StateCritical
+)
+
+var stateNames = map[State]string{
+	StateOK:       "OK",
+	StateWarning:  "WARNING",
+	StateCritical: "CRITICAL",
+}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Finding is single problem found at endpoint.
+type Finding struct {
+	State   State  `json:"state" yaml:"state"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// Result is outcome of checks of single monitor.
+type Result struct {
+	Name     string     `json:"name" yaml:"name"`
+	Address  string     `json:"address" yaml:"address"`
+	State    State      `json:"state" yaml:"state"`
+	Protocol string     `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Subject  string     `json:"subject,omitempty" yaml:"subject,omitempty"`
+	ValidTo  *time.Time `json:"validTo,omitempty" yaml:"validTo,omitempty"`
+	// Fingerprint is SHA-256 fingerprint of served certificate
+	Fingerprint string    `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Findings    []Finding `json:"findings,omitempty" yaml:"findings,omitempty"`
+}
+
+func (r *Result) add(s State, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{State: s, Message: fmt.Sprintf(format, args...)})
+	r.State = max(r.State, s)
+}
+
+// summary gets findings as single line, used to detect drift between runs.
+func (r *Result) summary() string {
+	msgs := make([]string, len(r.Findings))
+	for i, f := range r.Findings {
+		msgs[i] = f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func handshake(ctx context.Context, m *Monitor, minVersion, maxVersion uint16) (*tls.ConnectionState, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: m.Timeout},
+		Config: &tls.Config{
+			ServerName: m.ServerName,
+			MinVersion: minVersion,
+			MaxVersion: maxVersion,
+			// chain is verified separately, against CAs in store
+			InsecureSkipVerify: true, //nolint:gosec
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", m.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	cs := conn.(*tls.Conn).ConnectionState()
+	return &cs, nil
+}
+
+// checker checks monitors against certificates in store.
+type checker struct {
+	cm certmgr.Reader
+	// roots are self-signed CAs in store
+	roots []*x509.Certificate
+	now   time.Time
+}
+
+func (c *checker) check(ctx context.Context, m *Monitor) *Result {
+	r := &Result{Name: m.Name, Address: m.Address}
+	minVersion := tlsVersions[m.MinTLSVersion]
+	cs, err := handshake(ctx, m, tls.VersionTLS10, 0)
+	if err != nil {
+		r.add(StateCritical, "handshake failed: %v", err)
+		return r
+	}
+	r.Protocol = tls.VersionName(cs.Version)
+	if len(cs.PeerCertificates) == 0 {
+		r.add(StateCritical, "no certificate presented")
+		return r
+	}
+	leaf := cs.PeerCertificates[0]
+	fp := sha256.Sum256(leaf.Raw)
+	r.Subject, r.ValidTo, r.Fingerprint = leaf.Subject.String(), &leaf.NotAfter, hex.EncodeToString(fp[:])
+	if cs.Version < minVersion {
+		r.add(StateCritical, "negotiated %s, below minimum TLS %s", r.Protocol, m.MinTLSVersion)
+	} else if minVersion > tls.VersionTLS10 {
+		if weak, err := handshake(ctx, m, tls.VersionTLS10, minVersion-1); err == nil {
+			r.add(StateWarning, "accepts %s, below minimum TLS %s", tls.VersionName(weak.Version), m.MinTLSVersion)
+		}
+	}
+	c.checkExpiry(m, r, leaf)
+	if err = leaf.VerifyHostname(m.ServerName); err != nil {
+		r.add(StateCritical, "certificate is not valid for %s", m.ServerName)
+	}
+	if len(m.Fingerprint) > 0 && m.Fingerprint != r.Fingerprint {
+		r.add(StateCritical, "certificate fingerprint %s doesn't match expected %s", r.Fingerprint, m.Fingerprint)
+	}
+	roots := c.roots
+	if len(m.Alias) > 0 {
+		if roots, err = c.checkAlias(ctx, m, r, cs.PeerCertificates); err != nil {
+			r.add(StateCritical, "%v", err)
+			return r
+		}
+	}
+	pool := x509.NewCertPool()
+	if m.SystemRoots != nil && *m.SystemRoots {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			r.add(StateCritical, "can't load system roots: %v", err)
+			return r
+		}
+	}
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	// without any CA in store, chain can only be verified against system roots
+	if len(roots) > 0 || (m.SystemRoots != nil && *m.SystemRoots) {
+		inter := x509.NewCertPool()
+		for _, e := range cs.PeerCertificates[1:] {
+			inter.AddCert(e)
+		}
+		if _, err = leaf.Verify(x509.VerifyOptions{Roots: pool, Intermediates: inter, CurrentTime: c.now}); err != nil {
+			r.add(StateCritical, "presented chain doesn't verify: %v", err)
+		}
+	}
+	return r
+}
+
+func (c *checker) checkExpiry(m *Monitor, r *Result, leaf *x509.Certificate) {
+	days := int(math.Floor(leaf.NotAfter.Sub(c.now).Hours() / 24))
+	switch {
+	case c.now.After(leaf.NotAfter):
+		r.add(StateCritical, "certificate expired at %s", leaf.NotAfter.Format(time.DateOnly))
+	case c.now.Before(leaf.NotBefore):
+		r.add(StateCritical, "certificate is not valid before %s", leaf.NotBefore.Format(time.DateOnly))
+	case days < m.CriticalDays:
+		r.add(StateCritical, "certificate expires in %d days", days)
+	case days < m.WarningDays:
+		r.add(StateWarning, "certificate expires in %d days", days)
+	}
+}
+
+// checkAlias compares presented chain with chain of alias in store. Root of stored chain is returned,
+// so that presented chain is verified against it. Nil is returned when stored chain is broken.
+func (c *checker) checkAlias(ctx context.Context, m *Monitor, r *Result, presented []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain, err := c.cm.GetChain(ctx, m.Alias)
+	if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+		return nil, err
+	}
+	stored := chain[0].Cert
+	if !bytes.Equal(stored.Raw, presented[0].Raw) {
+		msg := fmt.Sprintf("serves certificate with serial %s instead of '%s' with serial %s",
+			presented[0].SerialNumber, m.Alias, stored.SerialNumber)
+		if stored.NotBefore.After(presented[0].NotBefore) {
+			msg += ", renewed certificate is not deployed"
+		}
+		r.add(StateCritical, "%s", msg)
+	}
+	// intermediates are expected in chain, trust anchor isn't
+	for _, e := range chain[1:max(len(chain)-1, 1)] {
+		found := false
+		for _, p := range presented[1:] {
+			found = found || bytes.Equal(p.Raw, e.Cert.Raw)
+		}
+		if !found {
+			r.add(StateWarning, "intermediate CA '%s' is missing from presented chain", e.Alias)
+		}
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return []*x509.Certificate{chain[len(chain)-1].Cert}, nil
+}
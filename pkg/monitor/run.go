@@ -0,0 +1,237 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type runData struct {
+	w           io.Writer
+	errw        io.Writer
+	of          common.OutputFormat
+	dir         string
+	file        string
+	names       []string
+	interval    time.Duration
+	concurrency int
+}
+
+// storeRoots gets self-signed CAs in store, which chains of monitors without alias are verified against.
+func storeRoots(ctx context.Context, cm certmgr.Reader) ([]*x509.Certificate, error) {
+	aliases, err := cm.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var res []*x509.Certificate
+	for _, alias := range aliases {
+		cert, err := cm.GetCert(ctx, alias)
+		if err != nil {
+			return nil, err
+		}
+		if cert.IsCA && bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			res = append(res, cert)
+		}
+	}
+	return res, nil
+}
+
+// selected gets monitors to run, all of them when no names are given.
+func selected(f *File, names []string) ([]*Monitor, error) {
+	var res []*Monitor
+	for i := range f.Monitors {
+		m := &f.Monitors[i]
+		if len(names) == 0 || slices.Contains(names, m.Name) {
+			res = append(res, m)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no monitor matches %s", strings.Join(names, ", "))
+	}
+	return res, nil
+}
+
+// checkAll checks monitors concurrently, results are in the same order as monitors.
+func checkAll(ctx context.Context, d *runData, monitors []*Monitor) ([]*Result, error) {
+	cm := certmgr.New(d.dir)
+	roots, err := storeRoots(ctx, cm)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{cm: cm, roots: roots, now: time.Now()}
+	res := make([]*Result, len(monitors))
+	sem := make(chan struct{}, d.concurrency)
+	var wg sync.WaitGroup
+	for i, m := range monitors {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, m *Monitor) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res[i] = c.check(ctx, m)
+		}(i, m)
+	}
+	wg.Wait()
+	return res, nil
+}
+
+func render(d *runData, res []*Result) error {
+	return common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Name", "Address", "State", "Valid to", "Protocol", "Findings"})
+		tbl.SetAutoWrapText(false)
+		for _, r := range res {
+			validTo := ""
+			if r.ValidTo != nil {
+				validTo = r.ValidTo.Format(time.DateOnly)
+			}
+			tbl.Append([]string{r.Name, r.Address, r.State.String(), validTo, r.Protocol, strings.Join(strings.Split(r.summary(), "; "), "\n")})
+		}
+	})
+}
+
+// worst gets the most severe state among results.
+func worst(res []*Result) State {
+	s := StateOK
+	for _, r := range res {
+		s = max(s, r.State)
+	}
+	return s
+}
+
+// watch checks monitors periodically and reports drift, that is every monitor whose state or findings changed
+// since previous check. All monitors are reported on the first check.
+func watch(ctx context.Context, d *runData, monitors []*Monitor) error {
+	last := map[string]string{}
+	for {
+		res, err := checkAll(ctx, d, monitors)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			_, _ = fmt.Fprintf(d.errw, "%s %v\n", time.Now().Format(time.RFC3339), err)
+		}
+		for _, r := range res {
+			if ctx.Err() != nil {
+				return nil
+			}
+			current := r.State.String() + ": " + r.summary()
+			if prev, ok := last[r.Name]; ok && prev == current {
+				continue
+			}
+			last[r.Name] = current
+			msg := r.summary()
+			if len(msg) == 0 {
+				msg = "served certificate matches expectations"
+			}
+			if _, err = fmt.Fprintf(d.w, "%s %s %s (%s): %s\n",
+				time.Now().Format(time.RFC3339), r.State, r.Name, r.Address, msg); err != nil {
+				return err
+			}
+		}
+		t := time.NewTimer(d.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func newRunSubCommand(w io.Writer) *cobra.Command {
+	d := &runData{
+		w:           w,
+		dir:         ".",
+		concurrency: 8,
+	}
+	cmd := &cobra.Command{
+		Use:   "run [names...]",
+		Short: "Check endpoints listed in monitors.yaml against certificates in store",
+		Long: "Check that endpoints listed in monitors.yaml serve expected certificates with valid chain and enough remaining\n" +
+			"validity, and that they don't accept protocol versions below minimum.\n" +
+			"Exit code is 0, 1 or 2 for the worst state found, OK, WARNING or CRITICAL.\n" +
+			"With --interval, endpoints are checked periodically and only changes in their state are reported.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if d.concurrency < 1 {
+				return fmt.Errorf("invalid concurrency: %d", d.concurrency)
+			}
+			if d.interval < 0 {
+				return fmt.Errorf("invalid interval: %v", d.interval)
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			d.errw = cmd.ErrOrStderr()
+			if len(d.file) == 0 {
+				d.file = PathFor(d.dir)
+			}
+			f, err := Load(d.file)
+			if err != nil {
+				return err
+			}
+			monitors, err := selected(f, args)
+			if err != nil {
+				return err
+			}
+			if d.interval > 0 {
+				return watch(cmd.Context(), d, monitors)
+			}
+			res, err := checkAll(cmd.Context(), d, monitors)
+			if err != nil {
+				return err
+			}
+			if err = render(d, res); err != nil {
+				return err
+			}
+			if s := worst(res); s != StateOK {
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: int(s)}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&d.file, "file", d.file, "File with monitors, monitors.yaml within directory with certificates by default")
+	cmd.Flags().DurationVar(&d.interval, "interval", d.interval, "Check periodically at given interval and report drift, like 5m")
+	cmd.Flags().IntVar(&d.concurrency, "concurrency", d.concurrency, "How many endpoints to check at once")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "monitor",
+		Short: "Monitor certificates served by remote endpoints",
+	}
+	cmd.AddCommand(newRunSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify sends alerts about expiring certificates to webhooks, Slack or e-mail.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	TypeWebhook = "webhook"
+	TypeSlack   = "slack"
+	TypeEmail   = "email"
+
+	defaultMessage = `{{if .Expired}}Certificate '{{.Alias}}' ({{.Subject}}) expired on {{.NotAfter.Format "2006-01-02"}}` +
+		`{{else}}Certificate '{{.Alias}}' ({{.Subject}}) expires in {{.Days}} days, on {{.NotAfter.Format "2006-01-02"}}{{end}}`
+	defaultSubject = `{{if .Expired}}Certificate '{{.Alias}}' expired{{else}}Certificate '{{.Alias}}' expires in {{.Days}} days{{end}}`
+)
+
+// DefaultThresholds are used when configuration doesn't specify any.
+var DefaultThresholds = []int{30, 7, 1}
+
+// Alert is data about certificate that crossed expiry threshold, it is also data of message templates.
+type Alert struct {
+	Alias     string    `json:"alias"`
+	Subject   string    `json:"subject"`
+	Serial    string    `json:"serial"`
+	NotAfter  time.Time `json:"notAfter"`
+	Days      int       `json:"days"`
+	Threshold int       `json:"threshold"`
+	Expired   bool      `json:"expired"`
+}
+
+// Notifier delivers alert to single channel.
+type Notifier interface {
+	Notify(ctx context.Context, a *Alert) error
+}
+
+// message renders alert using template.
+type message struct {
+	tmpl *template.Template
+}
+
+func newMessage(name, text, def string) (*message, error) {
+	if len(text) == 0 {
+		text = def
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of %s notifier: %w", name, err)
+	}
+	return &message{tmpl: tmpl}, nil
+}
+
+func (m *message) render(a *Alert) (string, error) {
+	var sb strings.Builder
+	if err := m.tmpl.Execute(&sb, a); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// post sends JSON payload to URL and checks that it was accepted.
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected HTTP status from %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// webhook posts alert as JSON document, along with rendered message.
+type webhook struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+	msg     *message
+}
+
+func (w *webhook) Notify(ctx context.Context, a *Alert) error {
+	text, err := w.msg.render(a)
+	if err != nil {
+		return err
+	}
+	return post(ctx, w.client, w.url, w.headers, struct {
+		*Alert
+		Message string `json:"message"`
+	}{a, text})
+}
+
+// slack posts rendered message to Slack incoming webhook.
+type slack struct {
+	client *http.Client
+	url    string
+	msg    *message
+}
+
+func (s *slack) Notify(ctx context.Context, a *Alert) error {
+	text, err := s.msg.render(a)
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.client, s.url, nil, map[string]string{"text": text})
+}
+
+// email sends rendered message using SMTP server, STARTTLS is used when server supports it.
+type email struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+	subject  *message
+	msg      *message
+}
+
+func (e *email) Notify(_ context.Context, a *Alert) error {
+	subject, err := e.subject.render(a)
+	if err != nil {
+		return err
+	}
+	text, err := e.msg.render(a)
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From: %s\r\n", e.from)
+	fmt.Fprintf(&sb, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&sb, "Subject: %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprintf(&sb, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	sb.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	sb.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	sb.WriteString("\r\n")
+	var auth smtp.Auth
+	if len(e.username) > 0 {
+		host, _, _ := net.SplitHostPort(e.addr)
+		auth = smtp.PlainAuth("", e.username, e.password, host)
+	}
+	return smtp.SendMail(e.addr, auth, e.from, e.to, []byte(sb.String()))
+}
+
+// New creates notifier from its configuration. Timeout applies to HTTP requests.
+func New(cfg *config.Notifier, timeout time.Duration) (Notifier, error) {
+	switch cfg.Type {
+	case TypeWebhook, TypeSlack:
+		url := cfg.Option("url", "")
+		if len(url) == 0 {
+			return nil, fmt.Errorf("option 'url' of %s notifier is required", cfg.Type)
+		}
+		msg, err := newMessage(cfg.Type, cfg.Template, defaultMessage)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: timeout}
+		if cfg.Type == TypeSlack {
+			return &slack{client: client, url: url, msg: msg}, nil
+		}
+		var headers map[string]string
+		if auth := cfg.Option("authorization", ""); len(auth) > 0 {
+			headers = map[string]string{"Authorization": auth}
+		}
+		return &webhook{client: client, url: url, headers: headers, msg: msg}, nil
+	case TypeEmail:
+		e := &email{
+			addr:     cfg.Option("host", ""),
+			from:     cfg.Option("from", ""),
+			username: cfg.Option("username", ""),
+			password: cfg.Option("password", ""),
+		}
+		for _, to := range strings.Split(cfg.Option("to", ""), ",") {
+			if to = strings.TrimSpace(to); len(to) > 0 {
+				e.to = append(e.to, to)
+			}
+		}
+		if len(e.addr) == 0 || len(e.from) == 0 || len(e.to) == 0 {
+			return nil, errors.New("options 'host', 'from' and 'to' of email notifier are required")
+		}
+		if _, _, err := net.SplitHostPort(e.addr); err != nil {
+			e.addr = net.JoinHostPort(e.addr, "25")
+		}
+		var err error
+		if e.subject, err = newMessage(cfg.Type, cfg.Option("subject", ""), defaultSubject); err != nil {
+			return nil, err
+		}
+		if e.msg, err = newMessage(cfg.Type, cfg.Template, defaultMessage); err != nil {
+			return nil, err
+		}
+		return e, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type: '%s'", cfg.Type)
+	}
+}
+
+// Multi delivers alert to all notifiers.
+type Multi []Notifier
+
+// NewMulti creates all notifiers from configuration.
+func NewMulti(cfg *config.Notify, timeout time.Duration) (Multi, error) {
+	if len(cfg.Notifiers) == 0 {
+		return nil, errors.New("no notifiers are configured")
+	}
+	res := make(Multi, 0, len(cfg.Notifiers))
+	for i := range cfg.Notifiers {
+		n, err := New(&cfg.Notifiers[i], timeout)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// Notify delivers alert to every notifier, even when some of them fail.
+func (m Multi) Notify(ctx context.Context, a *Alert) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.Notify(ctx, a))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package offline
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+type exportRequestData struct {
+	w            io.Writer
+	dir          string
+	pendingDir   string
+	out          string
+	alias        string
+	parent       string
+	intermediate bool
+	subject      pkix.Name
+	dnsSan       []string
+	ipSan        []net.IP
+	profile      string
+	bits         int
+	validYears   int
+	validDays    int
+}
+
+// exportRequest generates private key and CSR, and adds request to bundle. Key is kept in pending directory until response is imported.
+func exportRequest(ctx context.Context, d *exportRequestData) error {
+	cm := certmgr.New(d.dir)
+	if _, err := cm.GetCert(ctx, d.alias); err == nil {
+		return fmt.Errorf("%w: %s", certmgr.ErrAliasExists, d.alias)
+	} else if !errors.Is(err, certmgr.ErrAliasNotFound) {
+		return err
+	}
+	keyFile := pendingKeyPath(d.pendingDir, d.alias)
+	if _, err := os.Stat(keyFile); err == nil {
+		return fmt.Errorf("request for '%s' is already pending, its key is in %s", d.alias, keyFile)
+	}
+	var bundle RequestBundle
+	if _, err := os.Stat(d.out); err == nil {
+		if err = readBundle(d.out, nil, &bundle); err != nil {
+			return err
+		}
+	}
+	cd := &certmgr.CertData{
+		KeySize:         d.bits,
+		Subject:         d.subject,
+		DNSSan:          d.dnsSan,
+		IPSan:           d.ipSan,
+		IsCA:            d.intermediate,
+		NoCommonNameSAN: d.intermediate,
+	}
+	if !d.intermediate {
+		p, err := profiles.Get(d.profile)
+		if err != nil {
+			return err
+		}
+		p.Apply(cd)
+	}
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(d.pendingDir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o400)
+	if err != nil {
+		return err
+	}
+	if err = pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(csr.Key)}); err != nil {
+		_ = f.Close()
+		_ = os.Remove(keyFile)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	r := Request{
+		Alias:      d.alias,
+		Parent:     d.parent,
+		CA:         d.intermediate,
+		ValidYears: d.validYears,
+		ValidDays:  d.validDays,
+		CSR:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.CSR.Raw})),
+	}
+	if !d.intermediate {
+		r.Profile = d.profile
+	}
+	bundle.Requests = append(bundle.Requests, r)
+	if err = writeBundle(d.out, d.w, &bundle); err != nil {
+		_ = os.Remove(keyFile)
+		return err
+	}
+	if d.out == common.StdinMarker {
+		return nil
+	}
+	_, err = fmt.Fprintf(d.w, "Added request for '%s' to %s (%d pending), private key is kept in %s\n",
+		d.alias, d.out, len(bundle.Requests), keyFile)
+	return err
+}
+
+func validateExportRequest(d *exportRequestData) error {
+	if len(d.alias) == 0 {
+		return common.ErrAliasMissing
+	}
+	if len(d.parent) == 0 {
+		return common.ErrParentAliasMissing
+	}
+	if len(d.subject.CommonName) == 0 {
+		return errors.New("subject common name is required")
+	}
+	if len(d.out) == 0 {
+		return errors.New("request bundle file is required")
+	}
+	if d.validYears <= 0 && d.validDays <= 0 {
+		return errors.New("validity must be positive")
+	}
+	if d.intermediate && (len(d.dnsSan) > 0 || len(d.ipSan) > 0) {
+		return errors.New("intermediate CA can't have subject alternative names")
+	}
+	return validatePendingDir(d.dir, &d.pendingDir)
+}
+
+func newExportRequestSubCommand(w io.Writer) *cobra.Command {
+	d := &exportRequestData{
+		w:          w,
+		dir:        ".",
+		profile:    profiles.Default,
+		bits:       4096,
+		validYears: 1,
+	}
+	cmd := &cobra.Command{
+		Use:   "export-request",
+		Short: "Generate private key and add request to sign its certificate to bundle, that is taken to air-gapped machine",
+		Long: "Generate private key and add request to sign its certificate to bundle, that is taken to air-gapped machine.\n" +
+			"Bundle is created when it doesn't exist yet, otherwise request is added to it.\n" +
+			"Private key is kept in pending directory until response is imported.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateExportRequest(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportRequest(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Request bundle file to add request to")
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Alias for new certificate. Must be unique within directory")
+	cmd.Flags().StringVar(&d.parent, "parent", d.parent, "Alias of parent (issuing) CA on air-gapped machine")
+	cmd.Flags().BoolVar(&d.intermediate, "intermediate", d.intermediate, "Whether to request certificate of intermediate CA")
+	cmd.Flags().StringArrayVar(&d.dnsSan, "dns-san", d.dnsSan, "Optional DNS subject alternative name")
+	cmd.Flags().IPSliceVar(&d.ipSan, "ip-san", d.ipSan, "Optional IP subject alternative name")
+	cmd.Flags().StringVar(&d.profile, "profile", d.profile, "Profile of leaf certificate, one of "+strings.Join(profiles.Names(), ", "))
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().IntVar(&d.validYears, "years", d.validYears, "How many years should new certificate be valid for")
+	cmd.Flags().IntVar(&d.validDays, "days", d.validDays, "How many days should new certificate be valid for, takes precedence over years")
+	common.AddDNFlags("subject", &d.subject, cmd.Flags(), "")
+	addPendingDirFlag(&d.pendingDir, cmd)
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
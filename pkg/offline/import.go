@@ -0,0 +1,158 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package offline
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+type importResponseData struct {
+	w          io.Writer
+	in         io.Reader
+	errw       io.Writer
+	dir        string
+	pendingDir string
+	input      string
+	of         common.OutputFormat
+}
+
+// importedEntry is outcome of importing single result.
+type importedEntry struct {
+	Alias   string `json:"alias" yaml:"alias"`
+	Subject string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Issuer  string `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// importResult stores issued certificate along with private key of pending request, which is then removed.
+func importResult(ctx context.Context, d *importResponseData, cm certmgr.Interface, r *Result) (*x509.Certificate, error) {
+	if len(r.Error) > 0 {
+		return nil, errors.New(r.Error)
+	}
+	cert, err := certmgr.ParseCertificatePEM([]byte(r.Certificate))
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for _, c := range r.Chain {
+		ic, err := certmgr.ParseCertificatePEM([]byte(c))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, ic)
+	}
+	keyFile := pendingKeyPath(d.pendingDir, r.Alias)
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := certmgr.ParseEncryptedKeyPEM(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = cm.Import(ctx, r.Alias, cert, key, chain); err != nil {
+		return nil, err
+	}
+	return cert, os.Remove(keyFile)
+}
+
+// importResponse imports all results of response bundle. Returns true when any of them failed.
+// Keys of failed requests are kept, so that their requests can be exported again.
+func importResponse(ctx context.Context, d *importResponseData) (bool, error) {
+	var bundle ResponseBundle
+	if err := readBundle(d.input, d.in, &bundle); err != nil {
+		return false, err
+	}
+	cm := certmgr.New(d.dir, certmgr.WithProgress(common.NewSpinner(d.errw)))
+	var (
+		entries []importedEntry
+		failed  bool
+	)
+	for i := range bundle.Results {
+		r := &bundle.Results[i]
+		e := importedEntry{Alias: r.Alias}
+		cert, err := importResult(ctx, d, cm, r)
+		if cert != nil {
+			e.Subject, e.Issuer = cert.Subject.String(), cert.Issuer.String()
+		}
+		if err != nil {
+			failed = true
+			e.Error = err.Error()
+		}
+		entries = append(entries, e)
+	}
+	return failed, common.Render(d.w, d.of, entries, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Alias", "Subject", "Issuer", "Result"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range entries {
+			result := "imported"
+			if len(e.Error) > 0 {
+				result = "failed: " + e.Error
+			}
+			tbl.Append([]string{e.Alias, e.Subject, e.Issuer, result})
+		}
+	})
+}
+
+func validateImportResponse(d *importResponseData) error {
+	if len(d.input) == 0 {
+		return errors.New("response bundle file is required")
+	}
+	return validatePendingDir(d.dir, &d.pendingDir)
+}
+
+func newImportResponseSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &importResponseData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "import-response",
+		Short: "Store certificates from response bundle signed on air-gapped machine, along with private keys of pending requests",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateImportResponse(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			failed, err := importResponse(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if failed {
+				// failures were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&d.input, "in", d.input, "Response bundle file. Use '-' to read from standard input")
+	addPendingDirFlag(&d.pendingDir, cmd)
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
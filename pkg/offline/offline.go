@@ -0,0 +1,146 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package offline implements signing by CA kept on air-gapped machine.
+// Requests are bundled into portable file on online machine, which keeps private keys.
+// Bundle is signed on air-gapped machine and response is imported back.
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pendingDirName is name of directory within directory with certificates, where private keys of pending requests are kept.
+const pendingDirName = "offline-pending"
+
+// Request is request to sign single certificate.
+type Request struct {
+	Alias string `json:"alias"`
+	// Parent is alias of issuing CA on air-gapped machine
+	Parent string `json:"parent"`
+	// CA requests certificate of intermediate CA
+	CA         bool   `json:"ca,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	ValidYears int    `json:"validYears,omitempty"`
+	ValidDays  int    `json:"validDays,omitempty"`
+	// CSR is PEM-encoded certificate signing request
+	CSR string `json:"csr"`
+}
+
+// RequestBundle is portable file with pending requests.
+type RequestBundle struct {
+	Requests []Request `json:"requests"`
+}
+
+// Result is outcome of single request.
+type Result struct {
+	Alias string `json:"alias"`
+	// Certificate is PEM-encoded issued certificate
+	Certificate string `json:"certificate,omitempty"`
+	// Chain are PEM-encoded certificates of issuing CA and its issuers, up to the root CA
+	Chain []string `json:"chain,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// ResponseBundle is portable file with results of all requests of bundle.
+type ResponseBundle struct {
+	Results []Result `json:"results"`
+}
+
+// readBundle reads JSON bundle from file, or from in when name is "-".
+func readBundle(name string, in io.Reader, v interface{}) error {
+	data, err := common.ReadInput(name, in)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid bundle %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeBundle writes JSON bundle to file, or to w when name is "-".
+// File is written to temporary file first, so that existing one isn't left incomplete on error.
+func writeBundle(name string, w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if name == common.StdinMarker {
+		_, err = w.Write(data)
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".bundle-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// pendingKeyPath gets name of file with private key of pending request.
+func pendingKeyPath(pendingDir, alias string) string {
+	return filepath.Join(pendingDir, alias+".key")
+}
+
+func validatePendingDir(dir string, pendingDir *string) error {
+	if len(*pendingDir) == 0 {
+		// locations that are not directories, like plugins, have no default
+		if strings.Contains(dir, ":") && !filepath.IsAbs(dir) {
+			return errors.New("directory for private keys of pending requests is required, when certificates are not kept in directory")
+		}
+		*pendingDir = filepath.Join(dir, pendingDirName)
+	}
+	return nil
+}
+
+func addPendingDirFlag(pendingDir *string, cmd *cobra.Command) {
+	cmd.Flags().StringVar(pendingDir, "pending-dir", *pendingDir, "Directory with private keys of pending requests, defaults to "+
+		pendingDirName+" within directory with certificates")
+}
+
+func NewCommand(in io.Reader, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "offline",
+		Short: "Sign certificates by CA kept on air-gapped machine",
+		Long: "Sign certificates by CA kept on air-gapped machine:\n" +
+			"1. 'export-request' adds request to bundle on online machine, private key is kept there\n" +
+			"2. 'sign' signs all requests of bundle on air-gapped machine holding key of CA\n" +
+			"3. 'import-response' stores issued certificates on online machine, along with their keys",
+	}
+	cmd.AddCommand(newExportRequestSubCommand(out))
+	cmd.AddCommand(newSignSubCommand(in, out))
+	cmd.AddCommand(newImportResponseSubCommand(in, out))
+	return cmd
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package offline
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/spf13/cobra"
+	"io"
+	"time"
+)
+
+type signData struct {
+	w         io.Writer
+	in        io.Reader
+	dir       string
+	input     string
+	out       string
+	keyShares []string
+	of        common.OutputFormat
+}
+
+// signedEntry describes outcome of single request, for operator of air-gapped machine.
+type signedEntry struct {
+	Alias   string `json:"alias" yaml:"alias"`
+	Parent  string `json:"parent" yaml:"parent"`
+	Subject string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Serial  string `json:"serial,omitempty" yaml:"serial,omitempty"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// signRequest signs single request. Certificate is not stored, it's only returned in response.
+func signRequest(ctx context.Context, cm certmgr.Interface, r *Request) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(r.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("CSR is not PEM encoded certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	opts := &certmgr.SignOptions{
+		ValidYears: r.ValidYears,
+		Validity:   time.Duration(r.ValidDays) * 24 * time.Hour,
+		IsCA:       r.CA,
+	}
+	if !r.CA {
+		name := r.Profile
+		if len(name) == 0 {
+			name = profiles.Default
+		}
+		p, err := profiles.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.UnknownExtKeyUsage) > 0 || p.NoExpiry {
+			return nil, fmt.Errorf("profile %s can't be used for offline signing", name)
+		}
+		opts.ExtKeyUsage = p.ExtKeyUsage
+		opts.Profile = p.Name
+	}
+	return cm.SignCSR(ctx, r.Parent, csr, opts)
+}
+
+// sign signs all requests of bundle and writes response bundle. Returns true when any request failed.
+func sign(ctx context.Context, d *signData) (bool, error) {
+	var bundle RequestBundle
+	if err := readBundle(d.input, d.in, &bundle); err != nil {
+		return false, err
+	}
+	opts, err := common.KeyShareOptions(d.keyShares)
+	if err != nil {
+		return false, err
+	}
+	cm := certmgr.New(d.dir, opts...)
+	var (
+		resp    ResponseBundle
+		entries []signedEntry
+		failed  bool
+	)
+	for i := range bundle.Requests {
+		r := &bundle.Requests[i]
+		res := Result{Alias: r.Alias}
+		e := signedEntry{Alias: r.Alias, Parent: r.Parent}
+		cert, err := signRequest(ctx, cm, r)
+		if err == nil {
+			var chain []*certmgr.PairHolder
+			if chain, err = cm.GetChain(ctx, r.Parent); err == nil {
+				res.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+				for _, c := range chain {
+					res.Chain = append(res.Chain, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})))
+				}
+				e.Subject, e.Serial = cert.Subject.String(), cert.SerialNumber.String()
+			}
+		}
+		if err != nil {
+			failed = true
+			res.Error, e.Error = err.Error(), err.Error()
+		}
+		resp.Results = append(resp.Results, res)
+		entries = append(entries, e)
+	}
+	if err = writeBundle(d.out, d.w, &resp); err != nil {
+		return false, err
+	}
+	if d.out == common.StdinMarker {
+		return failed, nil
+	}
+	return failed, common.Render(d.w, d.of, entries, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{"Alias", "Parent", "Subject", "Serial", "Result"})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range entries {
+			result := "signed"
+			if len(e.Error) > 0 {
+				result = "failed: " + e.Error
+			}
+			tbl.Append([]string{e.Alias, e.Parent, e.Subject, e.Serial, result})
+		}
+	})
+}
+
+func validateSign(d *signData) error {
+	if len(d.input) == 0 {
+		return errors.New("request bundle file is required")
+	}
+	if len(d.out) == 0 {
+		return errors.New("response bundle file is required")
+	}
+	if d.input == common.StdinMarker && d.out == common.StdinMarker {
+		return errors.New("request and response bundles can't be both on standard streams")
+	}
+	return nil
+}
+
+func newSignSubCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &signData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Sign all requests of bundle by CA kept on this (air-gapped) machine and write response bundle",
+		Long: "Sign all requests of bundle by CA kept on this (air-gapped) machine and write response bundle.\n" +
+			"Issued certificates are not stored on this machine, but they are recorded in audit log.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateSign(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.of = common.OutputFormatOf(cmd)
+			failed, err := sign(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if failed {
+				// failures were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&d.input, "in", d.input, "Request bundle file. Use '-' to read from standard input")
+	cmd.Flags().StringVar(&d.out, "out", d.out, "Response bundle file to write. Use '-' to write to standard output")
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package plugin implements simple protocol used to talk to external executables (plugins).
+//
+// Plugin is executed once per request. Request is written as single JSON object to its standard input,
+// response is read as single JSON object from its standard output. Every response may contain
+// "error" field with error message and "notFound" field set to true if requested object does not exist.
+// Binary data are encoded using standard base64 encoding (as produced by encoding/json for []byte).
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Error is error reported by plugin.
+type Error struct {
+	Plugin   string
+	Message  string
+	NotFound bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plugin %s: %s", e.Plugin, e.Message)
+}
+
+// Is makes error reported for missing object match fs.ErrNotExist.
+func (e *Error) Is(target error) bool {
+	return e.NotFound && target == fs.ErrNotExist
+}
+
+type envelope struct {
+	Error    string `json:"error,omitempty"`
+	NotFound bool   `json:"notFound,omitempty"`
+}
+
+// Call executes plugin, passing it JSON-encoded request and decoding its response into resp.
+// Standard error of plugin is passed through.
+func Call(ctx context.Context, path string, req interface{}, resp interface{}) error {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("plugin %s failed: %w", path, err)
+	}
+	var env envelope
+	if err = json.Unmarshal(out.Bytes(), &env); err != nil {
+		return fmt.Errorf("plugin %s returned malformed response: %w", path, err)
+	}
+	if len(env.Error) > 0 || env.NotFound {
+		return &Error{Plugin: path, Message: strings.TrimSpace(env.Error), NotFound: env.NotFound}
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(out.Bytes(), resp)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+)
+
+// PEMType is type of PEM block that references key held by signer plugin.
+const PEMType = "PKITOOL PLUGIN KEY"
+
+const (
+	headerPlugin = "Plugin"
+	headerKeyID  = "Key-Id"
+)
+
+type signerRequest struct {
+	Op     string `json:"op"`
+	KeyID  string `json:"keyId"`
+	Digest []byte `json:"digest,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	PSS    bool   `json:"pss,omitempty"`
+}
+
+type signerResponse struct {
+	PublicKey []byte `json:"publicKey,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Signer is crypto.Signer which delegates signing to external plugin.
+// Private key never leaves plugin, which makes it suitable for HSMs or cloud KMS.
+//
+// Plugin must support two operations:
+//   - "public" - returns "publicKey" with DER-encoded PKIX public key of "keyId"
+//   - "sign" - returns "signature" of "digest" computed using "hash" (like "SHA-256"), "pss" is true for RSA-PSS
+type Signer struct {
+	ctx    context.Context
+	path   string
+	keyID  string
+	public crypto.PublicKey
+}
+
+// NewSigner creates signer backed by plugin executable at path, using key identified by keyID.
+func NewSigner(ctx context.Context, path, keyID string) (*Signer, error) {
+	var resp signerResponse
+	if err := Call(ctx, path, &signerRequest{Op: "public", KeyID: keyID}, &resp); err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{ctx: ctx, path: path, keyID: keyID, public: pub}, nil
+}
+
+// ParsePEM creates signer from PEM block produced by MarshalPEM.
+func ParsePEM(ctx context.Context, block *pem.Block) (*Signer, error) {
+	if block.Type != PEMType {
+		return nil, errors.New("not a plugin key reference")
+	}
+	return NewSigner(ctx, block.Headers[headerPlugin], block.Headers[headerKeyID])
+}
+
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	_, pss := opts.(*rsa.PSSOptions)
+	var resp signerResponse
+	if err := Call(s.ctx, s.path, &signerRequest{
+		Op:     "sign",
+		KeyID:  s.keyID,
+		Digest: digest,
+		Hash:   opts.HashFunc().String(),
+		PSS:    pss,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// MarshalPEM creates PEM block which references key, so that it can be stored in place of private key.
+func (s *Signer) MarshalPEM() (*pem.Block, error) {
+	return &pem.Block{
+		Type: PEMType,
+		Headers: map[string]string{
+			headerPlugin: s.path,
+			headerKeyID:  s.keyID,
+		},
+	}, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package profiles provides named presets of certificate properties, like intended key usage.
+package profiles
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/samber/lo"
+	"slices"
+)
+
+// ErrUnknownProfile is returned when there is no profile with given name.
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// Profile is named set of properties applied to certificate before it's issued.
+type Profile struct {
+	// Name is unique name of profile
+	Name string
+	// Description is short human-readable description
+	Description string
+	// ExtKeyUsage is list of extended key usages of certificate
+	ExtKeyUsage []x509.ExtKeyUsage
+	// UnknownExtKeyUsage is list of extended key usages not known to crypto/x509
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	// NoExpiry makes certificate valid without well-defined expiration date
+	NoExpiry bool
+}
+
+// oidSmartcardLogon is Microsoft smart card logon extended key usage
+var oidSmartcardLogon = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}
+
+// Apply applies profile to certificate data.
+func (p *Profile) Apply(cd *certmgr.CertData) {
+	cd.ExtKeyUsage = slices.Clone(p.ExtKeyUsage)
+	cd.UnknownExtKeyUsage = slices.Clone(p.UnknownExtKeyUsage)
+	cd.NoExpiry = p.NoExpiry
+	cd.Profile = p.Name
+}
+
+var builtin = map[string]*Profile{
+	"tls": {
+		Name:        "tls",
+		Description: "TLS server and client authentication",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	},
+	"tls-server": {
+		Name:        "tls-server",
+		Description: "TLS server authentication",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	},
+	"tls-client": {
+		Name:        "tls-client",
+		Description: "TLS client authentication",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	SmartcardLogon: {
+		Name:               SmartcardLogon,
+		Description:        "Windows smart card logon, needs user principal name and account SID",
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidSmartcardLogon},
+	},
+	"code-signing": {
+		Name:        "code-signing",
+		Description: "Code signing",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	},
+	"eap-tls": {
+		Name:        "eap-tls",
+		Description: "802.1X EAP-TLS client (supplicant) authentication",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	"eap-tls-server": {
+		Name:        "eap-tls-server",
+		Description: "802.1X EAP-TLS authentication server, like FreeRADIUS",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	},
+	"idevid": {
+		Name:        "idevid",
+		Description: "IEEE 802.1AR initial device identity, issued by manufacturer without expiration",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		NoExpiry:    true,
+	},
+	"ldevid": {
+		Name:        "ldevid",
+		Description: "IEEE 802.1AR locally significant device identity",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	"mysql-client": {
+		Name:        "mysql-client",
+		Description: "MySQL client authentication, common name is user name",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	"postgresql-client": {
+		Name:        "postgresql-client",
+		Description: "PostgreSQL client authentication, common name is user name",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	"email": {
+		Name:        "email",
+		Description: "S/MIME email protection",
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	},
+}
+
+const (
+	// Default is name of profile used when none is given.
+	Default = "tls"
+	// SmartcardLogon is name of Windows smart card logon profile
+	SmartcardLogon = "smartcard-logon"
+)
+
+// Get gets profile by name.
+func Get(name string) (*Profile, error) {
+	if p, ok := builtin[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+}
+
+// Names gets sorted names of all known profiles.
+func Names() []string {
+	names := lo.Keys(builtin)
+	slices.Sort(names)
+	return names
+}
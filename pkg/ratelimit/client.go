@@ -0,0 +1,61 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"google.golang.org/grpc/peer"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// clientName gets name of identity, or "ip:<address>" of anonymous client.
+func clientName(id *rbac.Identity, addr string) string {
+	if name := id.Name(); len(name) > 0 {
+		return name
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "ip:" + addr
+}
+
+// SetRetryAfter sets Retry-After header of HTTP response, when err was caused by exceeded limit.
+func SetRetryAfter(w http.ResponseWriter, err error) {
+	var le *Error
+	if errors.As(err, &le) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(le.RetryAfter.Seconds()))))
+	}
+}
+
+// Client identifies caller of HTTP request for rate limiting.
+func Client(r *http.Request) string {
+	return clientName(rbac.IdentityOf(r), r.RemoteAddr)
+}
+
+// GRPCClient identifies caller of gRPC method for rate limiting.
+func GRPCClient(ctx context.Context) string {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	return clientName(rbac.GRPCIdentity(ctx), addr)
+}
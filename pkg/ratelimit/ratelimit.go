@@ -0,0 +1,219 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit protects CA from runaway automation, by limiting how often each client of server can issue
+// certificates (token bucket per client) and how many certificates each CA can issue per period.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPeriod = 24 * time.Hour
+	// maxClients is number of tracked clients above which idle ones are forgotten
+	maxClients = 10000
+)
+
+var (
+	// ErrRateLimited is returned when client exhausted its rate limit.
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrQuotaExceeded is returned when CA issued as many certificates as its quota allows.
+	ErrQuotaExceeded = errors.New("issuance quota exceeded")
+)
+
+// Error is returned when issuance is refused, it tells when it can be retried.
+type Error struct {
+	// Err is either ErrRateLimited or ErrQuotaExceeded
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v, retry in %s", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// bucket is token bucket of single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens accumulated since last refill, up to burst.
+func (b *bucket) refill(now time.Time, l config.RateLimit) {
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Minutes()*l.Rate)
+	b.last = now
+}
+
+// quota keeps times of issuance by single CA within period.
+type quota struct {
+	max    int
+	period time.Duration
+	issued []time.Time
+}
+
+// expire forgets issuances that fell out of period.
+func (q *quota) expire(now time.Time) {
+	i := 0
+	for i < len(q.issued) && now.Sub(q.issued[i]) >= q.period {
+		i++
+	}
+	q.issued = q.issued[i:]
+}
+
+// Limiter enforces limits of clients and quotas of CAs. Nil limiter allows everything.
+type Limiter struct {
+	def     config.RateLimit
+	clients map[string]config.RateLimit
+	quotas  map[string]*quota
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func normalize(l config.RateLimit) config.RateLimit {
+	if l.Burst <= 0 {
+		l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	}
+	return l
+}
+
+// New creates limiter as configured in configuration file. Nil is returned when nothing is limited.
+// Quotas are primed from audit log of cm, so that certificates issued before server started count too.
+func New(ctx context.Context, configPath string, cm certmgr.Reader) (*Limiter, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &cfg.Limits
+	if c.Rate <= 0 && len(c.Clients) == 0 && len(c.Quotas) == 0 {
+		return nil, nil
+	}
+	l := &Limiter{
+		def:     normalize(c.RateLimit),
+		clients: map[string]config.RateLimit{},
+		quotas:  map[string]*quota{},
+		buckets: map[string]*bucket{},
+	}
+	for name, cl := range c.Clients {
+		l.clients[name] = normalize(cl)
+	}
+	var since time.Time
+	for _, q := range c.Quotas {
+		if len(q.CA) == 0 || q.Max <= 0 {
+			return nil, fmt.Errorf("invalid quota of CA '%s': CA and positive max are required", q.CA)
+		}
+		if q.Period <= 0 {
+			q.Period = defaultPeriod
+		}
+		l.quotas[q.CA] = &quota{max: q.Max, period: q.Period}
+		if start := time.Now().Add(-q.Period); since.IsZero() || start.Before(since) {
+			since = start
+		}
+	}
+	if len(l.quotas) > 0 {
+		if err = l.prime(ctx, cm, since); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// prime counts certificates issued since given time, as recorded in audit log.
+func (l *Limiter) prime(ctx context.Context, cm certmgr.Reader, since time.Time) error {
+	records, err := cm.AuditLog(ctx, &certmgr.AuditFilter{Since: since})
+	if err != nil {
+		if errors.Is(err, certmgr.ErrAuditUnsupported) {
+			return nil
+		}
+		return err
+	}
+	now := time.Now()
+	for _, r := range records {
+		if r.Op != certmgr.AuditCreate && r.Op != certmgr.AuditSign {
+			continue
+		}
+		if q, ok := l.quotas[r.Params["parent"]]; ok && now.Sub(r.Time) < q.period {
+			q.issued = append(q.issued, r.Time)
+		}
+	}
+	return nil
+}
+
+// limitOf gets rate limit of client.
+func (l *Limiter) limitOf(client string) config.RateLimit {
+	if cl, ok := l.clients[client]; ok {
+		return cl
+	}
+	return l.def
+}
+
+// forget removes buckets of clients that were idle long enough to be full again.
+func (l *Limiter) forget(now time.Time) {
+	for client, b := range l.buckets {
+		lim := l.limitOf(client)
+		if b.refill(now, lim); b.tokens >= float64(lim.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Allow checks that client can issue another certificate by CA, which is empty for self-signed certificates.
+// Allowed issuance takes token of client and counts towards quota of CA, even when it fails afterwards.
+// Error matching ErrRateLimited or ErrQuotaExceeded is returned otherwise, nothing is taken in such case.
+func (l *Limiter) Allow(client, ca string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	q := l.quotas[ca]
+	if q != nil {
+		if q.expire(now); len(q.issued) >= q.max {
+			return &Error{Err: fmt.Errorf("%w: CA '%s' can issue %d certificates per %s", ErrQuotaExceeded, ca, q.max, q.period),
+				RetryAfter: q.period - now.Sub(q.issued[0])}
+		}
+	}
+	if lim := l.limitOf(client); lim.Rate > 0 {
+		b := l.buckets[client]
+		if b == nil {
+			if len(l.buckets) >= maxClients {
+				l.forget(now)
+			}
+			b = &bucket{tokens: float64(lim.Burst), last: now}
+			l.buckets[client] = b
+		}
+		if b.refill(now, lim); b.tokens < 1 {
+			return &Error{Err: ErrRateLimited, RetryAfter: time.Duration((1 - b.tokens) / lim.Rate * float64(time.Minute))}
+		}
+		b.tokens--
+	}
+	if q != nil {
+		q.issued = append(q.issued, now)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// GRPCIdentity gets identity of caller of gRPC method.
+func GRPCIdentity(ctx context.Context) *Identity {
+	id := &Identity{}
+	if p, ok := peer.FromContext(ctx); ok {
+		if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(ti.State.VerifiedChains) > 0 {
+			id.Cert = ti.State.VerifiedChains[0][0]
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			if t, ok := strings.CutPrefix(v, "Bearer "); ok {
+				id.Token = strings.TrimSpace(t)
+			}
+		}
+	}
+	return id
+}
+
+// check checks that caller was granted role required by method. Methods without role are denied.
+func (a *Authorizer) check(ctx context.Context, roles map[string]Role, method string) error {
+	role, ok := roles[method]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "method %s is not covered by RBAC", method)
+	}
+	if !a.Allowed(GRPCIdentity(ctx), role) {
+		return status.Error(codes.PermissionDenied, fmt.Sprintf("role %s is required", role))
+	}
+	return nil
+}
+
+// ServerOptions gets interceptors that enforce roles required by gRPC methods, given by full method name.
+// Nil authorizer needs no interceptors.
+func (a *Authorizer) ServerOptions(roles map[string]Role) []grpc.ServerOption {
+	if a == nil {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := a.check(ctx, roles, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := a.check(ss.Context(), roles, info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rbac authorizes requests to servers by roles bound to client certificates or API tokens.
+package rbac
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Role grants access to group of operations.
+type Role string
+
+const (
+	// RoleRead allows fetching, listing and watching certificates
+	RoleRead Role = "read"
+	// RoleIssueLeaf allows issuing leaf certificates and signing CSRs
+	RoleIssueLeaf Role = "issue-leaf"
+	// RoleIssueCA allows issuing CA certificates, it implies RoleIssueLeaf
+	RoleIssueCA Role = "issue-ca"
+	// RoleRevoke allows revoking certificates
+	RoleRevoke Role = "revoke"
+	// RoleApprove allows listing and approving requests for sensitive operations
+	RoleApprove Role = "approve"
+	// RoleAdmin allows everything
+	RoleAdmin Role = "admin"
+)
+
+// implied are roles granted by role, in addition to role itself
+var implied = map[Role][]Role{
+	RoleIssueCA: {RoleIssueLeaf},
+	RoleAdmin:   {RoleRead, RoleIssueLeaf, RoleIssueCA, RoleRevoke, RoleApprove},
+}
+
+const (
+	tokenHashPrefix = "sha256:"
+	tokenEnvPrefix  = "env:"
+)
+
+// Policy is content of RBAC file.
+type Policy struct {
+	Bindings []Binding `yaml:"bindings"`
+}
+
+// Binding grants roles to identities.
+type Binding struct {
+	Roles []Role `yaml:"roles"`
+	// Clients are identities of client certificates, matched against common name, DNS names and URIs (like SPIFFE ID)
+	Clients []string `yaml:"clients,omitempty"`
+	// Tokens are API tokens sent as "Authorization: Bearer <token>", either as "sha256:<hex>" hash of token,
+	// or "env:NAME" to read token from environment variable NAME
+	Tokens []string `yaml:"tokens,omitempty"`
+}
+
+// binding is Binding with resolved roles and token hashes.
+type binding struct {
+	roles   []Role
+	clients []string
+	tokens  [][]byte
+}
+
+// Authorizer decides whether identity was granted role. Nil authorizer allows everything.
+type Authorizer struct {
+	bindings []binding
+}
+
+// Load loads policy from YAML file. Identity without any binding is denied everything.
+func Load(path string) (*Authorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err = yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid RBAC file %s: %w", path, err)
+	}
+	a := &Authorizer{}
+	for i, b := range p.Bindings {
+		rb := binding{clients: b.Clients}
+		for _, r := range b.Roles {
+			if _, ok := implied[r]; !ok && r != RoleRead && r != RoleRevoke && r != RoleIssueLeaf && r != RoleApprove {
+				return nil, fmt.Errorf("invalid RBAC file %s: binding #%d: unknown role '%s'", path, i+1, r)
+			}
+			rb.roles = append(append(rb.roles, r), implied[r]...)
+		}
+		for _, t := range b.Tokens {
+			h, err := tokenHash(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RBAC file %s: binding #%d: %w", path, i+1, err)
+			}
+			rb.tokens = append(rb.tokens, h)
+		}
+		a.bindings = append(a.bindings, rb)
+	}
+	return a, nil
+}
+
+// tokenHash gets SHA-256 hash of token given in policy.
+func tokenHash(t string) ([]byte, error) {
+	if v, ok := strings.CutPrefix(t, tokenHashPrefix); ok {
+		h, err := hex.DecodeString(v)
+		if err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("invalid token hash: %s", t)
+		}
+		return h, nil
+	}
+	if name, ok := strings.CutPrefix(t, tokenEnvPrefix); ok {
+		v := os.Getenv(name)
+		if len(v) == 0 {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		h := sha256.Sum256([]byte(v))
+		return h[:], nil
+	}
+	return nil, fmt.Errorf("token must be given as %s<hex> or %sNAME", tokenHashPrefix, tokenEnvPrefix)
+}
+
+// Identity is authenticated caller. Either of fields may be empty.
+type Identity struct {
+	// Cert is verified client certificate
+	Cert *x509.Certificate
+	// Token is API token presented by caller
+	Token string
+}
+
+// names gets identities of client certificate.
+func (id *Identity) names() []string {
+	if id.Cert == nil {
+		return nil
+	}
+	names := append([]string{id.Cert.Subject.CommonName}, id.Cert.DNSNames...)
+	for _, u := range id.Cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// Name gets name that tells operators apart, like in approvals of sensitive operations.
+// It's first non-empty identity of client certificate, or short hash of API token. Anonymous caller has empty name.
+func (id *Identity) Name() string {
+	for _, n := range id.names() {
+		if len(n) > 0 {
+			return n
+		}
+	}
+	if len(id.Token) > 0 {
+		h := sha256.Sum256([]byte(id.Token))
+		return "token:" + hex.EncodeToString(h[:6])
+	}
+	return ""
+}
+
+func (b *binding) matches(id *Identity) bool {
+	for _, n := range id.names() {
+		if len(n) > 0 && slices.Contains(b.clients, n) {
+			return true
+		}
+	}
+	if len(id.Token) > 0 {
+		h := sha256.Sum256([]byte(id.Token))
+		for _, t := range b.tokens {
+			if subtle.ConstantTimeCompare(h[:], t) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed tells whether identity was granted role.
+func (a *Authorizer) Allowed(id *Identity, role Role) bool {
+	if a == nil {
+		return true
+	}
+	for i := range a.bindings {
+		if slices.Contains(a.bindings[i].roles, role) && a.bindings[i].matches(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityOf gets identity of caller of HTTP request.
+func IdentityOf(r *http.Request) *Identity {
+	id := &Identity{}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		id.Cert = r.TLS.VerifiedChains[0][0]
+	}
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		id.Token = strings.TrimSpace(t)
+	}
+	return id
+}
+
+// Permit tells whether caller of HTTP request was granted role.
+func (a *Authorizer) Permit(r *http.Request, role Role) bool {
+	return a.Allowed(IdentityOf(r), role)
+}
+
+// HTTP wraps handler, so that it's only invoked for callers granted role. Others get 403 Forbidden.
+func (a *Authorizer) HTTP(role Role, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Permit(r, role) {
+			http.Error(w, fmt.Sprintf("role %s is required", role), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconcile
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"gopkg.in/yaml.v3"
+	"time"
+)
+
+// Manifest is desired state of store.
+type Manifest struct {
+	// Defaults apply to every certificate that doesn't set property itself
+	Defaults Defaults `yaml:"defaults"`
+	// Certificates are all certificates store should contain, in any order
+	Certificates []Certificate `yaml:"certificates"`
+}
+
+// Defaults are properties shared by certificates of manifest.
+type Defaults struct {
+	// Bits is RSA key size, 2048 when not set
+	Bits int `yaml:"bits,omitempty"`
+	// Years is validity of certificates, 1 when not set
+	Years int `yaml:"years,omitempty"`
+	// Organization is organization of subject DN
+	Organization []string `yaml:"organization,omitempty"`
+}
+
+// Certificate is single desired certificate.
+type Certificate struct {
+	// Alias is unique name of certificate within store
+	Alias string `yaml:"alias"`
+	// Parent is alias of issuing CA, either from manifest or already in store. Root CA has none.
+	Parent string `yaml:"parent,omitempty"`
+	// CA makes certificate root or intermediate CA, depending on whether parent is set
+	CA bool `yaml:"ca,omitempty"`
+	// CommonName of subject DN, alias is used when not set
+	CommonName string `yaml:"commonName,omitempty"`
+	// Organization of subject DN, overrides default
+	Organization []string `yaml:"organization,omitempty"`
+	// SANs are DNS names and IP addresses of leaf certificate
+	SANs []string `yaml:"sans,omitempty"`
+	// Profile of leaf certificate, see "pkitool create leaf --help"
+	Profile string `yaml:"profile,omitempty"`
+	// Bits is RSA key size, overrides default
+	Bits int `yaml:"bits,omitempty"`
+	// Years is validity of certificate, overrides default
+	Years int `yaml:"years,omitempty"`
+	// Days is validity of leaf certificate, takes precedence over years
+	Days int `yaml:"days,omitempty"`
+}
+
+// parseManifest parses manifest and checks that it's consistent.
+// Certificates are reordered so that every CA comes before certificates it issues.
+func parseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	byAlias := map[string]*Certificate{}
+	for i := range m.Certificates {
+		c := &m.Certificates[i]
+		if len(c.Alias) == 0 {
+			return nil, fmt.Errorf("invalid manifest: certificate #%d has no alias", i+1)
+		}
+		if _, dup := byAlias[c.Alias]; dup {
+			return nil, fmt.Errorf("invalid manifest: duplicate alias '%s'", c.Alias)
+		}
+		if !c.CA && len(c.Parent) == 0 {
+			return nil, fmt.Errorf("invalid manifest: leaf certificate '%s' has no parent", c.Alias)
+		}
+		if c.CA && (len(c.Profile) > 0 || len(c.SANs) > 0 || c.Days > 0) {
+			return nil, fmt.Errorf("invalid manifest: CA '%s' can't have profile, SANs or validity in days", c.Alias)
+		}
+		if len(c.Profile) > 0 {
+			if _, err := profiles.Get(c.Profile); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+		}
+		byAlias[c.Alias] = c
+	}
+	ordered := make([]Certificate, 0, len(m.Certificates))
+	// 0 = not visited, 1 = being visited, 2 = done
+	state := map[string]int{}
+	var visit func(c *Certificate) error
+	visit = func(c *Certificate) error {
+		switch state[c.Alias] {
+		case 1:
+			return fmt.Errorf("invalid manifest: issuers of '%s' form a cycle", c.Alias)
+		case 2:
+			return nil
+		}
+		state[c.Alias] = 1
+		if p, ok := byAlias[c.Parent]; ok {
+			if !p.CA {
+				return fmt.Errorf("invalid manifest: parent '%s' of '%s' is not CA", p.Alias, c.Alias)
+			}
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		state[c.Alias] = 2
+		ordered = append(ordered, *c)
+		return nil
+	}
+	for i := range m.Certificates {
+		if err := visit(&m.Certificates[i]); err != nil {
+			return nil, err
+		}
+	}
+	m.Certificates = ordered
+	return m, nil
+}
+
+// certData converts certificate of manifest to data certificate is issued from.
+func (m *Manifest) certData(c *Certificate) (*certmgr.CertData, error) {
+	cd := &certmgr.CertData{
+		KeySize:     firstPositive(c.Bits, m.Defaults.Bits, 2048),
+		ValidYears:  firstPositive(c.Years, m.Defaults.Years, 1),
+		Alias:       c.Alias,
+		ParentAlias: c.Parent,
+		IsCA:        c.CA,
+		Subject: pkix.Name{
+			CommonName:   c.CommonName,
+			Organization: c.Organization,
+		},
+	}
+	if len(cd.Subject.CommonName) == 0 {
+		cd.Subject.CommonName = c.Alias
+	}
+	if len(cd.Subject.Organization) == 0 {
+		cd.Subject.Organization = m.Defaults.Organization
+	}
+	if c.CA {
+		if len(c.Parent) == 0 {
+			cd.SelfSigned = true
+			cd.Issuer = cd.Subject
+		}
+		return cd, nil
+	}
+	if c.Days > 0 {
+		cd.Validity = time.Duration(c.Days) * 24 * time.Hour
+	}
+	for _, san := range c.SANs {
+		cd.AddSAN(san)
+	}
+	name := c.Profile
+	if len(name) == 0 {
+		name = profiles.Default
+	}
+	p, err := profiles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	p.Apply(cd)
+	return cd, nil
+}
+
+func firstPositive(values ...int) int {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
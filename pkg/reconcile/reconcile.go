@@ -0,0 +1,307 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reconcile brings store to state declared by manifest, so that it can be kept in version control.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/escrow"
+	"github.com/rkosegi/pkitool/pkg/webhook"
+	"github.com/spf13/cobra"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// actions of change
+const (
+	actionCreate    = "create"
+	actionRenew     = "renew"
+	actionPrune     = "prune"
+	actionUnchanged = "unchanged"
+	// actionUnmanaged is certificate in store that manifest doesn't mention, kept unless pruning
+	actionUnmanaged = "unmanaged"
+)
+
+type reconcileData struct {
+	w           io.Writer
+	errw        io.Writer
+	in          io.Reader
+	dir         string
+	file        string
+	prune       bool
+	dryRun      bool
+	renewBefore time.Duration
+	workers     int
+	of          common.OutputFormat
+}
+
+// change is planned or applied change of single alias.
+type change struct {
+	Alias   string   `json:"alias" yaml:"alias"`
+	Action  string   `json:"action" yaml:"action"`
+	Reasons []string `json:"reasons,omitempty" yaml:"reasons,omitempty"`
+	Error   string   `json:"error,omitempty" yaml:"error,omitempty"`
+
+	cd *certmgr.CertData
+}
+
+// report is machine-readable outcome of reconciliation.
+type report struct {
+	DryRun  bool     `json:"dryRun" yaml:"dryRun"`
+	Changes []change `json:"changes" yaml:"changes"`
+}
+
+// plan compares manifest with store and decides what to do with every alias.
+func plan(ctx context.Context, d *reconcileData, cm certmgr.Reader, m *Manifest) ([]change, error) {
+	existing, err := cm.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// aliases re-issued by this run, their certificates must be re-issued too
+	reissued := map[string]bool{}
+	var res []change
+	for i := range m.Certificates {
+		c := &m.Certificates[i]
+		cd, err := m.certData(c)
+		if err != nil {
+			return nil, fmt.Errorf("certificate '%s': %w", c.Alias, err)
+		}
+		ch := change{Alias: c.Alias, Action: actionUnchanged, cd: cd}
+		if !slices.Contains(existing, c.Alias) {
+			if len(c.Parent) > 0 && !reissued[c.Parent] && !slices.Contains(existing, c.Parent) {
+				return nil, fmt.Errorf("parent '%s' of '%s' is neither in manifest nor in store", c.Parent, c.Alias)
+			}
+			ch.Action = actionCreate
+		} else if ch.Reasons, err = drift(ctx, d, cm, cd, reissued); err != nil {
+			return nil, fmt.Errorf("certificate '%s': %w", c.Alias, err)
+		} else if len(ch.Reasons) > 0 {
+			ch.Action = actionRenew
+		}
+		if ch.Action != actionUnchanged {
+			reissued[c.Alias] = true
+		}
+		res = append(res, ch)
+	}
+	for _, alias := range existing {
+		if slices.ContainsFunc(m.Certificates, func(c Certificate) bool { return c.Alias == alias }) {
+			continue
+		}
+		ch := change{Alias: alias, Action: actionUnmanaged}
+		if d.prune {
+			ch.Action = actionPrune
+		}
+		res = append(res, ch)
+	}
+	return res, nil
+}
+
+// drift describes why stored certificate doesn't match desired state, if it doesn't.
+func drift(ctx context.Context, d *reconcileData, cm certmgr.Reader, cd *certmgr.CertData, reissued map[string]bool) ([]string, error) {
+	cert, err := cm.GetCert(ctx, cd.Alias)
+	if err != nil {
+		return nil, err
+	}
+	reasons, err := certmgr.Drift(cd, cert)
+	if err != nil {
+		return nil, err
+	}
+	if len(cd.ParentAlias) > 0 {
+		if reissued[cd.ParentAlias] {
+			reasons = append(reasons, fmt.Sprintf("issuer '%s' is re-issued", cd.ParentAlias))
+		} else if parent, err := cm.GetCert(ctx, cd.ParentAlias); err != nil {
+			return nil, err
+		} else if cert.CheckSignatureFrom(parent) != nil {
+			reasons = append(reasons, fmt.Sprintf("not issued by '%s'", cd.ParentAlias))
+		}
+	} else if cert.CheckSignatureFrom(cert) != nil {
+		reasons = append(reasons, "not self-signed")
+	}
+	if time.Now().Add(d.renewBefore).After(cert.NotAfter) {
+		reasons = append(reasons, fmt.Sprintf("expires on %s", cert.NotAfter.Format(time.DateOnly)))
+	}
+	return reasons, nil
+}
+
+// apply carries out planned changes in order. Returns true when any change failed.
+func apply(ctx context.Context, d *reconcileData, cm certmgr.Interface, changes []change) (bool, error) {
+	var cds []*certmgr.CertData
+	for _, ch := range changes {
+		if ch.cd != nil && ch.Action != actionUnchanged {
+			cds = append(cds, ch.cd)
+		}
+	}
+	// keys don't depend on each other, unlike certificates
+	if err := certmgr.GenerateKeys(ctx, common.NewSpinner(d.errw), d.workers, cds...); err != nil {
+		return false, err
+	}
+	failed := false
+	for i := range changes {
+		ch := &changes[i]
+		var err error
+		switch ch.Action {
+		case actionCreate:
+			err = issue(ctx, cm, ch.cd)
+		case actionRenew:
+			// replace certificate in place, parent must be stored before its children are issued
+			if err = cm.Delete(ctx, ch.Alias); err == nil {
+				err = issue(ctx, cm, ch.cd)
+			}
+		case actionPrune:
+			err = cm.Delete(ctx, ch.Alias)
+		}
+		if err != nil {
+			ch.Error = err.Error()
+			failed = true
+		}
+	}
+	return failed, nil
+}
+
+func issue(ctx context.Context, cm certmgr.Issuer, cd *certmgr.CertData) error {
+	switch {
+	case cd.IsCA && len(cd.ParentAlias) == 0:
+		return cm.NewRootCA(ctx, cd)
+	case cd.IsCA:
+		return cm.NewIntermediateCA(ctx, cd)
+	default:
+		return cm.NewLeaf(ctx, cd)
+	}
+}
+
+// reconcile brings store to state of manifest. Returns true when any change failed.
+func reconcile(ctx context.Context, d *reconcileData) (bool, error) {
+	data, err := common.ReadInput(d.file, d.in)
+	if err != nil {
+		return false, err
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		return false, err
+	}
+	opts, err := escrow.Options(config.PathFor(d.dir))
+	if err != nil {
+		return false, err
+	}
+	webhookOpts, err := webhook.Options(config.PathFor(d.dir), d.errw)
+	if err != nil {
+		return false, err
+	}
+	cm := certmgr.New(d.dir, append(opts, webhookOpts...)...)
+	changes, err := plan(ctx, d, cm, m)
+	if err != nil {
+		return false, err
+	}
+	failed := false
+	if !d.dryRun {
+		if failed, err = apply(ctx, d, cm, changes); err != nil {
+			return false, err
+		}
+	}
+	return failed, common.Render(d.w, d.of, &report{DryRun: d.dryRun, Changes: changes}, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Alias", "Action", "Reason", "Result",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		tbl.SetAutoWrapText(false)
+		for _, ch := range changes {
+			result := "ok"
+			switch {
+			case len(ch.Error) > 0:
+				result = "failed: " + ch.Error
+			case d.dryRun && ch.Action != actionUnchanged && ch.Action != actionUnmanaged:
+				result = "planned"
+			}
+			tbl.Append([]string{ch.Alias, ch.Action, strings.Join(ch.Reasons, "\n"), result})
+		}
+	})
+}
+
+func validate(d *reconcileData) error {
+	if len(d.file) == 0 {
+		return errors.New("manifest file is required")
+	}
+	if d.renewBefore < 0 {
+		return errors.New("renew-before can't be negative")
+	}
+	return nil
+}
+
+func NewCommand(in io.Reader, w io.Writer) *cobra.Command {
+	d := &reconcileData{
+		w:   w,
+		in:  in,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Bring store to state declared by YAML manifest",
+		Long: "Bring store to state declared by YAML manifest, so that PKI can be managed from version control.\n" +
+			"Missing certificates are created and those that differ from manifest (subject, SANs, profile, issuer) or expire are re-issued,\n" +
+			"together with everything they issued. Certificates not in manifest are only removed with --prune.\n" +
+			"Report of changes is rendered in format chosen by --output.",
+		Example: `  cat > pki.yaml <<EOF
+  defaults:
+    organization: [Acme]
+  certificates:
+    - alias: rootCA
+      ca: true
+      commonName: Acme Root CA
+      years: 10
+      bits: 4096
+    - alias: web
+      parent: rootCA
+      sans: [web.acme.tld, 10.0.0.10]
+      profile: tls-server
+      days: 90
+  EOF
+  pkitool reconcile -f pki.yaml --dry-run -o json`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validate(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			d.of = common.OutputFormatOf(cmd)
+			failed, err := reconcile(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if failed {
+				// failures were already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&d.file, "file", "f", d.file, "Manifest file. Use '-' to read from standard input")
+	cmd.Flags().BoolVar(&d.prune, "prune", d.prune, "Whether to remove certificates that are not in manifest")
+	cmd.Flags().BoolVar(&d.dryRun, "dry-run", d.dryRun, "Only report what would be changed")
+	cmd.Flags().DurationVar(&d.renewBefore, "renew-before", d.renewBefore, "Re-issue certificates that expire within given duration, like 720h")
+	cmd.Flags().IntVar(&d.workers, "workers", d.workers, "How many keys to generate concurrently, defaults to number of CPUs")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -17,21 +17,33 @@ limitations under the License.
 package remove
 
 import (
+	"context"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/webhook"
 	"github.com/spf13/cobra"
 	"io"
-	"pkitool/pkg/certmgr"
-	"pkitool/pkg/common"
 )
 
 type removeData struct {
-	w     io.Writer
-	dir   string
-	alias string
+	w          io.Writer
+	errw       io.Writer
+	dir        string
+	alias      string
+	postDelete string
 }
 
-func remove(d *removeData) error {
-	cm := certmgr.New(d.dir)
-	return cm.Delete(d.alias)
+func remove(ctx context.Context, d *removeData) error {
+	opts, err := webhook.Options(config.PathFor(d.dir), d.errw)
+	if err != nil {
+		return err
+	}
+	if len(d.postDelete) > 0 {
+		opts = append(opts, certmgr.WithHook(certmgr.ExecHook(d.postDelete, d.w, d.errw)))
+	}
+	cm := certmgr.New(d.dir, opts...)
+	return cm.Delete(ctx, d.alias)
 }
 
 func validate(d *removeData) error {
@@ -50,13 +62,19 @@ func NewCommand(w io.Writer) *cobra.Command {
 		Use:   "remove",
 		Short: "Remove certificate and private key corresponding to provided alias",
 		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
 			return validate(d)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return remove(d)
+			d.errw = cmd.ErrOrStderr()
+			return remove(cmd.Context(), d)
 		},
 	}
 	common.AddDirFlag(&d.dir, cmd.Flags())
 	cmd.Flags().StringVar(&d.alias, "alias", "", "Alias of certificate to show.")
+	cmd.Flags().StringVar(&d.postDelete, "post-delete-exec", d.postDelete, "Shell command to run after certificate is removed. "+
+		"Alias and file names are passed in PKITOOL_ALIAS, PKITOOL_CERT_FILE and PKITOOL_KEY_FILE environment variables")
 	return cmd
 }
@@ -0,0 +1,265 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/version"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRepo = "rkosegi/pkitool"
+	apiBase     = "https://api.github.com/repos/"
+)
+
+// ReleaseKey is base64 encoded (PKIX, DER) ECDSA public key, which signs checksums file of releases using cosign.
+// Injected at link time using -ldflags "-X ...", self-update is refused when it's empty.
+var ReleaseKey = ""
+
+type updateData struct {
+	w       io.Writer
+	repo    string
+	check   bool
+	force   bool
+	timeout time.Duration
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status while fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// findAsset finds release binary for current platform.
+// Assets are named by goreleaser, like pkitool_1.2.3_linux_amd64.
+func findAsset(rel *release) *releaseAsset {
+	marker := fmt.Sprintf("_%s_%s", runtime.GOOS, runtime.GOARCH)
+	for i, a := range rel.Assets {
+		name := strings.TrimSuffix(a.Name, ".exe")
+		if strings.HasPrefix(name, "pkitool_") && (strings.HasSuffix(name, marker) || strings.HasSuffix(name, marker+"_v1")) {
+			return &rel.Assets[i]
+		}
+	}
+	return nil
+}
+
+// findChecksum looks up expected SHA256 checksum of named asset within goreleaser's checksums file.
+func findChecksum(sums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("checksum for %s not found in release", name)
+}
+
+// verifySignature verifies cosign signature of data, which is base64 encoded ASN.1 ECDSA signature over SHA256 of data.
+func verifySignature(data, sig []byte) error {
+	der, err := base64.StdEncoding.DecodeString(ReleaseKey)
+	if err != nil {
+		return fmt.Errorf("invalid release key: %w", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("invalid release key: %w", err)
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported release key type: %T", pub)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature of checksums file: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(key, digest[:], raw) {
+		return errors.New("signature of checksums file doesn't match release key, refusing to update")
+	}
+	return nil
+}
+
+// replaceExecutable atomically replaces currently running binary with new content.
+// Old binary is renamed first, which is required on Windows where running executable can't be overwritten.
+func replaceExecutable(data []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".pkitool-update-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	old := exe + ".old"
+	if err = os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), exe); err != nil {
+		_ = os.Rename(old, exe)
+		return err
+	}
+	_ = os.Remove(old)
+	return nil
+}
+
+func selfUpdate(d *updateData) error {
+	client := &http.Client{Timeout: d.timeout}
+	body, err := fetch(client, apiBase+d.repo+"/releases/latest")
+	if err != nil {
+		return err
+	}
+	var rel release
+	if err = json.Unmarshal(body, &rel); err != nil {
+		return err
+	}
+	latest := strings.TrimPrefix(rel.TagName, "v")
+	if latest == strings.TrimPrefix(version.Version, "v") && !d.force {
+		_, err = fmt.Fprintf(d.w, "Already up to date (%s)\n", version.Version)
+		return err
+	}
+	if d.check {
+		_, err = fmt.Fprintf(d.w, "New version available: %s (current: %s)\n", latest, version.Version)
+		return err
+	}
+	if len(ReleaseKey) == 0 {
+		return errors.New("binary was built without release key, can't verify release, refusing to update")
+	}
+	asset := findAsset(&rel)
+	if asset == nil {
+		return fmt.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	var sumsURL, sigURL string
+	for _, a := range rel.Assets {
+		if strings.HasSuffix(a.Name, "checksums.txt") {
+			sumsURL = a.URL
+		}
+		if strings.HasSuffix(a.Name, "checksums.txt.sig") {
+			sigURL = a.URL
+		}
+	}
+	if len(sumsURL) == 0 {
+		return errors.New("release has no checksums file, refusing to update")
+	}
+	if len(sigURL) == 0 {
+		return errors.New("release has no signature of checksums file, refusing to update")
+	}
+	sums, err := fetch(client, sumsURL)
+	if err != nil {
+		return err
+	}
+	sig, err := fetch(client, sigURL)
+	if err != nil {
+		return err
+	}
+	if err = verifySignature(sums, sig); err != nil {
+		return err
+	}
+	expected, err := findChecksum(sums, asset.Name)
+	if err != nil {
+		return err
+	}
+	bin, err := fetch(client, asset.URL)
+	if err != nil {
+		return err
+	}
+	actual := sha256.Sum256(bin)
+	if hex.EncodeToString(actual[:]) != strings.ToLower(expected) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %x", asset.Name, expected, actual)
+	}
+	if err = replaceExecutable(bin); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.w, "Updated %s -> %s\n", version.Version, latest)
+	return err
+}
+
+func NewCommand(w io.Writer) *cobra.Command {
+	d := &updateData{
+		w:       w,
+		repo:    defaultRepo,
+		timeout: 5 * time.Minute,
+	}
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Update pkitool binary to the latest released version",
+		Args:  cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return common.RequireTable(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return selfUpdate(d)
+		},
+	}
+	cmd.Flags().BoolVar(&d.check, "check", d.check, "Only check whether new version is available")
+	cmd.Flags().BoolVar(&d.force, "force", d.force, "Update even if current version is the latest one")
+	cmd.Flags().StringVar(&d.repo, "repo", d.repo, "GitHub repository to fetch releases from")
+	cmd.Flags().DurationVar(&d.timeout, "timeout", d.timeout, "Timeout of HTTP requests")
+	return cmd
+}
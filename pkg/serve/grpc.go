@@ -0,0 +1,128 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pkitoolv1 "github.com/rkosegi/pkitool/pkg/api/pkitool/v1"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/grpcapi"
+	"github.com/rkosegi/pkitool/pkg/logging"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"github.com/rkosegi/pkitool/pkg/telemetry"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
+	"net"
+)
+
+var (
+	errClientCAWithoutTLS = errors.New("client CA requires TLS, use --tls-alias")
+	// API returns private keys, it must not be reachable by anyone on network in plaintext
+	errPlaintextNotLoopback = errors.New("plaintext gRPC API can only listen on loopback address, use --tls-alias")
+)
+
+// grpcRoles are roles required by methods of gRPC API
+var grpcRoles = map[string]rbac.Role{
+	pkitoolv1.PKIService_Issue_FullMethodName:         rbac.RoleIssueLeaf,
+	pkitoolv1.PKIService_Sign_FullMethodName:          rbac.RoleIssueLeaf,
+	pkitoolv1.PKIService_Revoke_FullMethodName:        rbac.RoleRevoke,
+	pkitoolv1.PKIService_IssueCA_FullMethodName:       rbac.RoleIssueCA,
+	pkitoolv1.PKIService_Get_FullMethodName:           rbac.RoleRead,
+	pkitoolv1.PKIService_List_FullMethodName:          rbac.RoleRead,
+	pkitoolv1.PKIService_Watch_FullMethodName:         rbac.RoleRead,
+	pkitoolv1.PKIService_ListApprovals_FullMethodName: rbac.RoleApprove,
+	pkitoolv1.PKIService_Approve_FullMethodName:       rbac.RoleApprove,
+}
+
+func serveGrpc(ctx context.Context, d *commonServeData) error {
+	if len(d.tlsAlias) == 0 && !isLoopback(d.listen) {
+		return errPlaintextNotLoopback
+	}
+	closer, err := d.start(ctx, "grpc")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+	cfg, err := d.tlsConfig(ctx)
+	if err != nil {
+		return err
+	}
+	authz, err := d.authorizer()
+	if err != nil {
+		return err
+	}
+	// calls are logged and traced even when RBAC denies them
+	opts := append(append(telemetry.ServerOptions(), logging.ServerOptions(d.logger)...), authz.ServerOptions(grpcRoles)...)
+	if cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	cmOpts, err := d.options()
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir)
+	approvals, err := d.approvals(cm)
+	if err != nil {
+		return err
+	}
+	limits, err := d.limits(ctx, cm)
+	if err != nil {
+		return err
+	}
+	api := grpcapi.NewServer(d.dir, cmOpts...)
+	api.Approvals = approvals
+	api.Limits = limits
+	srv := grpc.NewServer(opts...)
+	pkitoolv1.RegisterPKIServiceServer(srv, api)
+	l, err := net.Listen("tcp", d.listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	if _, err = fmt.Fprintf(d.w, "Serving gRPC on %s\n", l.Addr()); err != nil {
+		return err
+	}
+	d.logger.Info("serving gRPC", "address", l.Addr().String(), "tls", cfg != nil)
+	return srv.Serve(l)
+}
+
+func newGrpcSubCommand(w io.Writer) *cobra.Command {
+	d := &commonServeData{
+		w:      w,
+		dir:    ".",
+		listen: "localhost:9090",
+	}
+	cmd := &cobra.Command{
+		Use:   "grpc",
+		Short: "Serve gRPC issuance API",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveGrpc(cmd.Context(), d)
+		},
+	}
+	addCommonFlags(d, cmd.Flags())
+	addRBACFlag(d, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"time"
+)
+
+type httpsData struct {
+	commonServeData
+	root string
+}
+
+// writeCerts writes summary of certificates in chain, in order they were sent.
+func writeCerts(w io.Writer, certs []*x509.Certificate) {
+	for i, c := range certs {
+		_, _ = fmt.Fprintf(w, "  %d: %s\n     issuer: %s\n     valid to: %s\n", i, c.Subject, c.Issuer, c.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// diagnostics writes details about TLS connection, so that client setup can be checked without any content.
+func diagnostics(srvChain []*x509.Certificate) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		cs := r.TLS
+		_, _ = fmt.Fprintf(w, "TLS connection established.\n\n")
+		_, _ = fmt.Fprintf(w, "Client address:   %s\n", r.RemoteAddr)
+		_, _ = fmt.Fprintf(w, "User agent:       %s\n", r.UserAgent())
+		_, _ = fmt.Fprintf(w, "Protocol:         %s, %s\n", tls.VersionName(cs.Version), r.Proto)
+		_, _ = fmt.Fprintf(w, "Cipher suite:     %s\n", tls.CipherSuiteName(cs.CipherSuite))
+		_, _ = fmt.Fprintf(w, "Server name:      %s\n", cs.ServerName)
+		_, _ = fmt.Fprintf(w, "ALPN protocol:    %s\n", cs.NegotiatedProtocol)
+		_, _ = fmt.Fprintf(w, "Resumed session:  %t\n", cs.DidResume)
+		_, _ = fmt.Fprintf(w, "\nServer chain:\n")
+		writeCerts(w, srvChain)
+		if len(cs.PeerCertificates) > 0 {
+			_, _ = fmt.Fprintf(w, "\nClient chain:\n")
+			writeCerts(w, cs.PeerCertificates)
+		}
+	}
+}
+
+func serveHttps(ctx context.Context, d *httpsData) error {
+	closer, err := d.start(ctx, "https")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+	if len(d.root) > 0 {
+		return listenAndServe(ctx, &d.commonServeData, http.FileServer(http.Dir(d.root)), "files from "+d.root)
+	}
+	// listenAndServe loads configuration on its own, here it's only needed to show served chain
+	cfg, err := d.tlsConfig(ctx)
+	if err != nil {
+		return err
+	}
+	var chain []*x509.Certificate
+	for _, der := range cfg.Certificates[0].Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, c)
+	}
+	return listenAndServe(ctx, &d.commonServeData, diagnostics(chain), "diagnostic page")
+}
+
+func newHttpsSubCommand(w io.Writer) *cobra.Command {
+	d := &httpsData{
+		commonServeData: commonServeData{
+			w:      w,
+			dir:    ".",
+			listen: ":8443",
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "https",
+		Short: "Serve static files or diagnostic page over HTTPS, to check that clients trust certificate",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(d.tlsAlias) == 0 {
+				return common.ErrAliasMissing
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveHttps(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.tlsAlias, "alias", d.tlsAlias, "Alias of certificate and private key to serve")
+	cmd.Flags().StringVar(&d.root, "root", d.root, "Directory with static files to serve. Diagnostic page is served when not set")
+	cmd.Flags().StringVar(&d.listen, "listen", d.listen, "Address to listen on")
+	cmd.Flags().StringVar(&d.clientCA, "client-ca", d.clientCA, "Alias of CA certificate used to verify client certificates. "+
+		"When set, clients must present certificate issued by this CA")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/metrics"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+)
+
+func serveMetrics(ctx context.Context, d *commonServeData) error {
+	closer, err := d.start(ctx, "metrics")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+	reg := prometheus.NewRegistry()
+	if err = reg.Register(metrics.NewCollector(ctx, certmgr.New(d.dir))); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	return listenAndServe(ctx, d, mux, "metrics")
+}
+
+func newMetricsSubCommand(w io.Writer) *cobra.Command {
+	d := &commonServeData{
+		w:      w,
+		dir:    ".",
+		listen: ":9795",
+	}
+	cmd := &cobra.Command{
+		Use:   "metrics",
+		Short: "Serve Prometheus metrics about certificates at /metrics",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveMetrics(cmd.Context(), d)
+		},
+	}
+	addCommonFlags(d, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/approval"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/escrow"
+	"github.com/rkosegi/pkitool/pkg/logging"
+	"github.com/rkosegi/pkitool/pkg/ratelimit"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"github.com/rkosegi/pkitool/pkg/telemetry"
+	"github.com/rkosegi/pkitool/pkg/webhook"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// commonServeData holds settings shared by all server modes.
+type commonServeData struct {
+	w        io.Writer
+	dir      string
+	listen   string
+	tlsAlias string
+	clientCA string
+	// rbacFile enables role-based access control, when set
+	rbacFile string
+	log      logging.Options
+	otel     telemetry.Options
+	// logger is set by start
+	logger *slog.Logger
+}
+
+func addCommonFlags(d *commonServeData, pf *pflag.FlagSet) {
+	pf.StringVar(&d.listen, "listen", d.listen, "Address to listen on")
+	pf.StringVar(&d.tlsAlias, "tls-alias", d.tlsAlias, "Alias of certificate and private key to serve TLS with. Plaintext is used when not set")
+	pf.StringVar(&d.clientCA, "client-ca", d.clientCA, "Alias of CA certificate used to verify client certificates. "+
+		"When set, clients must present certificate issued by this CA. Requires --tls-alias")
+	logging.AddFlags(&d.log, pf)
+	telemetry.AddFlags(&d.otel, pf)
+	common.AddDirFlag(&d.dir, pf)
+}
+
+// addRBACFlag adds flag to enable role-based access control, for servers that enforce it.
+func addRBACFlag(d *commonServeData, pf *pflag.FlagSet) {
+	pf.StringVar(&d.rbacFile, "rbac-file", d.rbacFile, "YAML file binding roles (read, issue-leaf, issue-ca, revoke, approve, admin) "+
+		"to client certificates or API tokens. Every authenticated client may do anything when not set. Requires --tls-alias")
+}
+
+// authorizer loads RBAC policy, or returns nil when access control is not enabled.
+func (d *commonServeData) authorizer() (*rbac.Authorizer, error) {
+	if len(d.rbacFile) == 0 {
+		return nil, nil
+	}
+	// tokens must not travel in plaintext
+	if len(d.tlsAlias) == 0 {
+		return nil, errors.New("RBAC requires TLS, use --tls-alias")
+	}
+	return rbac.Load(d.rbacFile)
+}
+
+// isLoopback tells whether listen address only accepts connections from local host.
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// closers closes all its members, so that logging and telemetry can be stopped together.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var errs []error
+	for _, c := range cs {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// start creates logger of server and starts export of its telemetry, returned closer must be called once server stops.
+// Server is name of serve subcommand.
+func (d *commonServeData) start(ctx context.Context, server string) (io.Closer, error) {
+	l, logs, err := logging.New(&d.log)
+	if err != nil {
+		return nil, err
+	}
+	tel, err := telemetry.Start(ctx, &d.otel, server)
+	if err != nil {
+		_ = logs.Close()
+		return nil, fmt.Errorf("can't start telemetry: %w", err)
+	}
+	d.logger = l
+	return closers{tel, logs}, nil
+}
+
+// options creates options of certificate manager that escrow generated keys, log lifecycle events
+// and deliver them to webhooks, as configured for store. Failed deliveries are reported to output of server.
+func (d *commonServeData) options() ([]certmgr.Option, error) {
+	opts, err := escrow.Options(config.PathFor(d.dir))
+	if err != nil {
+		return nil, err
+	}
+	webhookOpts, err := webhook.Options(config.PathFor(d.dir), d.w)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(opts, certmgr.WithHook(logging.EventHook(d.logger))), webhookOpts...), nil
+}
+
+// approvals creates manager of approvals configured for store, recording them in audit log of cm.
+// Approvers are told apart by identities that RBAC verified, so it's required whenever any operation needs approval.
+func (d *commonServeData) approvals(cm certmgr.Issuer) (*approval.Manager, error) {
+	m, err := approval.New(config.PathFor(d.dir), cm)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && len(d.rbacFile) == 0 {
+		return nil, errors.New("approval of operations requires RBAC, use --rbac-file")
+	}
+	return m, nil
+}
+
+// limits creates limiter of issuance configured for store, priming quotas from audit log of cm.
+func (d *commonServeData) limits(ctx context.Context, cm certmgr.Reader) (*ratelimit.Limiter, error) {
+	return ratelimit.New(ctx, config.PathFor(d.dir), cm)
+}
+
+// tlsConfig creates TLS configuration from stored certificates, or nil when TLS is not enabled.
+func (d *commonServeData) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	if len(d.tlsAlias) == 0 {
+		if len(d.clientCA) > 0 {
+			return nil, errClientCAWithoutTLS
+		}
+		return nil, nil
+	}
+	var cm certmgr.Reader = certmgr.New(d.dir)
+	cert, err := certmgr.TLSCertificate(ctx, cm, d.tlsAlias)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+	if len(d.clientCA) > 0 {
+		ca, err := cm.GetCert(ctx, d.clientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		cfg.ClientCAs.AddCert(ca)
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run server exposing certificates from directory",
+		// servers only print address they listen on, there is no result to render
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return common.RequireTable(cmd)
+		},
+	}
+	cmd.AddCommand(newGrpcSubCommand(out))
+	cmd.AddCommand(newUISubCommand(out))
+	cmd.AddCommand(newMetricsSubCommand(out))
+	cmd.AddCommand(newSpiffeSubCommand(out))
+	cmd.AddCommand(newHttpsSubCommand(out))
+	cmd.AddCommand(newSignerSubCommand(out))
+	return cmd
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"github.com/rkosegi/pkitool/pkg/signer"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+)
+
+type signerData struct {
+	commonServeData
+	parents []string
+}
+
+func serveSigner(ctx context.Context, d *signerData) error {
+	closer, err := d.start(ctx, "signer")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+	opts, err := d.options()
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir, opts...)
+	// make sure that all CAs are usable before accepting any request
+	for _, alias := range d.parents {
+		ph, err := cm.Get(ctx, alias)
+		if err != nil {
+			return err
+		}
+		if !ph.Cert.IsCA {
+			return fmt.Errorf("%w: %s", certmgr.ErrParentNotCA, alias)
+		}
+	}
+	authz, err := d.authorizer()
+	if err != nil {
+		return err
+	}
+	h := signer.NewHandler(cm, d.parents)
+	if h.Approvals, err = d.approvals(cm); err != nil {
+		return err
+	}
+	if h.Limits, err = d.limits(ctx, cm); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(signer.Path, authz.HTTP(rbac.RoleIssueLeaf, h))
+	return listenAndServe(ctx, &d.commonServeData, mux, "signing API")
+}
+
+func validateSigner(d *signerData) error {
+	if len(d.tlsAlias) == 0 || len(d.clientCA) == 0 {
+		return errors.New("signing server requires mutual TLS, use --tls-alias and --client-ca")
+	}
+	if len(d.parents) == 0 {
+		return errors.New("at least one parent CA is required")
+	}
+	return nil
+}
+
+func newSignerSubCommand(w io.Writer) *cobra.Command {
+	d := &signerData{
+		commonServeData: commonServeData{
+			w:      w,
+			dir:    ".",
+			listen: ":8444",
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "signer",
+		Short: "Serve signing API, so that CA private keys never leave this host",
+		Long: "Serve signing API, so that CA private keys never leave this host.\n" +
+			"Clients send certificate signing requests over mutual TLS, using '--remote-signer' flag of 'create leaf'.\n" +
+			"Any client with certificate issued by --client-ca may request certificate from any of --parent CAs.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateSigner(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveSigner(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringArrayVar(&d.parents, "parent", d.parents, "Alias of CA clients may request certificates from, can be repeated")
+	addCommonFlags(&d.commonServeData, cmd.Flags())
+	addRBACFlag(&d.commonServeData, cmd.Flags())
+	return cmd
+}
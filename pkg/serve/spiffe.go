@@ -0,0 +1,165 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/logging"
+	"github.com/rkosegi/pkitool/pkg/spiffe"
+	"github.com/rkosegi/pkitool/pkg/telemetry"
+	"github.com/spf13/cobra"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type spiffeServeData struct {
+	w           io.Writer
+	dir         string
+	config      string
+	socket      string
+	ca          string
+	trustDomain string
+	ttl         time.Duration
+	bits        int
+	log         logging.Options
+	otel        telemetry.Options
+}
+
+// listenUnix listens on unix socket, replacing stale socket left behind by previous run.
+// Socket is accessible by all local users, workloads are told apart by their credentials.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0o777); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func serveSpiffe(ctx context.Context, d *spiffeServeData) error {
+	logger, logs, err := logging.New(&d.log)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = logs.Close()
+	}()
+	tel, err := telemetry.Start(ctx, &d.otel, "spiffe")
+	if err != nil {
+		return fmt.Errorf("can't start telemetry: %w", err)
+	}
+	defer func() {
+		_ = tel.Close()
+	}()
+	if len(d.config) == 0 {
+		d.config = config.PathFor(d.dir)
+	}
+	cfg, err := config.Load(d.config)
+	if err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir, certmgr.WithHook(logging.EventHook(logger)))
+	ca, err := cm.GetCert(ctx, d.ca)
+	if err != nil {
+		return err
+	}
+	if !ca.IsCA {
+		return fmt.Errorf("%w: %s", certmgr.ErrParentNotCA, d.ca)
+	}
+	ws, err := spiffe.NewServer(spiffe.NewIssuer(cm, d.ca, d.ttl, d.bits), d.trustDomain, cfg.SPIFFE.Entries)
+	if err != nil {
+		return err
+	}
+	srv := spiffe.NewGRPCServer(append(telemetry.ServerOptions(), logging.ServerOptions(logger)...)...)
+	ws.Register(srv)
+	l, err := listenUnix(d.socket)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	if _, err = fmt.Fprintf(d.w, "Serving SPIFFE Workload API for %d entries on unix://%s\n", len(cfg.SPIFFE.Entries), l.Addr()); err != nil {
+		return err
+	}
+	logger.Info("serving SPIFFE Workload API", "address", l.Addr().String(), "entries", len(cfg.SPIFFE.Entries))
+	return srv.Serve(l)
+}
+
+func validateSpiffe(d *spiffeServeData) error {
+	if len(d.ca) == 0 {
+		return errors.New("alias of CA is required")
+	}
+	if len(d.trustDomain) == 0 {
+		return errors.New("trust domain is required")
+	}
+	if d.ttl < time.Minute {
+		return errors.New("lifetime of SVIDs must be at least one minute")
+	}
+	return nil
+}
+
+func newSpiffeSubCommand(w io.Writer) *cobra.Command {
+	d := &spiffeServeData{
+		w:      w,
+		dir:    ".",
+		socket: "/tmp/pkitool/workload.sock",
+		ttl:    time.Hour,
+		bits:   2048,
+	}
+	cmd := &cobra.Command{
+		Use:   "spiffe",
+		Short: "Serve SPIFFE Workload API on unix socket, issuing short-lived X.509 SVIDs to local workloads",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateSpiffe(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveSpiffe(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.socket, "socket", d.socket, "Path of unix socket to listen on")
+	cmd.Flags().StringVar(&d.ca, "ca", d.ca, "Alias of CA that issues SVIDs")
+	cmd.Flags().StringVar(&d.trustDomain, "trust-domain", d.trustDomain, "Trust domain, like example.org. All SPIFFE IDs in entries must belong to it")
+	cmd.Flags().DurationVar(&d.ttl, "ttl", d.ttl, "Lifetime of issued SVIDs, they are renewed when half of it passes")
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits) of issued SVIDs")
+	cmd.Flags().StringVar(&d.config, "config", d.config, "Configuration file with workload entries, defaults to "+config.FileName+" in directory")
+	logging.AddFlags(&d.log, cmd.Flags())
+	telemetry.AddFlags(&d.otel, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,582 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package serve
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"embed"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/approval"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/logging"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/rkosegi/pkitool/pkg/ratelimit"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"github.com/rkosegi/pkitool/pkg/telemetry"
+	"github.com/spf13/cobra"
+	"html/template"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// private keys and passwords must not be reachable by anyone on network in plaintext
+var errUIPlaintextNotLoopback = errors.New("web UI with --allow-issue or --username can only listen on loopback address in plaintext, use --tls-alias")
+
+//go:embed ui/templates.html
+var uiTemplates embed.FS
+
+type uiData struct {
+	commonServeData
+	allowIssue   bool
+	username     string
+	passwordFile string
+	password     []byte
+}
+
+// uiCert is certificate as presented by UI.
+type uiCert struct {
+	*x509.Certificate
+	Alias    string
+	Subject  string
+	Issuer   string
+	Serial   string
+	DaysLeft int
+	Status   common.Status
+	Revoked  bool
+	Children []*uiCert
+}
+
+func (c *uiCert) StatusClass() string {
+	return strings.ReplaceAll(string(c.Status), " ", "-")
+}
+
+type uiServer struct {
+	d     *uiData
+	cm    certmgr.Interface
+	tmpl  *template.Template
+	authz *rbac.Authorizer
+	// approvals of sensitive operations, nil when none requires approval
+	approvals *approval.Manager
+	// limits of issuance, nil when it's not limited
+	limits *ratelimit.Limiter
+}
+
+// page is data passed to every template.
+type page struct {
+	AllowIssue bool
+	Query      string
+	Certs      []*uiCert
+	Tree       []*uiCert
+	Cert       *uiCert
+	Chain      []*certmgr.PairHolder
+	CAs        []string
+	Profiles   []string
+	Error      string
+	Issued     string
+
+	// Approvals are enabled, so that link to them is shown
+	Approvals bool
+	// IssueApproval tells whether issuance requires approval
+	IssueApproval bool
+	// Pending is request that needs to be approved before issuance can proceed
+	Pending  *approval.Request
+	Requests []*approval.Request
+	Approved string
+	// KeyDownload tells whether private key of issued certificate can be downloaded
+	KeyDownload bool
+}
+
+// load loads all certificates, sorted by expiry.
+func (s *uiServer) load(ctx context.Context) ([]*uiCert, error) {
+	ls, err := s.cm.ListStream(ctx, &certmgr.ListOptions{ParseCert: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = ls.Close()
+	}()
+	now := time.Now()
+	var res []*uiCert
+	for ls.Next() {
+		e := ls.Entry()
+		rev, err := s.cm.GetRevocation(ctx, e.Alias)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &uiCert{
+			Certificate: e.Cert,
+			Alias:       e.Alias,
+			Subject:     e.Cert.Subject.String(),
+			Issuer:      e.Cert.Issuer.String(),
+			Serial:      e.Cert.SerialNumber.String(),
+			DaysLeft:    int(e.Cert.NotAfter.Sub(now).Hours() / 24),
+			Status:      common.StatusOf(e.Cert, now),
+			Revoked:     rev != nil,
+		})
+	}
+	if err = ls.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(res, func(a, b *uiCert) int {
+		return a.NotAfter.Compare(b.NotAfter)
+	})
+	return res, nil
+}
+
+// buildTree arranges certificates by their issuers.
+// Certificates which issuer is not known are roots of tree.
+func buildTree(certs []*uiCert) []*uiCert {
+	var roots []*uiCert
+	for _, c := range certs {
+		c.Children = nil
+	}
+	for _, c := range certs {
+		var parent *uiCert
+		if c.Subject != c.Issuer {
+			for _, candidate := range certs {
+				if candidate != c && candidate.IsCA && certmgr.IsIssuedBy(c.Certificate, candidate.Certificate) {
+					parent = candidate
+					break
+				}
+			}
+		}
+		if parent == nil {
+			roots = append(roots, c)
+		} else {
+			parent.Children = append(parent.Children, c)
+		}
+	}
+	return roots
+}
+
+// keyDownload tells whether private keys of issued certificates can be downloaded.
+// Users must be authenticated then, otherwise anyone who can reach server would get keys.
+func (s *uiServer) keyDownload() bool {
+	return s.d.allowIssue && (s.authz != nil || len(s.d.username) > 0 || len(s.d.clientCA) > 0)
+}
+
+func (s *uiServer) render(w http.ResponseWriter, name string, p *page) {
+	p.AllowIssue = s.d.allowIssue
+	p.KeyDownload = s.keyDownload()
+	p.Approvals = s.approvals != nil
+	p.IssueApproval = s.approvals.Required(approval.OpIssue)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tmpl.ExecuteTemplate(w, name, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// httpError responds with status that corresponds to err.
+// Details of err, like file paths within store, are only logged, not sent to client.
+func (s *uiServer) httpError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, certmgr.ErrAliasNotFound) {
+		http.Error(w, certmgr.ErrAliasNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	s.d.logger.Error("request failed", "path", r.URL.Path, "error", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// clientError returns message of err that can be shown to client, errors of store are only logged.
+func (s *uiServer) clientError(r *http.Request, err error) string {
+	var pe *fs.PathError
+	switch {
+	case errors.Is(err, certmgr.ErrAliasNotFound):
+		return certmgr.ErrAliasNotFound.Error()
+	case errors.As(err, &pe):
+		s.d.logger.Error("request failed", "path", r.URL.Path, "error", err)
+		return http.StatusText(http.StatusInternalServerError)
+	}
+	return err.Error()
+}
+
+func (s *uiServer) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	certs, err := s.load(r.Context())
+	if err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+	p := &page{Query: r.URL.Query().Get("q"), Certs: certs}
+	if len(p.Query) > 0 {
+		q := strings.ToLower(p.Query)
+		p.Certs = slices.DeleteFunc(slices.Clone(certs), func(c *uiCert) bool {
+			return !strings.Contains(strings.ToLower(c.Alias), q) && !strings.Contains(strings.ToLower(c.Subject), q)
+		})
+	} else {
+		p.Tree = buildTree(certs)
+	}
+	s.render(w, "index", p)
+}
+
+func (s *uiServer) get(ctx context.Context, alias string) (*uiCert, []*certmgr.PairHolder, error) {
+	chain, err := s.cm.GetChain(ctx, alias)
+	if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+		return nil, nil, err
+	}
+	rev, err := s.cm.GetRevocation(ctx, alias)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert := chain[0].Cert
+	now := time.Now()
+	return &uiCert{
+		Certificate: cert,
+		Alias:       alias,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.String(),
+		DaysLeft:    int(cert.NotAfter.Sub(now).Hours() / 24),
+		Status:      common.StatusOf(cert, now),
+		Revoked:     rev != nil,
+	}, chain, nil
+}
+
+func (s *uiServer) cert(w http.ResponseWriter, r *http.Request) {
+	c, chain, err := s.get(r.Context(), strings.TrimPrefix(r.URL.Path, "/cert/"))
+	if err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+	s.render(w, "cert", &page{Cert: c, Chain: chain})
+}
+
+// download serves PEM file, name is one of <alias>.pem, <alias>-chain.pem or <alias>.key.
+// Private keys are only available when issuing is allowed to authenticated users, and never for CAs.
+func (s *uiServer) download(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/download/")
+	var (
+		alias string
+		data  []byte
+	)
+	switch {
+	case strings.HasSuffix(name, "-chain.pem"):
+		alias = strings.TrimSuffix(name, "-chain.pem")
+		chain, err := s.cm.GetChain(r.Context(), alias)
+		if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+			s.httpError(w, r, err)
+			return
+		}
+		for _, e := range chain {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+		}
+	case strings.HasSuffix(name, ".pem"):
+		alias = strings.TrimSuffix(name, ".pem")
+		cert, err := s.cm.GetCert(r.Context(), alias)
+		if err != nil {
+			s.httpError(w, r, err)
+			return
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	case strings.HasSuffix(name, ".key") && s.keyDownload():
+		if !s.authz.Permit(r, rbac.RoleIssueLeaf) {
+			http.Error(w, fmt.Sprintf("role %s is required", rbac.RoleIssueLeaf), http.StatusForbidden)
+			return
+		}
+		alias = strings.TrimSuffix(name, ".key")
+		ph, err := s.cm.Get(r.Context(), alias)
+		if err != nil {
+			s.httpError(w, r, err)
+			return
+		}
+		if ph.Cert.IsCA {
+			http.Error(w, "private key of CA can't be downloaded", http.StatusForbidden)
+			return
+		}
+		if data, err = certmgr.MarshalKeyPEM(ph.Key); err != nil {
+			s.httpError(w, r, err)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	_, _ = w.Write(data)
+}
+
+func (s *uiServer) issue(w http.ResponseWriter, r *http.Request) {
+	if !s.d.allowIssue {
+		http.NotFound(w, r)
+		return
+	}
+	certs, err := s.load(r.Context())
+	if err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+	p := &page{Profiles: profiles.Names()}
+	for _, c := range certs {
+		if c.IsCA && !c.Revoked {
+			p.CAs = append(p.CAs, c.Alias)
+		}
+	}
+	slices.Sort(p.CAs)
+	if r.Method == http.MethodPost {
+		var pending *approval.PendingError
+		switch err = s.issueLeaf(r); {
+		case errors.As(err, &pending):
+			w.WriteHeader(http.StatusAccepted)
+			p.Pending = pending.Request
+		case errors.Is(err, ratelimit.ErrRateLimited), errors.Is(err, ratelimit.ErrQuotaExceeded):
+			ratelimit.SetRetryAfter(w, err)
+			w.WriteHeader(http.StatusTooManyRequests)
+			p.Error = err.Error()
+		case err != nil:
+			w.WriteHeader(http.StatusBadRequest)
+			p.Error = s.clientError(r, err)
+		default:
+			p.Issued = r.FormValue("alias")
+		}
+	}
+	s.render(w, "issue", p)
+}
+
+func (s *uiServer) issueLeaf(r *http.Request) error {
+	prof, err := profiles.Get(r.FormValue("profile"))
+	if err != nil {
+		return err
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 {
+		return errors.New("number of valid days must be positive")
+	}
+	cd := &certmgr.CertData{
+		KeySize:     2048,
+		Validity:    time.Duration(days) * 24 * time.Hour,
+		Alias:       r.FormValue("alias"),
+		ParentAlias: r.FormValue("parent"),
+		Subject:     pkix.Name{CommonName: r.FormValue("cn")},
+	}
+	for _, san := range strings.Split(r.FormValue("sans"), ",") {
+		if san = strings.TrimSpace(san); len(san) > 0 {
+			cd.AddSAN(san)
+		}
+	}
+	prof.Apply(cd)
+	if err = s.approvals.Authorize(r.Context(), approval.OpIssue, cd.Alias, rbac.IdentityOf(r).Name(), r.FormValue("approval"),
+		approval.CertParams(cd)); err != nil {
+		return err
+	}
+	if err = s.limits.Allow(ratelimit.Client(r), cd.ParentAlias); err != nil {
+		return err
+	}
+	return s.cm.NewLeaf(r.Context(), cd)
+}
+
+// approve lists pending requests for approval and approves one of them on POST.
+func (s *uiServer) approve(w http.ResponseWriter, r *http.Request) {
+	if s.approvals == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p := &page{}
+	if r.Method == http.MethodPost {
+		if _, err := s.approvals.Approve(r.Context(), r.FormValue("id"), rbac.IdentityOf(r).Name()); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			p.Error = s.clientError(r, err)
+		} else {
+			p.Approved = r.FormValue("id")
+		}
+	}
+	var err error
+	if p.Requests, err = s.approvals.Pending(r.Context()); err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+	s.render(w, "approvals", p)
+}
+
+// authenticate wraps handler with HTTP basic authentication, when credentials are configured.
+func (s *uiServer) authenticate(next http.Handler) http.Handler {
+	if len(s.d.username) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.d.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), s.d.password) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pkitool"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin rejects state-changing requests sent by browser on behalf of other site, which would carry
+// basic authentication credentials or client certificate of user along. Browsers tell origin of request
+// in Sec-Fetch-Site header, or at least in Origin header. Requests without either don't come from browser.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if site := r.Header.Get("Sec-Fetch-Site"); len(site) > 0 {
+			if site != "same-origin" && site != "none" {
+				http.Error(w, "cross-origin request refused", http.StatusForbidden)
+				return
+			}
+		} else if origin := r.Header.Get("Origin"); len(origin) > 0 {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request refused", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *uiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.authz.HTTP(rbac.RoleRead, http.HandlerFunc(s.index)))
+	mux.Handle("/cert/", s.authz.HTTP(rbac.RoleRead, http.HandlerFunc(s.cert)))
+	mux.Handle("/download/", s.authz.HTTP(rbac.RoleRead, http.HandlerFunc(s.download)))
+	mux.Handle("/issue", s.authz.HTTP(rbac.RoleIssueLeaf, http.HandlerFunc(s.issue)))
+	mux.Handle("/approvals", s.authz.HTTP(rbac.RoleApprove, http.HandlerFunc(s.approve)))
+	return sameOrigin(s.authenticate(mux))
+}
+
+// listenAndServe serves handler until context is cancelled.
+func listenAndServe(ctx context.Context, d *commonServeData, h http.Handler, what string) error {
+	cfg, err := d.tlsConfig(ctx)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", d.listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           logging.HTTP(d.logger, telemetry.HTTP(h)),
+		TLSConfig:         cfg,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+	scheme := "http"
+	if cfg != nil {
+		scheme = "https"
+	}
+	if _, err = fmt.Fprintf(d.w, "Serving %s on %s://%s\n", what, scheme, l.Addr()); err != nil {
+		return err
+	}
+	d.logger.Info("serving "+what, "address", l.Addr().String(), "tls", cfg != nil)
+	if cfg != nil {
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		err = srv.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func serveUI(ctx context.Context, d *uiData) error {
+	if len(d.tlsAlias) == 0 && !isLoopback(d.listen) && (d.allowIssue || len(d.username) > 0) {
+		return errUIPlaintextNotLoopback
+	}
+	closer, err := d.start(ctx, "ui")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closer.Close()
+	}()
+	tmpl, err := template.ParseFS(uiTemplates, "ui/templates.html")
+	if err != nil {
+		return err
+	}
+	if len(d.passwordFile) > 0 {
+		if d.password, err = os.ReadFile(d.passwordFile); err != nil {
+			return err
+		}
+		d.password = []byte(strings.TrimSpace(string(d.password)))
+	}
+	authz, err := d.authorizer()
+	if err != nil {
+		return err
+	}
+	opts, err := d.options()
+	if err != nil {
+		return err
+	}
+	s := &uiServer{d: d, cm: certmgr.New(d.dir, opts...), tmpl: tmpl, authz: authz}
+	if s.approvals, err = d.approvals(s.cm); err != nil {
+		return err
+	}
+	if s.limits, err = d.limits(ctx, s.cm); err != nil {
+		return err
+	}
+	return listenAndServe(ctx, &d.commonServeData, s.handler(), "web UI")
+}
+
+func validateUI(d *uiData) error {
+	if (len(d.username) == 0) != (len(d.passwordFile) == 0) {
+		return errors.New("both --username and --password-file must be given to enable authentication")
+	}
+	return nil
+}
+
+func newUISubCommand(w io.Writer) *cobra.Command {
+	d := &uiData{
+		commonServeData: commonServeData{
+			w:      w,
+			dir:    ".",
+			listen: "localhost:8080",
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "ui",
+		Short: "Serve web interface with certificate dashboard",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateUI(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serveUI(cmd.Context(), d)
+		},
+	}
+	addCommonFlags(&d.commonServeData, cmd.Flags())
+	addRBACFlag(&d.commonServeData, cmd.Flags())
+	cmd.Flags().BoolVar(&d.allowIssue, "allow-issue", d.allowIssue, "Whether to allow issuing of leaf certificates from web interface")
+	cmd.Flags().StringVar(&d.username, "username", d.username, "Name of user for HTTP basic authentication")
+	cmd.Flags().StringVar(&d.passwordFile, "password-file", d.passwordFile, "File with password for HTTP basic authentication")
+	return cmd
+}
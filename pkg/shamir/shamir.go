@@ -0,0 +1,150 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shamir implements Shamir's secret sharing over GF(2^8).
+// Every byte of secret is shared independently, using random polynomial of degree threshold-1.
+// Share is as long as secret, followed by single byte holding its x-coordinate.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidShares is returned when shares can't be combined.
+var ErrInvalidShares = errors.New("invalid shares")
+
+var (
+	// expTable and logTable are exponents and logarithms of generator 3 in GF(2^8) with polynomial x^8+x^4+x^3+x+1
+	expTable [255]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		// multiply by 3, that is by x+1
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// eval evaluates polynomial with given coefficients, constant first, at x.
+func eval(coeffs []byte, x byte) byte {
+	var res byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		res = mul(res, x) ^ coeffs[i]
+	}
+	return res
+}
+
+// Split splits secret into n shares, any threshold of which can reconstruct it.
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+	if threshold < 2 || threshold > n {
+		return nil, fmt.Errorf("threshold must be between 2 and number of shares, got %d of %d", threshold, n)
+	}
+	if n > 255 {
+		return nil, fmt.Errorf("at most 255 shares are supported, got %d", n)
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+	coeffs := make([]byte, threshold)
+	for j, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i][j] = eval(coeffs, byte(i+1))
+		}
+	}
+	return shares, nil
+}
+
+// Combine reconstructs secret from shares, using Lagrange interpolation at zero.
+// Result is only correct when at least threshold distinct shares of the same secret are given.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: at least 2 shares are required", ErrInvalidShares)
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("%w: share is too short", ErrInvalidShares)
+	}
+	xs := make([]byte, len(shares))
+	for i, s := range shares {
+		if len(s) != size {
+			return nil, fmt.Errorf("%w: shares differ in length", ErrInvalidShares)
+		}
+		xs[i] = s[size-1]
+		if xs[i] == 0 {
+			return nil, fmt.Errorf("%w: share has invalid index", ErrInvalidShares)
+		}
+		for j := 0; j < i; j++ {
+			if xs[i] == xs[j] {
+				return nil, fmt.Errorf("%w: share %d is given more than once", ErrInvalidShares, xs[i])
+			}
+		}
+	}
+	// basis[i] is value of i-th Lagrange basis polynomial at zero
+	basis := make([]byte, len(shares))
+	for i := range shares {
+		num, den := byte(1), byte(1)
+		for j := range shares {
+			if i != j {
+				num = mul(num, xs[j])
+				den = mul(den, xs[i]^xs[j])
+			}
+		}
+		basis[i] = div(num, den)
+	}
+	secret := make([]byte, size-1)
+	for k := range secret {
+		var b byte
+		for i, s := range shares {
+			b ^= mul(s[k], basis[i])
+		}
+		secret[k] = b
+	}
+	return secret, nil
+}
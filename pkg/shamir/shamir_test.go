@@ -0,0 +1,163 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shamir
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		n, threshold int
+	}{
+		{"2 of 2", 2, 2},
+		{"2 of 3", 3, 2},
+		{"3 of 5", 5, 3},
+		{"5 of 5", 5, 5},
+		{"7 of 10", 10, 7},
+		{"255 of 255", 255, 255},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			shares, err := Split(secret, tc.n, tc.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shares) != tc.n {
+				t.Fatalf("expected %d shares, got %d", tc.n, len(shares))
+			}
+			// any threshold or more consecutive shares reconstruct secret
+			for k := tc.threshold; k <= tc.n; k++ {
+				for start := 0; start+k <= tc.n; start += tc.threshold {
+					got, err := Combine(shares[start : start+k])
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, secret) {
+						t.Fatalf("shares %d..%d didn't reconstruct secret", start, start+k-1)
+					}
+				}
+			}
+			if tc.threshold > 2 {
+				got, err := Combine(shares[:tc.threshold-1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bytes.Equal(got, secret) {
+					t.Fatalf("%d shares reconstructed secret, but threshold is %d", tc.threshold-1, tc.threshold)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		secret       []byte
+		n, threshold int
+	}{
+		{"empty secret", nil, 3, 2},
+		{"threshold of 1", secret, 3, 1},
+		{"threshold above shares", secret, 3, 4},
+		{"too many shares", secret, 256, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Split(tc.secret, tc.n, tc.threshold); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestCombineInvalid(t *testing.T) {
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := func(idx ...int) [][]byte {
+		res := make([][]byte, len(idx))
+		for i, j := range idx {
+			res[i] = bytes.Clone(shares[j])
+		}
+		return res
+	}
+	for _, tc := range []struct {
+		name   string
+		shares func() [][]byte
+		// when false, shares are accepted, but secret must not be reconstructed
+		err bool
+	}{
+		{"no shares", func() [][]byte { return nil }, true},
+		{"single share", func() [][]byte { return clone(0) }, true},
+		{"duplicate share", func() [][]byte { return clone(0, 1, 1) }, true},
+		{"duplicate index", func() [][]byte {
+			s := clone(0, 1, 2)
+			s[2][len(secret)] = s[0][len(secret)]
+			return s
+		}, true},
+		{"zero index", func() [][]byte {
+			s := clone(0, 1, 2)
+			s[1][len(secret)] = 0
+			return s
+		}, true},
+		{"truncated share", func() [][]byte {
+			s := clone(0, 1, 2)
+			s[2] = s[2][1:]
+			return s
+		}, true},
+		{"too short shares", func() [][]byte { return [][]byte{{1}, {2}} }, true},
+		{"corrupted value", func() [][]byte {
+			s := clone(0, 1, 2)
+			s[1][0] ^= 0xff
+			return s
+		}, false},
+		{"corrupted index", func() [][]byte {
+			s := clone(0, 1, 2)
+			s[2][len(secret)] = 42
+			return s
+		}, false},
+		{"shares of different secrets", func() [][]byte {
+			other, err := Split(bytes.ToUpper(secret), 5, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := clone(0, 1)
+			return append(s, other[2])
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Combine(tc.shares())
+			if tc.err {
+				if !errors.Is(err, ErrInvalidShares) {
+					t.Fatalf("expected ErrInvalidShares, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(got, secret) {
+				t.Fatal("secret was reconstructed from invalid shares")
+			}
+		})
+	}
+}
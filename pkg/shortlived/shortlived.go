@@ -0,0 +1,356 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shortlived issues certificates with lifetime of hours and keeps them fresh in output files.
+package shortlived
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"github.com/rkosegi/pkitool/pkg/deploy"
+	"github.com/rkosegi/pkitool/pkg/haproxy"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/rkosegi/pkitool/pkg/webhook"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxTTL is the longest lifetime of short-lived certificate, longer-lived ones are issued using "create leaf"
+	MaxTTL = 7 * 24 * time.Hour
+	// minTTL leaves enough time to reissue certificate before it expires
+	minTTL = 5 * time.Minute
+)
+
+type shortLivedData struct {
+	w         io.Writer
+	errw      io.Writer
+	dir       string
+	parent    string
+	subject   pkix.Name
+	dnsSan    []string
+	ipSan     []net.IP
+	profile   string
+	bits      int
+	ttl       time.Duration
+	certFile  string
+	keyFile   string
+	noChain   bool
+	renewAt   float64
+	watch     bool
+	retry     time.Duration
+	exec      string
+	keyShares []string
+	// haproxy and haproxyCert are runtime API of HAProxy and name of certificate to replace there
+	haproxy     haproxy.Runtime
+	haproxyCert string
+	// webhooks receive renew events
+	webhooks []*webhook.Webhook
+	// alias names certificate in events, deploys are actions configured for it
+	alias   string
+	deploys []*deploy.Deploy
+}
+
+// issued is certificate written to output files, along with time when it should be replaced.
+type issued struct {
+	cert    *x509.Certificate
+	renewAt time.Time
+}
+
+func (d *shortLivedData) renewTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * d.renewAt))
+}
+
+// current gets certificate already present in output files, so that restart doesn't reissue it needlessly.
+// Nil is returned when there is none, or when it's due for renewal.
+func (d *shortLivedData) current() *issued {
+	data, err := os.ReadFile(d.certFile)
+	if err != nil {
+		return nil
+	}
+	if _, err = os.Stat(d.keyFile); err != nil {
+		return nil
+	}
+	cert, err := certmgr.ParseCertificatePEM(data)
+	if err != nil {
+		return nil
+	}
+	renewAt := d.renewTime(cert)
+	if !time.Now().Before(renewAt) {
+		return nil
+	}
+	return &issued{cert: cert, renewAt: renewAt}
+}
+
+// writeFile writes data to temporary file first and renames it to name, so that readers never see partial content.
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// issue issues new certificate and writes it along with its private key to output files.
+// Certificate is not kept in store.
+func (d *shortLivedData) issue(ctx context.Context, cm certmgr.Interface, p *profiles.Profile) (*issued, error) {
+	cd := &certmgr.CertData{
+		KeySize:  d.bits,
+		Validity: d.ttl,
+		Subject:  d.subject,
+		DNSSan:   d.dnsSan,
+		IPSan:    d.ipSan,
+	}
+	p.Apply(cd)
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := cm.SignCSR(ctx, d.parent, csr.CSR, &certmgr.SignOptions{
+		Validity:    d.ttl,
+		ExtKeyUsage: cd.ExtKeyUsage,
+		Profile:     cd.Profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if !d.noChain {
+		chain, err := cm.GetChain(ctx, d.parent)
+		if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+			return nil, err
+		}
+		// trust anchor is not sent by peers, broken chain may not reach it
+		for _, e := range chain {
+			if !certmgr.IsSelfSigned(e.Cert) {
+				certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw})...)
+			}
+		}
+	}
+	keyPEM, err := certmgr.MarshalKeyPEM(csr.Key)
+	if err != nil {
+		return nil, err
+	}
+	// key goes first, consumers usually reload once certificate changes
+	if err = writeFile(d.keyFile, keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err = writeFile(d.certFile, certPEM, 0o644); err != nil {
+		return nil, err
+	}
+	ev := &certmgr.Event{
+		Type:     certmgr.EventRenew,
+		Alias:    d.alias,
+		CertFile: d.certFile,
+		KeyFile:  d.keyFile,
+		Cert:     cert,
+	}
+	if len(d.exec) > 0 {
+		if err = certmgr.ExecHook(d.exec, d.w, d.errw)(ctx, ev); err != nil {
+			return nil, fmt.Errorf("command run after renewal failed: %w", err)
+		}
+	}
+	if len(d.haproxy.Address) > 0 {
+		if err = haproxy.Hook(&d.haproxy, d.haproxyCert)(ctx, ev); err != nil {
+			return nil, err
+		}
+	}
+	if err = deploy.Hook(d.deploys)(ctx, ev); err != nil {
+		return nil, err
+	}
+	if err = webhook.Hook(d.webhooks, d.errw)(ctx, ev); err != nil {
+		return nil, err
+	}
+	return &issued{cert: cert, renewAt: d.renewTime(cert)}, nil
+}
+
+func (d *shortLivedData) report(i *issued) error {
+	_, err := fmt.Fprintf(d.w, "Issued certificate %s, serial %s, valid to %s, renewal at %s\n",
+		d.certFile, i.cert.SerialNumber, i.cert.NotAfter.Format(time.RFC3339), i.renewAt.Format(time.RFC3339))
+	return err
+}
+
+// wait waits until given time or until context is cancelled, returning false in the latter case.
+func wait(ctx context.Context, until time.Time) bool {
+	t := time.NewTimer(time.Until(until))
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func shortLived(ctx context.Context, d *shortLivedData) error {
+	p, err := profiles.Get(d.profile)
+	if err != nil {
+		return err
+	}
+	if len(p.UnknownExtKeyUsage) > 0 || p.NoExpiry {
+		return fmt.Errorf("profile %s can't be used for short-lived certificates", p.Name)
+	}
+	opts, err := common.KeyShareOptions(d.keyShares)
+	if err != nil {
+		return err
+	}
+	if d.webhooks, err = webhook.Load(config.PathFor(d.dir)); err != nil {
+		return err
+	}
+	if d.deploys, err = deploy.Load(config.PathFor(d.dir), d.w, d.errw); err != nil {
+		return err
+	}
+	cm := certmgr.New(d.dir, opts...)
+	cur := d.current()
+	if cur == nil {
+		if cur, err = d.issue(ctx, cm, p); err != nil {
+			return err
+		}
+		if err = d.report(cur); err != nil {
+			return err
+		}
+	} else if _, err = fmt.Fprintf(d.w, "Certificate %s is valid to %s, renewal at %s\n",
+		d.certFile, cur.cert.NotAfter.Format(time.RFC3339), cur.renewAt.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if !d.watch {
+		return nil
+	}
+	for wait(ctx, cur.renewAt) {
+		next, err := d.issue(ctx, cm, p)
+		if err != nil {
+			// keep serving current certificate and try again, as long as it is still valid
+			retryAt := time.Now().Add(d.retry)
+			if !retryAt.Before(cur.cert.NotAfter) {
+				retryAt = time.Now().Add(min(d.retry, max(time.Until(cur.cert.NotAfter)/2, time.Second)))
+			}
+			_, _ = fmt.Fprintf(d.errw, "Renewal of %s failed, retrying at %s: %v\n", d.certFile, retryAt.Format(time.RFC3339), err)
+			cur.renewAt = retryAt
+			continue
+		}
+		cur = next
+		if err = d.report(cur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateShortLived(d *shortLivedData) error {
+	if len(d.parent) == 0 {
+		return errors.New("parent CA is required")
+	}
+	if len(d.subject.CommonName) == 0 && len(d.dnsSan) == 0 && len(d.ipSan) == 0 {
+		return errors.New("either subject common name or subject alternative name is required")
+	}
+	if len(d.certFile) == 0 || len(d.keyFile) == 0 {
+		return errors.New("both certificate and key file are required")
+	}
+	if d.ttl < minTTL || d.ttl > MaxTTL {
+		return fmt.Errorf("TTL must be between %s and %s", minTTL, MaxTTL)
+	}
+	if d.renewAt <= 0 || d.renewAt >= 1 {
+		return fmt.Errorf("renewal point must be between 0 and 1, got %g", d.renewAt)
+	}
+	if len(d.haproxy.Address) > 0 && len(d.haproxyCert) == 0 {
+		return errors.New("name of HAProxy certificate is required along with its runtime API socket")
+	}
+	if d.retry <= 0 {
+		return fmt.Errorf("invalid retry interval: %s", d.retry)
+	}
+	return nil
+}
+
+func NewCommand(w io.Writer) *cobra.Command {
+	d := &shortLivedData{
+		w:       w,
+		dir:     ".",
+		profile: profiles.Default,
+		bits:    2048,
+		ttl:     8 * time.Hour,
+		renewAt: 0.5,
+		retry:   time.Minute,
+		haproxy: haproxy.Runtime{Timeout: 10 * time.Second},
+	}
+	cmd := &cobra.Command{
+		Use:   "short-lived",
+		Short: "Issue certificate with lifetime of hours and optionally keep reissuing it before it expires",
+		Long: "Issue certificate with lifetime of hours from stored CA and write it along with new private key to output files.\n" +
+			"Certificate is not kept in store. Certificate in output files that is not yet due for renewal is reused.\n" +
+			"With --watch, command keeps running and replaces certificate once given fraction of its lifetime elapses.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validateShortLived(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d.errw = cmd.ErrOrStderr()
+			return shortLived(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.parent, "parent", d.parent, "Alias of CA that issues certificate")
+	common.AddDNFlags("subject", &d.subject, cmd.Flags(), "")
+	cmd.Flags().StringArrayVar(&d.dnsSan, "dns-san", d.dnsSan, "Optional DNS subject alternative name")
+	cmd.Flags().IPSliceVar(&d.ipSan, "ip-san", d.ipSan, "Optional IP subject alternative name")
+	cmd.Flags().StringVar(&d.profile, "profile", d.profile, "Profile of certificate, one of "+strings.Join(profiles.Names(), ", "))
+	cmd.Flags().IntVar(&d.bits, "bits", d.bits, "Key size (bits), like 2048 or 4096.")
+	cmd.Flags().DurationVar(&d.ttl, "ttl", d.ttl, "Lifetime of certificate, up to "+MaxTTL.String())
+	cmd.Flags().StringVar(&d.certFile, "cert-file", d.certFile, "File to write certificate to")
+	cmd.Flags().StringVar(&d.keyFile, "key-file", d.keyFile, "File to write private key to")
+	cmd.Flags().BoolVar(&d.noChain, "no-chain", d.noChain, "Don't append certificates of intermediate CAs to certificate file")
+	cmd.Flags().Float64Var(&d.renewAt, "renew-at", d.renewAt, "Fraction of lifetime after which certificate is reissued")
+	cmd.Flags().BoolVar(&d.watch, "watch", d.watch, "Keep running and reissue certificate before it expires")
+	cmd.Flags().DurationVar(&d.retry, "retry-interval", d.retry, "How long to wait before failed renewal is retried")
+	cmd.Flags().StringVar(&d.exec, "exec", d.exec, "Shell command to run after certificate is written, like reload of workload. "+
+		"File names are passed in PKITOOL_CERT_FILE and PKITOOL_KEY_FILE environment variables")
+	cmd.Flags().StringVar(&d.haproxy.Address, "haproxy-socket", d.haproxy.Address, "Runtime API socket of HAProxy to push certificate to "+
+		"after it is written, without reload. Either path of UNIX socket, like /run/haproxy/admin.sock, or host:port")
+	cmd.Flags().StringVar(&d.haproxyCert, "haproxy-cert", d.haproxyCert, "Certificate file as referenced by 'crt' in HAProxy configuration, "+
+		"usually the same as --cert-file, with key loaded from <cert-file>.key")
+	cmd.Flags().StringVar(&d.alias, "alias", d.alias, "Name of certificate in events, deploy actions from pkitool.yaml matching it "+
+		"run after certificate is written. Certificate is not kept in store under it")
+	common.AddKeyShareFlag(&d.keyShares, cmd.Flags())
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -17,25 +17,50 @@ limitations under the License.
 package show
 
 import (
+	"context"
+	"crypto/rsa"
 	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/olekukonko/tablewriter"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 	"io"
-	"pkitool/pkg/certmgr"
-	"pkitool/pkg/common"
+	"net"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type propValueGetter func(*certmgr.PairHolder) string
 
 type showData struct {
 	w     io.Writer
+	in    io.Reader
 	alias string
+	file  string
 	dir   string
 	tree  bool
+	chain bool
+	text  bool
+	of    common.OutputFormat
+	cm    common.ColorMode
+}
+
+type chainEntry struct {
+	Alias   string        `json:"alias" yaml:"alias"`
+	Subject string        `json:"subject" yaml:"subject"`
+	ValidTo time.Time     `json:"validTo" yaml:"validTo"`
+	IsCA    bool          `json:"isCA" yaml:"isCA"`
+	Status  common.Status `json:"status" yaml:"status"`
+}
+
+type chainResult struct {
+	Chain  []chainEntry `json:"chain" yaml:"chain"`
+	Broken string       `json:"broken,omitempty" yaml:"broken,omitempty"`
 }
 
 var (
@@ -66,6 +91,47 @@ var (
 		"Valid to": func(holder *certmgr.PairHolder) string {
 			return holder.Cert.NotAfter.String()
 		},
+		"DNS names": func(holder *certmgr.PairHolder) string {
+			return strings.Join(lo.FilterMap(holder.Cert.DNSNames, func(name string, _ int) (string, bool) {
+				return dnsName(name), !certmgr.IsWildcard(name)
+			}), ",")
+		},
+		"Wildcard DNS names": func(holder *certmgr.PairHolder) string {
+			return strings.Join(lo.FilterMap(holder.Cert.DNSNames, func(name string, _ int) (string, bool) {
+				return dnsName(name), certmgr.IsWildcard(name)
+			}), ",")
+		},
+		"IP addresses": func(holder *certmgr.PairHolder) string {
+			return strings.Join(lo.Map(holder.Cert.IPAddresses, func(ip net.IP, _ int) string {
+				return ip.String()
+			}), ",")
+		},
+		"Email addresses": func(holder *certmgr.PairHolder) string {
+			return strings.Join(holder.Cert.EmailAddresses, ",")
+		},
+		"User principal names": func(holder *certmgr.PairHolder) string {
+			upns, err := certmgr.UPNs(holder.Cert)
+			if err != nil {
+				return err.Error()
+			}
+			return strings.Join(upns, ",")
+		},
+		"Security identifier": func(holder *certmgr.PairHolder) string {
+			sid, err := certmgr.SecurityID(holder.Cert)
+			if err != nil {
+				return err.Error()
+			}
+			return sid
+		},
+		"Hardware module names": func(holder *certmgr.PairHolder) string {
+			names, err := certmgr.HardwareModuleNames(holder.Cert)
+			if err != nil {
+				return err.Error()
+			}
+			return strings.Join(lo.Map(names, func(hw certmgr.HardwareModuleName, _ int) string {
+				return hw.String()
+			}), ",")
+		},
 		"Is CA?": func(holder *certmgr.PairHolder) string {
 			return strconv.FormatBool(holder.Cert.IsCA)
 		},
@@ -80,7 +146,10 @@ var (
 			}
 		},
 		"Public exponent": func(holder *certmgr.PairHolder) string {
-			return strconv.Itoa(holder.Key.E)
+			if pk, ok := holder.Cert.PublicKey.(*rsa.PublicKey); ok {
+				return strconv.Itoa(pk.E)
+			}
+			return "N/A"
 		},
 		"Key usage": func(holder *certmgr.PairHolder) string {
 			return strings.Join(
@@ -105,9 +174,18 @@ var (
 	}
 )
 
-func NewCommand(w io.Writer) *cobra.Command {
+// dnsName formats DNS name for display, with Unicode form of internationalized name in parentheses.
+func dnsName(name string) string {
+	if u := certmgr.ToUnicode(name); u != name {
+		return fmt.Sprintf("%s (%s)", name, u)
+	}
+	return name
+}
+
+func NewCommand(in io.Reader, w io.Writer) *cobra.Command {
 	d := &showData{
 		w:    w,
+		in:   in,
 		dir:  ".",
 		tree: false,
 	}
@@ -118,42 +196,153 @@ func NewCommand(w io.Writer) *cobra.Command {
 			return validate(d)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return show(d)
+			d.of = common.OutputFormatOf(cmd)
+			d.cm = common.ColorModeOf(cmd)
+			return show(cmd.Context(), d)
 		},
 	}
 	cmd.Flags().StringVar(&d.alias, "alias", "", "Alias of certificate to show.")
+	cmd.Flags().StringVar(&d.file, "file", "", "PEM file with certificate to show instead of alias. Use '-' to read from standard input.")
 	cmd.Flags().BoolVar(&d.tree, "tree", d.tree, "Whether to display information as a tree")
+	cmd.Flags().BoolVar(&d.chain, "chain", d.chain, "Whether to display whole chain from certificate up to its root")
+	cmd.Flags().BoolVar(&d.text, "text", d.text, "Whether to display all fields and extensions as text, like 'openssl x509 -text'. "+
+		"With --chain, every certificate of chain is displayed")
 	common.AddDirFlag(&d.dir, cmd.Flags())
 	return cmd
 }
 
 func validate(d *showData) error {
-	if len(d.alias) == 0 {
-		return common.ErrAliasMissing
+	if len(d.alias) == 0 && len(d.file) == 0 {
+		return common.ErrAliasOrFileMissing
 	}
 	return nil
 }
 
-func showTable(ph *certmgr.PairHolder, w io.Writer) {
-	tbl := tablewriter.NewWriter(w)
-	tbl.SetHeader([]string{
-		"Property", "Value",
-	})
-	tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+// load gets certificate either from file or from directory.
+// Private key is not loaded from directory, since nothing shown depends on it.
+func load(ctx context.Context, d *showData, cm certmgr.Reader) (*certmgr.PairHolder, error) {
+	if len(d.file) == 0 {
+		cert, err := cm.GetCert(ctx, d.alias)
+		if err != nil {
+			return nil, err
+		}
+		return &certmgr.PairHolder{Alias: d.alias, Cert: cert}, nil
+	}
+	data, err := common.ReadInput(d.file, d.in)
+	if err != nil {
+		return nil, err
+	}
+	ph, err := certmgr.ParsePairPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	ph.Alias = d.alias
+	if len(ph.Alias) == 0 {
+		ph.Alias = d.file
+		if d.file == common.StdinMarker {
+			ph.Alias = "<stdin>"
+		}
+	}
+	return ph, nil
+}
+
+func showTable(ph *certmgr.PairHolder, w io.Writer, of common.OutputFormat) error {
 	propKeys := lo.Keys(props)
 	slices.Sort(propKeys)
+	values := make(map[string]string, len(propKeys))
 	for _, e := range propKeys {
-		tbl.Append([]string{e, props[e](ph)})
+		values[e] = props[e](ph)
 	}
-	tbl.Render()
+	return common.Render(w, of, values, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"Property", "Value",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range propKeys {
+			tbl.Append([]string{e, values[e]})
+		}
+	})
 }
 
-func show(d *showData) error {
-	cm := certmgr.New(d.dir)
-	ph, err := cm.Get(d.alias)
+func showChain(ctx context.Context, d *showData, cm certmgr.Reader, ph *certmgr.PairHolder) error {
+	var (
+		res   chainResult
+		chain []*certmgr.PairHolder
+		err   error
+	)
+	now := time.Now()
+	if len(d.file) == 0 {
+		chain, err = cm.GetChain(ctx, d.alias)
+	} else {
+		chain, err = certmgr.BuildChain(ctx, cm, ph)
+	}
 	if err != nil {
+		if !errors.Is(err, certmgr.ErrChainBroken) {
+			return err
+		}
+		res.Broken = err.Error()
+	}
+	for _, e := range chain {
+		res.Chain = append(res.Chain, chainEntry{
+			Alias:   e.Alias,
+			Subject: e.Cert.Subject.String(),
+			ValidTo: e.Cert.NotAfter,
+			IsCA:    e.Cert.IsCA,
+			Status:  common.StatusOf(e.Cert, now),
+		})
+	}
+	c := common.NewColorizer(d.cm, d.w)
+	if err := common.Render(d.w, d.of, res, func(tbl *tablewriter.Table) {
+		tbl.SetHeader([]string{
+			"#", "Alias", "Subject", "Valid to", "Type", "Status",
+		})
+		tbl.SetAlignment(tablewriter.ALIGN_LEFT)
+		for i, e := range res.Chain {
+			tbl.Append([]string{strconv.Itoa(i), e.Alias, e.Subject, e.ValidTo.String(), c.Kind(e.IsCA), c.Status(e.Status)})
+		}
+	}); err != nil {
 		return err
 	}
-	showTable(ph, d.w)
+	if d.of == common.OutputTable && len(res.Broken) > 0 {
+		_, err := fmt.Fprintln(d.w, res.Broken)
+		return err
+	}
+	return nil
+}
+
+// showText displays certificate, or whole its chain, in the same form as openssl does.
+func showText(ctx context.Context, d *showData, cm certmgr.Reader, ph *certmgr.PairHolder) error {
+	chain := []*certmgr.PairHolder{ph}
+	if d.chain {
+		var err error
+		if len(d.file) == 0 {
+			chain, err = cm.GetChain(ctx, d.alias)
+		} else {
+			chain, err = certmgr.BuildChain(ctx, cm, ph)
+		}
+		if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+			return err
+		}
+	}
+	for _, e := range chain {
+		if err := writeText(d.w, e.Cert); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func show(ctx context.Context, d *showData) error {
+	var cm certmgr.Reader = certmgr.New(d.dir)
+	ph, err := load(ctx, d, cm)
+	if err != nil {
+		return err
+	}
+	if d.text {
+		return showText(ctx, d, cm, ph)
+	}
+	if d.chain {
+		return showChain(ctx, d, cm, ph)
+	}
+	return showTable(ph, d.w, d.of)
+}
@@ -0,0 +1,630 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package show
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// openssl-compatible names of signature algorithms, as printed by "openssl x509 -text"
+var sigAlgNames = map[x509.SignatureAlgorithm]string{
+	x509.MD5WithRSA:       "md5WithRSAEncryption",
+	x509.SHA1WithRSA:      "sha1WithRSAEncryption",
+	x509.SHA256WithRSA:    "sha256WithRSAEncryption",
+	x509.SHA384WithRSA:    "sha384WithRSAEncryption",
+	x509.SHA512WithRSA:    "sha512WithRSAEncryption",
+	x509.SHA256WithRSAPSS: "rsassaPss",
+	x509.SHA384WithRSAPSS: "rsassaPss",
+	x509.SHA512WithRSAPSS: "rsassaPss",
+	x509.ECDSAWithSHA1:    "ecdsa-with-SHA1",
+	x509.ECDSAWithSHA256:  "ecdsa-with-SHA256",
+	x509.ECDSAWithSHA384:  "ecdsa-with-SHA384",
+	x509.ECDSAWithSHA512:  "ecdsa-with-SHA512",
+	x509.PureEd25519:      "ED25519",
+}
+
+// short names of attributes of distinguished name
+var attrNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.4":                    "SN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.12":                   "title",
+	"2.5.4.17":                   "postalCode",
+	"2.5.4.15":                   "businessCategory",
+	"2.5.4.42":                   "GN",
+	"2.5.4.46":                   "dnQualifier",
+	"2.5.4.65":                   "pseudonym",
+	"2.5.4.97":                   "organizationIdentifier",
+	"1.3.6.1.4.1.311.60.2.1.1":   "jurisdictionL",
+	"1.3.6.1.4.1.311.60.2.1.2":   "jurisdictionST",
+	"1.3.6.1.4.1.311.60.2.1.3":   "jurisdictionC",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+var kuNames = []struct {
+	ku   x509.KeyUsage
+	name string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Non Repudiation"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var ekuNames = map[string]string{
+	"2.5.29.37.0":             "Any Extended Key Usage",
+	"1.3.6.1.5.5.7.3.1":       "TLS Web Server Authentication",
+	"1.3.6.1.5.5.7.3.2":       "TLS Web Client Authentication",
+	"1.3.6.1.5.5.7.3.3":       "Code Signing",
+	"1.3.6.1.5.5.7.3.4":       "E-mail Protection",
+	"1.3.6.1.5.5.7.3.5":       "IPSec End System",
+	"1.3.6.1.5.5.7.3.6":       "IPSec Tunnel",
+	"1.3.6.1.5.5.7.3.7":       "IPSec User",
+	"1.3.6.1.5.5.7.3.8":       "Time Stamping",
+	"1.3.6.1.5.5.7.3.9":       "OCSP Signing",
+	"1.3.6.1.5.5.7.3.17":      "ipsec Internet Key Exchange",
+	"1.3.6.1.5.2.3.5":         "Signing KDC Response",
+	"1.3.6.1.4.1.311.20.2.2":  "Microsoft Smartcard Login",
+	"1.3.6.1.4.1.311.10.3.4":  "Microsoft Encrypted File System",
+	"1.3.6.1.4.1.311.2.1.21":  "Microsoft Individual Code Signing",
+	"1.3.6.1.4.1.311.2.1.22":  "Microsoft Commercial Code Signing",
+	"1.3.6.1.4.1.311.10.3.3":  "Microsoft Server Gated Crypto",
+	"2.16.840.1.113730.4.1":   "Netscape Server Gated Crypto",
+	"1.3.6.1.4.1.11129.2.5.3": "Certificate Transparency",
+}
+
+// names of extensions, together with functions that format their values.
+// Value of extension without formatter is dumped as hex.
+var extensions = map[string]struct {
+	name   string
+	format func(*x509.Certificate, []byte) ([]string, error)
+}{
+	"2.5.29.14":               {"X509v3 Subject Key Identifier", fmtSubjectKeyID},
+	"2.5.29.15":               {"X509v3 Key Usage", fmtKeyUsage},
+	"2.5.29.17":               {"X509v3 Subject Alternative Name", fmtGeneralNames},
+	"2.5.29.18":               {"X509v3 Issuer Alternative Name", fmtGeneralNames},
+	"2.5.29.19":               {"X509v3 Basic Constraints", fmtBasicConstraints},
+	"2.5.29.30":               {"X509v3 Name Constraints", fmtNameConstraints},
+	"2.5.29.31":               {"X509v3 CRL Distribution Points", fmtCRLDistributionPoints},
+	"2.5.29.32":               {"X509v3 Certificate Policies", fmtPolicies},
+	"2.5.29.35":               {"X509v3 Authority Key Identifier", fmtAuthorityKeyID},
+	"2.5.29.37":               {"X509v3 Extended Key Usage", fmtExtKeyUsage},
+	"2.5.29.16":               {"X509v3 Private Key Usage Period", nil},
+	"1.3.6.1.5.5.7.1.1":       {"Authority Information Access", fmtAuthorityInfoAccess},
+	"1.3.6.1.5.5.7.1.24":      {"TLS Feature", fmtTLSFeature},
+	"1.3.6.1.5.5.7.48.1.5":    {"OCSP No Check", nil},
+	"1.3.6.1.4.1.11129.2.4.2": {"CT Precertificate SCTs", nil},
+	"1.3.6.1.4.1.11129.2.4.3": {"CT Precertificate Poison", nil},
+	"1.3.6.1.4.1.311.25.2":    {"Microsoft NTDS CA Extension", nil},
+	"1.3.6.1.4.1.44363.44":    {"Delegation Usage", nil},
+	"2.16.840.1.113730.1.1":   {"Netscape Cert Type", fmtNetscapeCertType},
+	"2.16.840.1.113730.1.13":  {"Netscape Comment", fmtString},
+}
+
+// textWriter writes lines indented by multiples of 4 spaces.
+type textWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *textWriter) line(indent int, format string, args ...interface{}) {
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.w, strings.Repeat("    ", indent)+format+"\n", args...)
+	}
+}
+
+// hexLines formats bytes as colon-separated hex, n bytes per line.
+func hexLines(b []byte, n int) []string {
+	var lines []string
+	for len(b) > 0 {
+		chunk := b[:min(n, len(b))]
+		b = b[len(chunk):]
+		s := hexColon(chunk, false)
+		if len(b) > 0 {
+			s += ":"
+		}
+		lines = append(lines, s)
+	}
+	return lines
+}
+
+func hexColon(b []byte, upper bool) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		if upper {
+			parts[i] = fmt.Sprintf("%02X", c)
+		} else {
+			parts[i] = fmt.Sprintf("%02x", c)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// formatName formats distinguished name in order it's encoded in, as openssl does.
+// With slashes, it's formatted as /C=CZ/CN=name, the form openssl uses within extensions.
+func formatName(raw []byte, slashes bool) string {
+	var rdns pkix.RDNSequence
+	if _, err := asn1.Unmarshal(raw, &rdns); err != nil {
+		return "<invalid name>"
+	}
+	var parts []string
+	for _, rdn := range rdns {
+		for _, atv := range rdn {
+			name, ok := attrNames[atv.Type.String()]
+			if !ok {
+				name = atv.Type.String()
+			}
+			value := fmt.Sprint(atv.Value)
+			switch {
+			case slashes:
+				parts = append(parts, "/"+name+"="+value)
+			case strings.ContainsAny(value, ",+;<>\""):
+				parts = append(parts, fmt.Sprintf("%s = \"%s\"", name, strings.ReplaceAll(value, "\"", "\\\"")))
+			default:
+				parts = append(parts, name+" = "+value)
+			}
+		}
+	}
+	if slashes {
+		return strings.Join(parts, "")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatTime(cert *x509.Certificate, notAfter bool) string {
+	t := cert.NotBefore
+	if notAfter {
+		t = cert.NotAfter
+	}
+	return t.UTC().Format("Jan _2 15:04:05 2006") + " GMT"
+}
+
+func sigAlgName(alg x509.SignatureAlgorithm) string {
+	if name, ok := sigAlgNames[alg]; ok {
+		return name
+	}
+	return alg.String()
+}
+
+func writePublicKey(t *textWriter, cert *x509.Certificate) {
+	switch pk := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		t.line(3, "Public Key Algorithm: rsaEncryption")
+		t.line(4, "Public-Key: (%d bit)", pk.N.BitLen())
+		t.line(4, "Modulus:")
+		// leading zero byte keeps modulus positive, as in DER
+		for _, l := range hexLines(append([]byte{0}, pk.N.Bytes()...), 15) {
+			t.line(5, "%s", l)
+		}
+		t.line(4, "Exponent: %d (0x%x)", pk.E, pk.E)
+	case *ecdsa.PublicKey:
+		t.line(3, "Public Key Algorithm: id-ecPublicKey")
+		t.line(4, "Public-Key: (%d bit)", pk.Curve.Params().BitSize)
+		t.line(4, "pub:")
+		//nolint:staticcheck // uncompressed point is what openssl prints
+		for _, l := range hexLines(elliptic.Marshal(pk.Curve, pk.X, pk.Y), 15) {
+			t.line(5, "%s", l)
+		}
+		curves := map[string]string{"P-224": "secp224r1", "P-256": "prime256v1", "P-384": "secp384r1", "P-521": "secp521r1"}
+		t.line(4, "ASN1 OID: %s", curves[pk.Curve.Params().Name])
+		t.line(4, "NIST CURVE: %s", pk.Curve.Params().Name)
+	case ed25519.PublicKey:
+		t.line(3, "Public Key Algorithm: ED25519")
+		t.line(4, "ED25519 Public-Key:")
+		t.line(4, "pub:")
+		for _, l := range hexLines(pk, 15) {
+			t.line(5, "%s", l)
+		}
+	default:
+		t.line(3, "Public Key Algorithm: %s", cert.PublicKeyAlgorithm)
+	}
+}
+
+func fmtSubjectKeyID(cert *x509.Certificate, _ []byte) ([]string, error) {
+	return []string{hexColon(cert.SubjectKeyId, true)}, nil
+}
+
+func fmtAuthorityKeyID(_ *x509.Certificate, value []byte) ([]string, error) {
+	var aki struct {
+		KeyID  []byte          `asn1:"optional,tag:0"`
+		Issuer []asn1.RawValue `asn1:"optional,tag:1"`
+		Serial *big.Int        `asn1:"optional,tag:2"`
+	}
+	if _, err := asn1.Unmarshal(value, &aki); err != nil {
+		return nil, err
+	}
+	// key identifier alone is printed without label
+	if len(aki.Issuer) == 0 && aki.Serial == nil {
+		return []string{hexColon(aki.KeyID, true)}, nil
+	}
+	var lines []string
+	if len(aki.KeyID) > 0 {
+		lines = append(lines, "keyid:"+hexColon(aki.KeyID, true))
+	}
+	for _, n := range aki.Issuer {
+		lines = append(lines, generalName(n))
+	}
+	if aki.Serial != nil {
+		lines = append(lines, "serial:"+hexColon(aki.Serial.Bytes(), true))
+	}
+	return lines, nil
+}
+
+func fmtKeyUsage(cert *x509.Certificate, _ []byte) ([]string, error) {
+	var names []string
+	for _, e := range kuNames {
+		if cert.KeyUsage&e.ku != 0 {
+			names = append(names, e.name)
+		}
+	}
+	return []string{strings.Join(names, ", ")}, nil
+}
+
+func fmtExtKeyUsage(_ *x509.Certificate, value []byte) ([]string, error) {
+	var oids []asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(value, &oids); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(oids))
+	for i, oid := range oids {
+		if names[i] = ekuNames[oid.String()]; len(names[i]) == 0 {
+			names[i] = oid.String()
+		}
+	}
+	return []string{strings.Join(names, ", ")}, nil
+}
+
+func fmtBasicConstraints(cert *x509.Certificate, _ []byte) ([]string, error) {
+	if !cert.IsCA {
+		return []string{"CA:FALSE"}, nil
+	}
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		return []string{fmt.Sprintf("CA:TRUE, pathlen:%d", cert.MaxPathLen)}, nil
+	}
+	return []string{"CA:TRUE"}, nil
+}
+
+// ipString formats IP address, IPv6 one is not compressed, as openssl does.
+func ipString(ip []byte) string {
+	if len(ip) == net.IPv4len {
+		return net.IP(ip).String()
+	}
+	groups := make([]string, len(ip)/2)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%X", int(ip[2*i])<<8|int(ip[2*i+1]))
+	}
+	return strings.Join(groups, ":")
+}
+
+// generalName formats single GeneralName, see RFC 5280, section 4.2.1.6.
+func generalName(name asn1.RawValue) string {
+	if name.Class != asn1.ClassContextSpecific {
+		return "<unsupported>"
+	}
+	switch name.Tag {
+	case 0:
+		var on struct {
+			TypeID asn1.ObjectIdentifier
+			Value  asn1.RawValue `asn1:"explicit,tag:0"`
+		}
+		if _, err := asn1.UnmarshalWithParams(name.FullBytes, &on, "tag:0"); err != nil {
+			return "othername: <invalid>"
+		}
+		var s string
+		if _, err := asn1.Unmarshal(on.Value.FullBytes, &s); err == nil {
+			if on.TypeID.String() == "1.3.6.1.4.1.311.20.2.3" {
+				return "othername: UPN::" + s
+			}
+			return fmt.Sprintf("othername: %s::%s", on.TypeID, s)
+		}
+		return fmt.Sprintf("othername: %s::%s", on.TypeID, hexColon(on.Value.Bytes, true))
+	case 1:
+		return "email:" + string(name.Bytes)
+	case 2:
+		return "DNS:" + string(name.Bytes)
+	case 4:
+		return "DirName:" + formatName(name.Bytes, true)
+	case 6:
+		return "URI:" + string(name.Bytes)
+	case 7:
+		switch len(name.Bytes) {
+		case net.IPv4len, net.IPv6len:
+			return "IP Address:" + ipString(name.Bytes)
+		case 2 * net.IPv4len, 2 * net.IPv6len:
+			// address and mask, used in name constraints
+			n := len(name.Bytes) / 2
+			return "IP:" + ipString(name.Bytes[:n]) + "/" + ipString(name.Bytes[n:])
+		}
+		return "IP Address:<invalid>"
+	case 8:
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.UnmarshalWithParams(name.FullBytes, &oid, "tag:8"); err != nil {
+			return "Registered ID:<invalid>"
+		}
+		return "Registered ID:" + oid.String()
+	}
+	return "<unsupported>"
+}
+
+func fmtGeneralNames(_ *x509.Certificate, value []byte) ([]string, error) {
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(value, &names); err != nil {
+		return nil, err
+	}
+	res := make([]string, len(names))
+	for i, n := range names {
+		res[i] = generalName(n)
+	}
+	return []string{strings.Join(res, ", ")}, nil
+}
+
+func fmtNameConstraints(_ *x509.Certificate, value []byte) ([]string, error) {
+	var nc struct {
+		Permitted []struct {
+			Base asn1.RawValue
+		} `asn1:"optional,tag:0"`
+		Excluded []struct {
+			Base asn1.RawValue
+		} `asn1:"optional,tag:1"`
+	}
+	if _, err := asn1.Unmarshal(value, &nc); err != nil {
+		return nil, err
+	}
+	var lines []string
+	if len(nc.Permitted) > 0 {
+		lines = append(lines, "Permitted:")
+		for _, s := range nc.Permitted {
+			lines = append(lines, "  "+generalName(s.Base))
+		}
+	}
+	if len(nc.Excluded) > 0 {
+		lines = append(lines, "Excluded:")
+		for _, s := range nc.Excluded {
+			lines = append(lines, "  "+generalName(s.Base))
+		}
+	}
+	return lines, nil
+}
+
+func fmtCRLDistributionPoints(_ *x509.Certificate, value []byte) ([]string, error) {
+	var points []struct {
+		Name asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(value, &points); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, p := range points {
+		// fullName [0] of distributionPoint holds GeneralNames
+		var full asn1.RawValue
+		if _, err := asn1.Unmarshal(p.Name.Bytes, &full); err != nil || full.Tag != 0 {
+			continue
+		}
+		lines = append(lines, "Full Name:")
+		rest := full.Bytes
+		for len(rest) > 0 {
+			var n asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &n); err != nil {
+				return nil, err
+			}
+			lines = append(lines, "  "+generalName(n))
+		}
+	}
+	return lines, nil
+}
+
+func fmtPolicies(_ *x509.Certificate, value []byte) ([]string, error) {
+	var policies []struct {
+		ID         asn1.ObjectIdentifier
+		Qualifiers []struct {
+			ID    asn1.ObjectIdentifier
+			Value asn1.RawValue
+		} `asn1:"optional"`
+	}
+	if _, err := asn1.Unmarshal(value, &policies); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, p := range policies {
+		if p.ID.String() == "2.5.29.32.0" {
+			lines = append(lines, "Policy: X509v3 Any Policy")
+		} else {
+			lines = append(lines, "Policy: "+p.ID.String())
+		}
+		for _, q := range p.Qualifiers {
+			switch q.ID.String() {
+			case "1.3.6.1.5.5.7.2.1":
+				lines = append(lines, "  CPS: "+string(q.Value.Bytes))
+			case "1.3.6.1.5.5.7.2.2":
+				lines = append(lines, "  User Notice:")
+				// only explicit text is shown, notice reference is rare
+				rest := q.Value.Bytes
+				for len(rest) > 0 {
+					var v asn1.RawValue
+					var err error
+					if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+						return nil, err
+					}
+					if v.Class == asn1.ClassUniversal && v.Tag != asn1.TagSequence {
+						lines = append(lines, "    Explicit Text: "+displayText(v))
+					}
+				}
+			default:
+				lines = append(lines, "  "+q.ID.String())
+			}
+		}
+	}
+	return lines, nil
+}
+
+// displayText decodes DisplayText of user notice, which may be BMPString.
+func displayText(v asn1.RawValue) string {
+	if v.Tag != asn1.TagBMPString {
+		return string(v.Bytes)
+	}
+	runes := make([]rune, 0, len(v.Bytes)/2)
+	for i := 0; i+1 < len(v.Bytes); i += 2 {
+		runes = append(runes, rune(v.Bytes[i])<<8|rune(v.Bytes[i+1]))
+	}
+	return string(runes)
+}
+
+func fmtAuthorityInfoAccess(_ *x509.Certificate, value []byte) ([]string, error) {
+	var ads []struct {
+		Method   asn1.ObjectIdentifier
+		Location asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(value, &ads); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, ad := range ads {
+		method := ad.Method.String()
+		switch method {
+		case "1.3.6.1.5.5.7.48.1":
+			method = "OCSP"
+		case "1.3.6.1.5.5.7.48.2":
+			method = "CA Issuers"
+		}
+		lines = append(lines, method+" - "+generalName(ad.Location))
+	}
+	return lines, nil
+}
+
+func fmtTLSFeature(_ *x509.Certificate, value []byte) ([]string, error) {
+	var features []int
+	if _, err := asn1.Unmarshal(value, &features); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(features))
+	for i, f := range features {
+		switch f {
+		case 5:
+			names[i] = "status_request"
+		case 17:
+			names[i] = "status_request_v2"
+		default:
+			names[i] = fmt.Sprint(f)
+		}
+	}
+	return []string{strings.Join(names, ", ")}, nil
+}
+
+func fmtNetscapeCertType(_ *x509.Certificate, value []byte) ([]string, error) {
+	var bits asn1.BitString
+	if _, err := asn1.Unmarshal(value, &bits); err != nil {
+		return nil, err
+	}
+	var names []string
+	for i, name := range []string{"SSL Client", "SSL Server", "S/MIME", "Object Signing", "Unused", "SSL CA", "S/MIME CA", "Object Signing CA"} {
+		if bits.At(i) == 1 {
+			names = append(names, name)
+		}
+	}
+	return []string{strings.Join(names, ", ")}, nil
+}
+
+func fmtString(_ *x509.Certificate, value []byte) ([]string, error) {
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(value, &v); err != nil {
+		return nil, err
+	}
+	return []string{displayText(v)}, nil
+}
+
+func writeExtensions(t *textWriter, cert *x509.Certificate) {
+	if len(cert.Extensions) == 0 {
+		return
+	}
+	t.line(2, "X509v3 extensions:")
+	for _, ext := range cert.Extensions {
+		critical := ""
+		if ext.Critical {
+			critical = "critical"
+		}
+		e, ok := extensions[ext.Id.String()]
+		if !ok {
+			e.name = ext.Id.String()
+		}
+		t.line(3, "%s: %s", e.name, critical)
+		var lines []string
+		var err error
+		if e.format != nil {
+			lines, err = e.format(cert, ext.Value)
+		}
+		if e.format == nil || err != nil {
+			lines = hexLines(ext.Value, 18)
+		}
+		for _, l := range lines {
+			t.line(4, "%s", l)
+		}
+	}
+}
+
+// writeText writes certificate in the same form as "openssl x509 -text -noout".
+func writeText(w io.Writer, cert *x509.Certificate) error {
+	t := &textWriter{w: w}
+	t.line(0, "Certificate:")
+	t.line(1, "Data:")
+	t.line(2, "Version: %d (0x%x)", cert.Version, cert.Version-1)
+	if cert.SerialNumber.Sign() >= 0 && cert.SerialNumber.IsInt64() {
+		t.line(2, "Serial Number: %s (0x%x)", cert.SerialNumber, cert.SerialNumber)
+	} else {
+		t.line(2, "Serial Number:")
+		t.line(3, "%s", hexColon(cert.SerialNumber.Bytes(), false))
+	}
+	t.line(2, "Signature Algorithm: %s", sigAlgName(cert.SignatureAlgorithm))
+	t.line(2, "Issuer: %s", formatName(cert.RawIssuer, false))
+	t.line(2, "Validity")
+	t.line(3, "Not Before: %s", formatTime(cert, false))
+	t.line(3, "Not After : %s", formatTime(cert, true))
+	t.line(2, "Subject: %s", formatName(cert.RawSubject, false))
+	t.line(2, "Subject Public Key Info:")
+	writePublicKey(t, cert)
+	writeExtensions(t, cert)
+	t.line(1, "Signature Algorithm: %s", sigAlgName(cert.SignatureAlgorithm))
+	t.line(1, "Signature Value:")
+	for _, l := range hexLines(cert.Signature, 18) {
+		t.line(2, "%s", l)
+	}
+	return t.err
+}
@@ -0,0 +1,383 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package signer implements remote signing, where CA private keys stay on signing server
+// and clients send certificate signing requests to it over mutual TLS.
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/approval"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/profiles"
+	"github.com/rkosegi/pkitool/pkg/ratelimit"
+	"github.com/rkosegi/pkitool/pkg/rbac"
+	"github.com/spf13/pflag"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path is URL path of signing endpoint.
+const Path = "/v1/sign"
+
+// maximal size of request body, CSR is few kilobytes at most
+const maxRequestSize = 64 << 10
+
+// SignRequest asks signing server to issue leaf certificate.
+type SignRequest struct {
+	// Parent is alias of issuing CA on server
+	Parent string `json:"parent"`
+	// CSR is certificate signing request in PEM format
+	CSR string `json:"csr"`
+	// Profile is name of profile, default one is used when empty
+	Profile    string `json:"profile,omitempty"`
+	ValidYears int    `json:"validYears,omitempty"`
+	// ValidDays, when set, takes precedence over ValidYears
+	ValidDays        int  `json:"validDays,omitempty"`
+	AllowAnyWildcard bool `json:"allowAnyWildcard,omitempty"`
+	AllowUnderscore  bool `json:"allowUnderscore,omitempty"`
+	// ApprovalID is ID of approved request, when issuance requires approval
+	ApprovalID string `json:"approvalId,omitempty"`
+}
+
+// SignResponse carries issued certificate. Error is set instead when request failed.
+type SignResponse struct {
+	// Certificate is issued certificate in PEM format
+	Certificate string `json:"certificate,omitempty"`
+	// Chain are issuers of certificate in PEM format, up to (but excluding) root CA
+	Chain string `json:"chain,omitempty"`
+	Error string `json:"error,omitempty"`
+	// ApprovalID is ID of request that needs to be approved before issuance can proceed
+	ApprovalID string `json:"approvalId,omitempty"`
+}
+
+// Handler serves signing requests using CAs of certificate manager.
+// Caller is expected to authenticate clients, usually by requiring client certificate.
+type Handler struct {
+	cm certmgr.Interface
+	// parents are aliases of CAs clients may request certificates from
+	parents []string
+	// Approvals of issuance, nil means that no issuance requires approval
+	Approvals *approval.Manager
+	// Limits of clients and quotas of CAs, nil means that issuance is not limited
+	Limits *ratelimit.Limiter
+}
+
+// NewHandler creates handler that issues certificates from given parents only.
+func NewHandler(cm certmgr.Interface, parents []string) *Handler {
+	return &Handler{cm: cm, parents: parents}
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *SignResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeResponse(w, http.StatusMethodNotAllowed, &SignResponse{Error: "only POST is allowed"})
+		return
+	}
+	var req SignRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeResponse(w, http.StatusBadRequest, &SignResponse{Error: "invalid request: " + err.Error()})
+		return
+	}
+	if !slices.Contains(h.parents, req.Parent) {
+		writeResponse(w, http.StatusForbidden, &SignResponse{Error: fmt.Sprintf("issuing from '%s' is not allowed", req.Parent)})
+		return
+	}
+	resp, err := h.sign(r, &req)
+	if err != nil {
+		resp = &SignResponse{Error: err.Error()}
+		var pending *approval.PendingError
+		if errors.As(err, &pending) {
+			resp.ApprovalID = pending.Request.ID
+		}
+		ratelimit.SetRetryAfter(w, err)
+		writeResponse(w, statusOf(err), resp)
+		return
+	}
+	writeResponse(w, http.StatusOK, resp)
+}
+
+var errInvalidRequest = errors.New("invalid request")
+
+// Upper bounds of validity requested by client, certificate never outlives its parent CA anyway
+const (
+	maxValidYears = 10
+	maxValidDays  = 3660
+)
+
+// statusOf maps error of signing to HTTP status.
+func statusOf(err error) int {
+	var pending *approval.PendingError
+	switch {
+	case errors.As(err, &pending):
+		return http.StatusAccepted
+	case errors.Is(err, approval.ErrInvalidApproval), errors.Is(err, approval.ErrUnauthenticated):
+		return http.StatusForbidden
+	case errors.Is(err, ratelimit.ErrRateLimited), errors.Is(err, ratelimit.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, certmgr.ErrPolicyViolation), errors.Is(err, certmgr.ErrConstraintViolation):
+		return http.StatusForbidden
+	case errors.Is(err, errInvalidRequest),
+		errors.Is(err, profiles.ErrUnknownProfile),
+		errors.Is(err, certmgr.ErrInvalidDNSName),
+		errors.Is(err, certmgr.ErrInvalidWildcard),
+		errors.Is(err, certmgr.ErrDuplicateSAN),
+		errors.Is(err, certmgr.ErrInvalidValidity):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// validity computes validity of certificate requested by req, clamped so that certificate doesn't outlive its parent CA.
+// Zero is returned when ValidYears of request is to be used as is.
+func (h *Handler) validity(ctx context.Context, req *SignRequest) (time.Duration, error) {
+	parent, err := h.cm.GetCert(ctx, req.Parent)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	left := parent.NotAfter.Sub(now).Truncate(time.Second)
+	if left <= 0 {
+		return 0, fmt.Errorf("%w: parent CA %s has expired", errInvalidRequest, req.Parent)
+	}
+	validity := time.Duration(req.ValidDays) * 24 * time.Hour
+	switch {
+	case validity > 0:
+	case req.ValidYears > 0:
+		validity = now.AddDate(req.ValidYears, 0, 0).Sub(now)
+	default:
+		// missing validity is rejected by certificate manager
+		return 0, nil
+	}
+	return min(validity, left), nil
+}
+
+// sign issues certificate for request made by client of HTTP request r.
+func (h *Handler) sign(r *http.Request, req *SignRequest) (*SignResponse, error) {
+	ctx := r.Context()
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: CSR is not PEM encoded certificate request", errInvalidRequest)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidRequest, err)
+	}
+	name := req.Profile
+	if len(name) == 0 {
+		name = profiles.Default
+	}
+	p, err := profiles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.UnknownExtKeyUsage) > 0 || p.NoExpiry {
+		return nil, fmt.Errorf("%w: profile %s can't be used for remote signing", errInvalidRequest, name)
+	}
+	if req.ValidYears < 0 || req.ValidYears > maxValidYears {
+		return nil, fmt.Errorf("%w: valid years must be between 0 and %d", errInvalidRequest, maxValidYears)
+	}
+	if req.ValidDays < 0 || req.ValidDays > maxValidDays {
+		return nil, fmt.Errorf("%w: valid days must be between 0 and %d", errInvalidRequest, maxValidDays)
+	}
+	params := approval.CSRParams(req.Parent, csr)
+	params["profile"] = p.Name
+	params["validYears"] = strconv.Itoa(req.ValidYears)
+	params["validDays"] = strconv.Itoa(req.ValidDays)
+	params["allowAnyWildcard"] = strconv.FormatBool(req.AllowAnyWildcard)
+	params["allowUnderscore"] = strconv.FormatBool(req.AllowUnderscore)
+	if err = h.Approvals.Authorize(ctx, approval.OpIssue, csr.Subject.String(), rbac.IdentityOf(r).Name(), req.ApprovalID, params); err != nil {
+		return nil, err
+	}
+	if err = h.Limits.Allow(ratelimit.Client(r), req.Parent); err != nil {
+		return nil, err
+	}
+	validity, err := h.validity(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := h.cm.SignCSR(ctx, req.Parent, csr, &certmgr.SignOptions{
+		ValidYears:       req.ValidYears,
+		Validity:         validity,
+		ExtKeyUsage:      p.ExtKeyUsage,
+		AllowAnyWildcard: req.AllowAnyWildcard,
+		AllowUnderscore:  req.AllowUnderscore,
+		Profile:          p.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	chain, err := h.cm.GetChain(ctx, req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	resp := &SignResponse{Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))}
+	for _, e := range chain {
+		if !certmgr.IsSelfSigned(e.Cert) {
+			resp.Chain += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.Cert.Raw}))
+		}
+	}
+	return resp, nil
+}
+
+// Options are settings of client of signing server. Certificates used for mutual TLS are taken from local store.
+type Options struct {
+	// URL is base URL of signing server, remote signing is disabled when empty
+	URL string
+	// CertAlias is alias of client certificate and key presented to server
+	CertAlias string
+	// CAAlias is alias of CA that issued server certificate, system roots are trusted when empty
+	CAAlias string
+	Timeout time.Duration
+	// ApprovalID is ID of approved request, when signing server requires approval of issuance
+	ApprovalID string
+}
+
+// AddFlags adds flags to set client options.
+func AddFlags(o *Options, pf *pflag.FlagSet) {
+	if o.Timeout == 0 {
+		o.Timeout = 30 * time.Second
+	}
+	pf.StringVar(&o.URL, "remote-signer", o.URL, "URL of signing server holding private key of parent CA, like https://signer.acme.tld:8444. "+
+		"Only CSR is sent, private key of certificate is generated and kept locally")
+	pf.StringVar(&o.CertAlias, "remote-signer-cert", o.CertAlias, "Alias of client certificate to authenticate to signing server with")
+	pf.StringVar(&o.CAAlias, "remote-signer-ca", o.CAAlias, "Alias of CA that issued certificate of signing server, system roots are trusted when not set")
+	pf.DurationVar(&o.Timeout, "remote-signer-timeout", o.Timeout, "Timeout of request to signing server")
+	pf.StringVar(&o.ApprovalID, "remote-signer-approval", o.ApprovalID, "ID of approved request, when signing server requires approval of issuance")
+}
+
+// Enabled tells whether remote signing is configured.
+func (o *Options) Enabled() bool {
+	return len(o.URL) > 0
+}
+
+// NewClient creates client using options, with TLS certificates loaded from local store.
+func (o *Options) NewClient(ctx context.Context, cm certmgr.Reader) (*Client, error) {
+	if !strings.HasPrefix(o.URL, "https://") {
+		return nil, fmt.Errorf("signing server must be reached over HTTPS: %s", o.URL)
+	}
+	if len(o.CertAlias) == 0 {
+		return nil, errors.New("client certificate to authenticate to signing server is required")
+	}
+	cert, err := certmgr.TLSCertificate(ctx, cm, o.CertAlias)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+	if len(o.CAAlias) > 0 {
+		ca, err := cm.GetCert(ctx, o.CAAlias)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		cfg.RootCAs.AddCert(ca)
+	}
+	return NewClient(o.URL, cfg, o.Timeout), nil
+}
+
+// Client sends signing requests to signing server.
+type Client struct {
+	url string
+	hc  *http.Client
+}
+
+// NewClient creates client of signing server at given base URL, like https://signer.acme.tld:8444.
+// TLS configuration should carry client certificate, unless server doesn't require it.
+func NewClient(url string, cfg *tls.Config, timeout time.Duration) *Client {
+	return &Client{
+		url: strings.TrimRight(url, "/"),
+		hc: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: cfg, Proxy: http.ProxyFromEnvironment},
+		},
+	}
+}
+
+// Sign sends request to server and returns issued certificate along with its chain.
+func (c *Client) Sign(ctx context.Context, req *SignRequest) (*x509.Certificate, []*x509.Certificate, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+Path, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	res, err := c.hc.Do(hr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxRequestSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp SignResponse
+	if err = json.Unmarshal(body, &resp); err != nil {
+		// errors reported by layers in front of handler, like authorization, are plain text
+		return nil, nil, fmt.Errorf("signing server responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if res.StatusCode != http.StatusOK || len(resp.Error) > 0 {
+		return nil, nil, fmt.Errorf("signing server responded with status %d: %s", res.StatusCode, resp.Error)
+	}
+	certs, err := parseCertificates([]byte(resp.Certificate + resp.Chain))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(certs) == 0 {
+		return nil, nil, errors.New("signing server responded without certificate")
+	}
+	return certs[0], certs[1:], nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return res, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cert)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// x509SVIDUse is value of "use" parameter of JWK that holds X.509 trust anchor
+const x509SVIDUse = "x509-svid"
+
+// JWK is key of SPIFFE bundle, only X.509 authorities are supported.
+type JWK struct {
+	Kty string   `json:"kty"`
+	Use string   `json:"use"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c"`
+}
+
+// Bundle is SPIFFE bundle of trust domain, as defined by SPIFFE Trust Domain and Bundle specification.
+type Bundle struct {
+	Keys []JWK `json:"keys"`
+	// Sequence must increase whenever content of bundle changes
+	Sequence uint64 `json:"spiffe_sequence,omitempty"`
+	// RefreshHint is number of seconds after which consumers should check for updated bundle
+	RefreshHint int64 `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// b64 encodes big-endian bytes of integer of given size, padded with leading zeroes (RFC 7518, section 6.2.1).
+func b64(n *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
+
+// jwkOf creates JWK of CA certificate.
+func jwkOf(cert *x509.Certificate) (JWK, error) {
+	k := JWK{
+		Use: x509SVIDUse,
+		X5c: []string{base64.StdEncoding.EncodeToString(cert.Raw)},
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		k.Kty = "EC"
+		k.Crv = pub.Curve.Params().Name
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		k.X = b64(pub.X, size)
+		k.Y = b64(pub.Y, size)
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return k, fmt.Errorf("unsupported public key type %T of %s", pub, cert.Subject)
+	}
+	return k, nil
+}
+
+// NewBundle creates SPIFFE bundle with given X.509 authorities.
+// Refresh hint is left out when it's zero.
+func NewBundle(cas []*x509.Certificate, sequence uint64, refreshHint time.Duration) (*Bundle, error) {
+	b := &Bundle{
+		Keys:        []JWK{},
+		Sequence:    sequence,
+		RefreshHint: int64(refreshHint / time.Second),
+	}
+	for _, ca := range cas {
+		k, err := jwkOf(ca)
+		if err != nil {
+			return nil, err
+		}
+		b.Keys = append(b.Keys, k)
+	}
+	return b, nil
+}
+
+// Marshal encodes bundle as JSON document.
+func (b *Bundle) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/credentials"
+	"net"
+)
+
+var errNoPeerCredentials = errors.New("credentials of peer process are not available")
+
+// Caller describes process on the other side of unix socket.
+type Caller struct {
+	credentials.CommonAuthInfo
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+func (c *Caller) AuthType() string {
+	return "peercred"
+}
+
+// peerCredentials is transport "security" that attests calling process using credentials of unix socket peer.
+// Connection itself is not encrypted, it never leaves host.
+type peerCredentials struct{}
+
+func (peerCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials can only be used by server")
+}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	c, err := peerOf(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.SecurityLevel = credentials.NoSecurity
+	return conn, c, nil
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (p peerCredentials) Clone() credentials.TransportCredentials {
+	return p
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
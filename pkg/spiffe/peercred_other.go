@@ -0,0 +1,28 @@
+//go:build !linux
+
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"net"
+)
+
+// peerOf gets credentials of process connected to unix socket, which is only supported on Linux.
+func peerOf(net.Conn) (*Caller, error) {
+	return nil, errNoPeerCredentials
+}
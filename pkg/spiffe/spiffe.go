@@ -0,0 +1,143 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package spiffe issues X.509 SVIDs (SPIFFE Verifiable Identity Documents) from stored CA
+// and serves them to local workloads over SPIFFE Workload API.
+package spiffe
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const scheme = "spiffe"
+
+var (
+	// subject of SVIDs, identity is carried by URI SAN only
+	subject = pkix.Name{Organization: []string{"SPIFFE"}}
+
+	trustDomainRe = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	pathSegmentRe = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+	ErrInvalidID = errors.New("invalid SPIFFE ID")
+)
+
+// ParseID parses SPIFFE ID and checks that it conforms to SPIFFE ID specification.
+func ParseID(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidID, err)
+	}
+	switch {
+	case u.Scheme != scheme:
+		return nil, fmt.Errorf("%w: scheme must be %s", ErrInvalidID, scheme)
+	case u.User != nil, u.Port() != "", u.RawQuery != "", u.Fragment != "", u.Opaque != "":
+		return nil, fmt.Errorf("%w: must not contain user info, port, query or fragment", ErrInvalidID)
+	case !trustDomainRe.MatchString(u.Host):
+		return nil, fmt.Errorf("%w: trust domain must consist of lowercase letters, digits, dots, dashes and underscores", ErrInvalidID)
+	}
+	if len(u.Path) > 0 {
+		for _, seg := range strings.Split(u.Path, "/")[1:] {
+			if !pathSegmentRe.MatchString(seg) || seg == "." || seg == ".." {
+				return nil, fmt.Errorf("%w: invalid path segment '%s'", ErrInvalidID, seg)
+			}
+		}
+	}
+	return u, nil
+}
+
+// TrustDomainID gets SPIFFE ID of trust domain, like spiffe://example.org.
+func TrustDomainID(id *url.URL) string {
+	return scheme + "://" + id.Host
+}
+
+// CertData creates input data of SVID with given SPIFFE ID.
+func CertData(id *url.URL, ttl time.Duration, bits int) *certmgr.CertData {
+	return &certmgr.CertData{
+		KeySize:  bits,
+		Validity: ttl,
+		Subject:  subject,
+		URISan:   []*url.URL{id},
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageClientAuth,
+			x509.ExtKeyUsageServerAuth,
+		},
+	}
+}
+
+// SVID is X.509 SVID along with its private key.
+type SVID struct {
+	ID   *url.URL
+	Cert *x509.Certificate
+	Key  crypto.Signer
+	// Intermediates are CA certificates between SVID and trust anchor, in order
+	Intermediates []*x509.Certificate
+	// Bundle contains trust anchors of trust domain
+	Bundle []*x509.Certificate
+}
+
+// refreshAt gets time after which SVID should be replaced, which is half of its lifetime.
+func (s *SVID) refreshAt() time.Time {
+	return s.Cert.NotBefore.Add(s.Cert.NotAfter.Sub(s.Cert.NotBefore) / 2)
+}
+
+// Issuer issues short-lived SVIDs using stored CA. SVIDs are not kept in store.
+type Issuer struct {
+	cm   certmgr.Interface
+	ca   string
+	ttl  time.Duration
+	bits int
+}
+
+func NewIssuer(cm certmgr.Interface, ca string, ttl time.Duration, bits int) *Issuer {
+	return &Issuer{cm: cm, ca: ca, ttl: ttl, bits: bits}
+}
+
+// Issue issues new SVID for given SPIFFE ID.
+func (i *Issuer) Issue(ctx context.Context, id *url.URL) (*SVID, error) {
+	cd := CertData(id, i.ttl, i.bits)
+	csr, err := certmgr.CreateCSR(ctx, cd)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := i.cm.SignCSR(ctx, i.ca, csr.CSR, &certmgr.SignOptions{
+		Validity:    cd.Validity,
+		ExtKeyUsage: cd.ExtKeyUsage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	chain, err := i.cm.GetChain(ctx, i.ca)
+	if err != nil && !errors.Is(err, certmgr.ErrChainBroken) {
+		return nil, err
+	}
+	// top of chain is trust anchor, even when its issuer is not in store
+	svid := &SVID{ID: id, Cert: cert, Key: csr.Key}
+	for _, e := range chain[:len(chain)-1] {
+		svid.Intermediates = append(svid.Intermediates, e.Cert)
+	}
+	svid.Bundle = []*x509.Certificate{chain[len(chain)-1].Cert}
+	return svid, nil
+}
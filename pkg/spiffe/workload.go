@@ -0,0 +1,227 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/api/spiffe/workload"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// securityHeader must be sent by clients of Workload API, to prevent SSRF-style attacks
+const securityHeader = "workload.spiffe.io"
+
+var ErrNoEntries = errors.New("no workload entries configured")
+
+// entry is workload entry with parsed SPIFFE ID.
+type entry struct {
+	config.WorkloadEntry
+	id *url.URL
+}
+
+func (e *entry) matches(c *Caller) bool {
+	return (e.UID == nil || *e.UID == c.UID) && (e.GID == nil || *e.GID == c.GID)
+}
+
+// Server implements X.509 part of SPIFFE Workload API.
+type Server struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	issuer      *Issuer
+	trustDomain string
+	entries     []entry
+	mu          sync.Mutex
+	// SVIDs are shared by all workloads with the same SPIFFE ID, until they need to be refreshed
+	cache map[string]*SVID
+}
+
+// NewServer creates Workload API server that grants identities from trust domain according to entries.
+func NewServer(issuer *Issuer, trustDomain string, entries []config.WorkloadEntry) (*Server, error) {
+	if !trustDomainRe.MatchString(trustDomain) {
+		return nil, fmt.Errorf("invalid trust domain: %s", trustDomain)
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoEntries
+	}
+	s := &Server{
+		issuer:      issuer,
+		trustDomain: scheme + "://" + trustDomain,
+		cache:       map[string]*SVID{},
+	}
+	for _, e := range entries {
+		id, err := ParseID(e.ID)
+		if err != nil {
+			return nil, err
+		}
+		if TrustDomainID(id) != s.trustDomain {
+			return nil, fmt.Errorf("SPIFFE ID %s doesn't belong to trust domain %s", e.ID, trustDomain)
+		}
+		if e.UID == nil && e.GID == nil {
+			return nil, fmt.Errorf("entry for %s must have at least one selector", e.ID)
+		}
+		s.entries = append(s.entries, entry{WorkloadEntry: e, id: id})
+	}
+	return s, nil
+}
+
+// Register registers server with gRPC server created by NewGRPCServer.
+func (s *Server) Register(srv *grpc.Server) {
+	workload.RegisterSpiffeWorkloadAPIServer(srv, s)
+}
+
+// NewGRPCServer creates gRPC server that identifies callers using credentials of unix socket peer.
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	return grpc.NewServer(append([]grpc.ServerOption{grpc.Creds(peerCredentials{})}, opts...)...)
+}
+
+// checkHeader verifies that request carries security header.
+func checkHeader(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(securityHeader); len(v) != 1 || v[0] != "true" {
+		return status.Errorf(codes.InvalidArgument, "security header %s is missing", securityHeader)
+	}
+	return nil
+}
+
+// entriesOf finds entries of calling workload.
+func (s *Server) entriesOf(ctx context.Context) ([]entry, error) {
+	if err := checkHeader(ctx); err != nil {
+		return nil, err
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, errNoPeerCredentials.Error())
+	}
+	c, ok := p.AuthInfo.(*Caller)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, errNoPeerCredentials.Error())
+	}
+	var res []entry
+	for _, e := range s.entries {
+		if e.matches(c) {
+			res = append(res, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil, status.Errorf(codes.PermissionDenied, "no identity issued to process %d (uid %d, gid %d)", c.PID, c.UID, c.GID)
+	}
+	return res, nil
+}
+
+// svid gets SVID for SPIFFE ID, issuing new one when there is none or when cached one needs to be refreshed.
+func (s *Server) svid(ctx context.Context, id *url.URL) (*SVID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if svid, ok := s.cache[id.String()]; ok && time.Now().Before(svid.refreshAt()) {
+		return svid, nil
+	}
+	svid, err := s.issuer.Issue(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.cache[id.String()] = svid
+	return svid, nil
+}
+
+func concatDER(certs ...*x509.Certificate) []byte {
+	var res []byte
+	for _, c := range certs {
+		res = append(res, c.Raw...)
+	}
+	return res
+}
+
+// x509SVIDResponse issues SVIDs for entries, returning also time when response should be refreshed.
+func (s *Server) x509SVIDResponse(ctx context.Context, entries []entry) (*workload.X509SVIDResponse, time.Time, error) {
+	resp := &workload.X509SVIDResponse{}
+	var refresh time.Time
+	for _, e := range entries {
+		svid, err := s.svid(ctx, e.id)
+		if err != nil {
+			return nil, refresh, err
+		}
+		key, err := x509.MarshalPKCS8PrivateKey(svid.Key)
+		if err != nil {
+			return nil, refresh, err
+		}
+		resp.Svids = append(resp.Svids, &workload.X509SVID{
+			SpiffeId:    svid.ID.String(),
+			X509Svid:    concatDER(append([]*x509.Certificate{svid.Cert}, svid.Intermediates...)...),
+			X509SvidKey: key,
+			Bundle:      concatDER(svid.Bundle...),
+			Hint:        e.Hint,
+		})
+		if refresh.IsZero() || svid.refreshAt().Before(refresh) {
+			refresh = svid.refreshAt()
+		}
+	}
+	return resp, refresh, nil
+}
+
+func (s *Server) FetchX509SVID(_ *workload.X509SVIDRequest, stream workload.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	ctx := stream.Context()
+	entries, err := s.entriesOf(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		resp, refresh, err := s.x509SVIDResponse(ctx, entries)
+		if err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		if err = stream.Send(resp); err != nil {
+			return err
+		}
+		t := time.NewTimer(time.Until(refresh))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Server) FetchX509Bundles(_ *workload.X509BundlesRequest, stream workload.SpiffeWorkloadAPI_FetchX509BundlesServer) error {
+	ctx := stream.Context()
+	entries, err := s.entriesOf(ctx)
+	if err != nil {
+		return err
+	}
+	svid, err := s.svid(ctx, entries[0].id)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if err = stream.Send(&workload.X509BundlesResponse{
+		Bundles: map[string][]byte{s.trustDomain: concatDER(svid.Bundle...)},
+	}); err != nil {
+		return err
+	}
+	// trust anchors don't change while server is running
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"github.com/rkosegi/pkitool/pkg/plugin"
+)
+
+type execRequest struct {
+	Op    string `json:"op"`
+	Alias string `json:"alias,omitempty"`
+	Kind  Kind   `json:"kind,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+type execResponse struct {
+	Aliases []string `json:"aliases,omitempty"`
+	Exists  bool     `json:"exists,omitempty"`
+	Data    []byte   `json:"data,omitempty"`
+}
+
+type execStore struct {
+	path string
+}
+
+// NewExec creates store backed by external plugin executable, see package plugin for protocol details.
+// Plugin must support operations "list", "exists", "read", "write" and "delete".
+func NewExec(path string) Interface {
+	return &execStore{path: path}
+}
+
+func (es *execStore) call(ctx context.Context, req *execRequest) (*execResponse, error) {
+	var resp execResponse
+	if err := plugin.Call(ctx, es.path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (es *execStore) List(ctx context.Context) ([]string, error) {
+	resp, err := es.call(ctx, &execRequest{Op: "list"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Aliases, nil
+}
+
+func (es *execStore) Exists(ctx context.Context, alias string, kind Kind) (bool, error) {
+	resp, err := es.call(ctx, &execRequest{Op: "exists", Alias: alias, Kind: kind})
+	if err != nil {
+		return false, err
+	}
+	return resp.Exists, nil
+}
+
+func (es *execStore) Read(ctx context.Context, alias string, kind Kind) ([]byte, error) {
+	resp, err := es.call(ctx, &execRequest{Op: "read", Alias: alias, Kind: kind})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+func (es *execStore) Write(ctx context.Context, alias string, kind Kind, data []byte) error {
+	_, err := es.call(ctx, &execRequest{Op: "write", Alias: alias, Kind: kind, Data: data})
+	return err
+}
+
+func (es *execStore) Delete(ctx context.Context, alias string, kind Kind) error {
+	_, err := es.call(ctx, &execRequest{Op: "delete", Alias: alias, Kind: kind})
+	return err
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChainSuffix ends alias part of name of file with chain. Alias ending with it would name chain of another alias,
+// like certificate of "x-chain" and chain of "x", both in x-chain.pem, so such aliases are refused.
+const ChainSuffix = "-chain"
+
+var (
+	suffixes = map[Kind]string{
+		KindCert:        ".pem",
+		KindKey:         ".key",
+		KindRevocation:  ".revoked",
+		KindChain:       ChainSuffix + ".pem",
+		KindConstraints: ".constraints.yaml",
+		KindEscrow:      ".escrow.p7m",
+	}
+	perms = map[Kind]os.FileMode{
+		KindCert:        0o640,
+		KindKey:         0o400,
+		KindRevocation:  0o640,
+		KindChain:       0o640,
+		KindConstraints: 0o640,
+		KindEscrow:      0o640,
+	}
+)
+
+const (
+	// auditFile is name of audit log file within directory
+	auditFile = "audit.log"
+	// policyFile is name of issuance policy file within directory
+	policyFile = "policy.yaml"
+)
+
+type fileStore struct {
+	dir string
+}
+
+// NewFile creates store which keeps objects as files in directory.
+// Certificate of alias is stored in <alias>.pem, private key in <alias>.key.
+func NewFile(dir string) Interface {
+	return &fileStore{dir: dir}
+}
+
+// Perm gets mode of files of given kind written by file store.
+func Perm(kind Kind) os.FileMode {
+	return perms[kind]
+}
+
+func (fs *fileStore) Path(alias string, kind Kind) string {
+	return fmt.Sprintf("%s/%s%s", fs.dir, alias, suffixes[kind])
+}
+
+// fileToAlias extracts alias from filename, if it's name of certificate or key.
+// Chain files are skipped, no alias can end with ChainSuffix.
+func fileToAlias(file string) (string, bool) {
+	if strings.HasSuffix(file, suffixes[KindChain]) {
+		return "", false
+	}
+	for _, kind := range []Kind{KindCert, KindKey} {
+		if strings.HasSuffix(file, suffixes[kind]) {
+			return strings.TrimSuffix(file, suffixes[kind]), true
+		}
+	}
+	return "", false
+}
+
+func (fs *fileStore) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Uniq(lo.FilterMap(entries, func(entry os.DirEntry, _ int) (string, bool) {
+		return fileToAlias(entry.Name())
+	})), nil
+}
+
+func (fs *fileStore) Exists(_ context.Context, alias string, kind Kind) (bool, error) {
+	if _, err := os.Stat(fs.Path(alias, kind)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (fs *fileStore) Read(_ context.Context, alias string, kind Kind) ([]byte, error) {
+	return os.ReadFile(fs.Path(alias, kind))
+}
+
+// Write writes data into temporary file first, then renames it to its final name.
+// That way, readers never see partially written file.
+func (fs *fileStore) Write(_ context.Context, alias string, kind Kind, data []byte) error {
+	if strings.HasSuffix(alias, ChainSuffix) {
+		return fmt.Errorf("alias %s would overwrite chain of %s", alias, strings.TrimSuffix(alias, ChainSuffix))
+	}
+	name := fs.Path(alias, kind)
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), perms[kind]); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (fs *fileStore) Delete(_ context.Context, alias string, kind Kind) error {
+	return os.Remove(fs.Path(alias, kind))
+}
+
+// AppendAudit appends record as single line, using single write so that concurrent writers don't interleave.
+func (fs *fileStore) AppendAudit(_ context.Context, record []byte) error {
+	f, err := os.OpenFile(filepath.Join(fs.dir, auditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(record, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (fs *fileStore) OpenAudit(_ context.Context) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(fs.dir, auditFile))
+}
+
+func (fs *fileStore) ReadPolicy(_ context.Context) ([]byte, error) {
+	return os.ReadFile(filepath.Join(fs.dir, policyFile))
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// Iterator yields aliases one at a time. Next returns io.EOF once there are no more aliases.
+type Iterator interface {
+	Next(ctx context.Context) (string, error)
+	Close() error
+}
+
+// Streamer is implemented by stores which can enumerate aliases lazily.
+type Streamer interface {
+	Stream(ctx context.Context) (Iterator, error)
+}
+
+// Stream gets iterator over aliases in store.
+// Stores which don't implement Streamer are listed eagerly using List.
+func Stream(ctx context.Context, s Interface) (Iterator, error) {
+	if st, ok := s.(Streamer); ok {
+		return st.Stream(ctx)
+	}
+	aliases, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewSliceIterator(aliases), nil
+}
+
+// NewSliceIterator creates iterator over given aliases, in order.
+func NewSliceIterator(aliases []string) Iterator {
+	return &sliceIterator{aliases: aliases}
+}
+
+type sliceIterator struct {
+	aliases []string
+}
+
+func (si *sliceIterator) Next(_ context.Context) (string, error) {
+	if len(si.aliases) == 0 {
+		return "", io.EOF
+	}
+	alias := si.aliases[0]
+	si.aliases = si.aliases[1:]
+	return alias, nil
+}
+
+func (si *sliceIterator) Close() error {
+	return nil
+}
+
+// number of directory entries read at once
+const dirBatchSize = 256
+
+type dirIterator struct {
+	fs  *fileStore
+	dir *os.File
+	buf []os.DirEntry
+}
+
+// Stream reads directory in batches, in no particular order.
+func (fs *fileStore) Stream(_ context.Context) (Iterator, error) {
+	dir, err := os.Open(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	return &dirIterator{fs: fs, dir: dir}, nil
+}
+
+func (di *dirIterator) Next(ctx context.Context) (string, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if len(di.buf) == 0 {
+			var err error
+			if di.buf, err = di.dir.ReadDir(dirBatchSize); err != nil {
+				return "", err
+			}
+		}
+		name := di.buf[0].Name()
+		di.buf = di.buf[1:]
+		alias, ok := fileToAlias(name)
+		if !ok {
+			continue
+		}
+		// alias with both files is reported once, for its certificate
+		if strings.HasSuffix(name, suffixes[KindKey]) {
+			if found, err := di.fs.Exists(ctx, alias, KindCert); err != nil {
+				return "", err
+			} else if found {
+				continue
+			}
+		}
+		return alias, nil
+	}
+}
+
+func (di *dirIterator) Close() error {
+	return di.dir.Close()
+}
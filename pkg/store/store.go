@@ -0,0 +1,87 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package store provides backends used to persist certificates and private keys.
+package store
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// Kind is kind of object stored under alias.
+type Kind string
+
+const (
+	KindCert Kind = "cert"
+	KindKey  Kind = "key"
+	// KindRevocation is record of certificate revocation. Alias is only listed when it has certificate or key.
+	KindRevocation Kind = "revocation"
+	// KindChain is PEM bundle of certificates that issued certificate of alias,
+	// either because they are not stored under own alias, or for convenience of deployment.
+	KindChain Kind = "chain"
+	// KindConstraints are constraints of certificates issued by CA, kept along with it.
+	KindConstraints Kind = "constraints"
+	// KindEscrow is copy of private key encrypted for recovery agent.
+	KindEscrow Kind = "escrow"
+
+	execPrefix = "exec:"
+)
+
+// Interface is backend used to persist objects under aliases.
+// Reading or deleting object that does not exist must result in error that matches fs.ErrNotExist.
+type Interface interface {
+	// List lists all aliases that have at least one object.
+	List(ctx context.Context) ([]string, error)
+	// Exists checks if object of given kind exists for alias.
+	Exists(ctx context.Context, alias string, kind Kind) (bool, error)
+	// Read reads object of given kind.
+	Read(ctx context.Context, alias string, kind Kind) ([]byte, error)
+	// Write writes object of given kind, replacing any existing one.
+	Write(ctx context.Context, alias string, kind Kind, data []byte) error
+	// Delete deletes object of given kind.
+	Delete(ctx context.Context, alias string, kind Kind) error
+}
+
+// Locator is implemented by stores which keep objects in local files.
+type Locator interface {
+	// Path gets name of file which holds object of given kind.
+	Path(alias string, kind Kind) string
+}
+
+// AuditLog is implemented by stores which keep append-only log of operations.
+type AuditLog interface {
+	// AppendAudit appends single record to log.
+	AppendAudit(ctx context.Context, record []byte) error
+	// OpenAudit opens log for reading, one record per line.
+	// Missing log results in error that matches fs.ErrNotExist.
+	OpenAudit(ctx context.Context) (io.ReadCloser, error)
+}
+
+// PolicySource is implemented by stores which keep issuance policy.
+type PolicySource interface {
+	// ReadPolicy reads policy document. Missing policy results in error that matches fs.ErrNotExist.
+	ReadPolicy(ctx context.Context) ([]byte, error)
+}
+
+// New creates store for given location.
+// Location "exec:/path/to/plugin" denotes external plugin, anything else is directory.
+func New(location string) Interface {
+	if strings.HasPrefix(location, execPrefix) {
+		return NewExec(strings.TrimPrefix(location, execPrefix))
+	}
+	return NewFile(location)
+}
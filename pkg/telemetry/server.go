@@ -0,0 +1,62 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"net/http"
+)
+
+// route gets pattern that request is routed to, when handler is multiplexer.
+// Patterns are used instead of paths, so that paths with aliases don't blow up cardinality of metrics.
+func route(h http.Handler, r *http.Request) string {
+	if mux, ok := h.(*http.ServeMux); ok {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+	return ""
+}
+
+// HTTP wraps handler, so that every request is traced and counted per route, method and response status.
+// Spans are named by method and route, or just by method when route isn't known.
+func HTTP(next http.Handler) http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pattern := route(next, r); len(pattern) > 0 {
+			attr := semconv.HTTPRoute(pattern)
+			trace.SpanFromContext(r.Context()).SetAttributes(attr)
+			if l, ok := otelhttp.LabelerFromContext(r.Context()); ok {
+				l.Add(attr)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(h, "HTTP", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		if pattern := route(next, r); len(pattern) > 0 {
+			return r.Method + " " + pattern
+		}
+		return r.Method
+	}))
+}
+
+// ServerOptions creates options of gRPC server that trace every call and record its duration per method and status code.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+}
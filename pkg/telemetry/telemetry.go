@@ -0,0 +1,108 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package telemetry exports traces and metrics of server modes to OpenTelemetry collector using OTLP.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"github.com/rkosegi/pkitool/pkg/version"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"io"
+	"time"
+)
+
+const (
+	serviceName = "pkitool"
+	// shutdownTimeout limits how long pending spans and metrics are flushed for when server stops
+	shutdownTimeout = 5 * time.Second
+)
+
+// Options configures export of telemetry.
+type Options struct {
+	// Endpoint is address of OTLP/gRPC receiver, like otel-collector:4317. Telemetry is disabled when empty
+	Endpoint string
+	// Insecure disables TLS of connection to receiver
+	Insecure bool
+}
+
+// AddFlags adds flags to set options.
+func AddFlags(o *Options, pf *pflag.FlagSet) {
+	pf.StringVar(&o.Endpoint, "otlp-endpoint", o.Endpoint, "Address of OpenTelemetry collector to export traces and metrics to "+
+		"using OTLP/gRPC, like otel-collector:4317. Telemetry is disabled when not set")
+	pf.BoolVar(&o.Insecure, "otlp-insecure", o.Insecure, "Whether to connect to OpenTelemetry collector without TLS")
+}
+
+type closeFunc func() error
+
+func (f closeFunc) Close() error { return f() }
+
+// Start installs global tracer and meter providers that export to configured endpoint, so that servers and
+// certificate manager record spans and metrics. Server is reported as resource attribute, along with version.
+// Closer flushes pending data and should be called once server stops.
+func Start(ctx context.Context, o *Options, server string) (io.Closer, error) {
+	if len(o.Endpoint) == 0 {
+		return closeFunc(func() error { return nil }), nil
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(version.Version),
+			attribute.String("pkitool.server", server),
+		))
+	if err != nil {
+		return nil, err
+	}
+	traceOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(o.Endpoint)}
+	metricOpts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(o.Endpoint)}
+	if o.Insecure {
+		traceOpts = append(traceOpts, otlptracegrpc.WithInsecure())
+		metricOpts = append(metricOpts, otlpmetricgrpc.WithInsecure())
+	}
+	te, err := otlptracegrpc.New(ctx, traceOpts...)
+	if err != nil {
+		return nil, err
+	}
+	me, err := otlpmetricgrpc.New(ctx, metricOpts...)
+	if err != nil {
+		_ = te.Shutdown(ctx)
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(te), sdktrace.WithResource(res))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(me)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return closeFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}), nil
+}
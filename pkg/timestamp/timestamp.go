@@ -0,0 +1,284 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package timestamp implements client of Time-Stamp Protocol (RFC 3161).
+package timestamp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/cms"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	queryContentType = "application/timestamp-query"
+	replyContentType = "application/timestamp-reply"
+	// limit of response size, tokens with certificates are few kilobytes
+	maxResponseSize = 1 << 20
+)
+
+var (
+	oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+	hashes = map[string]struct {
+		hash crypto.Hash
+		oid  asn1.ObjectIdentifier
+	}{
+		"sha256": {crypto.SHA256, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+		"sha384": {crypto.SHA384, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}},
+		"sha512": {crypto.SHA512, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}},
+	}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       asn1.RawValue `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+}
+
+type timestampData struct {
+	w      io.Writer
+	dir    string
+	file   string
+	tsaURL string
+	token  string
+	hash   string
+}
+
+// result is verified timestamp token.
+type result struct {
+	info   *tstInfo
+	signer *x509.Certificate
+}
+
+func digestFile(file string, h crypto.Hash) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	d := h.New()
+	if _, err = io.Copy(d, f); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
+
+// request asks TSA to timestamp given digest and returns DER-encoded token.
+func request(ctx context.Context, url string, mi messageImprint, nonce *big.Int) ([]byte, error) {
+	query, err := asn1.Marshal(timeStampReq{Version: 1, MessageImprint: mi, Nonce: nonce, CertReq: true})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", queryContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TSA responded with HTTP status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != replyContentType {
+		return nil, fmt.Errorf("unexpected content type of TSA response: '%s'", ct)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var tsr timeStampResp
+	if _, err = asn1.Unmarshal(data, &tsr); err != nil {
+		return nil, fmt.Errorf("invalid TSA response: %w", err)
+	}
+	// granted or grantedWithMods
+	if tsr.Status.Status > 1 {
+		return nil, fmt.Errorf("TSA rejected request with status %d %v", tsr.Status.Status, tsr.Status.StatusString)
+	}
+	if len(tsr.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("TSA response contains no token")
+	}
+	return tsr.TimeStampToken.FullBytes, nil
+}
+
+// verify checks that token is valid timestamp of given message imprint, signed by TSA trusted by store.
+func verify(ctx context.Context, cm certmgr.Reader, token []byte, mi messageImprint, nonce *big.Int) (*result, error) {
+	sd, err := cms.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("token doesn't contain timestamp info")
+	}
+	signers, err := sd.Verify(nil)
+	if err != nil {
+		return nil, err
+	}
+	var info tstInfo
+	if _, err = asn1.Unmarshal(sd.Content, &info); err != nil {
+		return nil, err
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(mi.HashAlgorithm.Algorithm) ||
+		!bytes.Equal(info.MessageImprint.HashedMessage, mi.HashedMessage) {
+		return nil, errors.New("timestamp was issued for different content")
+	}
+	if nonce != nil && (info.Nonce == nil || info.Nonce.Cmp(nonce) != 0) {
+		return nil, errors.New("nonce of timestamp doesn't match request")
+	}
+	roots, intermediates, err := certmgr.TrustPools(ctx, cm)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range sd.Certificates {
+		intermediates.AddCert(c)
+	}
+	if _, err = signers[0].Certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}); err != nil {
+		return nil, fmt.Errorf("TSA is not trusted: %w", err)
+	}
+	return &result{info: &info, signer: signers[0].Certificate}, nil
+}
+
+func timestamp(ctx context.Context, d *timestampData) error {
+	h := hashes[d.hash]
+	digest, err := digestFile(d.file, h.hash)
+	if err != nil {
+		return err
+	}
+	mi := messageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: h.oid}, HashedMessage: digest}
+	var (
+		token []byte
+		nonce *big.Int
+	)
+	if len(d.tsaURL) > 0 {
+		if nonce, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64)); err != nil {
+			return err
+		}
+		if token, err = request(ctx, d.tsaURL, mi, nonce); err != nil {
+			return err
+		}
+	} else if token, err = os.ReadFile(d.token); err != nil {
+		return err
+	}
+	res, err := verify(ctx, certmgr.New(d.dir), token, mi, nonce)
+	if err != nil {
+		return err
+	}
+	if len(d.tsaURL) > 0 {
+		if err = os.WriteFile(d.token, token, 0o644); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(d.w, "File %s was timestamped at %s by %s (serial %s), token: %s\n",
+		d.file, res.info.GenTime.Format(time.RFC3339), res.signer.Subject, res.info.SerialNumber, d.token)
+	return err
+}
+
+func validate(d *timestampData) error {
+	if len(d.file) == 0 {
+		return errors.New("file to timestamp is required")
+	}
+	if _, ok := hashes[d.hash]; !ok {
+		return fmt.Errorf("unsupported hash function: '%s'", d.hash)
+	}
+	if len(d.token) == 0 {
+		d.token = d.file + ".tst"
+	}
+	return nil
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	d := &timestampData{
+		w:    out,
+		dir:  ".",
+		hash: "sha256",
+	}
+	cmd := &cobra.Command{
+		Use:   "timestamp",
+		Short: "Obtain trusted timestamp (RFC 3161) of file, or verify existing one",
+		Long: "Obtain trusted timestamp (RFC 3161) of file from TSA given by --tsa-url, or verify existing one given by --token.\n" +
+			"Timestamp is trusted when TSA certificate is issued by CA in directory",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validate(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return timestamp(cmd.Context(), d)
+		},
+	}
+	cmd.Flags().StringVar(&d.file, "file", d.file, "File to timestamp")
+	cmd.Flags().StringVar(&d.tsaURL, "tsa-url", d.tsaURL, "URL of time-stamping authority. When not set, existing token is verified")
+	cmd.Flags().StringVar(&d.token, "token", d.token, "File to save token to, or to read token from when --tsa-url is not set. Defaults to <file>.tst")
+	cmd.Flags().StringVar(&d.hash, "hash", d.hash, "Hash function, one of sha256, sha384, sha512")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tlstest simulates TLS handshake between stored certificates, to find out why peers don't trust each other.
+package tlstest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/common"
+	"github.com/spf13/cobra"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+type tlsTestData struct {
+	w          io.Writer
+	dir        string
+	server     string
+	client     string
+	ca         string
+	clientCA   string
+	serverName string
+}
+
+// side is result of handshake on one side of connection.
+type side struct {
+	name string
+	err  error
+}
+
+// describe gets human-readable name of certificate.
+func describe(c *x509.Certificate) string {
+	return fmt.Sprintf("'%s'", c.Subject)
+}
+
+// explain translates verification error into reason that doesn't require knowledge of crypto/x509 internals.
+func explain(err error) string {
+	var (
+		invalid  x509.CertificateInvalidError
+		unknown  x509.UnknownAuthorityError
+		hostname x509.HostnameError
+	)
+	switch {
+	case errors.As(err, &invalid):
+		c := invalid.Cert
+		switch invalid.Reason {
+		case x509.Expired:
+			return fmt.Sprintf("certificate %s is expired or not yet valid, it's valid from %s to %s",
+				describe(c), c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+		case x509.IncompatibleUsage:
+			return fmt.Sprintf("certificate %s (or its CA) doesn't allow required extended key usage, "+
+				"server certificate needs server authentication, client certificate needs client authentication", describe(c))
+		case x509.NotAuthorizedToSign:
+			return fmt.Sprintf("certificate %s is used to sign other certificates, but it's not CA", describe(c))
+		case x509.CANotAuthorizedForThisName:
+			return fmt.Sprintf("name constraints of CA %s don't permit name in certificate", describe(c))
+		default:
+			return invalid.Error()
+		}
+	case errors.As(err, &unknown):
+		return fmt.Sprintf("certificate %s is issued by unknown authority '%s', "+
+			"either CA is not trusted or intermediate CA is missing from chain", describe(unknown.Cert), unknown.Cert.Issuer)
+	case errors.As(err, &hostname):
+		var names []string
+		names = append(names, hostname.Certificate.DNSNames...)
+		for _, ip := range hostname.Certificate.IPAddresses {
+			names = append(names, ip.String())
+		}
+		return fmt.Sprintf("certificate %s is not valid for name '%s', it's valid for: %s",
+			describe(hostname.Certificate), hostname.Host, strings.Join(names, ", "))
+	default:
+		return err.Error()
+	}
+}
+
+// origin finds side that failed handshake first. Error of other side is just reaction to alert sent by peer.
+func origin(sides ...side) *side {
+	for i := range sides {
+		var ve *tls.CertificateVerificationError
+		if errors.As(sides[i].err, &ve) {
+			return &sides[i]
+		}
+	}
+	for i := range sides {
+		if sides[i].err != nil && !strings.Contains(sides[i].err.Error(), "remote error") {
+			return &sides[i]
+		}
+	}
+	for i := range sides {
+		if sides[i].err != nil {
+			return &sides[i]
+		}
+	}
+	return nil
+}
+
+// pools gets pool with single CA, or pool of all self-signed CAs in store when alias is empty.
+func pools(ctx context.Context, cm certmgr.Reader, alias string) (*x509.CertPool, error) {
+	if len(alias) == 0 {
+		roots, _, err := certmgr.TrustPools(ctx, cm)
+		return roots, err
+	}
+	ca, err := cm.GetCert(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, nil
+}
+
+// defaultServerName gets name that client connects to, when not given explicitly.
+func defaultServerName(c *x509.Certificate) string {
+	switch {
+	case len(c.DNSNames) > 0:
+		return c.DNSNames[0]
+	case len(c.IPAddresses) > 0:
+		return c.IPAddresses[0].String()
+	default:
+		return c.Subject.CommonName
+	}
+}
+
+// handshake runs TLS handshake over in-memory connection and returns results of both sides.
+func handshake(ctx context.Context, srvCfg, cliCfg *tls.Config) (*tls.ConnectionState, side, side) {
+	sc, cc := net.Pipe()
+	srv := tls.Server(sc, srvCfg)
+	cli := tls.Client(cc, cliCfg)
+	ch := make(chan error, 1)
+	go func() {
+		err := srv.HandshakeContext(ctx)
+		if err != nil {
+			_ = srv.Close()
+		}
+		ch <- err
+	}()
+	cliErr := cli.HandshakeContext(ctx)
+	if cliErr != nil {
+		_ = cli.Close()
+	} else {
+		// with TLS 1.3, client is done before server verifies its certificate,
+		// so alert sent by server must be consumed, otherwise server blocks on unbuffered pipe
+		go func() {
+			_, _ = io.Copy(io.Discard, cli)
+		}()
+	}
+	srvErr := <-ch
+	cs := cli.ConnectionState()
+	_ = srv.Close()
+	_ = cli.Close()
+	return &cs, side{name: "client", err: cliErr}, side{name: "server", err: srvErr}
+}
+
+func writeChain(w io.Writer, chains [][]*x509.Certificate) {
+	if len(chains) == 0 {
+		return
+	}
+	subjects := make([]string, 0, len(chains[0]))
+	for _, c := range chains[0] {
+		subjects = append(subjects, describe(c))
+	}
+	_, _ = fmt.Fprintf(w, "  chain: %s\n", strings.Join(subjects, " -> "))
+}
+
+// run performs handshake and reports result. Returns true when handshake succeeded.
+func run(ctx context.Context, d *tlsTestData) (bool, error) {
+	var cm certmgr.Reader = certmgr.New(d.dir)
+	srvCert, err := certmgr.TLSCertificate(ctx, cm, d.server)
+	if err != nil {
+		return false, err
+	}
+	roots, err := pools(ctx, cm, d.ca)
+	if err != nil {
+		return false, err
+	}
+	if len(d.serverName) == 0 {
+		d.serverName = defaultServerName(srvCert.Leaf)
+	}
+	srvCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*srvCert},
+	}
+	cliCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    roots,
+		ServerName: d.serverName,
+	}
+	_, _ = fmt.Fprintf(d.w, "Server: %s (alias '%s'), connecting as '%s'\n", describe(srvCert.Leaf), d.server, d.serverName)
+	if len(d.client) > 0 {
+		cliCert, err := certmgr.TLSCertificate(ctx, cm, d.client)
+		if err != nil {
+			return false, err
+		}
+		clientCA := d.clientCA
+		if len(clientCA) == 0 {
+			clientCA = d.ca
+		}
+		if srvCfg.ClientCAs, err = pools(ctx, cm, clientCA); err != nil {
+			return false, err
+		}
+		srvCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cliCfg.Certificates = []tls.Certificate{*cliCert}
+		_, _ = fmt.Fprintf(d.w, "Client: %s (alias '%s')\n", describe(cliCert.Leaf), d.client)
+	}
+	cs, cli, srv := handshake(ctx, srvCfg, cliCfg)
+	if failed := origin(cli, srv); failed != nil {
+		reason := explain(failed.err)
+		if failed.name == srv.name && len(d.client) > 0 && strings.Contains(failed.err.Error(), "didn't provide a certificate") {
+			// client only sends certificate issued by one of CAs that server asks for
+			reason = "client certificate is not issued by CA trusted by server, so client didn't send it"
+		}
+		_, _ = fmt.Fprintf(d.w, "Handshake FAILED on %s side: %s\n", failed.name, reason)
+		return false, nil
+	}
+	_, _ = fmt.Fprintf(d.w, "Handshake succeeded: %s, %s\n", tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite))
+	_, _ = fmt.Fprintf(d.w, "Client verified server certificate\n")
+	writeChain(d.w, cs.VerifiedChains)
+	if len(d.client) > 0 {
+		_, _ = fmt.Fprintf(d.w, "Server verified client certificate\n")
+	}
+	return true, nil
+}
+
+func validate(d *tlsTestData) error {
+	if len(d.server) == 0 {
+		return errors.New("alias of server certificate is required")
+	}
+	if len(d.clientCA) > 0 && len(d.client) == 0 {
+		return errors.New("client CA requires client certificate, use --client")
+	}
+	return nil
+}
+
+func NewCommand(out io.Writer) *cobra.Command {
+	d := &tlsTestData{
+		w:   out,
+		dir: ".",
+	}
+	cmd := &cobra.Command{
+		Use:   "tls-test",
+		Short: "Simulate TLS handshake between stored server and client certificates and explain why it fails",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.RequireTable(cmd); err != nil {
+				return err
+			}
+			return validate(d)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ok, err := run(cmd.Context(), d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// reason was already reported
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return &common.ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&d.server, "server", d.server, "Alias of server certificate and private key")
+	cmd.Flags().StringVar(&d.client, "client", d.client, "Alias of client certificate and private key. Mutual TLS is tested when set")
+	cmd.Flags().StringVar(&d.ca, "ca", d.ca, "Alias of CA trusted by client. All root CAs in directory are trusted when not set")
+	cmd.Flags().StringVar(&d.clientCA, "client-ca", d.clientCA, "Alias of CA trusted by server to verify client certificate, defaults to --ca")
+	cmd.Flags().StringVar(&d.serverName, "server-name", d.serverName, "Name client connects to, defaults to first SAN of server certificate")
+	common.AddDirFlag(&d.dir, cmd.Flags())
+	return cmd
+}
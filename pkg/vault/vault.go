@@ -0,0 +1,184 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault is minimal client of HashiCorp Vault HTTP API, as needed to talk to PKI secrets engine.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultAddress = "http://127.0.0.1:8200"
+
+// Options are connection settings of Vault, defaulting to the same environment variables as used by vault CLI.
+type Options struct {
+	Address   string
+	TokenFile string
+	Namespace string
+	Timeout   time.Duration
+}
+
+// APIError is error response of Vault.
+type APIError struct {
+	Status int      `json:"-"`
+	Errors []string `json:"errors"`
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault responded with status %d", e.Status)
+	}
+	return fmt.Sprintf("vault responded with status %d: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// Client performs authenticated requests against Vault API.
+type Client struct {
+	address   string
+	token     string
+	namespace string
+	hc        *http.Client
+}
+
+// AddFlags adds flags to set connection options.
+func AddFlags(o *Options, pf *pflag.FlagSet) {
+	if len(o.Address) == 0 {
+		o.Address = os.Getenv("VAULT_ADDR")
+	}
+	if len(o.Address) == 0 {
+		o.Address = defaultAddress
+	}
+	if len(o.Namespace) == 0 {
+		o.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 30 * time.Second
+	}
+	pf.StringVar(&o.Address, "vault-address", o.Address, "Address of Vault server, defaults to $VAULT_ADDR")
+	pf.StringVar(&o.TokenFile, "token-file", o.TokenFile, "File with Vault token. Defaults to $VAULT_TOKEN, or ~/.vault-token when not set")
+	pf.StringVar(&o.Namespace, "namespace", o.Namespace, "Vault Enterprise namespace, defaults to $VAULT_NAMESPACE")
+	pf.DurationVar(&o.Timeout, "timeout", o.Timeout, "Timeout of single request to Vault")
+}
+
+// token gets Vault token from file, environment or token helper file of vault CLI, in that order.
+func (o *Options) token() (string, error) {
+	file := o.TokenFile
+	if len(file) == 0 {
+		if t := os.Getenv("VAULT_TOKEN"); len(t) > 0 {
+			return t, nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		file = filepath.Join(home, ".vault-token")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if len(o.TokenFile) == 0 && errors.Is(err, os.ErrNotExist) {
+			return "", errors.New("vault token is required, use --token-file or set VAULT_TOKEN")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// NewClient creates client using connection options.
+func NewClient(o *Options) (*Client, error) {
+	token, err := o.token()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		address:   strings.TrimRight(o.Address, "/"),
+		token:     token,
+		namespace: o.Namespace,
+		hc:        &http.Client{Timeout: o.Timeout},
+	}, nil
+}
+
+// MountPath normalizes path of secrets engine mount, like "pki/" to "pki".
+func MountPath(mount string) string {
+	return strings.Trim(mount, "/")
+}
+
+// Do sends request with JSON body in to API path (without "/v1/" prefix) and decodes JSON response into out.
+// Either of in and out may be nil.
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.address+"/v1/"+strings.TrimLeft(path, "/"), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if len(c.namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 300 {
+		ae := &APIError{Status: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(ae)
+		return ae
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// List gets keys under API path, empty when there are none.
+func (c *Client) List(ctx context.Context, path string) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := c.Do(ctx, "LIST", path, nil, &resp); err != nil {
+		var ae *APIError
+		if errors.As(err, &ae) && ae.Status == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return resp.Data.Keys, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024 Richard Kosegi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook delivers lifecycle events of certificates to HTTP endpoints, like inventory systems or SIEMs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rkosegi/pkitool/pkg/certmgr"
+	"github.com/rkosegi/pkitool/pkg/config"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex>" HMAC-SHA256 of request body, keyed by webhook secret
+	SignatureHeader = "X-Pkitool-Signature"
+	// EventHeader carries action of event, so that receivers can route it without parsing body
+	EventHeader = "X-Pkitool-Event"
+
+	signaturePrefix = "sha256="
+	defaultTimeout  = 10 * time.Second
+)
+
+var events = []certmgr.EventType{certmgr.EventCreate, certmgr.EventRenew, certmgr.EventRevoke, certmgr.EventDelete}
+
+// Payload is JSON document posted to webhook.
+type Payload struct {
+	Action certmgr.EventType `json:"action"`
+	// Alias is empty for short-lived certificates issued without --alias, they are not kept in store
+	Alias string `json:"alias,omitempty"`
+	// Serial is decimal serial number, Fingerprint is hex-encoded SHA-256 of DER certificate.
+	// Both are empty when certificate is not known, like when deleted alias had none.
+	Serial      string     `json:"serial,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	NotAfter    *time.Time `json:"notAfter,omitempty"`
+	Time        time.Time  `json:"time"`
+}
+
+// NewPayload describes event.
+func NewPayload(ev *certmgr.Event) *Payload {
+	p := &Payload{
+		Action: ev.Type,
+		Alias:  ev.Alias,
+		Time:   time.Now().UTC(),
+	}
+	if ev.Cert != nil {
+		fp := sha256.Sum256(ev.Cert.Raw)
+		notAfter := ev.Cert.NotAfter.UTC()
+		p.Serial = ev.Cert.SerialNumber.String()
+		p.Fingerprint = hex.EncodeToString(fp[:])
+		p.Subject = ev.Cert.Subject.String()
+		p.NotAfter = &notAfter
+	}
+	return p
+}
+
+// Sign computes value of SignatureHeader for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that signature, as found in SignatureHeader, was made by secret over body.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Webhook posts events to single endpoint.
+type Webhook struct {
+	url    string
+	secret []byte
+	events []certmgr.EventType
+	client *http.Client
+}
+
+// New creates webhook from its configuration.
+func New(cfg *config.Webhook) (*Webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid URL of webhook: '%s'", cfg.URL)
+	}
+	w := &Webhook{
+		url:    cfg.URL,
+		secret: []byte(cfg.SecretValue()),
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+	if len(w.secret) == 0 {
+		return nil, fmt.Errorf("secret of webhook %s is required", u.Redacted())
+	}
+	if w.client.Timeout == 0 {
+		w.client.Timeout = defaultTimeout
+	}
+	for _, e := range cfg.Events {
+		if !slices.Contains(events, certmgr.EventType(e)) {
+			return nil, fmt.Errorf("unknown event of webhook %s: %s", u.Redacted(), e)
+		}
+		w.events = append(w.events, certmgr.EventType(e))
+	}
+	return w, nil
+}
+
+// Wants tells whether webhook receives events of given type.
+func (w *Webhook) Wants(t certmgr.EventType) bool {
+	return len(w.events) == 0 || slices.Contains(w.events, t)
+}
+
+// Send posts signed payload and checks that it was accepted.
+func (w *Webhook) Send(ctx context.Context, p *Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(p.Action))
+	req.Header.Set(SignatureHeader, Sign(w.secret, data))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected HTTP status from %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// Hook creates hook of certificate manager that delivers events to all webhooks.
+// Failed deliveries are reported to errw rather than returned, since operation that triggered event already happened.
+func Hook(webhooks []*Webhook, errw io.Writer) certmgr.Hook {
+	return func(ctx context.Context, ev *certmgr.Event) error {
+		p := NewPayload(ev)
+		var errs []error
+		for _, w := range webhooks {
+			if w.Wants(ev.Type) {
+				errs = append(errs, w.Send(ctx, p))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			for _, line := range strings.Split(err.Error(), "\n") {
+				_, _ = fmt.Fprintf(errw, "Warning: webhook delivery of %s event failed: %s\n", ev.Type, line)
+			}
+		}
+		return nil
+	}
+}
+
+// Load creates webhooks configured in configuration file. Nil is returned when there are none.
+func Load(configPath string) ([]*Webhook, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Webhook
+	for i := range cfg.Webhooks {
+		w, err := New(&cfg.Webhooks[i])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	return res, nil
+}
+
+// Options creates options of certificate manager that deliver events to webhooks configured in configuration file.
+// Nil is returned when no webhook is configured.
+func Options(configPath string, errw io.Writer) ([]certmgr.Option, error) {
+	webhooks, err := Load(configPath)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	return []certmgr.Option{certmgr.WithHook(Hook(webhooks, errw))}, nil
+}